@@ -0,0 +1,223 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	log "github.com/sirupsen/logrus"
+)
+
+// adaptiveStepFactor is how much the sample rate is divided by on every escalation
+const adaptiveStepFactor = 10
+
+// adaptiveMinEvidence is the number of validations needed after a change before the sample
+// rate is raised again
+const adaptiveMinEvidence = 10
+
+// AdaptiveSamplingConfig describes how sampling escalates when validations fail
+type AdaptiveSamplingConfig struct {
+	FailureThreshold float64 // Rolling failure rate above which the sample rate is raised, e.g. 0.01
+	Window           int     // Number of recent validations the failure rate is computed over (optional, defaults to 100)
+	PrefixDelimiter  string  // Focus on the partition key prefix up to this delimiter instead of the whole key (optional)
+	FocusSuccesses   int     // Successful validations before a focused key is sampled normally again (optional, defaults to 3)
+	MaxFocusedKeys   int     // Maximum number of keys or prefixes sampled at 100% (optional, defaults to 1000)
+}
+
+// adaptiveSampler samples keys at a base rate, divided while the rolling failure rate is
+// above the threshold, and samples every event of keys or key prefixes that recently
+// failed. Without an adaptive configuration it only samples at the base rate. It is safe
+// for concurrent use.
+type adaptiveSampler struct {
+	mu       sync.Mutex
+	cfg      *AdaptiveSamplingConfig
+	salt     string
+	baseRate int
+	level    int // Number of escalation steps applied to the base rate
+	minRate  int // Lowest share of keys that can be sampled, e.g. within the read budget
+	sampler  KeySampler
+	logger   *log.Entry
+
+	// Rolling window of validation outcomes, true for a failure
+	recent      []bool
+	next        int
+	failures    int
+	sinceChange int
+
+	focused map[string]int // Focused key or prefix, and the successes still needed to release it
+	reason  string         // Why the sample rate last changed
+}
+
+// newAdaptiveSampler creates a sampler starting at the base rate, cfg may be nil
+func newAdaptiveSampler(cfg *AdaptiveSamplingConfig, baseRate int, salt string, logger *log.Entry) *adaptiveSampler {
+	s := &adaptiveSampler{
+		salt:     salt,
+		baseRate: baseRate,
+		logger:   logger,
+		focused:  make(map[string]int),
+	}
+	if cfg != nil {
+		c := *cfg
+		if c.Window <= 0 {
+			c.Window = 100
+		}
+		if c.FocusSuccesses <= 0 {
+			c.FocusSuccesses = 3
+		}
+		if c.MaxFocusedKeys <= 0 {
+			c.MaxFocusedKeys = 1000
+		}
+		s.cfg = &c
+		s.recent = make([]bool, 0, c.Window)
+	}
+	s.sampler = NewKeySampler(s.effectiveRate(), salt)
+	return s
+}
+
+// Sampled reports whether an event of the key is validated
+func (s *adaptiveSampler) Sampled(key map[string]types.AttributeValue, partitionKeyValue string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.focused[s.focusKey(partitionKeyValue)]; ok {
+		return true
+	}
+	return s.sampler.Sampled(key)
+}
+
+// BaseRate returns the sample rate before escalation
+func (s *adaptiveSampler) BaseRate() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.baseRate
+}
+
+// SetBaseRate changes the sample rate before escalation
+func (s *adaptiveSampler) SetBaseRate(rate int, reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.baseRate = rate
+	s.changeRate(reason)
+}
+
+// SetMinRate keeps the sample rate at or above rate, 0 removes the limit
+func (s *adaptiveSampler) SetMinRate(rate int, reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.minRate = rate
+	s.changeRate(reason)
+}
+
+// UnlimitedRate returns the sample rate the sampler would use without the minimum rate
+func (s *adaptiveSampler) UnlimitedRate() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.unlimitedRate()
+}
+
+// Status returns the effective sample rate, why it last changed and the number of focused keys
+func (s *adaptiveSampler) Status() (rate int, reason string, focused int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sampler.Rate(), s.reason, len(s.focused)
+}
+
+// Observe records the outcome of a validation and escalates or relaxes the sampling
+func (s *adaptiveSampler) Observe(partitionKeyValue string, success bool) {
+	if s.cfg == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.observeKey(partitionKeyValue, success)
+
+	// Update the rolling window
+	if len(s.recent) < s.cfg.Window {
+		s.recent = append(s.recent, !success)
+	} else {
+		if s.recent[s.next] {
+			s.failures--
+		}
+		s.recent[s.next] = !success
+		s.next = (s.next + 1) % s.cfg.Window
+	}
+	if !success {
+		s.failures++
+	}
+	s.sinceChange++
+
+	failureRate := float64(s.failures) / float64(len(s.recent))
+	switch {
+	case failureRate > s.cfg.FailureThreshold && s.unlimitedRate() > max(1, s.minRate) && s.sinceChange >= min(adaptiveMinEvidence, s.cfg.Window):
+		s.level++
+		s.changeRate(fmt.Sprintf("raised, failure rate %.1f%% over the last %d validations is above %.1f%%",
+			failureRate*100, len(s.recent), s.cfg.FailureThreshold*100))
+	case failureRate <= s.cfg.FailureThreshold && s.level > 0 && s.sinceChange >= s.cfg.Window:
+		s.level--
+		s.changeRate(fmt.Sprintf("lowered, failure rate %.1f%% over the last %d validations is back under %.1f%%",
+			failureRate*100, len(s.recent), s.cfg.FailureThreshold*100))
+	}
+}
+
+// observeKey focuses on a key or prefix that failed, and releases it after enough
+// successes. The caller holds the lock.
+func (s *adaptiveSampler) observeKey(partitionKeyValue string, success bool) {
+	focusKey := s.focusKey(partitionKeyValue)
+	remaining, focused := s.focused[focusKey]
+
+	switch {
+	case !success && !focused && len(s.focused) < s.cfg.MaxFocusedKeys:
+		s.logger.Warnf("[ADAPTIVE] Validating every event of %s after a failed validation", focusKey)
+		s.focused[focusKey] = s.cfg.FocusSuccesses
+	case !success && focused:
+		s.focused[focusKey] = s.cfg.FocusSuccesses
+	case success && focused && remaining <= 1:
+		s.logger.Infof("[ADAPTIVE] %s validated %d times in a row, back to the normal sample rate", focusKey, s.cfg.FocusSuccesses)
+		delete(s.focused, focusKey)
+	case success && focused:
+		s.focused[focusKey] = remaining - 1
+	}
+}
+
+// changeRate applies the base rate and escalation level and logs the new rate. The caller
+// holds the lock.
+func (s *adaptiveSampler) changeRate(reason string) {
+	rate := s.effectiveRate()
+	if rate == s.sampler.Rate() {
+		return
+	}
+	s.logger.Infof("[SAMPLING] Sample rate changed from 1 in %d to 1 in %d keys: %s", s.sampler.Rate(), rate, reason)
+	s.sampler = NewKeySampler(rate, s.salt)
+	s.reason = reason
+	s.sinceChange = 0
+}
+
+// effectiveRate returns the base rate divided once for every escalation step, kept at or
+// above the minimum rate. The caller holds the lock.
+func (s *adaptiveSampler) effectiveRate() int {
+	return max(s.unlimitedRate(), s.minRate)
+}
+
+// unlimitedRate returns the base rate divided once for every escalation step. The caller
+// holds the lock.
+func (s *adaptiveSampler) unlimitedRate() int {
+	rate := s.baseRate
+	for range s.level {
+		rate /= adaptiveStepFactor
+	}
+	return max(1, rate)
+}
+
+// focusKey returns the key or key prefix focused on when the partition key fails
+func (s *adaptiveSampler) focusKey(partitionKeyValue string) string {
+	if s.cfg == nil || s.cfg.PrefixDelimiter == "" {
+		return partitionKeyValue
+	}
+	if i := strings.Index(partitionKeyValue, s.cfg.PrefixDelimiter); i >= 0 {
+		return partitionKeyValue[:i+len(s.cfg.PrefixDelimiter)]
+	}
+	return partitionKeyValue
+}
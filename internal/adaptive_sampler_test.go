@@ -0,0 +1,132 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// newTestAdaptiveSampler creates a sampler logging to the discarded standard logger
+func newTestAdaptiveSampler(cfg *AdaptiveSamplingConfig, baseRate int) *adaptiveSampler {
+	return newAdaptiveSampler(cfg, baseRate, "", log.NewEntry(log.StandardLogger()))
+}
+
+// observeN records n validations of different keys with the same outcome
+func observeN(s *adaptiveSampler, n int, success bool) {
+	for range n {
+		s.Observe("key", success)
+	}
+}
+
+func TestAdaptiveSamplerDisabled(t *testing.T) {
+	s := newTestAdaptiveSampler(nil, 100)
+	observeN(s, 50, false)
+
+	rate, reason, focused := s.Status()
+	if rate != 100 || reason != "" || focused != 0 {
+		t.Errorf("status = %d, %q, %d focused, want the base rate without changes", rate, reason, focused)
+	}
+}
+
+func TestAdaptiveSamplerEscalates(t *testing.T) {
+	s := newTestAdaptiveSampler(&AdaptiveSamplingConfig{FailureThreshold: 0.1, Window: 20}, 100)
+
+	// Failures raise the rate once per 10 validations, down to every key
+	observeN(s, 10, false)
+	if rate, reason, _ := s.Status(); rate != 10 || !strings.HasPrefix(reason, "raised") {
+		t.Errorf("status after 10 failures = %d, %q, want 1 in 10 raised", rate, reason)
+	}
+	observeN(s, 20, false)
+	if rate, _, _ := s.Status(); rate != 1 {
+		t.Errorf("rate after 30 failures = %d, want 1", rate)
+	}
+
+	// A full window of successes lowers it again one step at a time
+	observeN(s, 20, true)
+	if rate, reason, _ := s.Status(); rate != 10 || !strings.HasPrefix(reason, "lowered") {
+		t.Errorf("status after 20 successes = %d, %q, want 1 in 10 lowered", rate, reason)
+	}
+	observeN(s, 20, true)
+	if rate, _, _ := s.Status(); rate != 100 {
+		t.Errorf("rate after 40 successes = %d, want the base rate of 100", rate)
+	}
+}
+
+func TestAdaptiveSamplerStaysUnderThreshold(t *testing.T) {
+	s := newTestAdaptiveSampler(&AdaptiveSamplingConfig{FailureThreshold: 0.1, Window: 20}, 100)
+	for range 10 {
+		observeN(s, 19, true)
+		observeN(s, 1, false)
+	}
+
+	if rate, _, _ := s.Status(); rate != 100 {
+		t.Errorf("rate at a 5%% failure rate = %d, want the base rate of 100", rate)
+	}
+}
+
+func TestAdaptiveSamplerFocusesOnPrefix(t *testing.T) {
+	cfg := &AdaptiveSamplingConfig{FailureThreshold: 0.5, PrefixDelimiter: "#", FocusSuccesses: 2}
+	s := newTestAdaptiveSampler(cfg, 1<<62) // Practically no key sampled by hash
+	key := testKey("tenant1#order2", 0)
+
+	if s.Sampled(key, "tenant1#order2") {
+		t.Fatal("key sampled before any failure")
+	}
+
+	s.Observe("tenant1#order1", false)
+	if !s.Sampled(key, "tenant1#order2") {
+		t.Error("key with a failed prefix is not sampled")
+	}
+	if s.Sampled(testKey("tenant2#order2", 0), "tenant2#order2") {
+		t.Error("key of another prefix is sampled")
+	}
+	if _, _, focused := s.Status(); focused != 1 {
+		t.Errorf("focused keys = %d, want 1", focused)
+	}
+
+	// The prefix is released after two successes in a row
+	s.Observe("tenant1#order2", true)
+	s.Observe("tenant1#order3", true)
+	if s.Sampled(key, "tenant1#order2") {
+		t.Error("key still sampled after its prefix succeeded twice")
+	}
+}
+
+func TestAdaptiveSamplerMaxFocusedKeys(t *testing.T) {
+	s := newTestAdaptiveSampler(&AdaptiveSamplingConfig{FailureThreshold: 0.5, MaxFocusedKeys: 2}, 100)
+	for _, pk := range []string{"a", "b", "c"} {
+		s.Observe(pk, false)
+	}
+
+	if _, _, focused := s.Status(); focused != 2 {
+		t.Errorf("focused keys = %d, want 2", focused)
+	}
+}
+
+func TestAdaptiveSamplerBaseRateChange(t *testing.T) {
+	s := newTestAdaptiveSampler(&AdaptiveSamplingConfig{FailureThreshold: 0.1}, 100)
+	observeN(s, 10, false)
+
+	s.SetBaseRate(1000, "planned for 50.0 events/sec")
+	if rate, reason, _ := s.Status(); rate != 100 || reason != "planned for 50.0 events/sec" {
+		t.Errorf("status = %d, %q, want the new base rate divided once", rate, reason)
+	}
+}
+
+func TestAdaptiveSamplerMinRate(t *testing.T) {
+	s := newTestAdaptiveSampler(&AdaptiveSamplingConfig{FailureThreshold: 0.1, Window: 20}, 100)
+
+	// Escalation stops at the minimum rate
+	s.SetMinRate(50, "budget")
+	observeN(s, 30, false)
+	if rate, _, _ := s.Status(); rate != 50 || s.UnlimitedRate() != 10 {
+		t.Errorf("rate = %d, unlimited %d, want 50 and 10", rate, s.UnlimitedRate())
+	}
+
+	// Removing the minimum applies the escalated rate
+	s.SetMinRate(0, "budget removed")
+	if rate, reason, _ := s.Status(); rate != 10 || reason != "budget removed" {
+		t.Errorf("status = %d, %q, want 1 in 10 after removing the minimum", rate, reason)
+	}
+}
@@ -0,0 +1,354 @@
+package internal
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	streamtypes "github.com/aws/aws-sdk-go-v2/service/dynamodbstreams/types"
+)
+
+// AttributeMap is a DynamoDB item or key that marshals to and from the
+// DynamoDB JSON format used by the AWS CLI, e.g. {"pk": {"S": "USER#1"}}
+type AttributeMap map[string]types.AttributeValue
+
+// jsonAttributeValue is the wire form of a single attribute value in DynamoDB JSON
+type jsonAttributeValue struct {
+	S    *string                        `json:"S,omitempty"`
+	N    *string                        `json:"N,omitempty"`
+	B    *[]byte                        `json:"B,omitempty"`
+	BOOL *bool                          `json:"BOOL,omitempty"`
+	NULL *bool                          `json:"NULL,omitempty"`
+	SS   []string                       `json:"SS,omitempty"`
+	NS   []string                       `json:"NS,omitempty"`
+	BS   [][]byte                       `json:"BS,omitempty"`
+	L    *[]jsonAttributeValue          `json:"L,omitempty"`
+	M    *map[string]jsonAttributeValue `json:"M,omitempty"`
+}
+
+// MarshalJSON encodes the map in DynamoDB JSON format
+func (m AttributeMap) MarshalJSON() ([]byte, error) {
+	out, err := toJSONAttributeMap(m)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(out)
+}
+
+// UnmarshalJSON decodes a map in DynamoDB JSON format
+func (m *AttributeMap) UnmarshalJSON(data []byte) error {
+	var raw map[string]jsonAttributeValue
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	if raw == nil {
+		*m = nil
+		return nil
+	}
+
+	out := make(AttributeMap, len(raw))
+	for name, value := range raw {
+		av, err := fromJSONAttributeValue(value)
+		if err != nil {
+			return fmt.Errorf("attribute %s: %w", name, err)
+		}
+		out[name] = av
+	}
+	*m = out
+	return nil
+}
+
+func toJSONAttributeMap(m map[string]types.AttributeValue) (map[string]jsonAttributeValue, error) {
+	out := make(map[string]jsonAttributeValue, len(m))
+	for name, av := range m {
+		value, err := toJSONAttributeValue(av)
+		if err != nil {
+			return nil, fmt.Errorf("attribute %s: %w", name, err)
+		}
+		out[name] = value
+	}
+	return out, nil
+}
+
+func toJSONAttributeValue(av types.AttributeValue) (jsonAttributeValue, error) {
+	switch v := av.(type) {
+	case *types.AttributeValueMemberS:
+		return jsonAttributeValue{S: &v.Value}, nil
+	case *types.AttributeValueMemberN:
+		return jsonAttributeValue{N: &v.Value}, nil
+	case *types.AttributeValueMemberB:
+		return jsonAttributeValue{B: &v.Value}, nil
+	case *types.AttributeValueMemberBOOL:
+		return jsonAttributeValue{BOOL: &v.Value}, nil
+	case *types.AttributeValueMemberNULL:
+		return jsonAttributeValue{NULL: &v.Value}, nil
+	case *types.AttributeValueMemberSS:
+		return jsonAttributeValue{SS: v.Value}, nil
+	case *types.AttributeValueMemberNS:
+		return jsonAttributeValue{NS: v.Value}, nil
+	case *types.AttributeValueMemberBS:
+		return jsonAttributeValue{BS: v.Value}, nil
+	case *types.AttributeValueMemberL:
+		list := make([]jsonAttributeValue, 0, len(v.Value))
+		for i, item := range v.Value {
+			value, err := toJSONAttributeValue(item)
+			if err != nil {
+				return jsonAttributeValue{}, fmt.Errorf("list index %d: %w", i, err)
+			}
+			list = append(list, value)
+		}
+		return jsonAttributeValue{L: &list}, nil
+	case *types.AttributeValueMemberM:
+		m, err := toJSONAttributeMap(v.Value)
+		if err != nil {
+			return jsonAttributeValue{}, err
+		}
+		return jsonAttributeValue{M: &m}, nil
+	default:
+		return jsonAttributeValue{}, fmt.Errorf("unsupported attribute value type %T", av)
+	}
+}
+
+func fromJSONAttributeValue(value jsonAttributeValue) (types.AttributeValue, error) {
+	switch {
+	case value.S != nil:
+		return &types.AttributeValueMemberS{Value: *value.S}, nil
+	case value.N != nil:
+		return &types.AttributeValueMemberN{Value: *value.N}, nil
+	case value.B != nil:
+		return &types.AttributeValueMemberB{Value: *value.B}, nil
+	case value.BOOL != nil:
+		return &types.AttributeValueMemberBOOL{Value: *value.BOOL}, nil
+	case value.NULL != nil:
+		return &types.AttributeValueMemberNULL{Value: *value.NULL}, nil
+	case value.SS != nil:
+		return &types.AttributeValueMemberSS{Value: value.SS}, nil
+	case value.NS != nil:
+		return &types.AttributeValueMemberNS{Value: value.NS}, nil
+	case value.BS != nil:
+		return &types.AttributeValueMemberBS{Value: value.BS}, nil
+	case value.L != nil:
+		list := make([]types.AttributeValue, 0, len(*value.L))
+		for i, item := range *value.L {
+			av, err := fromJSONAttributeValue(item)
+			if err != nil {
+				return nil, fmt.Errorf("list index %d: %w", i, err)
+			}
+			list = append(list, av)
+		}
+		return &types.AttributeValueMemberL{Value: list}, nil
+	case value.M != nil:
+		m := make(map[string]types.AttributeValue, len(*value.M))
+		for name, item := range *value.M {
+			av, err := fromJSONAttributeValue(item)
+			if err != nil {
+				return nil, fmt.Errorf("attribute %s: %w", name, err)
+			}
+			m[name] = av
+		}
+		return &types.AttributeValueMemberM{Value: m}, nil
+	default:
+		return nil, fmt.Errorf("empty attribute value")
+	}
+}
+
+// streamToDynamoAttributeValue converts a DynamoDB Streams attribute value into
+// the equivalent DynamoDB attribute value so it can be used in table requests
+func streamToDynamoAttributeValue(av streamtypes.AttributeValue) types.AttributeValue {
+	switch v := av.(type) {
+	case *streamtypes.AttributeValueMemberS:
+		return &types.AttributeValueMemberS{Value: v.Value}
+	case *streamtypes.AttributeValueMemberN:
+		return &types.AttributeValueMemberN{Value: v.Value}
+	case *streamtypes.AttributeValueMemberB:
+		return &types.AttributeValueMemberB{Value: v.Value}
+	case *streamtypes.AttributeValueMemberBOOL:
+		return &types.AttributeValueMemberBOOL{Value: v.Value}
+	case *streamtypes.AttributeValueMemberNULL:
+		return &types.AttributeValueMemberNULL{Value: v.Value}
+	case *streamtypes.AttributeValueMemberSS:
+		return &types.AttributeValueMemberSS{Value: v.Value}
+	case *streamtypes.AttributeValueMemberNS:
+		return &types.AttributeValueMemberNS{Value: v.Value}
+	case *streamtypes.AttributeValueMemberBS:
+		return &types.AttributeValueMemberBS{Value: v.Value}
+	case *streamtypes.AttributeValueMemberL:
+		list := make([]types.AttributeValue, 0, len(v.Value))
+		for _, item := range v.Value {
+			list = append(list, streamToDynamoAttributeValue(item))
+		}
+		return &types.AttributeValueMemberL{Value: list}
+	case *streamtypes.AttributeValueMemberM:
+		return &types.AttributeValueMemberM{Value: streamImageToItem(v.Value)}
+	default:
+		return nil
+	}
+}
+
+// streamImageToItem converts a stream image (Keys, NewImage or OldImage) into a DynamoDB item
+func streamImageToItem(image map[string]streamtypes.AttributeValue) map[string]types.AttributeValue {
+	if image == nil {
+		return nil
+	}
+	item := make(map[string]types.AttributeValue, len(image))
+	for name, av := range image {
+		if converted := streamToDynamoAttributeValue(av); converted != nil {
+			item[name] = converted
+		}
+	}
+	return item
+}
+
+// extractPrimaryKey returns the typed primary key of a stream record, or nil
+// if the partition key (or the configured sort key) is missing
+func extractPrimaryKey(keys map[string]streamtypes.AttributeValue, partitionKey, sortKey string) map[string]types.AttributeValue {
+	pkAttr, ok := keys[partitionKey]
+	if !ok {
+		return nil
+	}
+
+	key := map[string]types.AttributeValue{
+		partitionKey: streamToDynamoAttributeValue(pkAttr),
+	}
+
+	if sortKey != "" {
+		skAttr, ok := keys[sortKey]
+		if !ok {
+			return nil
+		}
+		key[sortKey] = streamToDynamoAttributeValue(skAttr)
+	}
+
+	return key
+}
+
+// formatAttributeValue renders a scalar attribute value for logs and CSV files
+func formatAttributeValue(av types.AttributeValue) string {
+	switch v := av.(type) {
+	case *types.AttributeValueMemberS:
+		return v.Value
+	case *types.AttributeValueMemberN:
+		return v.Value
+	case *types.AttributeValueMemberB:
+		return base64.StdEncoding.EncodeToString(v.Value)
+	case *types.AttributeValueMemberBOOL:
+		return fmt.Sprintf("%t", v.Value)
+	case *types.AttributeValueMemberNULL:
+		return "NULL"
+	case nil:
+		return ""
+	default:
+		encoded, err := toJSONAttributeValue(av)
+		if err != nil {
+			return fmt.Sprintf("%v", av)
+		}
+		data, _ := json.Marshal(encoded)
+		return string(data)
+	}
+}
+
+// String renders the map as "name=value" pairs sorted by name, for logs
+func (m AttributeMap) String() string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		parts = append(parts, fmt.Sprintf("%s=%s", name, formatAttributeValue(m[name])))
+	}
+	return strings.Join(parts, ",")
+}
+
+// itemsEqual reports whether two items hold the same attributes with the same values
+func itemsEqual(a, b map[string]types.AttributeValue) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name, av := range a {
+		other, ok := b[name]
+		if !ok || !attributeValuesEqual(av, other) {
+			return false
+		}
+	}
+	return true
+}
+
+// attributeValuesEqual compares two attribute values. Sets are compared without regard to order.
+func attributeValuesEqual(a, b types.AttributeValue) bool {
+	switch v := a.(type) {
+	case *types.AttributeValueMemberS:
+		o, ok := b.(*types.AttributeValueMemberS)
+		return ok && v.Value == o.Value
+	case *types.AttributeValueMemberN:
+		o, ok := b.(*types.AttributeValueMemberN)
+		return ok && v.Value == o.Value
+	case *types.AttributeValueMemberB:
+		o, ok := b.(*types.AttributeValueMemberB)
+		return ok && string(v.Value) == string(o.Value)
+	case *types.AttributeValueMemberBOOL:
+		o, ok := b.(*types.AttributeValueMemberBOOL)
+		return ok && v.Value == o.Value
+	case *types.AttributeValueMemberNULL:
+		o, ok := b.(*types.AttributeValueMemberNULL)
+		return ok && v.Value == o.Value
+	case *types.AttributeValueMemberSS:
+		o, ok := b.(*types.AttributeValueMemberSS)
+		return ok && stringSetsEqual(v.Value, o.Value)
+	case *types.AttributeValueMemberNS:
+		o, ok := b.(*types.AttributeValueMemberNS)
+		return ok && stringSetsEqual(v.Value, o.Value)
+	case *types.AttributeValueMemberBS:
+		o, ok := b.(*types.AttributeValueMemberBS)
+		if !ok {
+			return false
+		}
+		as := make([]string, 0, len(v.Value))
+		for _, item := range v.Value {
+			as = append(as, string(item))
+		}
+		bs := make([]string, 0, len(o.Value))
+		for _, item := range o.Value {
+			bs = append(bs, string(item))
+		}
+		return stringSetsEqual(as, bs)
+	case *types.AttributeValueMemberL:
+		o, ok := b.(*types.AttributeValueMemberL)
+		if !ok || len(v.Value) != len(o.Value) {
+			return false
+		}
+		for i := range v.Value {
+			if !attributeValuesEqual(v.Value[i], o.Value[i]) {
+				return false
+			}
+		}
+		return true
+	case *types.AttributeValueMemberM:
+		o, ok := b.(*types.AttributeValueMemberM)
+		return ok && itemsEqual(v.Value, o.Value)
+	default:
+		return false
+	}
+}
+
+func stringSetsEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	counts := make(map[string]int, len(a))
+	for _, s := range a {
+		counts[s]++
+	}
+	for _, s := range b {
+		counts[s]--
+		if counts[s] < 0 {
+			return false
+		}
+	}
+	return true
+}
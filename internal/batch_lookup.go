@@ -0,0 +1,216 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// maxBatchGetKeys is the maximum number of keys of one BatchGetItem call
+const maxBatchGetKeys = 100
+
+// Backoff between the retries of unprocessed keys, doubled after every retry
+const (
+	unprocessedKeysInitialWait = 50 * time.Millisecond
+	unprocessedKeysMaxWait     = 5 * time.Second
+	unprocessedKeysMaxRetries  = 8
+)
+
+// defaultReadCostPerKey is the read capacity a key is assumed to consume before any is
+// measured: an eventually consistent read of up to 4 KB. A strongly consistent read
+// costs twice as much.
+const defaultReadCostPerKey = 0.5
+
+// lookupResult is the outcome of looking up one key
+type lookupResult struct {
+	Item map[string]types.AttributeValue // Item found, nil if missing
+	Err  error                           // Error of the call that should have returned the item
+}
+
+// lookupCost is the read capacity consumed by a lookup and the time it waited for the
+// read budget
+type lookupCost struct {
+	Consumed float64
+	Waited   time.Duration
+}
+
+// batchLookup reads items of one table with BatchGetItem, projecting the primary key and
+// the requested attributes. With a limiter, every call first waits for its estimated
+// read capacity, and the estimate is corrected once the consumed capacity is returned.
+type batchLookup struct {
+	client       TableAPI
+	table        string
+	partitionKey string
+	sortKey      string
+	attributes   []string     // Attributes read besides the primary key, nil for the key only
+	wholeItem    bool         // Read every attribute instead of the projection
+	consistent   bool         // Use strongly consistent reads
+	limiter      *RateLimiter // Read capacity units per second (optional)
+
+	mu          sync.Mutex
+	keysRead    int     // Keys read so far, for the cost estimate
+	capacityAll float64 // Read capacity consumed so far, for the cost estimate
+}
+
+// CostPerKey returns the average read capacity consumed per key so far
+func (l *batchLookup) CostPerKey() float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.keysRead == 0 || l.capacityAll == 0 {
+		if l.consistent {
+			return 2 * defaultReadCostPerKey
+		}
+		return defaultReadCostPerKey
+	}
+	return l.capacityAll / float64(l.keysRead)
+}
+
+// Lookup returns the result of every key, in the order of keys, and the read capacity
+// consumed. Keys are read in batches of up to 100, unprocessed keys are retried with
+// backoff.
+func (l *batchLookup) Lookup(ctx context.Context, keys []map[string]types.AttributeValue) ([]lookupResult, lookupCost) {
+	results := make([]lookupResult, len(keys))
+	var cost lookupCost
+
+	// BatchGetItem rejects duplicate keys, so every distinct key is read once
+	positions := make(map[string][]int, len(keys))
+	var unique []map[string]types.AttributeValue
+	for i, key := range keys {
+		id := l.keyID(key)
+		if _, ok := positions[id]; !ok {
+			unique = append(unique, key)
+		}
+		positions[id] = append(positions[id], i)
+	}
+
+	for start := 0; start < len(unique); start += maxBatchGetKeys {
+		chunk := unique[start:min(start+maxBatchGetKeys, len(unique))]
+		items, err := l.lookupChunk(ctx, chunk, &cost)
+
+		// Items read before a call failed are still found, only the others get the error
+		for _, key := range chunk {
+			id := l.keyID(key)
+			result := lookupResult{Item: items[id]}
+			if result.Item == nil {
+				result.Err = err
+			}
+			for _, i := range positions[id] {
+				results[i] = result
+			}
+		}
+	}
+	return results, cost
+}
+
+// lookupChunk reads up to 100 keys, retrying unprocessed keys, and returns the items
+// found by key ID. The capacity consumed and the time waited are added to cost.
+func (l *batchLookup) lookupChunk(ctx context.Context, keys []map[string]types.AttributeValue, cost *lookupCost) (map[string]map[string]types.AttributeValue, error) {
+	items := make(map[string]map[string]types.AttributeValue, len(keys))
+
+	request := l.keysAndAttributes(keys)
+	wait := unprocessedKeysInitialWait
+	for attempt := 0; ; attempt++ {
+		// Wait for the estimated read capacity of the call
+		estimate := float64(len(request.Keys)) * l.CostPerKey()
+		if l.limiter != nil {
+			start := time.Now()
+			err := l.limiter.Wait(ctx, estimate)
+			cost.Waited += time.Since(start)
+			if err != nil {
+				return items, err
+			}
+		}
+
+		out, err := l.client.BatchGetItem(ctx, &dynamodb.BatchGetItemInput{
+			RequestItems:           map[string]types.KeysAndAttributes{l.table: request},
+			ReturnConsumedCapacity: types.ReturnConsumedCapacityTotal,
+		})
+		if err != nil {
+			return items, err
+		}
+
+		var consumed float64
+		for _, capacity := range out.ConsumedCapacity {
+			consumed += aws.ToFloat64(capacity.CapacityUnits)
+		}
+		cost.Consumed += consumed
+		for _, item := range out.Responses[l.table] {
+			items[l.keyID(item)] = item
+		}
+
+		// Without a reported capacity, e.g. from DynamoDB Local, the estimate stays charged
+		unprocessed, ok := out.UnprocessedKeys[l.table]
+		if len(out.ConsumedCapacity) > 0 {
+			l.limiter.Adjust(estimate - consumed)
+			l.mu.Lock()
+			l.keysRead += len(request.Keys) - len(unprocessed.Keys)
+			l.capacityAll += consumed
+			l.mu.Unlock()
+		}
+
+		if !ok || len(unprocessed.Keys) == 0 {
+			return items, nil
+		}
+		if attempt == unprocessedKeysMaxRetries {
+			return items, fmt.Errorf("%d keys still unprocessed after %d retries", len(unprocessed.Keys), attempt)
+		}
+
+		// Back off before asking again for the keys DynamoDB did not process
+		request = unprocessed
+		select {
+		case <-ctx.Done():
+			return items, ctx.Err()
+		case <-time.After(wait):
+		}
+		wait = min(2*wait, unprocessedKeysMaxWait)
+	}
+}
+
+// keysAndAttributes builds the request for keys, projecting the primary key and the
+// requested attributes. Names are passed as placeholders, so reserved words can be used.
+func (l *batchLookup) keysAndAttributes(keys []map[string]types.AttributeValue) types.KeysAndAttributes {
+	if l.wholeItem {
+		return types.KeysAndAttributes{Keys: keys, ConsistentRead: aws.Bool(l.consistent)}
+	}
+
+	names := []string{l.partitionKey}
+	if l.sortKey != "" {
+		names = append(names, l.sortKey)
+	}
+	for _, name := range l.attributes {
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+
+	placeholders := make([]string, 0, len(names))
+	expressionNames := make(map[string]string, len(names))
+	for i, name := range names {
+		placeholder := fmt.Sprintf("#a%d", i)
+		placeholders = append(placeholders, placeholder)
+		expressionNames[placeholder] = name
+	}
+
+	return types.KeysAndAttributes{
+		Keys:                     keys,
+		ProjectionExpression:     aws.String(strings.Join(placeholders, ", ")),
+		ExpressionAttributeNames: expressionNames,
+		ConsistentRead:           aws.Bool(l.consistent),
+	}
+}
+
+// keyID returns the primary key of an item or key as a map key
+func (l *batchLookup) keyID(item map[string]types.AttributeValue) string {
+	id := formatAttributeValue(item[l.partitionKey])
+	if l.sortKey != "" {
+		id += "\x00" + formatAttributeValue(item[l.sortKey])
+	}
+	return id
+}
@@ -0,0 +1,172 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// newTestLookup returns a lookup of the target table of m and the keys of items 1 to n,
+// with only the odd items stored
+func newTestLookup(m *fakeMigration, n int) (*batchLookup, []map[string]types.AttributeValue) {
+	var keys []map[string]types.AttributeValue
+	for i := 1; i <= n; i++ {
+		if i%2 == 1 {
+			m.target.put(testItem(i), 0)
+		}
+		keys = append(keys, m.keyOf(testItem(i)))
+	}
+	return &batchLookup{client: m.target, table: m.target.name, partitionKey: "pk", sortKey: "sk"}, keys
+}
+
+func TestBatchLookupBatchesKeys(t *testing.T) {
+	m := newFakeMigration()
+	lookup, keys := newTestLookup(m, 250)
+
+	results, cost := lookup.Lookup(context.Background(), keys)
+
+	if !slices.Equal(m.target.batchKeys, []int{100, 100, 50}) {
+		t.Errorf("batch sizes = %v, want [100 100 50]", m.target.batchKeys)
+	}
+	if cost.Consumed != 125 {
+		t.Errorf("consumed %g RCU, want 125", cost.Consumed)
+	}
+	for i, result := range results {
+		if found := result.Item != nil; found != (i%2 == 0) || result.Err != nil {
+			t.Fatalf("key %d: found %t, error %v", i+1, found, result.Err)
+		}
+	}
+}
+
+func TestBatchLookupRetriesUnprocessedKeys(t *testing.T) {
+	m := newFakeMigration()
+	m.target.maxProcessed = 40
+	lookup, keys := newTestLookup(m, 100)
+
+	results, cost := lookup.Lookup(context.Background(), keys)
+
+	if !slices.Equal(m.target.batchKeys, []int{100, 60, 20}) {
+		t.Errorf("batch sizes = %v, want [100 60 20]", m.target.batchKeys)
+	}
+	if cost.Consumed != 50 {
+		t.Errorf("consumed %g RCU, want 50", cost.Consumed)
+	}
+	for i, result := range results {
+		if found := result.Item != nil; found != (i%2 == 0) {
+			t.Fatalf("key %d: found %t after retries", i+1, found)
+		}
+	}
+}
+
+func TestBatchLookupProjection(t *testing.T) {
+	m := newFakeMigration()
+	lookup, keys := newTestLookup(m, 1)
+	item := testItem(1)
+	item["status"] = &types.AttributeValueMemberS{Value: "active"}
+	m.target.put(item, 0)
+
+	results, _ := lookup.Lookup(context.Background(), keys)
+	if got := len(results[0].Item); got != 2 {
+		t.Errorf("key-only lookup returned %d attributes, want 2", got)
+	}
+
+	lookup.attributes = []string{"status", "pk"}
+	results, _ = lookup.Lookup(context.Background(), keys)
+	if got := results[0].Item; len(got) != 3 || got["status"] == nil {
+		t.Errorf("lookup of status returned %v, want the key and status", AttributeMap(got))
+	}
+}
+
+func TestBatchLookupDuplicatesAndErrors(t *testing.T) {
+	m := newFakeMigration()
+	lookup, keys := newTestLookup(m, 2)
+	keys = append(keys, keys[0])
+
+	results, _ := lookup.Lookup(context.Background(), keys)
+	if !slices.Equal(m.target.batchKeys, []int{2}) || results[2].Item == nil {
+		t.Errorf("batch sizes = %v, duplicate found %t, want [2] and true", m.target.batchKeys, results[2].Item != nil)
+	}
+
+	m.target.readErr = errors.New("throttled")
+	results, _ = lookup.Lookup(context.Background(), keys)
+	for i, result := range results {
+		if result.Err == nil || result.Item != nil {
+			t.Errorf("key %d: item %v, error %v, want the call error", i+1, result.Item, result.Err)
+		}
+	}
+}
+
+// failingRetryTable fails every BatchGetItem call after the first one
+type failingRetryTable struct {
+	*fakeTable
+	calls int
+}
+
+func (t *failingRetryTable) BatchGetItem(ctx context.Context, params *dynamodb.BatchGetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchGetItemOutput, error) {
+	if t.calls++; t.calls > 1 {
+		return nil, errors.New("throttled")
+	}
+	return t.fakeTable.BatchGetItem(ctx, params, optFns...)
+}
+
+func TestBatchLookupPartialChunkError(t *testing.T) {
+	m := newFakeMigration()
+	m.target.maxProcessed = 4
+	lookup, keys := newTestLookup(m, 10)
+	lookup.client = &failingRetryTable{fakeTable: m.target}
+
+	// Items 1 and 3 are read by the first call, the retry of the unprocessed keys fails
+	results, _ := lookup.Lookup(context.Background(), keys)
+	for i, result := range results {
+		found := i == 0 || i == 2
+		if (result.Item != nil) != found || (result.Err != nil) == found {
+			t.Errorf("key %d: item %v, error %v, want found %t without error, or the call error", i+1, AttributeMap(result.Item), result.Err, found)
+		}
+	}
+}
+
+func TestBatchLookupReadBudget(t *testing.T) {
+	m := newFakeMigration()
+	lookup, keys := newTestLookup(m, 300)
+	lookup.limiter = NewRateLimiter(100, 100)
+
+	// 150 RCU at 100 RCU/sec with a full bucket of 100 waits for the last 50
+	_, cost := lookup.Lookup(context.Background(), keys)
+	if cost.Consumed != 150 || cost.Waited < 400*time.Millisecond {
+		t.Errorf("consumed %g RCU and waited %s, want 150 RCU and about 500ms", cost.Consumed, cost.Waited)
+	}
+}
+
+// noCapacityTable reports no consumed capacity, like DynamoDB Local
+type noCapacityTable struct {
+	*fakeTable
+}
+
+func (t noCapacityTable) BatchGetItem(ctx context.Context, params *dynamodb.BatchGetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchGetItemOutput, error) {
+	out, err := t.fakeTable.BatchGetItem(ctx, params, optFns...)
+	if out != nil {
+		out.ConsumedCapacity = nil
+	}
+	return out, err
+}
+
+func TestBatchLookupReadBudgetWithoutConsumedCapacity(t *testing.T) {
+	m := newFakeMigration()
+	lookup, keys := newTestLookup(m, 200)
+	lookup.client = noCapacityTable{m.target}
+	lookup.limiter = NewRateLimiter(100, 50)
+
+	// Each call keeps its estimate of 50 RCU charged, so the second one waits for a refill
+	_, cost := lookup.Lookup(context.Background(), keys)
+	if cost.Waited < 400*time.Millisecond {
+		t.Errorf("waited %s, want about 500ms", cost.Waited)
+	}
+	if got := lookup.CostPerKey(); got != defaultReadCostPerKey {
+		t.Errorf("cost per key = %g, want the default %g", got, defaultReadCostPerKey)
+	}
+}
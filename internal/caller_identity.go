@@ -0,0 +1,55 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	log "github.com/sirupsen/logrus"
+)
+
+// CallerIdentity is the AWS account and principal a client's credentials belong to
+type CallerIdentity struct {
+	Account string `json:"account"`
+	Arn     string `json:"arn"`
+	UserID  string `json:"user_id"`
+}
+
+// checkCallerIdentity asks STS who the credentials of cfg belong to. If expectedAccount is
+// set, a different account, or a failed lookup, is an error.
+func checkCallerIdentity(ctx context.Context, name string, cfg aws.Config, expectedAccount string) (*CallerIdentity, error) {
+	out, err := sts.NewFromConfig(cfg).GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		if expectedAccount != "" {
+			return nil, fmt.Errorf("failed to verify the account of the %s client: %w", name, err)
+		}
+		log.Warnf("Could not determine the identity of the %s client: %v", name, err)
+		return nil, nil
+	}
+
+	identity := &CallerIdentity{
+		Account: aws.ToString(out.Account),
+		Arn:     aws.ToString(out.Arn),
+		UserID:  aws.ToString(out.UserId),
+	}
+
+	if expectedAccount != "" && identity.Account != expectedAccount {
+		return nil, fmt.Errorf("%s client uses account %s (%s), expected account %s",
+			name, identity.Account, identity.Arn, expectedAccount)
+	}
+	return identity, nil
+}
+
+// logClientIdentity logs the region, account and principal of one client
+func logClientIdentity(name, region string, identity *CallerIdentity) {
+	if identity == nil {
+		log.WithField("region", region).Warnf("⚠️ %s client identity unknown (static credentials or identity check failed)", name)
+		return
+	}
+	log.WithFields(log.Fields{
+		"region":    region,
+		"account":   identity.Account,
+		"principal": identity.Arn,
+	}).Infof("✅ %s client identity", name)
+}
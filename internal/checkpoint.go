@@ -0,0 +1,132 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// ShardCheckpoint records how far a shard has been processed
+type ShardCheckpoint struct {
+	SequenceNumber string `json:"sequence_number,omitempty"` // Last applied sequence number
+	Closed         bool   `json:"closed,omitempty"`          // Shard was read to the end
+}
+
+// Checkpoint is the on-disk form of the checkpoint file
+type Checkpoint struct {
+	StreamArn string                     `json:"stream_arn"`
+	UpdatedAt time.Time                  `json:"updated_at"`
+	Shards    map[string]ShardCheckpoint `json:"shards"`
+}
+
+// CheckpointStore keeps per-shard progress in memory and persists it to a JSON file.
+// With an empty path it only keeps progress in memory. It is safe for concurrent use.
+type CheckpointStore struct {
+	mu    sync.Mutex
+	path  string
+	cp    Checkpoint
+	dirty bool
+}
+
+// LoadCheckpointStore loads the checkpoint file at path, or starts a new one if it does not exist
+func LoadCheckpointStore(path, streamArn string) (*CheckpointStore, error) {
+	store := &CheckpointStore{
+		path: path,
+		cp: Checkpoint{
+			StreamArn: streamArn,
+			Shards:    make(map[string]ShardCheckpoint),
+		},
+	}
+	if path == "" {
+		return store, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checkpoint file: %w", err)
+	}
+
+	var cp Checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, fmt.Errorf("failed to parse checkpoint file: %w", err)
+	}
+	if cp.StreamArn != streamArn {
+		return nil, fmt.Errorf("checkpoint file belongs to stream %s, not %s", cp.StreamArn, streamArn)
+	}
+	if cp.Shards == nil {
+		cp.Shards = make(map[string]ShardCheckpoint)
+	}
+	store.cp = cp
+	return store, nil
+}
+
+// Get returns the checkpoint of a shard
+func (s *CheckpointStore) Get(shardID string) (ShardCheckpoint, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cp, ok := s.cp.Shards[shardID]
+	return cp, ok
+}
+
+// Advance records the last applied sequence number of a shard
+func (s *CheckpointStore) Advance(shardID, sequenceNumber string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cp := s.cp.Shards[shardID]
+	cp.SequenceNumber = sequenceNumber
+	s.cp.Shards[shardID] = cp
+	s.dirty = true
+}
+
+// MarkClosed records that a shard was read to the end
+func (s *CheckpointStore) MarkClosed(shardID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cp := s.cp.Shards[shardID]
+	cp.Closed = true
+	s.cp.Shards[shardID] = cp
+	s.dirty = true
+}
+
+// Save writes the checkpoint file if anything changed since the last save.
+// The file is replaced atomically so a crash never leaves a partial checkpoint.
+func (s *CheckpointStore) Save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.path == "" || !s.dirty {
+		return nil
+	}
+
+	s.cp.UpdatedAt = time.Now()
+	data, err := json.MarshalIndent(s.cp, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode checkpoint: %w", err)
+	}
+
+	// Create directory if it doesn't exist
+	dir := filepath.Dir(s.path)
+	if dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create directory for checkpoint file: %w", err)
+		}
+	}
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write checkpoint file: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("failed to replace checkpoint file: %w", err)
+	}
+
+	s.dirty = false
+	return nil
+}
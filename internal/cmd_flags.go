@@ -3,90 +3,647 @@ package internal
 import (
 	"errors"
 	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // CommandFlags contains all command line parameters
 type CommandFlags struct {
-	SourceProfile string // Source AWS profile name
-	TargetProfile string // Target AWS profile name
-	StreamProfile string // Stream AWS profile name (optional, defaults to target profile)
-	StreamArn     string // DynamoDB Stream ARN (optional)
-	TargetTable   string // Target DynamoDB table name
-	PartitionKey  string // Name of the partition key
-	SortKey       string // Name of the sort key (optional)
-	Region        string // AWS Region (optional, defaults to ap-northeast-1)
-	SampleRate    int    // Validate 1 out of every SampleRate records (optional, defaults to 100)
-	IteratorType  string // DynamoDB Stream Iterator Type (optional, defaults to LATEST)
-	VerifyOn      string // Which table to verify against: source or target (optional, defaults to source)
-	Verbose       bool   // Whether to show success validation logs (optional, defaults to false)
+	ConfigFile    string  // YAML or JSON configuration file (optional)
+	SourceProfile string  // Source AWS profile name
+	TargetProfile string  // Target AWS profile name
+	StreamProfile string  // Stream AWS profile name (optional, defaults to target profile)
+	StreamArn     string  // DynamoDB Stream ARN (optional)
+	SourceTable   string  // Source DynamoDB table name (optional, defaults to target table)
+	TargetTable   string  // Target DynamoDB table name
+	PartitionKey  string  // Name of the partition key
+	SortKey       string  // Name of the sort key (optional)
+	Region        string  // AWS Region (optional, defaults to ap-northeast-1)
+	SourceRegion  string  // Region of the source client (optional, defaults to the stream ARN region, then region)
+	TargetRegion  string  // Region of the target client (optional, defaults to region)
+	StreamRegion  string  // Region of the stream client (optional, defaults to the stream ARN region, then source region)
+	SampleRate    int     // Validate every event of 1 out of every SampleRate keys (optional, defaults to 100)
+	SampleSalt    string  // Changes which keys are sampled (optional)
+	Confidence    float64 // Confidence level of the reported success interval (optional, defaults to 0.95)
+	IteratorType  string  // DynamoDB Stream Iterator Type (optional, defaults to LATEST)
+	VerifyOn      string  // Which table to verify against: source or target (optional, defaults to source)
+	Verbose       bool    // Whether to show success validation logs (optional, defaults to false)
+	FailureFile   string  // JSON-lines file that receives failed validations (optional)
+
+	// Comma-separated attributes read from the verified table besides the primary key (optional)
+	LookupAttributes string
+
+	// Maximum read capacity units per second of validation lookups, per table (optional, 0 = unlimited)
+	ReadBudget float64
+
+	// Read consistency of validation lookups (optional, eventually consistent by default)
+	ConsistentRead    bool // Make every lookup strongly consistent
+	ConsistentRecheck bool // Read missing items again with a strongly consistent read before counting them
+
+	// Semicolon-separated steps computing the item expected in the target table from the
+	// stream image (optional, the stream image as is)
+	Transform string
+
+	// Attribute comparison (optional, existence only unless compare-items or a rule is set),
+	// rules are comma-separated name=value pairs
+	CompareItems     bool   // Compare the attributes of the verified item with the stream image
+	IgnoreAttributes string // Attributes that are not compared
+	RenameAttributes string // Stream image name=verified item name
+	NumericTolerance string // name=largest absolute difference
+	TimeTolerance    string // name=largest difference, e.g. updatedAt=2s
+	CoerceAttributes string // name=S or name=N, the type both values are converted to
+
+	// Assumed roles (optional), each one a comma-separated chain of role ARNs
+	SourceRoleArn   string        // Role assumed by the source client
+	TargetRoleArn   string        // Role assumed by the target client
+	StreamRoleArn   string        // Role assumed by the stream client (defaults to source role when stream profile is not set)
+	RoleExternalID  string        // External ID passed when assuming roles
+	RoleSessionName string        // Session name of the assumed roles
+	RoleDuration    time.Duration // Duration of the assumed role sessions
+
+	// Endpoint overrides, e.g. DynamoDB Local or LocalStack (optional)
+	SourceEndpoint string // Endpoint of the source client
+	TargetEndpoint string // Endpoint of the target client
+	StreamEndpoint string // Endpoint of the stream client (defaults to source endpoint)
+
+	// Credential policy (optional)
+	StrictCredentials bool   // Fail instead of falling back to the EC2 instance role when a profile cannot be used
+	SourceAccountID   string // Expected account of the source client
+	TargetAccountID   string // Expected account of the target client
+	StreamAccountID   string // Expected account of the stream client (defaults to source account when stream profile is not set)
+
+	// Repair mode (optional, disabled by default)
+	Repair                 bool    // Copy missing or drifted items from source to target
+	RepairDryRun           bool    // Log repairs without writing
+	RepairRate             float64 // Maximum repair writes per second (0 = unlimited)
+	RepairAuditFile        string  // JSON-lines file that receives every repair attempt
+	RepairVersionAttribute string  // Attribute used to decide whether the source item is newer
+
+	// Sample rate planning (optional, disabled unless a target margin is set)
+	TargetMargin      float64       // Wanted half-width of the success rate interval
+	ExpectedEventRate float64       // Expected events per second, until traffic is observed
+	PlanWindow        time.Duration // Period the margin should be reached in
+
+	// Adaptive sampling (optional, disabled unless a failure threshold is set)
+	AdaptiveFailureThreshold float64 // Rolling failure rate above which the sample rate is raised
+	AdaptiveWindow           int     // Number of recent validations the failure rate is computed over
+	AdaptivePrefixDelimiter  string  // Focus on the partition key prefix up to this delimiter
+
+	// Event filters (optional), lists are comma-separated and times are RFC 3339
+	IncludeKeyPrefix  string // Only count and validate partition keys with one of these prefixes
+	ExcludeKeyPrefix  string // Skip partition keys with one of these prefixes
+	IncludeKeyPattern string // Only count and validate partition keys matching this regular expression
+	ExcludeKeyPattern string // Skip partition keys matching this regular expression
+	EventTypes        string // Only count and validate these event types, INSERT and/or MODIFY
+	EventsAfter       string // Skip records created before this time
+	EventsBefore      string // Skip records created at or after this time
+
+	// Performance tuning parameters
+	ValidationConfig ValidationConfig
+
+	// Tables monitored in one process, only settable in the config file (optional)
+	Tables []TableConfig
+
+	// Where each option's effective value came from, keyed by flag name
+	sources map[string]string
+	flagSet *flag.FlagSet
 }
 
-// ParseCommandFlags parses command line flags and returns the configuration
-func ParseCommandFlags() (*CommandFlags, error) {
-	sourceProfilePtr := flag.String("source-profile", "", "Source AWS profile name (required)")
-	targetProfilePtr := flag.String("target-profile", "", "Target AWS profile name (required)")
-	streamProfilePtr := flag.String("stream-profile", "", "Stream AWS profile name (optional, defaults to target profile)")
-	streamArnPtr := flag.String("stream-arn", "", "DynamoDB Stream ARN for stream-based count check (optional)")
-	targetTablePtr := flag.String("target-table", "", "Target DynamoDB table name for stream-based check (required if stream-arn is set)")
-	partitionKeyPtr := flag.String("partition-key", "", "Name of the partition key (required if stream-arn is set)")
-	sortKeyPtr := flag.String("sort-key", "", "Name of the sort key (optional)")
-	regionPtr := flag.String("region", "ap-northeast-1", "AWS Region (optional, defaults to ap-northeast-1)")
-	sampleRatePtr := flag.Int("sample-rate", 100, "Validate 1 out of every N records (optional, defaults to 100)")
-	iteratorTypePtr := flag.String("iterator-type", "LATEST", "DynamoDB Stream Iterator Type (optional, LATEST or TRIM_HORIZON)")
-	verifyOnPtr := flag.String("verify-on", "source", "Which table to verify against: source or target (optional, defaults to source)")
-	verbosePtr := flag.Bool("verbose", false, "Show success validation logs (optional, defaults to false)")
-	flag.Parse()
-
-	// Validate required flags
-	if *sourceProfilePtr == "" || *targetProfilePtr == "" {
-		return nil, errors.New("missing required flags: source-profile and target-profile are required")
+// defaultCommandFlags returns the configuration used when nothing is set
+func defaultCommandFlags() CommandFlags {
+	return CommandFlags{
+		Region:           "ap-northeast-1",
+		SampleRate:       100,
+		Confidence:       defaultConfidence,
+		PlanWindow:       time.Hour,
+		AdaptiveWindow:   100,
+		IteratorType:     "LATEST",
+		VerifyOn:         "source",
+		RepairRate:       10,
+		RoleSessionName:  defaultRoleSessionName,
+		RoleDuration:     time.Hour,
+		ValidationConfig: DefaultValidationConfig(),
+	}
+}
+
+// parseCommandFlags registers the shared flags on fs and parses the configuration file,
+// environment variables and command line flags, in that order of precedence from lowest
+// to highest. Flags registered on fs beforehand are parsed from the same layers.
+func parseCommandFlags(fs *flag.FlagSet, args []string, defaults CommandFlags) (*CommandFlags, error) {
+	cfg := &CommandFlags{}
+	*cfg = defaults
+
+	fs.StringVar(&cfg.ConfigFile, "config", "", "YAML or JSON configuration file (optional)")
+	fs.StringVar(&cfg.SourceProfile, "source-profile", "", "Source AWS profile name (required)")
+	fs.StringVar(&cfg.TargetProfile, "target-profile", "", "Target AWS profile name (required)")
+	fs.StringVar(&cfg.StreamProfile, "stream-profile", "", "Stream AWS profile name (optional, defaults to target profile)")
+	fs.StringVar(&cfg.StreamArn, "stream-arn", "", "DynamoDB Stream ARN for stream-based count check (optional)")
+	fs.StringVar(&cfg.SourceTable, "source-table", "", "Source DynamoDB table name (optional, defaults to target-table)")
+	fs.StringVar(&cfg.TargetTable, "target-table", "", "Target DynamoDB table name for stream-based check (required if stream-arn is set)")
+	fs.StringVar(&cfg.PartitionKey, "partition-key", "", "Name of the partition key (required if stream-arn is set)")
+	fs.StringVar(&cfg.SortKey, "sort-key", "", "Name of the sort key (optional)")
+	fs.StringVar(&cfg.Region, "region", cfg.Region, "AWS Region (optional, defaults to ap-northeast-1)")
+	fs.StringVar(&cfg.SourceRegion, "source-region", "", "Region of the source client (optional, defaults to the stream ARN region, then region)")
+	fs.StringVar(&cfg.TargetRegion, "target-region", "", "Region of the target client (optional, defaults to region)")
+	fs.StringVar(&cfg.StreamRegion, "stream-region", "", "Region of the stream client (optional, defaults to the stream ARN region, then source region)")
+	fs.IntVar(&cfg.SampleRate, "sample-rate", cfg.SampleRate, "Validate every event of 1 out of every N keys (optional, defaults to 100)")
+	fs.StringVar(&cfg.SampleSalt, "sample-salt", "", "Salt of the key hash, changes which keys are sampled (optional)")
+	fs.Float64Var(&cfg.Confidence, "confidence", cfg.Confidence, "Confidence level of the reported success interval (optional, defaults to 0.95)")
+	fs.Float64Var(&cfg.TargetMargin, "target-margin", 0, "Choose the sample rate to reach this margin of error, e.g. 0.005 for ±0.5% (optional)")
+	fs.Float64Var(&cfg.ExpectedEventRate, "expected-event-rate", 0, "Expected events per second, used by target-margin until traffic is observed (optional)")
+	fs.DurationVar(&cfg.PlanWindow, "plan-window", cfg.PlanWindow, "Period in which target-margin should be reached (optional, defaults to 1h)")
+	fs.Float64Var(&cfg.AdaptiveFailureThreshold, "adaptive-failure-threshold", 0, "Raise the sample rate while the rolling failure rate is above this, e.g. 0.01 (optional)")
+	fs.IntVar(&cfg.AdaptiveWindow, "adaptive-window", cfg.AdaptiveWindow, "Number of recent validations the rolling failure rate is computed over (optional, defaults to 100)")
+	fs.StringVar(&cfg.AdaptivePrefixDelimiter, "adaptive-prefix-delimiter", "", "After a failure, validate every event of the partition key prefix up to this delimiter instead of the key (optional)")
+	fs.StringVar(&cfg.IncludeKeyPrefix, "include-key-prefix", "", "Only validate partition keys starting with one of these comma-separated prefixes (optional)")
+	fs.StringVar(&cfg.ExcludeKeyPrefix, "exclude-key-prefix", "", "Skip partition keys starting with one of these comma-separated prefixes (optional)")
+	fs.StringVar(&cfg.IncludeKeyPattern, "include-key-pattern", "", "Only validate partition keys matching this regular expression (optional)")
+	fs.StringVar(&cfg.ExcludeKeyPattern, "exclude-key-pattern", "", "Skip partition keys matching this regular expression (optional)")
+	fs.StringVar(&cfg.EventTypes, "event-types", "", "Only validate these comma-separated event types, INSERT and/or MODIFY (optional, defaults to both)")
+	fs.StringVar(&cfg.EventsAfter, "events-after", "", "Skip records created before this RFC 3339 time, e.g. 2024-05-01T10:00:00Z (optional)")
+	fs.StringVar(&cfg.EventsBefore, "events-before", "", "Skip records created at or after this RFC 3339 time (optional)")
+	fs.StringVar(&cfg.IteratorType, "iterator-type", cfg.IteratorType, "DynamoDB Stream Iterator Type (optional, LATEST or TRIM_HORIZON)")
+	fs.StringVar(&cfg.VerifyOn, "verify-on", cfg.VerifyOn, "Which table to verify against: source or target (optional, defaults to source)")
+	fs.BoolVar(&cfg.Verbose, "verbose", false, "Show success validation logs (optional, defaults to false)")
+	fs.StringVar(&cfg.FailureFile, "failure-file", "", "JSON-lines file to append failed validations to (optional)")
+	fs.Float64Var(&cfg.ReadBudget, "read-budget", 0, "Maximum read capacity units per second of validation lookups per table, 0 for unlimited (optional)")
+	fs.BoolVar(&cfg.ConsistentRead, "consistent-read", false, "Use strongly consistent reads for every validation lookup (optional)")
+	fs.BoolVar(&cfg.ConsistentRecheck, "consistent-recheck", false, "Read items still missing after retry again with a strongly consistent read before counting them as failed (optional)")
+	fs.StringVar(&cfg.Transform, "transform", "", "Semicolon-separated steps computing the expected target item from the stream image, e.g. 'pk=tenant42#${pk}' (optional)")
+	registerCompareFlags(fs, cfg)
+	fs.StringVar(&cfg.LookupAttributes, "lookup-attributes", "", "Comma-separated attributes read from the verified table besides the primary key (optional, defaults to the key only)")
+	fs.StringVar(&cfg.SourceRoleArn, "source-role-arn", "", "Role assumed by the source client, or a comma-separated chain of roles (optional)")
+	fs.StringVar(&cfg.TargetRoleArn, "target-role-arn", "", "Role assumed by the target client, or a comma-separated chain of roles (optional)")
+	fs.StringVar(&cfg.StreamRoleArn, "stream-role-arn", "", "Role assumed by the stream client, or a comma-separated chain of roles (optional, defaults to source-role-arn)")
+	fs.StringVar(&cfg.RoleExternalID, "role-external-id", "", "External ID passed when assuming roles (optional)")
+	fs.StringVar(&cfg.RoleSessionName, "role-session-name", cfg.RoleSessionName, "Session name of the assumed roles (optional, defaults to dynamodb-migration-monitor)")
+	fs.DurationVar(&cfg.RoleDuration, "role-duration", cfg.RoleDuration, "Duration of the assumed role sessions, refreshed automatically (optional, defaults to 1h)")
+	fs.StringVar(&cfg.SourceEndpoint, "source-endpoint", "", "Endpoint URL of the source client, e.g. http://localhost:8000 (optional)")
+	fs.StringVar(&cfg.TargetEndpoint, "target-endpoint", "", "Endpoint URL of the target client (optional)")
+	fs.StringVar(&cfg.StreamEndpoint, "stream-endpoint", "", "Endpoint URL of the stream client (optional, defaults to source-endpoint)")
+	fs.BoolVar(&cfg.StrictCredentials, "strict-credentials", false, "Fail if a profile cannot be used instead of falling back to the EC2 instance role (optional)")
+	fs.StringVar(&cfg.SourceAccountID, "source-account-id", "", "Expected AWS account of the source client, checked at startup (optional)")
+	fs.StringVar(&cfg.TargetAccountID, "target-account-id", "", "Expected AWS account of the target client, checked at startup (optional)")
+	fs.StringVar(&cfg.StreamAccountID, "stream-account-id", "", "Expected AWS account of the stream client, checked at startup (optional, defaults to source-account-id)")
+	fs.BoolVar(&cfg.Repair, "repair", false, "Copy missing or drifted items from source to target when validation fails (optional)")
+	fs.BoolVar(&cfg.RepairDryRun, "repair-dry-run", false, "Log repairs without writing to the target table (optional)")
+	fs.Float64Var(&cfg.RepairRate, "repair-rate", cfg.RepairRate, "Maximum repair writes per second, 0 for unlimited (optional, defaults to 10)")
+	fs.StringVar(&cfg.RepairAuditFile, "repair-audit-file", "", "JSON-lines file to append every repair attempt to (optional)")
+	fs.StringVar(&cfg.RepairVersionAttribute, "repair-version-attribute", "", "Attribute that increases on every write, required to overwrite drifted items (optional)")
+
+	// Validation tuning parameters
+	vc := &cfg.ValidationConfig
+	fs.IntVar(&vc.BufferSize, "validation-buffer-size", vc.BufferSize, "Size of the validation buffer (optional, defaults to 100)")
+	fs.IntVar(&vc.ChannelSize, "validation-channel-size", vc.ChannelSize, "Size of the validation channel (optional, defaults to 10)")
+	fs.DurationVar(&vc.ValidationInterval, "validation-interval", vc.ValidationInterval, "How often to process the validation buffer (optional, defaults to 30s)")
+	fs.DurationVar(&vc.ReplicationWaitTime, "replication-wait-time", vc.ReplicationWaitTime, "How long to wait for data replication (optional, defaults to 5s)")
+	fs.DurationVar(&vc.RetryWaitTime, "retry-wait-time", vc.RetryWaitTime, "How long to wait before retry (optional, defaults to 2s)")
+	fs.Var((*int32Value)(&vc.BatchSize), "batch-size", "Size of stream batch (optional, defaults to 100)")
+	fs.DurationVar(&vc.StatsInterval, "stats-interval", vc.StatsInterval, "How often to show statistics (optional, defaults to 30s)")
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	// Merge the configuration file, environment variables and flags
+	sources, err := applyConfigLayers(fs, cfg, defaults)
+	if err != nil {
+		return nil, err
+	}
+	cfg.sources = sources
+	cfg.flagSet = fs
+
+	// Validate required flags, a custom endpoint can be used without a profile
+	if (cfg.SourceProfile == "" && cfg.SourceEndpoint == "") || (cfg.TargetProfile == "" && cfg.TargetEndpoint == "") {
+		return nil, errors.New("missing required flags: source-profile and target-profile are required unless the matching endpoint is set")
 	}
 
 	// Additional validation
-	if *streamArnPtr != "" {
-		if *targetTablePtr == "" {
+	if cfg.StreamArn != "" {
+		if cfg.TargetTable == "" {
 			return nil, errors.New("target-table is required when using stream-arn")
 		}
-		if *partitionKeyPtr == "" {
+		if cfg.PartitionKey == "" {
 			return nil, errors.New("partition-key is required when using stream-arn")
 		}
 	}
 
+	// Validate repair rate and read budget
+	if cfg.RepairRate < 0 {
+		return nil, errors.New("repair-rate must not be negative")
+	}
+	if cfg.ReadBudget < 0 {
+		return nil, errors.New("read-budget must not be negative")
+	}
+
+	// Every lookup is already strongly consistent with consistent-read
+	if cfg.ConsistentRead && cfg.ConsistentRecheck {
+		return nil, errors.New("consistent-read and consistent-recheck cannot be combined")
+	}
+
 	// Validate sample rate
-	if *sampleRatePtr <= 0 {
+	if cfg.SampleRate <= 0 {
 		return nil, errors.New("sample-rate must be greater than 0")
 	}
 
+	// Validate confidence and sample rate planning
+	if cfg.Confidence <= 0 || cfg.Confidence >= 1 {
+		return nil, errors.New("confidence must be between 0 and 1")
+	}
+	if cfg.TargetMargin < 0 || cfg.TargetMargin >= 0.5 {
+		return nil, errors.New("target-margin must be between 0 and 0.5")
+	}
+	if cfg.ExpectedEventRate < 0 || cfg.PlanWindow <= 0 {
+		return nil, errors.New("expected-event-rate must not be negative and plan-window must be greater than 0")
+	}
+
+	// Validate adaptive sampling
+	if cfg.AdaptiveFailureThreshold < 0 || cfg.AdaptiveFailureThreshold >= 1 {
+		return nil, errors.New("adaptive-failure-threshold must be between 0 and 1")
+	}
+	if cfg.AdaptiveWindow <= 0 {
+		return nil, errors.New("adaptive-window must be greater than 0")
+	}
+
+	// Validate event filters
+	if _, err := cfg.EventFilter(); err != nil {
+		return nil, err
+	}
+
+	// Validate the transform, items are reshaped on their way to the target table only
+	if _, err := cfg.ParsedTransform(); err != nil {
+		return nil, err
+	}
+	if cfg.Transform != "" && cfg.VerifyOn != "target" {
+		return nil, errors.New("transform requires verify-on target")
+	}
+	if cfg.Transform != "" && (cfg.Repair || cfg.RepairDryRun) {
+		return nil, errors.New("transform cannot be combined with repair, the repairer copies items as is")
+	}
+
+	// Validate compare rules
+	if _, err := cfg.CompareRules(); err != nil {
+		return nil, err
+	}
+
 	// Validate iterator type
-	iteratorType := *iteratorTypePtr
-	if iteratorType != "LATEST" && iteratorType != "TRIM_HORIZON" {
+	if cfg.IteratorType != "LATEST" && cfg.IteratorType != "TRIM_HORIZON" {
 		return nil, errors.New("iterator-type must be either LATEST or TRIM_HORIZON")
 	}
 
 	// Validate verify-on
-	verifyOn := *verifyOnPtr
-	if verifyOn != "source" && verifyOn != "target" {
+	if cfg.VerifyOn != "source" && cfg.VerifyOn != "target" {
 		return nil, errors.New("verify-on must be either source or target")
 	}
+	// Repair copies source items to the target, so it only follows up on target failures
+	if cfg.VerifyOn == "source" && (cfg.Repair || cfg.RepairDryRun) {
+		return nil, errors.New("repair requires verify-on target, it copies items from the source table to the target table")
+	}
+
+	// Validate role session duration, STS accepts 15 minutes to 12 hours
+	if cfg.RoleDuration < 15*time.Minute || cfg.RoleDuration > 12*time.Hour {
+		return nil, errors.New("role-duration must be between 15m and 12h")
+	}
+
+	// Validate validation tuning parameters
+	if vc.BufferSize <= 0 || vc.ChannelSize <= 0 || vc.BatchSize <= 0 {
+		return nil, errors.New("validation-buffer-size, validation-channel-size and batch-size must be greater than 0")
+	}
+	if vc.ValidationInterval <= 0 || vc.StatsInterval <= 0 {
+		return nil, errors.New("validation-interval and stats-interval must be greater than 0")
+	}
+	if vc.ReplicationWaitTime < 0 || vc.RetryWaitTime < 0 {
+		return nil, errors.New("replication-wait-time and retry-wait-time must not be negative")
+	}
+
+	// If stream-profile is not set, use source-profile and source-role-arn
+	if cfg.StreamProfile == "" {
+		cfg.StreamProfile = cfg.SourceProfile
+		cfg.sources["stream-profile"] = "source-profile"
+		if cfg.StreamRoleArn == "" && cfg.SourceRoleArn != "" {
+			cfg.StreamRoleArn = cfg.SourceRoleArn
+			cfg.sources["stream-role-arn"] = "source-role-arn"
+		}
+		if cfg.StreamAccountID == "" && cfg.SourceAccountID != "" {
+			cfg.StreamAccountID = cfg.SourceAccountID
+			cfg.sources["stream-account-id"] = "source-account-id"
+		}
+	}
+
+	// If stream-endpoint is not set, use source-endpoint
+	if cfg.StreamEndpoint == "" && cfg.SourceEndpoint != "" {
+		cfg.StreamEndpoint = cfg.SourceEndpoint
+		cfg.sources["stream-endpoint"] = "source-endpoint"
+	}
+
+	// If source-table is not set, use target-table
+	if cfg.SourceTable == "" && cfg.TargetTable != "" {
+		cfg.SourceTable = cfg.TargetTable
+		cfg.sources["source-table"] = "target-table"
+	}
+
+	// A dry run implies repair mode
+	if cfg.RepairDryRun {
+		cfg.Repair = true
+	}
+
+	// Fill in and validate the tables of a multi-table run
+	if len(cfg.Tables) > 0 {
+		if cfg.StreamArn != "" {
+			return nil, errors.New("stream-arn cannot be combined with tables, set stream_arn on each table instead")
+		}
+		if err := cfg.resolveTables(); err != nil {
+			return nil, err
+		}
+	}
+
+	return cfg, nil
+}
+
+// SamplePlan returns the sample rate planning settings, or nil if planning is disabled
+func (c *CommandFlags) SamplePlan() *SamplePlanConfig {
+	if c.TargetMargin == 0 {
+		return nil
+	}
+	return &SamplePlanConfig{
+		TargetMargin:      c.TargetMargin,
+		ExpectedEventRate: c.ExpectedEventRate,
+		Window:            c.PlanWindow,
+	}
+}
+
+// AdaptiveSampling returns the adaptive sampling settings, or nil if it is disabled
+func (c *CommandFlags) AdaptiveSampling() *AdaptiveSamplingConfig {
+	if c.AdaptiveFailureThreshold == 0 {
+		return nil
+	}
+	return &AdaptiveSamplingConfig{
+		FailureThreshold: c.AdaptiveFailureThreshold,
+		Window:           c.AdaptiveWindow,
+		PrefixDelimiter:  c.AdaptivePrefixDelimiter,
+	}
+}
+
+// EventFilter returns the compiled event filters, or nil if no filter is set
+func (c *CommandFlags) EventFilter() (*EventFilter, error) {
+	filterCfg := EventFilterConfig{
+		IncludeKeyPrefixes: splitList(c.IncludeKeyPrefix),
+		ExcludeKeyPrefixes: splitList(c.ExcludeKeyPrefix),
+		IncludeKeyPattern:  c.IncludeKeyPattern,
+		ExcludeKeyPattern:  c.ExcludeKeyPattern,
+		EventTypes:         splitList(c.EventTypes),
+	}
+
+	var err error
+	if c.EventsAfter != "" {
+		if filterCfg.After, err = time.Parse(time.RFC3339, c.EventsAfter); err != nil {
+			return nil, fmt.Errorf("events-after must be an RFC 3339 time: %w", err)
+		}
+	}
+	if c.EventsBefore != "" {
+		if filterCfg.Before, err = time.Parse(time.RFC3339, c.EventsBefore); err != nil {
+			return nil, fmt.Errorf("events-before must be an RFC 3339 time: %w", err)
+		}
+	}
+
+	if filterCfg.IsZero() {
+		return nil, nil
+	}
+	return NewEventFilter(filterCfg)
+}
+
+// ParsedTransform returns the parsed transform, or nil if none is set
+func (c *CommandFlags) ParsedTransform() (*Transform, error) {
+	if strings.TrimSpace(c.Transform) == "" {
+		return nil, nil
+	}
+	return ParseTransform(c.Transform)
+}
+
+// registerCompareFlags registers the attribute comparison flags, shared with verify-keys
+func registerCompareFlags(fs *flag.FlagSet, cfg *CommandFlags) {
+	fs.BoolVar(&cfg.CompareItems, "compare-items", false, "Compare the attributes of the verified item with the stream image, not only its existence (optional)")
+	fs.StringVar(&cfg.IgnoreAttributes, "ignore-attributes", "", "Comma-separated attributes not compared, implies compare-items (optional)")
+	fs.StringVar(&cfg.RenameAttributes, "rename-attributes", "", "Comma-separated stream=verified attribute names, e.g. userName=user_name, implies compare-items (optional)")
+	fs.StringVar(&cfg.NumericTolerance, "numeric-tolerance", "", "Comma-separated attribute=tolerance of numbers, e.g. price=0.01, implies compare-items (optional)")
+	fs.StringVar(&cfg.TimeTolerance, "time-tolerance", "", "Comma-separated attribute=duration of RFC 3339 or epoch timestamps, e.g. updatedAt=2s, implies compare-items (optional)")
+	fs.StringVar(&cfg.CoerceAttributes, "coerce-attributes", "", "Comma-separated attribute=S or attribute=N compared after converting both values, implies compare-items (optional)")
+}
+
+// CompareRules returns the attribute comparison rules, or nil if items are only checked
+// for existence
+func (c *CommandFlags) CompareRules() (*CompareRules, error) {
+	rules := &CompareRules{Ignore: splitList(c.IgnoreAttributes)}
+
+	var err error
+	if rules.Rename, err = splitPairs(c.RenameAttributes, "rename-attributes"); err != nil {
+		return nil, err
+	}
+	if rules.Coerce, err = splitPairs(c.CoerceAttributes, "coerce-attributes"); err != nil {
+		return nil, err
+	}
+	for name, coerce := range rules.Coerce {
+		rules.Coerce[name] = strings.ToUpper(coerce)
+	}
+
+	numeric, err := splitPairs(c.NumericTolerance, "numeric-tolerance")
+	if err != nil {
+		return nil, err
+	}
+	if numeric != nil {
+		rules.NumericTolerance = make(map[string]float64, len(numeric))
+	}
+	for name, value := range numeric {
+		if rules.NumericTolerance[name], err = strconv.ParseFloat(value, 64); err != nil {
+			return nil, fmt.Errorf("numeric-tolerance of %s must be a number: %w", name, err)
+		}
+	}
+
+	timestamps, err := splitPairs(c.TimeTolerance, "time-tolerance")
+	if err != nil {
+		return nil, err
+	}
+	if timestamps != nil {
+		rules.TimeTolerance = make(map[string]time.Duration, len(timestamps))
+	}
+	for name, value := range timestamps {
+		if rules.TimeTolerance[name], err = time.ParseDuration(value); err != nil {
+			return nil, fmt.Errorf("time-tolerance of %s must be a duration: %w", name, err)
+		}
+	}
+
+	if !c.CompareItems && len(rules.Ignore) == 0 && rules.Rename == nil && rules.Coerce == nil &&
+		rules.NumericTolerance == nil && rules.TimeTolerance == nil {
+		return nil, nil
+	}
+	if err := rules.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid compare rules: %w", err)
+	}
+	return rules, nil
+}
+
+// splitPairs splits a comma-separated list of name=value pairs, or returns nil if s is empty
+func splitPairs(s, flagName string) (map[string]string, error) {
+	var pairs map[string]string
+	for _, item := range splitList(s) {
+		name, value, ok := strings.Cut(item, "=")
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		if !ok || name == "" || value == "" {
+			return nil, fmt.Errorf("%s must be a comma-separated list of name=value, got %q", flagName, item)
+		}
+		if pairs == nil {
+			pairs = make(map[string]string)
+		}
+		pairs[name] = value
+	}
+	return pairs, nil
+}
+
+// splitList splits a comma-separated list, dropping empty entries
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// ClientConfig returns the configuration of the source, target and stream clients
+func (c *CommandFlags) ClientConfig() ClientConfig {
+	return ClientConfig{
+		SourceProfile: c.SourceProfile,
+		TargetProfile: c.TargetProfile,
+		StreamProfile: c.StreamProfile,
+		Region:        c.Region,
+		SourceRegion:  c.SourceRegion,
+		TargetRegion:  c.TargetRegion,
+		StreamRegion:  c.StreamRegion,
+		StreamArn:     c.clientStreamArn(),
+
+		SourceRoleArn:   c.SourceRoleArn,
+		TargetRoleArn:   c.TargetRoleArn,
+		StreamRoleArn:   c.StreamRoleArn,
+		RoleExternalID:  c.RoleExternalID,
+		RoleSessionName: c.RoleSessionName,
+		RoleDuration:    c.RoleDuration,
+
+		SourceEndpoint: c.SourceEndpoint,
+		TargetEndpoint: c.TargetEndpoint,
+		StreamEndpoint: c.StreamEndpoint,
+
+		StrictCredentials: c.StrictCredentials,
+		SourceAccountID:   c.SourceAccountID,
+		TargetAccountID:   c.TargetAccountID,
+		StreamAccountID:   c.StreamAccountID,
+	}
+}
+
+// clientStreamArn returns the stream ARN used to infer the source and stream regions. All
+// tables of a multi-table run share the same clients, so their streams share a region.
+func (c *CommandFlags) clientStreamArn() string {
+	if len(c.Tables) > 0 {
+		return c.Tables[0].StreamArn
+	}
+	return c.StreamArn
+}
+
+// TableConfigs returns the tables to work on: the tables of the config file, or the single
+// table given by the top-level options, or nil if no table is set. The stream ARN of the
+// single table may be empty.
+func (c *CommandFlags) TableConfigs() []TableConfig {
+	if len(c.Tables) > 0 {
+		return c.Tables
+	}
+	if c.TargetTable == "" {
+		return nil
+	}
+	return []TableConfig{{
+		Name:                   c.TargetTable,
+		StreamArn:              c.StreamArn,
+		SourceTable:            c.SourceTable,
+		TargetTable:            c.TargetTable,
+		PartitionKey:           c.PartitionKey,
+		SortKey:                c.SortKey,
+		SampleRate:             c.SampleRate,
+		IteratorType:           c.IteratorType,
+		VerifyOn:               c.VerifyOn,
+		RepairVersionAttribute: c.RepairVersionAttribute,
+	}}
+}
+
+// resolveTables fills the empty fields of every table from the top-level options and
+// validates the result
+func (c *CommandFlags) resolveTables() error {
+	names := make(map[string]struct{}, len(c.Tables))
+	for i := range c.Tables {
+		t := &c.Tables[i]
 
-	// If stream-profile is not set, use source-profile
-	streamProfile := *streamProfilePtr
-	if streamProfile == "" {
-		streamProfile = *sourceProfilePtr
-	}
-
-	return &CommandFlags{
-		SourceProfile: *sourceProfilePtr,
-		TargetProfile: *targetProfilePtr,
-		StreamProfile: streamProfile,
-		StreamArn:     *streamArnPtr,
-		TargetTable:   *targetTablePtr,
-		PartitionKey:  *partitionKeyPtr,
-		SortKey:       *sortKeyPtr,
-		Region:        *regionPtr,
-		SampleRate:    *sampleRatePtr,
-		IteratorType:  iteratorType,
-		VerifyOn:      verifyOn,
-		Verbose:       *verbosePtr,
-	}, nil
+		// Inherit top-level options
+		if t.PartitionKey == "" {
+			t.PartitionKey = c.PartitionKey
+		}
+		if t.SortKey == "" {
+			t.SortKey = c.SortKey
+		}
+		if t.SampleRate == 0 {
+			t.SampleRate = c.SampleRate
+		}
+		if t.IteratorType == "" {
+			t.IteratorType = c.IteratorType
+		}
+		if t.VerifyOn == "" {
+			t.VerifyOn = c.VerifyOn
+		}
+		if t.RepairVersionAttribute == "" {
+			t.RepairVersionAttribute = c.RepairVersionAttribute
+		}
+		if t.Transform == "" {
+			t.Transform = c.Transform
+		}
+		if t.SourceTable == "" {
+			t.SourceTable = t.TargetTable
+		}
+		if t.Name == "" {
+			t.Name = t.TargetTable
+		}
+
+		// Validate the table
+		if t.StreamArn == "" || t.TargetTable == "" || t.PartitionKey == "" {
+			return fmt.Errorf("table %d: stream_arn, target_table and partition_key are required", i+1)
+		}
+		if t.SampleRate <= 0 {
+			return fmt.Errorf("table %s: sample_rate must be greater than 0", t.Name)
+		}
+		if t.IteratorType != "LATEST" && t.IteratorType != "TRIM_HORIZON" {
+			return fmt.Errorf("table %s: iterator_type must be either LATEST or TRIM_HORIZON", t.Name)
+		}
+		if t.VerifyOn != "source" && t.VerifyOn != "target" {
+			return fmt.Errorf("table %s: verify_on must be either source or target", t.Name)
+		}
+		if t.VerifyOn == "source" && (c.Repair || c.RepairDryRun) {
+			return fmt.Errorf("table %s: repair requires verify_on target", t.Name)
+		}
+		if t.Transform != "" {
+			transform, err := ParseTransform(t.Transform)
+			if err != nil {
+				return fmt.Errorf("table %s: %w", t.Name, err)
+			}
+			t.ParsedTransform = transform
+			if t.VerifyOn != "target" || c.Repair || c.RepairDryRun {
+				return fmt.Errorf("table %s: transform requires verify_on target and cannot be combined with repair", t.Name)
+			}
+		}
+		if RegionFromArn(t.StreamArn) != RegionFromArn(c.Tables[0].StreamArn) {
+			return fmt.Errorf("table %s: all streams must be in the same region, run one monitor per region", t.Name)
+		}
+		if _, ok := names[t.Name]; ok {
+			return fmt.Errorf("table %s is listed more than once, set a unique name", t.Name)
+		}
+		names[t.Name] = struct{}{}
+	}
+	return nil
 }
@@ -0,0 +1,114 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// The preflight, drift and clone commands accept the same flags and config file as the
+// monitor, so they work on exactly the tables the monitor will run against
+
+// runPreflightCommand validates permissions, streams and key schemas before a run
+func runPreflightCommand(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	migrationDuration := fs.Duration("migration-duration", 0, "Expected time until the monitor has caught up, compared with the 24h stream retention (optional)")
+
+	cmdFlags, clients, err := parseClientFlags(ctx, fs, args, defaultCommandFlags())
+	if err != nil {
+		return err
+	}
+
+	tables := cmdFlags.TableConfigs()
+	if len(tables) == 0 {
+		return errors.New("nothing to check: set stream-arn and target-table or list tables in the config file")
+	}
+
+	report := RunPreflight(ctx, PreflightConfig{
+		Clients:           clients,
+		Tables:            tables,
+		MigrationDuration: *migrationDuration,
+	})
+
+	if report.Count(PreflightFail) > 0 {
+		return errCommandFailed
+	}
+	return nil
+}
+
+// runDriftCommand compares the configuration of the source and target tables
+func runDriftCommand(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	ignore := fs.String("ignore", "", "Comma-separated drift categories to leave out, e.g. tags,capacity (optional)")
+
+	cmdFlags, clients, err := parseClientFlags(ctx, fs, args, defaultCommandFlags())
+	if err != nil {
+		return err
+	}
+
+	tables := cmdFlags.TableConfigs()
+	if len(tables) == 0 {
+		return errors.New("nothing to compare: set target-table or list tables in the config file")
+	}
+
+	var ignored []string
+	if *ignore != "" {
+		ignored = strings.Split(*ignore, ",")
+	}
+
+	results := RunDriftCheck(ctx, DriftConfig{
+		SourceClient: clients.SourceClient,
+		TargetClient: clients.TargetClient,
+		Tables:       tables,
+		Ignore:       ignored,
+	})
+
+	for _, r := range results {
+		if r.Error != "" || len(r.Drifts) > 0 {
+			return errCommandFailed
+		}
+	}
+	return nil
+}
+
+// runCloneCommand creates every target table from its source table definition
+func runCloneCommand(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	billingMode := fs.String("billing-mode", "", "Billing mode of the new table: PAY_PER_REQUEST or PROVISIONED (optional, defaults to the source billing mode)")
+	readCapacity := fs.Int64("read-capacity", 0, "Read capacity units of the table and every GSI when provisioned (optional, defaults to the source capacity)")
+	writeCapacity := fs.Int64("write-capacity", 0, "Write capacity units of the table and every GSI when provisioned (optional, defaults to the source capacity)")
+	waitTimeout := fs.Duration("wait-timeout", 0, "How long to wait for the table to become ACTIVE (optional, defaults to 10m)")
+
+	cmdFlags, clients, err := parseClientFlags(ctx, fs, args, defaultCommandFlags())
+	if err != nil {
+		return err
+	}
+
+	tables := cmdFlags.TableConfigs()
+	if len(tables) == 0 {
+		return errors.New("nothing to clone: set target-table or list tables in the config file")
+	}
+
+	failed := false
+	for _, table := range tables {
+		err := CloneTableSchema(ctx, CloneConfig{
+			SourceClient:  clients.SourceClient,
+			TargetClient:  clients.TargetClient,
+			SourceTable:   table.SourceTable,
+			TargetTable:   table.TargetTable,
+			BillingMode:   *billingMode,
+			ReadCapacity:  *readCapacity,
+			WriteCapacity: *writeCapacity,
+			WaitTimeout:   *waitTimeout,
+		})
+		if err != nil {
+			log.Errorf("❌ [CLONE] %v", err)
+			failed = true
+		}
+	}
+
+	if failed {
+		return errCommandFailed
+	}
+	return nil
+}
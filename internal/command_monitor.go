@@ -0,0 +1,127 @@
+package internal
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// runMonitorCommand verifies the stream events of one table, or of every table in the
+// config file, against the source or target table
+func runMonitorCommand(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	cmdFlags, clients, err := parseClientFlags(ctx, fs, args, defaultCommandFlags())
+	if err != nil {
+		return err
+	}
+	clients.LogIdentities()
+
+	// Nothing to monitor without a stream
+	if cmdFlags.StreamArn == "" && len(cmdFlags.Tables) == 0 {
+		return nil
+	}
+
+	// Open the dead-letter file for failed validations, shared by every table
+	var failureLog *FailureLog
+	if cmdFlags.FailureFile != "" {
+		failureLog, err = OpenFailureLog(cmdFlags.FailureFile)
+		if err != nil {
+			return fmt.Errorf("failed to open failure file: %w", err)
+		}
+		defer failureLog.Close()
+		log.Infof("Failed validations will be written to %s", cmdFlags.FailureFile)
+	}
+
+	// Set up the repair settings if repair mode is enabled
+	var repairCfg *RepairConfig
+	if cmdFlags.Repair {
+		var auditLog *AuditLog
+		if cmdFlags.RepairAuditFile != "" {
+			auditLog, err = OpenAuditLog(cmdFlags.RepairAuditFile)
+			if err != nil {
+				return fmt.Errorf("failed to open repair audit file: %w", err)
+			}
+			defer auditLog.Close()
+		}
+
+		repairCfg = &RepairConfig{
+			SourceClient:     clients.SourceClient,
+			TargetClient:     clients.TargetClient,
+			SourceTable:      cmdFlags.SourceTable,
+			TargetTable:      cmdFlags.TargetTable,
+			PartitionKey:     cmdFlags.PartitionKey,
+			VersionAttribute: cmdFlags.RepairVersionAttribute,
+			DryRun:           cmdFlags.RepairDryRun,
+			WritesPerSecond:  cmdFlags.RepairRate,
+			AuditLog:         auditLog,
+		}
+		log.Infof("Repair mode enabled (dry run: %t, rate: %.1f writes/sec)", cmdFlags.RepairDryRun, cmdFlags.RepairRate)
+	}
+
+	eventFilter, err := cmdFlags.EventFilter()
+	if err != nil {
+		return err
+	}
+	compareRules, err := cmdFlags.CompareRules()
+	if err != nil {
+		return err
+	}
+	transform, err := cmdFlags.ParsedTransform()
+	if err != nil {
+		return err
+	}
+
+	verifyCfg := StreamVerificationConfig{
+		SourceClient: clients.SourceClient,
+		TargetClient: clients.TargetClient,
+		StreamClient: clients.StreamClient,
+		StreamArn:    cmdFlags.StreamArn,
+		SourceTable:  cmdFlags.SourceTable,
+		TargetTable:  cmdFlags.TargetTable,
+		SampleRate:   cmdFlags.SampleRate,
+		SampleSalt:   cmdFlags.SampleSalt,
+		Confidence:   cmdFlags.Confidence,
+		PartitionKey: cmdFlags.PartitionKey,
+		SortKey:      cmdFlags.SortKey,
+		IteratorType: cmdFlags.IteratorType,
+		VerifyOn:     cmdFlags.VerifyOn,
+		Verbose:      cmdFlags.Verbose,
+		FailureLog:   failureLog,
+
+		LookupAttributes:  splitList(cmdFlags.LookupAttributes),
+		ReadBudget:        cmdFlags.ReadBudget,
+		ConsistentRead:    cmdFlags.ConsistentRead,
+		ConsistentRecheck: cmdFlags.ConsistentRecheck,
+		SamplePlan:        cmdFlags.SamplePlan(),
+		AdaptiveSampling:  cmdFlags.AdaptiveSampling(),
+		EventFilter:       eventFilter,
+		CompareRules:      compareRules,
+		Transform:         transform,
+		ValidationConfig:  cmdFlags.ValidationConfig,
+	}
+
+	// Monitor every table listed in the config file on the same clients
+	if len(cmdFlags.Tables) > 0 {
+		summaries := RunMultiTableVerification(ctx, MultiTableConfig{
+			Base:   verifyCfg,
+			Tables: cmdFlags.Tables,
+			Repair: repairCfg,
+		})
+		for _, summary := range summaries {
+			if summary.StopErr != nil {
+				return errCommandFailed
+			}
+		}
+		return nil
+	}
+
+	// Run the stream-based verification process
+	if repairCfg != nil {
+		verifyCfg.Repairer = NewRepairer(*repairCfg)
+	}
+	if summary := RunStreamStyleVerification(ctx, &verifyCfg); summary.StopErr != nil {
+		return fmt.Errorf("verification stopped: %w", summary.StopErr)
+	}
+	return nil
+}
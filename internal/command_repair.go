@@ -0,0 +1,185 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	log "github.com/sirupsen/logrus"
+)
+
+// runRepairCommand copies the items of a failure file or keys file from the source table
+// to the target table
+func runRepairCommand(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	keysFile := fs.String("keys-file", "", "CSV file with the keys to repair, such as the one written by gen (optional)")
+
+	// The monitor's failure-file is read here instead of written
+	cmdFlags, clients, err := parseClientFlags(ctx, fs, args, defaultCommandFlags())
+	if err != nil {
+		return err
+	}
+	if len(cmdFlags.Tables) > 0 {
+		return errors.New("repair works on a single table, tables in the config file are not supported")
+	}
+	if cmdFlags.TargetTable == "" || cmdFlags.PartitionKey == "" {
+		return errors.New("target-table and partition-key are required")
+	}
+
+	records, err := readKeyRecords(cmdFlags.FailureFile, *keysFile, cmdFlags.TargetTable, cmdFlags.PartitionKey, cmdFlags.SortKey)
+	if err != nil {
+		return err
+	}
+
+	// A multi-table monitor writes the failures of every table to the same file
+	records, skipped := tableRecords(records, cmdFlags.SourceTable, cmdFlags.TargetTable)
+	if skipped > 0 {
+		log.Warnf("Skipped %d records of other tables than %s ⚠️", skipped, cmdFlags.TargetTable)
+	}
+	if len(records) == 0 && skipped > 0 {
+		return fmt.Errorf("no record of %s is for table %s", cmdFlags.FailureFile, cmdFlags.TargetTable)
+	}
+
+	// The repairer copies items as is, it cannot reshape them like the transform did
+	for _, rec := range records {
+		if rec.ExpectedKey != nil {
+			return fmt.Errorf("event %s was verified with a transform, repair only copies items with the same key", rec.EventID)
+		}
+	}
+
+	var auditLog *AuditLog
+	if cmdFlags.RepairAuditFile != "" {
+		auditLog, err = OpenAuditLog(cmdFlags.RepairAuditFile)
+		if err != nil {
+			return fmt.Errorf("failed to open repair audit file: %w", err)
+		}
+		defer auditLog.Close()
+	}
+
+	if cmdFlags.RepairDryRun {
+		log.Info("DRY RUN: No items will be written")
+	}
+
+	repairer := NewRepairer(RepairConfig{
+		SourceClient:     clients.SourceClient,
+		TargetClient:     clients.TargetClient,
+		SourceTable:      cmdFlags.SourceTable,
+		TargetTable:      cmdFlags.TargetTable,
+		PartitionKey:     cmdFlags.PartitionKey,
+		VersionAttribute: cmdFlags.RepairVersionAttribute,
+		DryRun:           cmdFlags.RepairDryRun,
+		WritesPerSecond:  cmdFlags.RepairRate,
+		AuditLog:         auditLog,
+	})
+
+	keys := make([]map[string]types.AttributeValue, 0, len(records))
+	for _, rec := range records {
+		keys = append(keys, rec.Key)
+	}
+
+	summary, err := RunRepair(ctx, repairer, keys)
+	if err != nil {
+		return fmt.Errorf("repair interrupted: %w", err)
+	}
+
+	if summary[RepairOutcomeError] > 0 {
+		return errCommandFailed
+	}
+	return nil
+}
+
+// runVerifyKeysCommand checks that the items of a failure file or keys file exist in a table
+func runVerifyKeysCommand(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	table := registerTableFlags(fs)
+	failureFile := fs.String("failure-file", "", "Failure file written by the monitor with --failure-file (optional)")
+	keysFile := fs.String("keys-file", "", "CSV file with the keys to verify, such as the one written by gen (optional)")
+	output := fs.String("output", "", "File to write the records that still fail to (optional)")
+	verbose := fs.Bool("verbose", false, "Show success validation logs (optional, defaults to false)")
+	compare := &CommandFlags{}
+	registerCompareFlags(fs, compare)
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *keysFile != "" && table.Table == "" {
+		return errors.New("table is required with keys-file")
+	}
+	rules, err := compare.CompareRules()
+	if err != nil {
+		return err
+	}
+
+	// The table of a failure file defaults to the one recorded in each entry
+	records, err := readKeyRecords(*failureFile, *keysFile, table.Table, table.PartitionKey, table.SortKey)
+	if err != nil {
+		return err
+	}
+
+	client, err := table.client(ctx)
+	if err != nil {
+		return err
+	}
+
+	result, err := RunFailureReplay(ctx, &FailureReplayConfig{
+		Client:       client,
+		Records:      records,
+		OutputFile:   *output,
+		TableName:    table.Table,
+		CompareRules: rules,
+		WaitTime:     table.Wait,
+		Verbose:      *verbose,
+	})
+	if err != nil {
+		return fmt.Errorf("verification failed: %w", err)
+	}
+
+	if result.Remaining > 0 {
+		return errCommandFailed
+	}
+	return nil
+}
+
+// tableRecords returns the records verified against the target table, or against the
+// source table with verify-on source, and the number of other records
+func tableRecords(records []FailureRecord, sourceTable, targetTable string) ([]FailureRecord, int) {
+	kept := make([]FailureRecord, 0, len(records))
+	for _, rec := range records {
+		table := targetTable
+		if rec.VerifyOn == "source" {
+			table = sourceTable
+		}
+		if rec.Table == table {
+			kept = append(kept, rec)
+		}
+	}
+	return kept, len(records) - len(kept)
+}
+
+// readKeyRecords reads the keys to work on from exactly one of a failure file or a keys
+// file. Keys read from a keys file are recorded against the given table.
+func readKeyRecords(failureFile, keysFile, table, partitionKey, sortKey string) ([]FailureRecord, error) {
+	if (failureFile == "") == (keysFile == "") {
+		return nil, errors.New("exactly one of failure-file or keys-file is required")
+	}
+
+	if failureFile != "" {
+		records, err := ReadFailureRecords(failureFile)
+		if err != nil {
+			return nil, err
+		}
+		log.Infof("Found %d failure records in %s", len(records), failureFile)
+		return records, nil
+	}
+
+	keys, err := ReadKeyFile(keysFile, partitionKey, sortKey)
+	if err != nil {
+		return nil, err
+	}
+	records := make([]FailureRecord, 0, len(keys))
+	for _, key := range keys {
+		records = append(records, FailureRecord{Table: table, Key: key})
+	}
+	log.Infof("Found %d keys in %s", len(records), keysFile)
+	return records, nil
+}
@@ -0,0 +1,74 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// runReplicateCommand replicates the source stream to the target table until interrupted,
+// saving the checkpoint before it exits
+func runReplicateCommand(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	versionAttribute := fs.String("version-attribute", "", "Attribute that increases on every write, used to never overwrite a newer target version (required unless unconditional-writes is set)")
+	unconditional := fs.Bool("unconditional-writes", false, "Write without a version condition, a newer target item can be overwritten (optional)")
+	checkpointFile := fs.String("checkpoint-file", "", "File that stores per-shard progress, used to resume after a restart (optional)")
+	checkpointInterval := fs.Duration("checkpoint-interval", 10*time.Second, "How often to save the checkpoint file (optional, defaults to 10s)")
+	rate := fs.Float64("rate", 0, "Maximum writes per second, 0 for unlimited (optional)")
+	maxShards := fs.Int("max-shards", 5, "Maximum number of shards replicated at the same time (optional, defaults to 5)")
+
+	// Without a checkpoint, replicate the whole retained stream
+	defaults := defaultCommandFlags()
+	defaults.IteratorType = "TRIM_HORIZON"
+
+	cmdFlags, clients, err := parseClientFlags(ctx, fs, args, defaults)
+	if err != nil {
+		return err
+	}
+	clients.LogIdentities()
+	if cmdFlags.StreamArn == "" {
+		return errors.New("stream-arn is required")
+	}
+	if len(cmdFlags.Tables) > 0 {
+		return errors.New("replicate works on a single stream, tables in the config file are not supported")
+	}
+	if *versionAttribute == "" && !*unconditional {
+		return errors.New("version-attribute is required to never overwrite a newer target item, set unconditional-writes to write without a condition")
+	}
+	if *versionAttribute == "" {
+		log.Warn("[REPLICATE] No version attribute, every write overwrites the target item ⚠️")
+	}
+	if *checkpointFile == "" {
+		log.Warn("No checkpoint file specified, replication will restart from the iterator type after a restart")
+	}
+
+	stats, err := RunReplication(ctx, &ReplicatorConfig{
+		SourceClient:        clients.SourceClient,
+		TargetClient:        clients.TargetClient,
+		StreamClient:        clients.StreamClient,
+		StreamArn:           cmdFlags.StreamArn,
+		SourceTable:         cmdFlags.SourceTable,
+		TargetTable:         cmdFlags.TargetTable,
+		PartitionKey:        cmdFlags.PartitionKey,
+		VersionAttribute:    *versionAttribute,
+		UnconditionalWrites: *unconditional,
+		IteratorType:        cmdFlags.IteratorType,
+		CheckpointFile:      *checkpointFile,
+		CheckpointInterval:  *checkpointInterval,
+		WritesPerSecond:     *rate,
+		MaxConcurrentShards: *maxShards,
+		BatchSize:           cmdFlags.ValidationConfig.BatchSize,
+		StatsInterval:       cmdFlags.ValidationConfig.StatsInterval,
+	})
+	if err != nil {
+		return fmt.Errorf("replication failed: %w", err)
+	}
+
+	if stats.Errors > 0 {
+		return errCommandFailed
+	}
+	return nil
+}
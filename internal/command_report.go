@@ -0,0 +1,36 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"os"
+)
+
+// runReportCommand summarizes a failure file written by the monitor or scan-compare
+func runReportCommand(_ context.Context, fs *flag.FlagSet, args []string) error {
+	failureFile := fs.String("failure-file", "", "Failure file written by monitor or scan-compare")
+	asJSON := fs.Bool("json", false, "Print the report as JSON to stdout instead of logging it (optional)")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *failureFile == "" {
+		return errors.New("failure-file is required")
+	}
+
+	records, err := ReadFailureRecords(*failureFile)
+	if err != nil {
+		return err
+	}
+	report := BuildFailureReport(records)
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	}
+	LogFailureReport(report)
+	return nil
+}
@@ -0,0 +1,87 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// runScanCompareCommand scans the source table and checks that every item exists in the
+// target table, and matches it when comparing attributes
+func runScanCompareCommand(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	segments := fs.Int("segments", 1, "Number of scan segments read in parallel (optional, defaults to 1)")
+	pageSize := fs.Int("page-size", 0, "Items per Scan call, 0 for up to 1 MB of items (optional)")
+	scanBudget := fs.Float64("scan-budget", 0, "Maximum read capacity units per second of the source scan, 0 for unlimited (optional)")
+
+	// The items are always checked in the target table, which the transform requires. Every
+	// key is compared unless sample-rate is set, e.g. to the monitor's rate to check its keys.
+	defaults := defaultCommandFlags()
+	defaults.VerifyOn = "target"
+	defaults.SampleRate = 1
+
+	// The monitor's failure-file, transform, compare rules and read-budget apply to the scan
+	cmdFlags, clients, err := parseClientFlags(ctx, fs, args, defaults)
+	if err != nil {
+		return err
+	}
+	if len(cmdFlags.Tables) > 0 {
+		return errors.New("scan-compare works on a single table, tables in the config file are not supported")
+	}
+	if cmdFlags.TargetTable == "" || cmdFlags.PartitionKey == "" {
+		return errors.New("target-table and partition-key are required")
+	}
+	if *segments < 1 || *pageSize < 0 || *scanBudget < 0 {
+		return errors.New("segments must be at least 1, page-size and scan-budget must not be negative")
+	}
+
+	transform, err := cmdFlags.ParsedTransform()
+	if err != nil {
+		return err
+	}
+	compareRules, err := cmdFlags.CompareRules()
+	if err != nil {
+		return err
+	}
+
+	var failureLog *FailureLog
+	if cmdFlags.FailureFile != "" {
+		failureLog, err = OpenFailureLog(cmdFlags.FailureFile)
+		if err != nil {
+			return fmt.Errorf("failed to open failure file: %w", err)
+		}
+		defer failureLog.Close()
+		log.Infof("Items that do not match will be written to %s", cmdFlags.FailureFile)
+	}
+
+	result, err := RunScanCompare(ctx, &ScanCompareConfig{
+		SourceClient:   clients.SourceClient,
+		TargetClient:   clients.TargetClient,
+		SourceTable:    cmdFlags.SourceTable,
+		TargetTable:    cmdFlags.TargetTable,
+		PartitionKey:   cmdFlags.PartitionKey,
+		SortKey:        cmdFlags.SortKey,
+		SampleRate:     cmdFlags.SampleRate,
+		SampleSalt:     cmdFlags.SampleSalt,
+		Segments:       *segments,
+		PageSize:       int32(*pageSize),
+		ScanBudget:     *scanBudget,
+		ReadBudget:     cmdFlags.ReadBudget,
+		ConsistentRead: cmdFlags.ConsistentRead,
+		Transform:      transform,
+		CompareRules:   compareRules,
+		FailureLog:     failureLog,
+		StatsInterval:  cmdFlags.ValidationConfig.StatsInterval,
+		Verbose:        cmdFlags.Verbose,
+	})
+	if err != nil {
+		return fmt.Errorf("scan interrupted: %w", err)
+	}
+
+	if result.Failed() > 0 {
+		return errCommandFailed
+	}
+	return nil
+}
@@ -0,0 +1,251 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	log "github.com/sirupsen/logrus"
+)
+
+// maxBatchWriteItems is the maximum number of requests in one BatchWriteItem call
+const maxBatchWriteItems = 25
+
+// runGenCommand writes random test items to a table and records their keys to a CSV file
+func runGenCommand(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	table := registerTableFlags(fs)
+	count := fs.Int("count", 10, "Number of items to generate (optional, defaults to 10)")
+	batch := fs.Bool("batch", false, "Use batch writes, wait is ignored (optional)")
+	keysFile := fs.String("keys-file", "", "CSV file to save the generated keys to (optional)")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if table.Table == "" {
+		return errors.New("table is required")
+	}
+
+	client, err := table.client(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Generate and insert data
+	var keys []string
+	if *batch {
+		keys, err = generateBatchItems(ctx, client, table, *count)
+	} else {
+		keys, err = generateSingleItems(ctx, client, table, *count)
+	}
+
+	// Save the keys written so far, even if generation stopped early
+	if *keysFile != "" && len(keys) > 0 {
+		if saveErr := writeKeyFile(*keysFile, table, keys); saveErr != nil {
+			return saveErr
+		}
+		log.Infof("[GEN] Saved %d keys to %s", len(keys), *keysFile)
+	}
+	if err != nil {
+		return err
+	}
+
+	log.Infof("✅ [GEN] Generated %d items in table %s", len(keys), table.Table)
+	return nil
+}
+
+// generateSingleItems writes the items one at a time and returns their keys as CSV rows
+func generateSingleItems(ctx context.Context, client *dynamodb.Client, table *tableFlags, count int) ([]string, error) {
+	var keys []string
+	for i := 1; i <= count && ctx.Err() == nil; i++ {
+		item := createRandomItem(i, table)
+		_, err := client.PutItem(ctx, &dynamodb.PutItemInput{
+			TableName: aws.String(table.Table),
+			Item:      item,
+		})
+		if err != nil {
+			return keys, fmt.Errorf("failed to put item %d: %w", i, err)
+		}
+
+		key := itemKeyRow(item, table)
+		keys = append(keys, key)
+		log.Infof("[GEN] Added item %d: %s", i, key)
+
+		sleepContext(ctx, table.Wait)
+	}
+	return keys, ctx.Err()
+}
+
+// generateBatchItems writes the items in batches and returns their keys as CSV rows
+func generateBatchItems(ctx context.Context, client *dynamodb.Client, table *tableFlags, count int) ([]string, error) {
+	var keys []string
+	for i := 0; i < count && ctx.Err() == nil; i += maxBatchWriteItems {
+		size := min(maxBatchWriteItems, count-i)
+
+		writeRequests := make([]types.WriteRequest, 0, size)
+		batchKeys := make([]string, 0, size)
+		for j := 1; j <= size; j++ {
+			item := createRandomItem(i+j, table)
+			batchKeys = append(batchKeys, itemKeyRow(item, table))
+			writeRequests = append(writeRequests, types.WriteRequest{
+				PutRequest: &types.PutRequest{Item: item},
+			})
+		}
+
+		_, err := client.BatchWriteItem(ctx, &dynamodb.BatchWriteItemInput{
+			RequestItems: map[string][]types.WriteRequest{
+				table.Table: writeRequests,
+			},
+		})
+		if err != nil {
+			return keys, fmt.Errorf("failed to batch write items %d to %d: %w", i+1, i+size, err)
+		}
+
+		keys = append(keys, batchKeys...)
+		log.Infof("[GEN] Added items %d to %d", i+1, i+size)
+	}
+	return keys, ctx.Err()
+}
+
+// createRandomItem creates test item number num
+func createRandomItem(num int, table *tableFlags) map[string]types.AttributeValue {
+	r := rand.New(rand.NewSource(time.Now().UnixNano() + int64(num)))
+
+	item := map[string]types.AttributeValue{
+		table.PartitionKey: &types.AttributeValueMemberS{Value: fmt.Sprintf("TEST_PK_%d", num)},
+		"id":               &types.AttributeValueMemberN{Value: strconv.Itoa(num)},
+		"timestamp":        &types.AttributeValueMemberS{Value: time.Now().Format(time.RFC3339)},
+		"randomValue":      &types.AttributeValueMemberN{Value: strconv.Itoa(r.Intn(1000))},
+		"data":             &types.AttributeValueMemberS{Value: fmt.Sprintf("This is test data #%d", num)},
+	}
+	if table.SortKey != "" {
+		item[table.SortKey] = &types.AttributeValueMemberS{Value: fmt.Sprintf("TEST_SK_%d", num)}
+	}
+	return item
+}
+
+// itemKeyRow returns the key of a generated item as a keys file row
+func itemKeyRow(item map[string]types.AttributeValue, table *tableFlags) string {
+	row := item[table.PartitionKey].(*types.AttributeValueMemberS).Value
+	if table.SortKey != "" {
+		row += "," + item[table.SortKey].(*types.AttributeValueMemberS).Value
+	}
+	return row
+}
+
+// writeKeyFile writes the keys of generated items to a CSV file with a header row
+func writeKeyFile(path string, table *tableFlags, rows []string) error {
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create directory for keys file: %w", err)
+		}
+	}
+
+	header := table.PartitionKey
+	if table.SortKey != "" {
+		header += "," + table.SortKey
+	}
+
+	content := header + "\n" + strings.Join(rows, "\n") + "\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		return fmt.Errorf("failed to write keys file: %w", err)
+	}
+	return nil
+}
+
+// runDelCommand deletes the items listed in a keys file
+func runDelCommand(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	table := registerTableFlags(fs)
+	keysFile := fs.String("keys-file", "", "CSV file with the keys to delete, such as the one written by gen")
+	batch := fs.Bool("batch", false, "Use batch deletes, wait is ignored (optional)")
+	dryRun := fs.Bool("dry-run", false, "Log the deletes without deleting (optional)")
+	verbose := fs.Bool("verbose", false, "Log every delete (optional)")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if table.Table == "" || *keysFile == "" {
+		return errors.New("table and keys-file are required")
+	}
+
+	keys, err := ReadKeyFile(*keysFile, table.PartitionKey, table.SortKey)
+	if err != nil {
+		return err
+	}
+
+	client, err := table.client(ctx)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("[DEL] Found %d items to delete from file %s", len(keys), *keysFile)
+	if *dryRun {
+		log.Info("DRY RUN: No items will be deleted")
+	}
+
+	// Delete items, one batch at a time when batching
+	batchSize := 1
+	if *batch {
+		batchSize = maxBatchWriteItems
+	}
+
+	deleted, failed := 0, 0
+	for i := 0; i < len(keys) && ctx.Err() == nil; i += batchSize {
+		chunk := keys[i:min(i+batchSize, len(keys))]
+		if *verbose {
+			log.Infof("[DEL] Deleting items %d to %d", i+1, i+len(chunk))
+		}
+
+		if !*dryRun {
+			if err := deleteItems(ctx, client, table.Table, chunk); err != nil {
+				log.Errorf("❌ [DEL] Failed to delete items %d to %d: %v", i+1, i+len(chunk), err)
+				failed += len(chunk)
+				continue
+			}
+		}
+		deleted += len(chunk)
+
+		if !*batch {
+			sleepContext(ctx, table.Wait)
+		}
+	}
+
+	log.Infof("[DEL] Deleted %d/%d items from table %s", deleted, len(keys), table.Table)
+	if failed > 0 {
+		return errCommandFailed
+	}
+	return ctx.Err()
+}
+
+// deleteItems deletes one item, or up to 25 items in a single batch
+func deleteItems(ctx context.Context, client *dynamodb.Client, tableName string, keys []map[string]types.AttributeValue) error {
+	if len(keys) == 1 {
+		_, err := client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+			TableName: aws.String(tableName),
+			Key:       keys[0],
+		})
+		return err
+	}
+
+	writeRequests := make([]types.WriteRequest, 0, len(keys))
+	for _, key := range keys {
+		writeRequests = append(writeRequests, types.WriteRequest{
+			DeleteRequest: &types.DeleteRequest{Key: key},
+		})
+	}
+	_, err := client.BatchWriteItem(ctx, &dynamodb.BatchWriteItemInput{
+		RequestItems: map[string][]types.WriteRequest{
+			tableName: writeRequests,
+		},
+	})
+	return err
+}
@@ -0,0 +1,185 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	log "github.com/sirupsen/logrus"
+)
+
+// binaryName is the name of the binary shown in usage messages
+const binaryName = "dynamodb-migration-monitor"
+
+// defaultCommand runs when no subcommand is given
+const defaultCommand = "monitor"
+
+// errCommandFailed is returned by a command that ran to completion but found problems.
+// The command has already logged the details, so it only sets the exit status.
+var errCommandFailed = errors.New("command reported failures")
+
+// Command is one subcommand of the binary
+type Command struct {
+	Name    string
+	Summary string
+	Run     func(ctx context.Context, fs *flag.FlagSet, args []string) error
+}
+
+// commands lists every subcommand in the order shown by help
+var commands = []Command{
+	{Name: "monitor", Summary: "Verify stream events against the source or target table (default)", Run: runMonitorCommand},
+	{Name: "preflight", Summary: "Check permissions, streams and key schemas before a run", Run: runPreflightCommand},
+	{Name: "drift", Summary: "Compare the configuration of the source and target tables", Run: runDriftCommand},
+	{Name: "clone", Summary: "Create the target table from the source table definition", Run: runCloneCommand},
+	{Name: "replicate", Summary: "Replicate the source stream to the target table", Run: runReplicateCommand},
+	{Name: "scan-compare", Summary: "Scan the source table and check every item in the target table", Run: runScanCompareCommand},
+	{Name: "verify-keys", Summary: "Check that the items of a failure file or keys file exist", Run: runVerifyKeysCommand},
+	{Name: "repair", Summary: "Copy the items of a failure file or keys file from source to target", Run: runRepairCommand},
+	{Name: "report", Summary: "Summarize a failure file by table, reason and attribute", Run: runReportCommand},
+	{Name: "gen", Summary: "Generate test items and record their keys", Run: runGenCommand},
+	{Name: "del", Summary: "Delete the items listed in a keys file", Run: runDelCommand},
+}
+
+// RunCLI runs the subcommand named by the first argument and returns the exit status.
+// Without a subcommand, or when the first argument is a flag, the monitor is run.
+func RunCLI(args []string) int {
+	log.SetFormatter(&log.TextFormatter{
+		FullTimestamp: true,
+	})
+
+	name := defaultCommand
+	if len(args) > 0 {
+		switch {
+		case args[0] == "help" || args[0] == "-h" || args[0] == "-help" || args[0] == "--help":
+			printUsage(os.Stdout)
+			return 0
+		case !strings.HasPrefix(args[0], "-"):
+			name, args = args[0], args[1:]
+		}
+	}
+
+	cmd, ok := findCommand(name)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", name)
+		printUsage(os.Stderr)
+		return 2
+	}
+
+	ctx, stop := signalContext(cmd.Name)
+	defer stop()
+
+	if err := cmd.Run(ctx, newFlagSet(cmd), args); err != nil {
+		if !errors.Is(err, errCommandFailed) {
+			log.Errorf("❌ %v", err)
+		}
+		return 1
+	}
+	return 0
+}
+
+// findCommand returns the subcommand with the given name
+func findCommand(name string) (Command, bool) {
+	for _, cmd := range commands {
+		if cmd.Name == name {
+			return cmd, true
+		}
+	}
+	return Command{}, false
+}
+
+// printUsage lists the subcommands
+func printUsage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: %s <command> [flags]\n\nCommands:\n", binaryName)
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-13s %s\n", cmd.Name, cmd.Summary)
+	}
+	fmt.Fprintf(w, "\nRun '%s <command> -h' for the flags of a command.\n", binaryName)
+}
+
+// newFlagSet creates the flag set of a subcommand, which exits on invalid flags
+func newFlagSet(cmd Command) *flag.FlagSet {
+	fs := flag.NewFlagSet(cmd.Name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s %s [flags]\n\n%s\n\nFlags:\n", binaryName, cmd.Name, cmd.Summary)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// signalContext returns a context that is canceled on Ctrl+C or SIGTERM
+func signalContext(name string) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-c:
+			log.Infof("Received interrupt signal, stopping %s...", name)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, func() {
+		signal.Stop(c)
+		cancel()
+	}
+}
+
+// parseClientFlags parses the shared flags of the commands that work on a source and a
+// target table, prints the effective configuration and creates the clients
+func parseClientFlags(ctx context.Context, fs *flag.FlagSet, args []string, defaults CommandFlags) (*CommandFlags, *DynamoDBClients, error) {
+	cmdFlags, err := parseCommandFlags(fs, args, defaults)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error parsing command line flags: %w", err)
+	}
+	cmdFlags.LogEffectiveConfig()
+
+	clients, err := NewDynamoDBClients(ctx, cmdFlags.ClientConfig())
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create DynamoDB clients: %w", err)
+	}
+	return cmdFlags, clients, nil
+}
+
+// tableFlags are the flags shared by the commands that work on a single table
+type tableFlags struct {
+	Profile      string
+	Region       string
+	Endpoint     string
+	Table        string
+	PartitionKey string
+	SortKey      string
+	Wait         time.Duration
+}
+
+// registerTableFlags registers the single-table flags on fs
+func registerTableFlags(fs *flag.FlagSet) *tableFlags {
+	f := &tableFlags{}
+	fs.StringVar(&f.Profile, "profile", "", "AWS profile name (optional, defaults to the EC2 instance role)")
+	fs.StringVar(&f.Region, "region", "ap-northeast-1", "AWS Region (optional, defaults to ap-northeast-1)")
+	fs.StringVar(&f.Endpoint, "endpoint", "", "Endpoint URL, e.g. http://localhost:8000 (optional)")
+	fs.StringVar(&f.Table, "table", "", "DynamoDB table name")
+	fs.StringVar(&f.PartitionKey, "partition-key", "pk", "Name of the partition key (optional, defaults to pk)")
+	fs.StringVar(&f.SortKey, "sort-key", "sk", "Name of the sort key, empty for tables without a sort key (optional, defaults to sk)")
+	fs.DurationVar(&f.Wait, "wait", 0, "Time to wait between requests, e.g. 100ms (optional)")
+	return f
+}
+
+// client creates the DynamoDB client of the table
+func (f *tableFlags) client(ctx context.Context) (*dynamodb.Client, error) {
+	cfg, err := loadAWSConfig(ctx, clientSettings{profile: f.Profile, region: f.Region, endpoint: f.Endpoint})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create DynamoDB client: %w", err)
+	}
+	return dynamodb.NewFromConfig(cfg, withDynamoDBEndpoint(f.Endpoint)), nil
+}
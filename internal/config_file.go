@@ -0,0 +1,225 @@
+package internal
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// Configuration sources, from lowest to highest precedence
+const (
+	configSourceDefault = "default"
+	configSourceFile    = "file"
+	configSourceEnv     = "env"
+	configSourceFlag    = "flag"
+)
+
+// configTablesKey is the config file option listing the tables of a multi-table run
+const configTablesKey = "tables"
+
+// configEnvPrefix is prepended to every option name to form its environment variable,
+// e.g. --replication-wait-time can be set with DDB_REPLICATION_WAIT_TIME
+const configEnvPrefix = "DDB_"
+
+// envVarName returns the environment variable for a flag name
+func envVarName(flagName string) string {
+	return configEnvPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// applyConfigLayers merges the configuration file, the environment variables and the
+// explicitly set flags into cfg, which the flags of fs are bound to. It returns the
+// source of every option's effective value, keyed by flag name.
+func applyConfigLayers(fs *flag.FlagSet, cfg *CommandFlags, defaults CommandFlags) (map[string]string, error) {
+	// Remember the flags given on the command line, they are applied last
+	explicit := make(map[string]string)
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = f.Value.String()
+	})
+
+	configPath, ok := explicit["config"]
+	if !ok {
+		configPath = os.Getenv(envVarName("config"))
+	}
+
+	// Start over from the defaults
+	*cfg = defaults
+	sources := make(map[string]string)
+	fs.VisitAll(func(f *flag.Flag) {
+		sources[f.Name] = configSourceDefault
+	})
+
+	// 1. Configuration file
+	if configPath != "" {
+		values, tables, err := readConfigFile(configPath)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Tables = tables
+		for key, value := range values {
+			name := strings.ReplaceAll(key, "_", "-")
+			if fs.Lookup(name) == nil || name == "config" {
+				return nil, fmt.Errorf("unknown option %q in config file %s", key, configPath)
+			}
+			if err := fs.Set(name, value); err != nil {
+				return nil, fmt.Errorf("invalid value for %q in config file %s: %w", key, configPath, err)
+			}
+			sources[name] = configSourceFile
+		}
+		cfg.ConfigFile = configPath
+	}
+
+	// 2. Environment variables
+	var envErr error
+	fs.VisitAll(func(f *flag.Flag) {
+		value, ok := os.LookupEnv(envVarName(f.Name))
+		if !ok || envErr != nil {
+			return
+		}
+		if err := fs.Set(f.Name, value); err != nil {
+			envErr = fmt.Errorf("invalid value for %s: %w", envVarName(f.Name), err)
+			return
+		}
+		sources[f.Name] = configSourceEnv
+	})
+	if envErr != nil {
+		return nil, envErr
+	}
+
+	// 3. Command line flags
+	for name, value := range explicit {
+		if err := fs.Set(name, value); err != nil {
+			return nil, fmt.Errorf("invalid value for flag -%s: %w", name, err)
+		}
+		sources[name] = configSourceFlag
+	}
+
+	return sources, nil
+}
+
+// readConfigFile reads a YAML or JSON configuration file. Keys are option names with
+// either dashes or underscores, e.g. replication_wait_time: 10s. The optional tables
+// option lists the tables of a multi-table run.
+func readConfigFile(path string) (map[string]string, []TableConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	// JSON is a subset of YAML, so one parser handles both formats
+	var raw map[string]interface{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	values := make(map[string]string, len(raw))
+	var tables []TableConfig
+	for key, value := range raw {
+		if key == configTablesKey {
+			tables, err = parseTableConfigs(value)
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid tables in config file %s: %w", path, err)
+			}
+			continue
+		}
+
+		switch v := value.(type) {
+		case map[string]interface{}, []interface{}:
+			return nil, nil, fmt.Errorf("option %q in config file %s must be a single value", key, path)
+		case nil:
+			continue
+		case float64:
+			values[key] = strconv.FormatFloat(v, 'f', -1, 64)
+		case time.Time:
+			values[key] = v.Format(time.RFC3339Nano)
+		default:
+			values[key] = fmt.Sprint(v)
+		}
+	}
+	return values, tables, nil
+}
+
+// parseTableConfigs decodes the tables option. Like top-level options, table keys may
+// use either dashes or underscores.
+func parseTableConfigs(value interface{}) ([]TableConfig, error) {
+	entries, ok := value.([]interface{})
+	if !ok {
+		return nil, errors.New("must be a list")
+	}
+
+	tables := make([]TableConfig, 0, len(entries))
+	for i, entry := range entries {
+		fields, ok := entry.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("entry %d must be a mapping", i+1)
+		}
+
+		normalized := make(map[string]interface{}, len(fields))
+		for key, v := range fields {
+			normalized[strings.ReplaceAll(key, "-", "_")] = v
+		}
+
+		// Round-trip through YAML so unknown keys and wrong types are reported
+		data, err := yaml.Marshal(normalized)
+		if err != nil {
+			return nil, fmt.Errorf("entry %d: %w", i+1, err)
+		}
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+
+		var table TableConfig
+		if err := dec.Decode(&table); err != nil {
+			return nil, fmt.Errorf("entry %d: %w", i+1, err)
+		}
+		tables = append(tables, table)
+	}
+	return tables, nil
+}
+
+// LogEffectiveConfig prints every option's effective value and where it came from
+func (c *CommandFlags) LogEffectiveConfig() {
+	log.Infof("========= Effective Configuration =========")
+	if c.ConfigFile != "" {
+		log.Infof("Config file: %s", c.ConfigFile)
+	}
+	// The flags are bound to c, so their values are the effective ones
+	c.flagSet.VisitAll(func(f *flag.Flag) {
+		if f.Name == "config" {
+			return
+		}
+		source := c.sources[f.Name]
+		if source == configSourceEnv {
+			source = configSourceEnv + " " + envVarName(f.Name)
+		}
+		log.Infof("  %-26s = %-20s (%s)", f.Name, f.Value.String(), source)
+	})
+	for _, table := range c.Tables {
+		log.Infof("  table %-20s stream=%s source=%s target=%s keys=%s,%s sample-rate=%d verify-on=%s",
+			table.Name, table.StreamArn, table.SourceTable, table.TargetTable,
+			table.PartitionKey, table.SortKey, table.SampleRate, table.VerifyOn)
+	}
+	log.Infof("========================================")
+}
+
+// int32Value implements flag.Value for int32 options
+type int32Value int32
+
+func (v *int32Value) String() string {
+	return strconv.FormatInt(int64(*v), 10)
+}
+
+func (v *int32Value) Set(s string) error {
+	n, err := strconv.ParseInt(s, 10, 32)
+	if err != nil {
+		return err
+	}
+	*v = int32Value(n)
+	return nil
+}
@@ -0,0 +1,179 @@
+package internal
+
+import (
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestApplyConfigLayers(t *testing.T) {
+	const streamArn = "arn:aws:dynamodb:ap-northeast-1:123456789012:table/orders/stream/2025-01-01T00:00:00.000"
+
+	// waitTime checks the effective replication wait time and where it came from
+	waitTime := func(want time.Duration, source string) func(*testing.T, *CommandFlags) {
+		return func(t *testing.T, cfg *CommandFlags) {
+			if got := cfg.ValidationConfig.ReplicationWaitTime; got != want {
+				t.Errorf("replication wait time = %s, want %s", got, want)
+			}
+			if got := cfg.sources["replication-wait-time"]; got != source {
+				t.Errorf("source = %s, want %s", got, source)
+			}
+		}
+	}
+
+	tests := []struct {
+		name    string
+		file    string            // Config file content, no file if empty
+		fileEnv bool              // Pass the config file with DDB_CONFIG instead of --config
+		env     map[string]string // Environment variables
+		args    []string          // Flags besides the profiles
+		wantErr string
+		check   func(*testing.T, *CommandFlags)
+	}{
+		{
+			name:  "default",
+			check: waitTime(5*time.Second, configSourceDefault),
+		},
+		{
+			name:  "file over default",
+			file:  "replication_wait_time: 10s\n",
+			check: waitTime(10*time.Second, configSourceFile),
+		},
+		{
+			name:  "file key with dashes",
+			file:  "replication-wait-time: 12s\n",
+			check: waitTime(12*time.Second, configSourceFile),
+		},
+		{
+			name:  "JSON file",
+			file:  `{"replication_wait_time": "14s", "sample_rate": 10}`,
+			check: waitTime(14*time.Second, configSourceFile),
+		},
+		{
+			name:    "file from environment",
+			file:    "replication_wait_time: 10s\n",
+			fileEnv: true,
+			check:   waitTime(10*time.Second, configSourceFile),
+		},
+		{
+			name:  "env over file",
+			file:  "replication_wait_time: 10s\n",
+			env:   map[string]string{"DDB_REPLICATION_WAIT_TIME": "20s"},
+			check: waitTime(20*time.Second, configSourceEnv),
+		},
+		{
+			name:  "flag over env and file",
+			file:  "replication_wait_time: 10s\n",
+			env:   map[string]string{"DDB_REPLICATION_WAIT_TIME": "20s"},
+			args:  []string{"-replication-wait-time", "30s"},
+			check: waitTime(30*time.Second, configSourceFlag),
+		},
+		{
+			name: "layers apply per option",
+			file: "sample_rate: 10\nverify_on: target\niterator_type: TRIM_HORIZON\n",
+			env:  map[string]string{"DDB_SAMPLE_RATE": "20"},
+			args: []string{"-verify-on", "source"},
+			check: func(t *testing.T, cfg *CommandFlags) {
+				if cfg.SampleRate != 20 || cfg.VerifyOn != "source" || cfg.IteratorType != "TRIM_HORIZON" {
+					t.Errorf("sample rate = %d, verify on = %s, iterator type = %s, want 20, source, TRIM_HORIZON",
+						cfg.SampleRate, cfg.VerifyOn, cfg.IteratorType)
+				}
+			},
+		},
+		{
+			name:    "unknown file key",
+			file:    "replication_wait: 10s\n",
+			wantErr: `unknown option "replication_wait"`,
+		},
+		{
+			name:    "config key in file",
+			file:    "config: other.yaml\n",
+			wantErr: `unknown option "config"`,
+		},
+		{
+			name:    "invalid file value",
+			file:    "replication_wait_time: soon\n",
+			wantErr: `invalid value for "replication_wait_time"`,
+		},
+		{
+			name:    "nested file value",
+			file:    "sample_rate:\n  orders: 10\n",
+			wantErr: "must be a single value",
+		},
+		{
+			name:    "invalid env value",
+			env:     map[string]string{"DDB_SAMPLE_RATE": "often"},
+			wantErr: "invalid value for DDB_SAMPLE_RATE",
+		},
+		{
+			name: "tables",
+			file: "sample_rate: 10\ntables:\n" +
+				"  - name: orders\n    stream_arn: " + streamArn + "\n    target_table: orders_v2\n    partition_key: pk\n    sort_key: sk\n    sample_rate: 1\n" +
+				"  - stream-arn: " + streamArn + "\n    target-table: users\n    partition-key: id\n    transform: 'pk=${id}'\n    verify-on: target\n",
+			check: func(t *testing.T, cfg *CommandFlags) {
+				if len(cfg.Tables) != 2 {
+					t.Fatalf("got %d tables, want 2", len(cfg.Tables))
+				}
+				orders, users := cfg.Tables[0], cfg.Tables[1]
+				if orders.Name != "orders" || orders.TargetTable != "orders_v2" || orders.SourceTable != "orders_v2" ||
+					orders.SortKey != "sk" || orders.SampleRate != 1 || orders.ParsedTransform != nil {
+					t.Errorf("orders = %+v", orders)
+				}
+				// Keys with dashes decode, and missing keys fall back to the top-level options
+				if users.Name != "users" || users.PartitionKey != "id" || users.Transform != "pk=${id}" || users.ParsedTransform == nil ||
+					users.VerifyOn != "target" || users.SampleRate != 10 || users.StreamArn != streamArn {
+					t.Errorf("users = %+v", users)
+				}
+			},
+		},
+		{
+			name:    "unknown table key",
+			file:    "tables:\n  - stream_arn: " + streamArn + "\n    target_table: orders\n    partition_key: pk\n    shard_count: 2\n",
+			wantErr: "field shard_count not found",
+		},
+		{
+			name:    "tables not a list",
+			file:    "tables:\n  orders: {}\n",
+			wantErr: "must be a list",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := []string{"-source-profile", "src", "-target-profile", "dst"}
+			if tt.file != "" {
+				path := filepath.Join(t.TempDir(), "config.yaml")
+				if err := os.WriteFile(path, []byte(tt.file), 0o644); err != nil {
+					t.Fatal(err)
+				}
+				if tt.fileEnv {
+					t.Setenv("DDB_CONFIG", path)
+				} else {
+					args = append(args, "-config", path)
+				}
+			}
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			args = append(args, tt.args...)
+
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			fs.SetOutput(io.Discard)
+			cfg, err := parseCommandFlags(fs, args, defaultCommandFlags())
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.check(t, cfg)
+		})
+	}
+}
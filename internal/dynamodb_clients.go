@@ -3,27 +3,100 @@ package internal
 import (
 	"context"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodbstreams"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
 	log "github.com/sirupsen/logrus"
 )
 
+// localEndpointAccessKey is the static access key used for endpoints without a profile or
+// role. DynamoDB Local and LocalStack accept any credentials but still require them.
+const localEndpointAccessKey = "local"
+
+// ddbLocalRegion is the placeholder region in the stream ARNs of DynamoDB Local
+const ddbLocalRegion = "ddblocal"
+
+// defaultRoleSessionName identifies the monitor in the CloudTrail logs of assumed roles
+const defaultRoleSessionName = "dynamodb-migration-monitor"
+
 // ClientConfig holds AWS profile configuration for creating DynamoDB clients
 type ClientConfig struct {
 	SourceProfile string
 	TargetProfile string
 	StreamProfile string // Optional, profile for Stream client (defaults to SourceProfile)
-	Region        string // Optional, defaults to ap-southeast-1
+	Region        string // Optional, defaults to ap-northeast-1
+
+	// Per-client regions (optional), each one defaults to Region. The source and stream
+	// regions default to the region of StreamArn when it is set.
+	SourceRegion string
+	TargetRegion string
+	StreamRegion string
+	StreamArn    string
+
+	// Roles assumed on top of the profile credentials (optional). Each one can be a
+	// comma-separated chain of role ARNs, assumed in order.
+	SourceRoleArn string
+	TargetRoleArn string
+	StreamRoleArn string // Optional, defaults to SourceRoleArn when StreamProfile is not set
+
+	RoleExternalID  string        // Optional, external ID passed when assuming roles
+	RoleSessionName string        // Optional, defaults to dynamodb-migration-monitor
+	RoleDuration    time.Duration // Optional, defaults to 1 hour
+
+	// Endpoint overrides, e.g. http://localhost:8000 for DynamoDB Local (optional)
+	SourceEndpoint string
+	TargetEndpoint string
+	StreamEndpoint string // Optional, defaults to SourceEndpoint
+
+	// StrictCredentials makes a profile that cannot be used an error instead of falling
+	// back to the EC2 instance role
+	StrictCredentials bool
+
+	// Expected AWS account of each client's credentials (optional)
+	SourceAccountID string
+	TargetAccountID string
+	StreamAccountID string // Optional, defaults to SourceAccountID when StreamProfile is not set
 }
 
 // DynamoDBClients holds all necessary DynamoDB clients
 type DynamoDBClients struct {
-	SourceClient *dynamodb.Client
-	TargetClient *dynamodb.Client
-	StreamClient *dynamodbstreams.Client
+	SourceClient TableAdminAPI
+	TargetClient TableAdminAPI
+	StreamClient StreamAPI
+
+	// Effective region of each client
+	SourceRegion string
+	TargetRegion string
+	StreamRegion string
+
+	// Account and principal of each client, nil if unknown (e.g. local endpoints)
+	SourceIdentity *CallerIdentity
+	TargetIdentity *CallerIdentity
+	StreamIdentity *CallerIdentity
+}
+
+// clientSettings describes how the credentials of a single client are obtained
+type clientSettings struct {
+	profile string
+	region  string
+	roleArn string // Comma-separated chain of role ARNs (optional)
+
+	// Endpoint override (optional). Without a profile or role, static credentials are used.
+	endpoint string
+
+	strict          bool   // Never fall back to the EC2 instance role
+	expectedAccount string // Expected account of the credentials (optional)
+
+	externalID  string
+	sessionName string
+	duration    time.Duration
 }
 
 // NewDynamoDBClients creates all required DynamoDB clients
@@ -33,82 +106,213 @@ func NewDynamoDBClients(ctx context.Context, cfg ClientConfig) (*DynamoDBClients
 		cfg.Region = "ap-northeast-1"
 	}
 
-	// Set default StreamProfile if not provided
+	// Set default StreamProfile, StreamRoleArn and StreamAccountID if not provided
 	streamProfile := cfg.StreamProfile
+	streamRoleArn := cfg.StreamRoleArn
+	streamAccountID := cfg.StreamAccountID
 	if streamProfile == "" {
 		streamProfile = cfg.SourceProfile
 		log.Infof("No Stream profile specified, using Source profile: %s", streamProfile)
+		if streamRoleArn == "" {
+			streamRoleArn = cfg.SourceRoleArn
+		}
+		if streamAccountID == "" {
+			streamAccountID = cfg.SourceAccountID
+		}
+	}
+
+	// The stream belongs to the source table, so it is served by the same endpoint
+	streamEndpoint := cfg.StreamEndpoint
+	if streamEndpoint == "" {
+		streamEndpoint = cfg.SourceEndpoint
+	}
+
+	sourceRegion, targetRegion, streamRegion := cfg.resolveRegions()
+
+	settings := func(profile, region, roleArn, endpoint, expectedAccount string) clientSettings {
+		return clientSettings{
+			profile:         profile,
+			region:          region,
+			roleArn:         roleArn,
+			endpoint:        endpoint,
+			strict:          cfg.StrictCredentials,
+			expectedAccount: expectedAccount,
+			externalID:      cfg.RoleExternalID,
+			sessionName:     cfg.RoleSessionName,
+			duration:        cfg.RoleDuration,
+		}
 	}
 
-	sourceClient, err := NewDynamoDBClient(ctx, cfg.SourceProfile, cfg.Region)
+	sourceCfg, sourceIdentity, err := loadVerifiedAWSConfig(ctx, "source",
+		settings(cfg.SourceProfile, sourceRegion, cfg.SourceRoleArn, cfg.SourceEndpoint, cfg.SourceAccountID))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create DynamoDB client for source profile: %w", err)
 	}
 
 	// Configure the target Dynamodb client
-	targetClient, err := NewDynamoDBClient(ctx, cfg.TargetProfile, cfg.Region)
+	targetCfg, targetIdentity, err := loadVerifiedAWSConfig(ctx, "target",
+		settings(cfg.TargetProfile, targetRegion, cfg.TargetRoleArn, cfg.TargetEndpoint, cfg.TargetAccountID))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create DynamoDB client for target profile: %w", err)
 	}
 
 	// Use the specified stream profile
-	streamClient, err := NewDynamoDBStreamClient(ctx, streamProfile, cfg.Region)
+	streamCfg, streamIdentity, err := loadVerifiedAWSConfig(ctx, "stream",
+		settings(streamProfile, streamRegion, streamRoleArn, streamEndpoint, streamAccountID))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create DynamoDB Stream client for profile %s: %w", streamProfile, err)
 	}
 
 	return &DynamoDBClients{
-		SourceClient: sourceClient,
-		TargetClient: targetClient,
-		StreamClient: streamClient,
+		SourceClient:   dynamodb.NewFromConfig(sourceCfg, withDynamoDBEndpoint(cfg.SourceEndpoint)),
+		TargetClient:   dynamodb.NewFromConfig(targetCfg, withDynamoDBEndpoint(cfg.TargetEndpoint)),
+		StreamClient:   dynamodbstreams.NewFromConfig(streamCfg, withStreamEndpoint(streamEndpoint)),
+		SourceRegion:   sourceRegion,
+		TargetRegion:   targetRegion,
+		StreamRegion:   streamRegion,
+		SourceIdentity: sourceIdentity,
+		TargetIdentity: targetIdentity,
+		StreamIdentity: streamIdentity,
 	}, nil
 }
 
-// NewDynamoDBClient creates a new DynamoDB client with the specified profile
-func NewDynamoDBClient(ctx context.Context, profile, region string) (*dynamodb.Client, error) {
-	var cfg aws.Config
-	var err error
+// LogIdentities logs the region, account and principal of every client
+func (c *DynamoDBClients) LogIdentities() {
+	logClientIdentity("source", c.SourceRegion, c.SourceIdentity)
+	logClientIdentity("target", c.TargetRegion, c.TargetIdentity)
+	logClientIdentity("stream", c.StreamRegion, c.StreamIdentity)
+}
 
-	// try to use profile
-	if profile != "" {
-		// use profile
-		cfg, err = config.LoadDefaultConfig(ctx,
-			config.WithSharedConfigProfile(profile),
-			config.WithRegion(region),
-		)
+// loadVerifiedAWSConfig loads the AWS configuration of one client and checks which
+// account and principal its credentials belong to
+func loadVerifiedAWSConfig(ctx context.Context, name string, s clientSettings) (aws.Config, *CallerIdentity, error) {
+	cfg, err := loadAWSConfig(ctx, s)
+	if err != nil {
+		return aws.Config{}, nil, err
+	}
 
-		if err == nil {
-			// successfully load profile
-			_, err = cfg.Credentials.Retrieve(ctx)
-			if err == nil {
-				log.Infof("✅ Successfully loaded credentials for profile %s", profile)
-				return dynamodb.NewFromConfig(cfg), nil
-			}
-			log.Warnf("Failed to use profile %s: %v", profile, err)
+	// Static credentials of a local endpoint do not belong to any account
+	if s.usesLocalCredentials() {
+		if s.expectedAccount != "" {
+			log.Warnf("Cannot verify the account of the %s client, endpoint %s uses static credentials", name, s.endpoint)
 		}
+		return cfg, nil, nil
 	}
 
-	// if no profile or profile is not available, try to use EC2 IAM role
-	log.Infof("Attempting to use EC2 instance role")
-	cfg, err = config.LoadDefaultConfig(ctx,
-		config.WithRegion(region),
-	)
+	identity, err := checkCallerIdentity(ctx, name, cfg, s.expectedAccount)
 	if err != nil {
-		return nil, fmt.Errorf("failed to load EC2 instance role: %w", err)
+		return aws.Config{}, nil, err
+	}
+	return cfg, identity, nil
+}
+
+// resolveRegions returns the effective region of the source, target and stream clients
+// and logs where each one came from at debug level
+func (cfg ClientConfig) resolveRegions() (source, target, stream string) {
+	pick := func(name, explicit, inferred, fallback, fallbackSource string) string {
+		switch {
+		case explicit != "":
+			log.Debugf("%s client region: %s", name, explicit)
+			return explicit
+		case inferred != "":
+			log.Debugf("%s client region: %s (from stream ARN)", name, inferred)
+			return inferred
+		default:
+			log.Debugf("%s client region: %s (%s)", name, fallback, fallbackSource)
+			return fallback
+		}
 	}
 
-	// verify credentials
-	_, err = cfg.Credentials.Retrieve(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("failed to retrieve EC2 role credentials: %w", err)
+	// A table and its stream always live in the same region
+	arnRegion := RegionFromArn(cfg.StreamArn)
+	if arnRegion == ddbLocalRegion {
+		arnRegion = ""
 	}
 
-	log.Infof("✅ Successfully loaded EC2 instance role credentials")
+	source = pick("Source", cfg.SourceRegion, arnRegion, cfg.Region, "region")
+	target = pick("Target", cfg.TargetRegion, "", cfg.Region, "region")
+	stream = pick("Stream", cfg.StreamRegion, arnRegion, source, "source region")
+	if arnRegion != "" && stream != arnRegion {
+		log.Warnf("Stream client region %s does not match the stream ARN region %s", stream, arnRegion)
+	}
+	return source, target, stream
+}
+
+// RegionFromArn returns the region of an ARN such as
+// arn:aws:dynamodb:ap-northeast-1:123456789012:table/my-table/stream/..., or an empty
+// string if it cannot be parsed
+func RegionFromArn(arn string) string {
+	parts := strings.SplitN(arn, ":", 5)
+	if len(parts) < 5 || parts[0] != "arn" {
+		return ""
+	}
+	return parts[3]
+}
+
+// withDynamoDBEndpoint overrides the endpoint of a DynamoDB client if one is given
+func withDynamoDBEndpoint(endpoint string) func(*dynamodb.Options) {
+	return func(o *dynamodb.Options) {
+		if endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+		}
+	}
+}
+
+// withStreamEndpoint overrides the endpoint of a DynamoDB Streams client if one is given
+func withStreamEndpoint(endpoint string) func(*dynamodbstreams.Options) {
+	return func(o *dynamodbstreams.Options) {
+		if endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+		}
+	}
+}
+
+// NewDynamoDBClient creates a new DynamoDB client with the specified profile
+func NewDynamoDBClient(ctx context.Context, profile, region string) (*dynamodb.Client, error) {
+	cfg, err := loadAWSConfig(ctx, clientSettings{profile: profile, region: region})
+	if err != nil {
+		return nil, err
+	}
 	return dynamodb.NewFromConfig(cfg), nil
 }
 
 // NewDynamoDBStreamClient creates a new DynamoDB Streams client with the specified profile
 func NewDynamoDBStreamClient(ctx context.Context, profile, region string) (*dynamodbstreams.Client, error) {
+	cfg, err := loadAWSConfig(ctx, clientSettings{profile: profile, region: region})
+	if err != nil {
+		return nil, err
+	}
+	return dynamodbstreams.NewFromConfig(cfg), nil
+}
+
+// loadAWSConfig loads the AWS configuration of one client: the profile (or the EC2
+// instance role), followed by the chain of assumed roles if any. A custom endpoint
+// without a profile or role gets static credentials instead.
+func loadAWSConfig(ctx context.Context, s clientSettings) (aws.Config, error) {
+	if s.usesLocalCredentials() {
+		return loadLocalEndpointConfig(ctx, s.endpoint, s.region)
+	}
+
+	cfg, err := loadBaseConfig(ctx, s.profile, s.region, s.strict)
+	if err != nil {
+		return aws.Config{}, err
+	}
+
+	if s.roleArn == "" {
+		return cfg, nil
+	}
+	return assumeRoleChain(ctx, cfg, s)
+}
+
+// usesLocalCredentials reports whether the client talks to a custom endpoint without a
+// profile or role, and therefore uses static credentials
+func (s clientSettings) usesLocalCredentials() bool {
+	return s.endpoint != "" && s.profile == "" && s.roleArn == ""
+}
+
+// loadBaseConfig loads the configuration of the specified profile, falling back to the
+// EC2 instance role if no profile is given or, unless strict, the profile is not usable
+func loadBaseConfig(ctx context.Context, profile, region string, strict bool) (aws.Config, error) {
 	var cfg aws.Config
 	var err error
 
@@ -125,10 +329,13 @@ func NewDynamoDBStreamClient(ctx context.Context, profile, region string) (*dyna
 			_, err = cfg.Credentials.Retrieve(ctx)
 			if err == nil {
 				log.Infof("✅ Successfully loaded credentials for profile %s", profile)
-				return dynamodbstreams.NewFromConfig(cfg), nil
+				return cfg, nil
 			}
-			log.Warnf("Failed to use profile %s: %v", profile, err)
 		}
+		if strict {
+			return aws.Config{}, fmt.Errorf("failed to use profile %s: %w", profile, err)
+		}
+		log.Warnf("Failed to use profile %s: %v", profile, err)
 	}
 
 	// if no profile or profile is not available, try to use EC2 IAM role
@@ -137,15 +344,77 @@ func NewDynamoDBStreamClient(ctx context.Context, profile, region string) (*dyna
 		config.WithRegion(region),
 	)
 	if err != nil {
-		return nil, fmt.Errorf("failed to load EC2 instance role: %w", err)
+		return aws.Config{}, fmt.Errorf("failed to load EC2 instance role: %w", err)
 	}
 
 	// verify credentials
 	_, err = cfg.Credentials.Retrieve(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to retrieve EC2 role credentials: %w", err)
+		return aws.Config{}, fmt.Errorf("failed to retrieve EC2 role credentials: %w", err)
 	}
 
 	log.Infof("✅ Successfully loaded EC2 instance role credentials")
-	return dynamodbstreams.NewFromConfig(cfg), nil
+	return cfg, nil
+}
+
+// loadLocalEndpointConfig loads a configuration with static credentials, for endpoints
+// such as DynamoDB Local or LocalStack that do not check them
+func loadLocalEndpointConfig(ctx context.Context, endpoint, region string) (aws.Config, error) {
+	cfg, err := config.LoadDefaultConfig(ctx,
+		config.WithRegion(region),
+		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(localEndpointAccessKey, localEndpointAccessKey, "")),
+	)
+	if err != nil {
+		return aws.Config{}, fmt.Errorf("failed to load config for endpoint %s: %w", endpoint, err)
+	}
+
+	log.Infof("✅ Using static credentials for endpoint %s", endpoint)
+	return cfg, nil
+}
+
+// assumeRoleChain assumes every role of the chain in order, each one with the credentials
+// of the previous one. The credentials are cached and refreshed before they expire, so
+// long-running monitors keep working.
+func assumeRoleChain(ctx context.Context, cfg aws.Config, s clientSettings) (aws.Config, error) {
+	sessionName := s.sessionName
+	if sessionName == "" {
+		sessionName = defaultRoleSessionName
+	}
+
+	hops := 0
+	for _, roleArn := range strings.Split(s.roleArn, ",") {
+		roleArn = strings.TrimSpace(roleArn)
+		if roleArn == "" {
+			continue
+		}
+
+		// AWS limits sessions obtained through role chaining to one hour
+		duration := s.duration
+		if hops > 0 && duration > time.Hour {
+			duration = time.Hour
+		}
+		hops++
+
+		// The STS client signs with the credentials of the previous hop
+		provider := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(cfg), roleArn, func(o *stscreds.AssumeRoleOptions) {
+			o.RoleSessionName = sessionName
+			if duration > 0 {
+				o.Duration = duration
+			}
+			if s.externalID != "" {
+				o.ExternalID = aws.String(s.externalID)
+			}
+		})
+		cfg.Credentials = aws.NewCredentialsCache(provider, func(o *aws.CredentialsCacheOptions) {
+			o.ExpiryWindow = 5 * time.Minute
+		})
+
+		// verify credentials
+		if _, err := cfg.Credentials.Retrieve(ctx); err != nil {
+			return aws.Config{}, fmt.Errorf("failed to assume role %s: %w", roleArn, err)
+		}
+		log.Infof("✅ Successfully assumed role %s (session %s)", roleArn, sessionName)
+	}
+
+	return cfg, nil
 }
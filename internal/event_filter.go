@@ -0,0 +1,160 @@
+package internal
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	streamtypes "github.com/aws/aws-sdk-go-v2/service/dynamodbstreams/types"
+)
+
+// FilterReason tells why a stream record was filtered out
+type FilterReason string
+
+const (
+	FilterReasonNone      FilterReason = ""
+	FilterReasonKey       FilterReason = "key"        // Partition key does not match the key rules
+	FilterReasonEventType FilterReason = "event_type" // Event type is not one of the selected types
+	FilterReasonTime      FilterReason = "time"       // Created outside the time window
+)
+
+// EventFilterConfig selects the stream records that are counted and validated. Key rules
+// apply to the partition key value. A record passes when it matches the include rules
+// that are set and none of the exclude rules.
+type EventFilterConfig struct {
+	IncludeKeyPrefixes []string  // Partition key prefixes to keep (optional)
+	ExcludeKeyPrefixes []string  // Partition key prefixes to drop (optional)
+	IncludeKeyPattern  string    // Regular expression the partition key must match (optional)
+	ExcludeKeyPattern  string    // Regular expression the partition key must not match (optional)
+	EventTypes         []string  // Event types to keep, INSERT or MODIFY (optional, defaults to both)
+	After              time.Time // Keep records created at or after this time (optional)
+	Before             time.Time // Keep records created before this time (optional)
+}
+
+// IsZero reports whether no filter is set
+func (c EventFilterConfig) IsZero() bool {
+	return len(c.IncludeKeyPrefixes) == 0 && len(c.ExcludeKeyPrefixes) == 0 &&
+		c.IncludeKeyPattern == "" && c.ExcludeKeyPattern == "" &&
+		len(c.EventTypes) == 0 && c.After.IsZero() && c.Before.IsZero()
+}
+
+// EventFilter is a compiled EventFilterConfig
+type EventFilter struct {
+	cfg        EventFilterConfig
+	include    *regexp.Regexp
+	exclude    *regexp.Regexp
+	eventTypes map[streamtypes.OperationType]struct{}
+}
+
+// NewEventFilter compiles the filter, returning an error for an invalid pattern or event type
+func NewEventFilter(cfg EventFilterConfig) (*EventFilter, error) {
+	f := &EventFilter{cfg: cfg}
+
+	var err error
+	if cfg.IncludeKeyPattern != "" {
+		if f.include, err = regexp.Compile(cfg.IncludeKeyPattern); err != nil {
+			return nil, fmt.Errorf("invalid include key pattern: %w", err)
+		}
+	}
+	if cfg.ExcludeKeyPattern != "" {
+		if f.exclude, err = regexp.Compile(cfg.ExcludeKeyPattern); err != nil {
+			return nil, fmt.Errorf("invalid exclude key pattern: %w", err)
+		}
+	}
+
+	if len(cfg.EventTypes) > 0 {
+		f.eventTypes = make(map[streamtypes.OperationType]struct{}, len(cfg.EventTypes))
+		for _, name := range cfg.EventTypes {
+			eventType := streamtypes.OperationType(strings.ToUpper(strings.TrimSpace(name)))
+			if eventType != streamtypes.OperationTypeInsert && eventType != streamtypes.OperationTypeModify {
+				return nil, fmt.Errorf("invalid event type %q, must be INSERT or MODIFY", name)
+			}
+			f.eventTypes[eventType] = struct{}{}
+		}
+	}
+
+	if !cfg.After.IsZero() && !cfg.Before.IsZero() && !cfg.After.Before(cfg.Before) {
+		return nil, fmt.Errorf("the time window must end after it starts")
+	}
+	return f, nil
+}
+
+// Describe returns a one-line summary of the filters for the startup log
+func (f *EventFilter) Describe() string {
+	var parts []string
+	if len(f.cfg.IncludeKeyPrefixes) > 0 {
+		parts = append(parts, "key prefix in "+strings.Join(f.cfg.IncludeKeyPrefixes, ","))
+	}
+	if len(f.cfg.ExcludeKeyPrefixes) > 0 {
+		parts = append(parts, "key prefix not in "+strings.Join(f.cfg.ExcludeKeyPrefixes, ","))
+	}
+	if f.include != nil {
+		parts = append(parts, "key matches "+f.include.String())
+	}
+	if f.exclude != nil {
+		parts = append(parts, "key does not match "+f.exclude.String())
+	}
+	if len(f.cfg.EventTypes) > 0 {
+		parts = append(parts, "event type in "+strings.ToUpper(strings.Join(f.cfg.EventTypes, ",")))
+	}
+	if !f.cfg.After.IsZero() {
+		parts = append(parts, "created at or after "+f.cfg.After.Format(time.RFC3339))
+	}
+	if !f.cfg.Before.IsZero() {
+		parts = append(parts, "created before "+f.cfg.Before.Format(time.RFC3339))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Match returns FilterReasonNone if the record passes the filter, or why it was filtered out
+func (f *EventFilter) Match(rec *streamtypes.Record, partitionKeyValue string) FilterReason {
+	if f.eventTypes != nil {
+		if _, ok := f.eventTypes[rec.EventName]; !ok {
+			return FilterReasonEventType
+		}
+	}
+
+	if !f.matchKey(partitionKeyValue) {
+		return FilterReasonKey
+	}
+
+	if !f.cfg.After.IsZero() || !f.cfg.Before.IsZero() {
+		if rec.Dynamodb == nil || rec.Dynamodb.ApproximateCreationDateTime == nil {
+			return FilterReasonTime
+		}
+		created := *rec.Dynamodb.ApproximateCreationDateTime
+		if created.Before(f.cfg.After) || (!f.cfg.Before.IsZero() && !created.Before(f.cfg.Before)) {
+			return FilterReasonTime
+		}
+	}
+
+	return FilterReasonNone
+}
+
+// matchKey reports whether the partition key passes the key rules
+func (f *EventFilter) matchKey(value string) bool {
+	if len(f.cfg.IncludeKeyPrefixes) > 0 && !hasAnyPrefix(value, f.cfg.IncludeKeyPrefixes) {
+		return false
+	}
+	if hasAnyPrefix(value, f.cfg.ExcludeKeyPrefixes) {
+		return false
+	}
+	if f.include != nil && !f.include.MatchString(value) {
+		return false
+	}
+	if f.exclude != nil && f.exclude.MatchString(value) {
+		return false
+	}
+	return true
+}
+
+// hasAnyPrefix reports whether s starts with one of the prefixes
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,99 @@
+package internal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	streamtypes "github.com/aws/aws-sdk-go-v2/service/dynamodbstreams/types"
+)
+
+// filterRecord returns a stream record of the event type created at the given time
+func filterRecord(eventName streamtypes.OperationType, created time.Time) *streamtypes.Record {
+	return &streamtypes.Record{
+		EventName: eventName,
+		Dynamodb:  &streamtypes.StreamRecord{ApproximateCreationDateTime: aws.Time(created)},
+	}
+}
+
+func TestEventFilterKeys(t *testing.T) {
+	filter, err := NewEventFilter(EventFilterConfig{
+		IncludeKeyPrefixes: []string{"USER#", "ORDER#"},
+		ExcludeKeyPrefixes: []string{"USER#test"},
+		ExcludeKeyPattern:  `#\d+-tmp$`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := filterRecord(streamtypes.OperationTypeInsert, time.Now())
+	tests := map[string]FilterReason{
+		"USER#42":        FilterReasonNone,
+		"ORDER#7":        FilterReasonNone,
+		"USER#test-1":    FilterReasonKey,
+		"ORDER#7-tmp":    FilterReasonKey,
+		"SESSION#1":      FilterReasonKey,
+		"user#lowercase": FilterReasonKey,
+	}
+	for key, want := range tests {
+		if got := filter.Match(rec, key); got != want {
+			t.Errorf("Match(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestEventFilterEventTypes(t *testing.T) {
+	filter, err := NewEventFilter(EventFilterConfig{EventTypes: []string{"modify"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := filter.Match(filterRecord(streamtypes.OperationTypeModify, time.Now()), "k"); got != FilterReasonNone {
+		t.Errorf("MODIFY filtered out as %q", got)
+	}
+	if got := filter.Match(filterRecord(streamtypes.OperationTypeInsert, time.Now()), "k"); got != FilterReasonEventType {
+		t.Errorf("INSERT got %q, want %q", got, FilterReasonEventType)
+	}
+}
+
+func TestEventFilterTimeWindow(t *testing.T) {
+	start := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+	filter, err := NewEventFilter(EventFilterConfig{After: start, Before: end})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		created time.Time
+		want    FilterReason
+	}{
+		{start.Add(-time.Second), FilterReasonTime},
+		{start, FilterReasonNone},
+		{start.Add(30 * time.Minute), FilterReasonNone},
+		{end, FilterReasonTime},
+	}
+	for _, tt := range tests {
+		if got := filter.Match(filterRecord(streamtypes.OperationTypeInsert, tt.created), "k"); got != tt.want {
+			t.Errorf("created at %s: got %q, want %q", tt.created.Format(time.RFC3339), got, tt.want)
+		}
+	}
+
+	// A record without a creation time cannot be placed in the window
+	if got := filter.Match(&streamtypes.Record{EventName: streamtypes.OperationTypeInsert}, "k"); got != FilterReasonTime {
+		t.Errorf("record without creation time got %q, want %q", got, FilterReasonTime)
+	}
+}
+
+func TestNewEventFilterErrors(t *testing.T) {
+	now := time.Now()
+	for name, cfg := range map[string]EventFilterConfig{
+		"pattern":    {IncludeKeyPattern: "("},
+		"event type": {EventTypes: []string{"REMOVE"}},
+		"window":     {After: now, Before: now.Add(-time.Minute)},
+	} {
+		if _, err := NewEventFilter(cfg); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
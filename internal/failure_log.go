@@ -0,0 +1,92 @@
+package internal
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Failure reasons recorded in the dead-letter file
+const (
+	FailureReasonNotFound       = "item_not_found"
+	FailureReasonQueryError     = "query_error"
+	FailureReasonMismatch       = "attribute_mismatch"
+	FailureReasonTransformError = "transform_error"
+)
+
+// FailureRecord is one entry of the dead-letter file. It holds everything needed
+// to re-run the verification later without access to the original stream record.
+type FailureRecord struct {
+	Timestamp      time.Time    `json:"timestamp"`
+	Table          string       `json:"table"`                   // Table the item was verified against
+	VerifyOn       string       `json:"verify_on"`               // source or target
+	Key            AttributeMap `json:"key"`                     // Typed primary key
+	EventID        string       `json:"event_id"`                // Stream event ID
+	EventName      string       `json:"event_name"`              // INSERT or MODIFY
+	SequenceNumber string       `json:"sequence_number"`         // Stream sequence number
+	StreamImage    AttributeMap `json:"stream_image,omitempty"`  // NewImage of the stream record
+	ExpectedKey    AttributeMap `json:"expected_key,omitempty"`  // Key in the verified table computed by the transform, if any
+	ExpectedItem   AttributeMap `json:"expected_item,omitempty"` // Item computed by the transform from the stream image, if any
+	VerifiedItem   AttributeMap `json:"verified_item,omitempty"` // Item returned by the verified table, if any
+	Error          string       `json:"error,omitempty"`         // Error returned by the verified table, if any
+	Differences    []string     `json:"differences,omitempty"`   // Unexpected differences between the stream image and the verified item, if any
+	Reason         string       `json:"reason"`                  // Why the validation failed
+}
+
+// FailureLog appends failure records to a JSON-lines file. It is safe for concurrent use.
+type FailureLog struct {
+	*jsonLinesFile
+}
+
+// OpenFailureLog opens (or creates) a dead-letter file in append mode
+func OpenFailureLog(path string) (*FailureLog, error) {
+	file, err := openJSONLinesFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return &FailureLog{file}, nil
+}
+
+// Write appends a single failure record as one JSON line
+func (l *FailureLog) Write(rec FailureRecord) error {
+	if rec.Timestamp.IsZero() {
+		rec.Timestamp = time.Now()
+	}
+	return l.write(rec)
+}
+
+// ReadFailureRecords reads all records from a dead-letter file
+func ReadFailureRecords(path string) ([]FailureRecord, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open failure file: %w", err)
+	}
+	defer file.Close()
+
+	var records []FailureRecord
+	scanner := bufio.NewScanner(file)
+	// Stream images can be up to 400KB, so allow long lines
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+
+		var rec FailureRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return nil, fmt.Errorf("failed to parse line %d: %w", lineNum, err)
+		}
+		records = append(records, rec)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read failure file: %w", err)
+	}
+
+	return records, nil
+}
@@ -0,0 +1,105 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func TestFailureRecordRoundTrip(t *testing.T) {
+	s := func(v string) types.AttributeValue { return &types.AttributeValueMemberS{Value: v} }
+	n := func(v string) types.AttributeValue { return &types.AttributeValueMemberN{Value: v} }
+	want := FailureRecord{
+		Timestamp:      time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC),
+		Table:          "orders",
+		VerifyOn:       "target",
+		Key:            AttributeMap{"pk": s("USER#1"), "sk": n("42")},
+		EventID:        "event-1",
+		EventName:      "MODIFY",
+		SequenceNumber: "000001",
+		StreamImage: AttributeMap{
+			"pk":      s("USER#1"),
+			"sk":      n("42"),
+			"active":  &types.AttributeValueMemberBOOL{Value: true},
+			"deleted": &types.AttributeValueMemberNULL{Value: true},
+			"blob":    &types.AttributeValueMemberB{Value: []byte{0, 1, 2}},
+			"tags":    &types.AttributeValueMemberSS{Value: []string{"a", "b"}},
+			"scores":  &types.AttributeValueMemberNS{Value: []string{"1", "2.5"}},
+			"chunks":  &types.AttributeValueMemberBS{Value: [][]byte{{1}, {2}}},
+			"lines":   &types.AttributeValueMemberL{Value: []types.AttributeValue{s("x"), n("1")}},
+			"address": &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{"city": s("Taipei")}},
+		},
+		ExpectedKey:  AttributeMap{"id": s("1")},
+		ExpectedItem: AttributeMap{"id": s("1"), "price": n("10.5")},
+		VerifiedItem: AttributeMap{"id": s("1"), "price": n("12")},
+		Differences:  []string{"price: expected 10.5, got 12"},
+		Reason:       FailureReasonMismatch,
+	}
+
+	path := filepath.Join(t.TempDir(), "failures.jsonl")
+	log, err := OpenFailureLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := log.Write(want); err != nil {
+		t.Fatal(err)
+	}
+	if err := log.Write(FailureRecord{Key: AttributeMap{"pk": s("USER#2")}, Error: "throttled", Reason: FailureReasonQueryError}); err != nil {
+		t.Fatal(err)
+	}
+	if err := log.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := ReadFailureRecords(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("read %d records, want 2", len(records))
+	}
+
+	got := records[0]
+	if !got.Timestamp.Equal(want.Timestamp) || got.Table != want.Table || got.VerifyOn != want.VerifyOn ||
+		got.EventID != want.EventID || got.EventName != want.EventName || got.SequenceNumber != want.SequenceNumber ||
+		got.Reason != want.Reason || got.Error != want.Error {
+		t.Errorf("record = %+v, want %+v", got, want)
+	}
+	for name, pair := range map[string][2]AttributeMap{
+		"key":           {got.Key, want.Key},
+		"stream image":  {got.StreamImage, want.StreamImage},
+		"expected key":  {got.ExpectedKey, want.ExpectedKey},
+		"expected item": {got.ExpectedItem, want.ExpectedItem},
+		"verified item": {got.VerifiedItem, want.VerifiedItem},
+	} {
+		if !itemsEqual(pair[0], pair[1]) {
+			t.Errorf("%s = %v, want %v", name, pair[0], pair[1])
+		}
+	}
+	if !slices.Equal(got.Differences, want.Differences) {
+		t.Errorf("differences = %v, want %v", got.Differences, want.Differences)
+	}
+
+	// Optional fields stay empty and a timestamp is filled in
+	second := records[1]
+	if second.StreamImage != nil || second.ExpectedKey != nil || second.Differences != nil {
+		t.Errorf("second record = %+v, want no image, expected key or differences", second)
+	}
+	if second.Timestamp.IsZero() || second.Error != "throttled" {
+		t.Errorf("second record = %+v, want a timestamp and the error", second)
+	}
+}
+
+func TestReadFailureRecordsInvalidLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "failures.jsonl")
+	if err := os.WriteFile(path, []byte(`{"key":{"pk":{"S":"a"}}}`+"\n\n"+`{"key":{"pk":{"X":"a"}}}`+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadFailureRecords(path); err == nil {
+		t.Error("expected an error for an attribute value of unknown type")
+	}
+}
@@ -0,0 +1,146 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	log "github.com/sirupsen/logrus"
+)
+
+// FailureReplayConfig contains the configuration for re-running failed validations
+type FailureReplayConfig struct {
+	Client       TableAPI
+	Records      []FailureRecord // Records to verify, e.g. read from the dead-letter file of the stream monitor
+	OutputFile   string          // File that receives the records that still fail (optional)
+	TableName    string          // Overrides the table recorded in each entry (optional)
+	CompareRules *CompareRules   // Attribute comparison rules, nil to only check that items exist (optional)
+	WaitTime     time.Duration   // Time to wait between lookups
+	Verbose      bool            // Whether to show success validation logs
+}
+
+// FailureReplayResult summarizes a replay run
+type FailureReplayResult struct {
+	Total     int
+	Resolved  int // Records that now exist in the verified table, and match when compared
+	Remaining int // Records that still fail
+}
+
+// RunFailureReplay re-runs the verification of every record. With compare rules, an item
+// that exists is also compared with the expected item, or the stream image without a
+// transform. A record that failed with attribute_mismatch is always compared, exactly if
+// no rules are given, so it only resolves once the item matches.
+func RunFailureReplay(ctx context.Context, cfg *FailureReplayConfig) (*FailureReplayResult, error) {
+	records := cfg.Records
+
+	var remainingLog *FailureLog
+	var err error
+	if cfg.OutputFile != "" {
+		remainingLog, err = OpenFailureLog(cfg.OutputFile)
+		if err != nil {
+			return nil, err
+		}
+		defer remainingLog.Close()
+	}
+
+	result := &FailureReplayResult{Total: len(records)}
+
+	for i, rec := range records {
+		if ctx.Err() != nil {
+			return result, ctx.Err()
+		}
+
+		tableName := rec.Table
+		if cfg.TableName != "" {
+			tableName = cfg.TableName
+		}
+		if tableName == "" {
+			return result, fmt.Errorf("record %d has no table name, use a table override", i+1)
+		}
+
+		// A transformed record is looked up by the key the transform computed
+		key := rec.Key
+		if rec.ExpectedKey != nil {
+			key = rec.ExpectedKey
+		}
+		item, err := lookupItem(ctx, cfg.Client, tableName, key)
+		var mismatches []AttributeDiff
+		if err == nil && item != nil {
+			mismatches = cfg.compare(rec, item)
+		}
+		if err == nil && item != nil && len(mismatches) == 0 {
+			result.Resolved++
+			if cfg.Verbose {
+				log.WithFields(log.Fields{
+					"event_id": rec.EventID,
+					"key":      rec.Key.String(),
+				}).Info("[REPLAY] SUCCESS: Item exists in " + tableName + " ✅")
+			}
+		} else {
+			result.Remaining++
+
+			rec.Timestamp = time.Now()
+			rec.Table = tableName
+			rec.VerifiedItem = item
+			rec.Error = ""
+			rec.Differences = nil
+			rec.Reason = FailureReasonNotFound
+			fields := log.Fields{
+				"event_id": rec.EventID,
+				"key":      rec.Key.String(),
+			}
+			switch {
+			case err != nil:
+				rec.Error = err.Error()
+				rec.Reason = FailureReasonQueryError
+				fields["reason"] = rec.Reason
+				fields["error"] = rec.Error
+				log.WithFields(fields).Warn("[REPLAY] FAILED: Item still not found in " + tableName + " ❌")
+			case item != nil:
+				rec.Reason = FailureReasonMismatch
+				rec.Differences = diffStrings(mismatches)
+				fields["differences"] = rec.Differences
+				log.WithFields(fields).Warn("[REPLAY] MISMATCH: Item still differs in " + tableName + " ❌")
+			default:
+				fields["reason"] = rec.Reason
+				log.WithFields(fields).Warn("[REPLAY] FAILED: Item still not found in " + tableName + " ❌")
+			}
+
+			if remainingLog != nil {
+				if err := remainingLog.Write(rec); err != nil {
+					return result, err
+				}
+			}
+		}
+
+		// Wait if configured
+		if cfg.WaitTime > 0 {
+			select {
+			case <-ctx.Done():
+				return result, ctx.Err()
+			case <-time.After(cfg.WaitTime):
+			}
+		}
+	}
+
+	log.Infof("[REPLAY] Done: %d records, %d resolved, %d still failing", result.Total, result.Resolved, result.Remaining)
+	return result, nil
+}
+
+// compare returns the unexpected differences between the item and the one the record
+// expected, or nil if the record is not compared
+func (cfg *FailureReplayConfig) compare(rec FailureRecord, item map[string]types.AttributeValue) []AttributeDiff {
+	rules := cfg.CompareRules
+	if rules == nil && rec.Reason == FailureReasonMismatch {
+		rules = &CompareRules{}
+	}
+	expected := rec.ExpectedItem
+	if expected == nil {
+		expected = rec.StreamImage
+	}
+	if rules == nil || expected == nil {
+		return nil
+	}
+	return rules.Compare(expected, item).Mismatches
+}
@@ -0,0 +1,126 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func TestRunFailureReplay(t *testing.T) {
+	stale := testItem(1)
+	stale["data"] = &types.AttributeValueMemberS{Value: "stale"}
+
+	tests := []struct {
+		name        string
+		reason      string                          // Reason of the replayed record
+		target      map[string]types.AttributeValue // Item in the target table, nil if missing
+		rules       *CompareRules
+		wantReason  string   // Reason of the remaining record, empty if resolved
+		wantDiffers []string // Differences of the remaining record
+	}{
+		{
+			name:   "missing item now exists",
+			reason: FailureReasonNotFound,
+			target: stale,
+		},
+		{
+			name:       "missing item still missing",
+			reason:     FailureReasonNotFound,
+			wantReason: FailureReasonNotFound,
+		},
+		{
+			name:        "mismatched item still differs",
+			reason:      FailureReasonMismatch,
+			target:      stale,
+			wantReason:  FailureReasonMismatch,
+			wantDiffers: []string{"data: expected This is test data #1, got stale"},
+		},
+		{
+			name:   "mismatched item now matches",
+			reason: FailureReasonMismatch,
+			target: testItem(1),
+		},
+		{
+			name:   "mismatched item differs as the rules expect",
+			reason: FailureReasonMismatch,
+			target: stale,
+			rules:  &CompareRules{Ignore: []string{"data"}},
+		},
+		{
+			name:        "missing item exists but differs",
+			reason:      FailureReasonNotFound,
+			target:      stale,
+			rules:       &CompareRules{},
+			wantReason:  FailureReasonMismatch,
+			wantDiffers: []string{"data: expected This is test data #1, got stale"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			table := newFakeTable("orders", "pk", "sk")
+			if tt.target != nil {
+				table.put(tt.target, 0)
+			}
+			output := filepath.Join(t.TempDir(), "remaining.jsonl")
+
+			result, err := RunFailureReplay(context.Background(), &FailureReplayConfig{
+				Client: table,
+				Records: []FailureRecord{{
+					Table:       "orders",
+					Key:         AttributeMap{"pk": testItem(1)["pk"], "sk": testItem(1)["sk"]},
+					StreamImage: testItem(1),
+					Reason:      tt.reason,
+				}},
+				OutputFile:   output,
+				CompareRules: tt.rules,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			remaining, err := ReadFailureRecords(output)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantReason == "" {
+				if result.Resolved != 1 || len(remaining) != 0 {
+					t.Errorf("resolved %d, %d remaining records, want the record resolved", result.Resolved, len(remaining))
+				}
+				return
+			}
+			if result.Remaining != 1 || len(remaining) != 1 {
+				t.Fatalf("%d remaining, %d remaining records, want 1", result.Remaining, len(remaining))
+			}
+			if remaining[0].Reason != tt.wantReason {
+				t.Errorf("reason = %s, want %s", remaining[0].Reason, tt.wantReason)
+			}
+			if !reflect.DeepEqual(remaining[0].Differences, tt.wantDiffers) {
+				t.Errorf("differences = %q, want %q", remaining[0].Differences, tt.wantDiffers)
+			}
+		})
+	}
+}
+
+func TestRunFailureReplayStopsWaiting(t *testing.T) {
+	table := newFakeTable("orders", "pk", "sk")
+	records := make([]FailureRecord, 3)
+	for i := range records {
+		records[i] = FailureRecord{Table: "orders", Key: AttributeMap{"pk": testItem(i)["pk"], "sk": testItem(i)["sk"]}}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	result, err := RunFailureReplay(ctx, &FailureReplayConfig{Client: table, Records: records, WaitTime: time.Hour})
+	if !errors.Is(err, context.DeadlineExceeded) || time.Since(start) > time.Second {
+		t.Fatalf("returned %v after %s, want the context error right after it is done", err, time.Since(start))
+	}
+	if result.Remaining != 1 {
+		t.Errorf("%d records verified, want 1 before the wait", result.Remaining)
+	}
+}
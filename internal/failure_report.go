@@ -0,0 +1,117 @@
+package internal
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// FailureReport summarizes the records of a failure file
+type FailureReport struct {
+	Total      int                       `json:"total"`
+	First      time.Time                 `json:"first"`      // Timestamp of the oldest record
+	Last       time.Time                 `json:"last"`       // Timestamp of the newest record
+	Reasons    map[string]int            `json:"reasons"`    // Records per failure reason
+	Tables     map[string]map[string]int `json:"tables"`     // Records per table and failure reason
+	Attributes map[string]int            `json:"attributes"` // Mismatched records per attribute
+	Errors     map[string]int            `json:"errors"`     // Records per lookup or transform error
+}
+
+// BuildFailureReport counts the records by table, reason, mismatched attribute and error
+func BuildFailureReport(records []FailureRecord) FailureReport {
+	report := FailureReport{
+		Total:      len(records),
+		Reasons:    make(map[string]int),
+		Tables:     make(map[string]map[string]int),
+		Attributes: make(map[string]int),
+		Errors:     make(map[string]int),
+	}
+	for _, rec := range records {
+		if report.First.IsZero() || rec.Timestamp.Before(report.First) {
+			report.First = rec.Timestamp
+		}
+		if rec.Timestamp.After(report.Last) {
+			report.Last = rec.Timestamp
+		}
+
+		report.Reasons[rec.Reason]++
+		if report.Tables[rec.Table] == nil {
+			report.Tables[rec.Table] = make(map[string]int)
+		}
+		report.Tables[rec.Table][rec.Reason]++
+		if rec.Error != "" {
+			report.Errors[rec.Error]++
+		}
+
+		// Differences start with the attribute name, count every attribute once per record
+		seen := make(map[string]struct{}, len(rec.Differences))
+		for _, diff := range rec.Differences {
+			name, _, _ := strings.Cut(diff, ": ")
+			if _, ok := seen[name]; !ok {
+				seen[name] = struct{}{}
+				report.Attributes[name]++
+			}
+		}
+	}
+	return report
+}
+
+// LogFailureReport prints the report, largest counts first
+func LogFailureReport(report FailureReport) {
+	log.Infof("========= Failure Report (%d records) =========", report.Total)
+	if report.Total == 0 {
+		log.Infof("==============================================")
+		return
+	}
+	log.Infof("From %s to %s", report.First.Format(time.RFC3339), report.Last.Format(time.RFC3339))
+
+	log.Infof("By reason:")
+	for _, name := range namesByCount(report.Reasons) {
+		log.Infof("  %-24s %d", name, report.Reasons[name])
+	}
+
+	log.Infof("By table:")
+	tables := make([]string, 0, len(report.Tables))
+	for table := range report.Tables {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	for _, table := range tables {
+		reasons := report.Tables[table]
+		for _, reason := range namesByCount(reasons) {
+			log.Infof("  %-24s %-24s %d", table, reason, reasons[reason])
+		}
+	}
+
+	if len(report.Attributes) > 0 {
+		log.Infof("Mismatched attributes:")
+		for _, name := range namesByCount(report.Attributes) {
+			log.Infof("  %-24s %d", name, report.Attributes[name])
+		}
+	}
+
+	if len(report.Errors) > 0 {
+		log.Infof("Errors:")
+		for _, msg := range namesByCount(report.Errors) {
+			log.Infof("  %6d  %s", report.Errors[msg], msg)
+		}
+	}
+	log.Infof("==============================================")
+}
+
+// namesByCount returns the keys of counts, largest count first, then by name
+func namesByCount(counts map[string]int) []string {
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if counts[names[i]] != counts[names[j]] {
+			return counts[names[i]] > counts[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	return names
+}
@@ -0,0 +1,47 @@
+package internal
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBuildFailureReport(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	records := []FailureRecord{
+		{Timestamp: start.Add(time.Hour), Table: "orders", Reason: FailureReasonNotFound},
+		{Timestamp: start, Table: "orders", Reason: FailureReasonMismatch, Differences: []string{
+			"status: expected PAID, got NEW",
+			"total: expected 10, got 12",
+		}},
+		{Timestamp: start.Add(2 * time.Hour), Table: "users", Reason: FailureReasonMismatch, Differences: []string{
+			"status: expected <missing>, got NEW",
+		}},
+		{Timestamp: start.Add(30 * time.Minute), Table: "users", Reason: FailureReasonQueryError, Error: "throttled"},
+	}
+
+	report := BuildFailureReport(records)
+
+	want := FailureReport{
+		Total: 4,
+		First: start,
+		Last:  start.Add(2 * time.Hour),
+		Reasons: map[string]int{
+			FailureReasonNotFound:   1,
+			FailureReasonMismatch:   2,
+			FailureReasonQueryError: 1,
+		},
+		Tables: map[string]map[string]int{
+			"orders": {FailureReasonNotFound: 1, FailureReasonMismatch: 1},
+			"users":  {FailureReasonMismatch: 1, FailureReasonQueryError: 1},
+		},
+		Attributes: map[string]int{"status": 2, "total": 1},
+		Errors:     map[string]int{"throttled": 1},
+	}
+	if !reflect.DeepEqual(report, want) {
+		t.Errorf("report = %+v, want %+v", report, want)
+	}
+	if got := namesByCount(report.Reasons); !reflect.DeepEqual(got, []string{FailureReasonMismatch, FailureReasonNotFound, FailureReasonQueryError}) {
+		t.Errorf("reasons by count = %v", got)
+	}
+}
@@ -0,0 +1,787 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodbstreams"
+	streamtypes "github.com/aws/aws-sdk-go-v2/service/dynamodbstreams/types"
+)
+
+// fakeTable is an in-memory DynamoDB table implementing TableAdminAPI. Items written with a
+// delay only become visible once the delay has passed, to simulate replication lag.
+type fakeTable struct {
+	mu           sync.Mutex
+	name         string
+	partitionKey string
+	sortKey      string
+	streamArn    string
+	items        map[string]fakeItem
+	readErr      error // Returned by every GetItem and BatchGetItem call when set
+	readCalls    int   // Number of GetItem and BatchGetItem calls
+	writeErr     error // Returned by every write when set
+	writes       int   // Number of successful writes
+	batchKeys    []int // Number of keys of every BatchGetItem call
+	maxProcessed int   // Keys processed per BatchGetItem call, the others are unprocessed (optional)
+
+	// Eventually consistent reads miss items that became visible less than this long ago (optional)
+	staleReads      time.Duration
+	consistentCalls int // Number of strongly consistent BatchGetItem calls
+
+	// Settings returned by the describe calls (optional). DescribeTable returns definition
+	// instead of the key schema when it is set.
+	definition *types.TableDescription
+	ttl        *types.TimeToLiveDescription
+	pitr       types.PointInTimeRecoveryStatus
+	tags       []types.Tag
+	tagsErr    error // Returned by ListTagsOfResource when set
+
+	created *dynamodb.CreateTableInput // Request of the last CreateTable call
+}
+
+// fakeItem is a stored item and the time it becomes visible
+type fakeItem struct {
+	item      map[string]types.AttributeValue
+	visibleAt time.Time
+}
+
+func newFakeTable(name, partitionKey, sortKey string) *fakeTable {
+	return &fakeTable{
+		name:         name,
+		partitionKey: partitionKey,
+		sortKey:      sortKey,
+		items:        make(map[string]fakeItem),
+	}
+}
+
+// put stores an item that becomes visible after delay
+func (t *fakeTable) put(item map[string]types.AttributeValue, delay time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.items[t.keyString(item)] = fakeItem{item: item, visibleAt: time.Now().Add(delay)}
+}
+
+// remove deletes the item with the key of item
+func (t *fakeTable) remove(item map[string]types.AttributeValue) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.items, t.keyString(item))
+}
+
+// keyString returns the primary key of item as a map key
+func (t *fakeTable) keyString(item map[string]types.AttributeValue) string {
+	key := AttributeMap{t.partitionKey: item[t.partitionKey]}
+	if t.sortKey != "" {
+		key[t.sortKey] = item[t.sortKey]
+	}
+	return key.String()
+}
+
+// GetItem implements TableAPI
+func (t *fakeTable) GetItem(_ context.Context, params *dynamodb.GetItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.readCalls++
+
+	if t.readErr != nil {
+		return nil, t.readErr
+	}
+	if aws.ToString(params.TableName) != t.name {
+		return nil, &types.ResourceNotFoundException{Message: aws.String("Requested resource not found: " + aws.ToString(params.TableName))}
+	}
+
+	stored, ok := t.items[t.keyString(params.Key)]
+	if !ok || time.Now().Before(stored.visibleAt) {
+		return &dynamodb.GetItemOutput{}, nil
+	}
+	return &dynamodb.GetItemOutput{Item: stored.item}, nil
+}
+
+// BatchGetItem implements TableAPI. Every key consumes 0.5 RCU, or 1 RCU with a strongly
+// consistent read, and only the projected attributes are returned.
+func (t *fakeTable) BatchGetItem(_ context.Context, params *dynamodb.BatchGetItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.BatchGetItemOutput, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.readCalls++
+
+	if t.readErr != nil {
+		return nil, t.readErr
+	}
+	request, ok := params.RequestItems[t.name]
+	if !ok || len(params.RequestItems) != 1 {
+		return nil, &types.ResourceNotFoundException{Message: aws.String("Requested resource not found")}
+	}
+	if len(request.Keys) > 100 {
+		return nil, errors.New("ValidationException: too many items requested for the BatchGetItem call")
+	}
+	t.batchKeys = append(t.batchKeys, len(request.Keys))
+	staleness, costPerKey := t.staleReads, 0.5
+	if aws.ToBool(request.ConsistentRead) {
+		t.consistentCalls++
+		staleness, costPerKey = 0, 1
+	}
+
+	keys := request.Keys
+	out := &dynamodb.BatchGetItemOutput{Responses: map[string][]map[string]types.AttributeValue{}}
+	if t.maxProcessed > 0 && len(keys) > t.maxProcessed {
+		unprocessed := request
+		unprocessed.Keys = keys[t.maxProcessed:]
+		out.UnprocessedKeys = map[string]types.KeysAndAttributes{t.name: unprocessed}
+		keys = keys[:t.maxProcessed]
+	}
+
+	var projection []string
+	for _, placeholder := range strings.Split(aws.ToString(request.ProjectionExpression), ",") {
+		if placeholder = strings.TrimSpace(placeholder); placeholder != "" {
+			projection = append(projection, request.ExpressionAttributeNames[placeholder])
+		}
+	}
+
+	for _, key := range keys {
+		stored, ok := t.items[t.keyString(key)]
+		if !ok || time.Now().Before(stored.visibleAt.Add(staleness)) {
+			continue
+		}
+		item := stored.item
+		if projection != nil {
+			item = make(map[string]types.AttributeValue, len(projection))
+			for _, name := range projection {
+				if av, ok := stored.item[name]; ok {
+					item[name] = av
+				}
+			}
+		}
+		out.Responses[t.name] = append(out.Responses[t.name], item)
+	}
+	out.ConsumedCapacity = []types.ConsumedCapacity{{
+		TableName:     aws.String(t.name),
+		CapacityUnits: aws.Float64(costPerKey * float64(len(keys))),
+	}}
+	return out, nil
+}
+
+// Scan implements TableAPI. Items are returned in the order of their key, split into
+// segments by a hash of the key. Every item read consumes 0.5 RCU.
+func (t *fakeTable) Scan(_ context.Context, params *dynamodb.ScanInput, _ ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.readCalls++
+
+	if t.readErr != nil {
+		return nil, t.readErr
+	}
+	if aws.ToString(params.TableName) != t.name {
+		return nil, &types.ResourceNotFoundException{Message: aws.String("Requested resource not found: " + aws.ToString(params.TableName))}
+	}
+
+	var keys []string
+	for key, stored := range t.items {
+		if time.Now().Before(stored.visibleAt) {
+			continue
+		}
+		if segments := aws.ToInt32(params.TotalSegments); segments > 1 {
+			h := fnv.New32a()
+			h.Write([]byte(key))
+			if int32(h.Sum32()%uint32(segments)) != aws.ToInt32(params.Segment) {
+				continue
+			}
+		}
+		if params.ExclusiveStartKey != nil && key <= t.keyString(params.ExclusiveStartKey) {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	out := &dynamodb.ScanOutput{}
+	for _, key := range keys {
+		if limit := aws.ToInt32(params.Limit); limit > 0 && len(out.Items) == int(limit) {
+			last := out.Items[len(out.Items)-1]
+			out.LastEvaluatedKey = map[string]types.AttributeValue{t.partitionKey: last[t.partitionKey]}
+			if t.sortKey != "" {
+				out.LastEvaluatedKey[t.sortKey] = last[t.sortKey]
+			}
+			break
+		}
+		out.Items = append(out.Items, t.items[key].item)
+	}
+	out.Count = int32(len(out.Items))
+	out.ScannedCount = out.Count
+	out.ConsumedCapacity = &types.ConsumedCapacity{
+		TableName:     aws.String(t.name),
+		CapacityUnits: aws.Float64(0.5 * float64(len(out.Items))),
+	}
+	return out, nil
+}
+
+// PutItem implements TableAPI. Conditions see every stored item, even one that is not
+// visible to reads yet.
+func (t *fakeTable) PutItem(_ context.Context, params *dynamodb.PutItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.writeErr != nil {
+		return nil, t.writeErr
+	}
+	if aws.ToString(params.TableName) != t.name {
+		return nil, &types.ResourceNotFoundException{Message: aws.String("Requested resource not found: " + aws.ToString(params.TableName))}
+	}
+
+	key := t.keyString(params.Item)
+	ok, err := fakeCondition(aws.ToString(params.ConditionExpression), params.ExpressionAttributeNames, params.ExpressionAttributeValues, t.items[key].item)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, &types.ConditionalCheckFailedException{Message: aws.String("The conditional request failed")}
+	}
+	t.items[key] = fakeItem{item: params.Item}
+	t.writes++
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+// DeleteItem implements TableAPI
+func (t *fakeTable) DeleteItem(_ context.Context, params *dynamodb.DeleteItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.writeErr != nil {
+		return nil, t.writeErr
+	}
+	if aws.ToString(params.TableName) != t.name {
+		return nil, &types.ResourceNotFoundException{Message: aws.String("Requested resource not found: " + aws.ToString(params.TableName))}
+	}
+
+	key := t.keyString(params.Key)
+	ok, err := fakeCondition(aws.ToString(params.ConditionExpression), params.ExpressionAttributeNames, params.ExpressionAttributeValues, t.items[key].item)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, &types.ConditionalCheckFailedException{Message: aws.String("The conditional request failed")}
+	}
+	delete(t.items, key)
+	t.writes++
+	return &dynamodb.DeleteItemOutput{}, nil
+}
+
+// count returns the number of stored items
+func (t *fakeTable) count() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.items)
+}
+
+// writeCount returns the number of successful writes
+func (t *fakeTable) writeCount() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.writes
+}
+
+// get returns a stored item, visible or not, or nil
+func (t *fakeTable) get(key map[string]types.AttributeValue) map[string]types.AttributeValue {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.items[t.keyString(key)].item
+}
+
+// fakeCondition evaluates a condition expression against the stored item (nil if missing).
+// Only clauses joined by OR are supported: attribute_not_exists(#a), attribute_exists(#a),
+// #a < :v and #a <= :v.
+func fakeCondition(expr string, names map[string]string, values map[string]types.AttributeValue, item map[string]types.AttributeValue) (bool, error) {
+	if expr == "" {
+		return true, nil
+	}
+	for _, clause := range strings.Split(expr, " OR ") {
+		clause = strings.TrimSpace(clause)
+		var holds bool
+		switch {
+		case strings.HasPrefix(clause, "attribute_not_exists(") && strings.HasSuffix(clause, ")"):
+			_, exists := item[names[clause[len("attribute_not_exists("):len(clause)-1]]]
+			holds = !exists
+		case strings.HasPrefix(clause, "attribute_exists(") && strings.HasSuffix(clause, ")"):
+			_, holds = item[names[clause[len("attribute_exists("):len(clause)-1]]]
+		default:
+			parts := strings.Fields(clause)
+			if len(parts) != 3 || (parts[1] != "<" && parts[1] != "<=") {
+				return false, fmt.Errorf("ValidationException: unsupported condition %q", clause)
+			}
+			current, ok := item[names[parts[0]]]
+			if !ok {
+				break
+			}
+			cmp, ok := compareFakeValues(current, values[parts[2]])
+			if !ok {
+				return false, fmt.Errorf("ValidationException: cannot compare %s", clause)
+			}
+			holds = cmp < 0 || (parts[1] == "<=" && cmp == 0)
+		}
+		if holds {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// compareFakeValues compares two numbers or two strings
+func compareFakeValues(a, b types.AttributeValue) (int, bool) {
+	switch a := a.(type) {
+	case *types.AttributeValueMemberN:
+		b, ok := b.(*types.AttributeValueMemberN)
+		if !ok {
+			return 0, false
+		}
+		x, okX := new(big.Rat).SetString(a.Value)
+		y, okY := new(big.Rat).SetString(b.Value)
+		if !okX || !okY {
+			return 0, false
+		}
+		return x.Cmp(y), true
+	case *types.AttributeValueMemberS:
+		b, ok := b.(*types.AttributeValueMemberS)
+		if !ok {
+			return 0, false
+		}
+		return strings.Compare(a.Value, b.Value), true
+	}
+	return 0, false
+}
+
+// DescribeTable implements TableAPI
+func (t *fakeTable) DescribeTable(_ context.Context, params *dynamodb.DescribeTableInput, _ ...func(*dynamodb.Options)) (*dynamodb.DescribeTableOutput, error) {
+	if aws.ToString(params.TableName) != t.name {
+		return nil, &types.ResourceNotFoundException{Message: aws.String("Requested resource not found: " + aws.ToString(params.TableName))}
+	}
+
+	keySchema := []types.KeySchemaElement{{AttributeName: aws.String(t.partitionKey), KeyType: types.KeyTypeHash}}
+	if t.sortKey != "" {
+		keySchema = append(keySchema, types.KeySchemaElement{AttributeName: aws.String(t.sortKey), KeyType: types.KeyTypeRange})
+	}
+
+	desc := &types.TableDescription{KeySchema: keySchema}
+	if t.definition != nil {
+		definition := *t.definition
+		desc = &definition
+	}
+	desc.TableName = aws.String(t.name)
+	desc.TableArn = aws.String("arn:aws:dynamodb:ap-northeast-1:123456789012:table/" + t.name)
+	desc.TableStatus = types.TableStatusActive
+	if t.streamArn != "" {
+		desc.LatestStreamArn = aws.String(t.streamArn)
+	}
+	return &dynamodb.DescribeTableOutput{Table: desc}, nil
+}
+
+// CreateTable implements TableAdminAPI. The table already exists, only the request is kept.
+func (t *fakeTable) CreateTable(_ context.Context, params *dynamodb.CreateTableInput, _ ...func(*dynamodb.Options)) (*dynamodb.CreateTableOutput, error) {
+	if aws.ToString(params.TableName) != t.name {
+		return nil, fmt.Errorf("fake table %s cannot create table %s", t.name, aws.ToString(params.TableName))
+	}
+	t.created = params
+	return &dynamodb.CreateTableOutput{}, nil
+}
+
+// UpdateTimeToLive implements TableAdminAPI
+func (t *fakeTable) UpdateTimeToLive(_ context.Context, params *dynamodb.UpdateTimeToLiveInput, _ ...func(*dynamodb.Options)) (*dynamodb.UpdateTimeToLiveOutput, error) {
+	status := types.TimeToLiveStatusDisabled
+	if aws.ToBool(params.TimeToLiveSpecification.Enabled) {
+		status = types.TimeToLiveStatusEnabled
+	}
+	t.ttl = &types.TimeToLiveDescription{TimeToLiveStatus: status, AttributeName: params.TimeToLiveSpecification.AttributeName}
+	return &dynamodb.UpdateTimeToLiveOutput{}, nil
+}
+
+// DescribeTimeToLive implements TableAdminAPI
+func (t *fakeTable) DescribeTimeToLive(_ context.Context, _ *dynamodb.DescribeTimeToLiveInput, _ ...func(*dynamodb.Options)) (*dynamodb.DescribeTimeToLiveOutput, error) {
+	return &dynamodb.DescribeTimeToLiveOutput{TimeToLiveDescription: t.ttl}, nil
+}
+
+// DescribeContinuousBackups implements TableAdminAPI
+func (t *fakeTable) DescribeContinuousBackups(_ context.Context, _ *dynamodb.DescribeContinuousBackupsInput, _ ...func(*dynamodb.Options)) (*dynamodb.DescribeContinuousBackupsOutput, error) {
+	pitr := t.pitr
+	if pitr == "" {
+		pitr = types.PointInTimeRecoveryStatusDisabled
+	}
+	return &dynamodb.DescribeContinuousBackupsOutput{
+		ContinuousBackupsDescription: &types.ContinuousBackupsDescription{
+			ContinuousBackupsStatus:        types.ContinuousBackupsStatusEnabled,
+			PointInTimeRecoveryDescription: &types.PointInTimeRecoveryDescription{PointInTimeRecoveryStatus: pitr},
+		},
+	}, nil
+}
+
+// ListTagsOfResource implements TableAdminAPI
+func (t *fakeTable) ListTagsOfResource(_ context.Context, _ *dynamodb.ListTagsOfResourceInput, _ ...func(*dynamodb.Options)) (*dynamodb.ListTagsOfResourceOutput, error) {
+	if t.tagsErr != nil {
+		return nil, t.tagsErr
+	}
+	return &dynamodb.ListTagsOfResourceOutput{Tags: t.tags}, nil
+}
+
+// fakeStream is an in-memory DynamoDB stream implementing StreamAPI. Shards can be split,
+// which closes the shard and opens two children, and trimmed, which drops their oldest
+// records as the 24h retention would.
+type fakeStream struct {
+	mu        sync.Mutex
+	arn       string
+	shards    []*fakeShard
+	sequence  int
+	iterators map[string]fakeIterator
+	pageSize  int // Shards per DescribeStream page
+
+	// Returned by DescribeStream (optional), an enabled NEW_AND_OLD_IMAGES stream by default
+	status   streamtypes.StreamStatus
+	viewType streamtypes.StreamViewType
+	table    string // Defaults to the table in the stream ARN
+
+	// onGetRecords is called before every GetRecords call, outside the lock (optional)
+	onGetRecords func(shardID string)
+
+	expireNext int // Number of following GetRecords calls that fail with an expired iterator
+}
+
+// fakeShard holds the records of one shard
+type fakeShard struct {
+	id       string
+	parentID string
+	records  []streamtypes.Record
+	trimmed  int // Number of records removed from the start of the shard
+	closed   bool
+}
+
+// fakeIterator points at the next record of a shard, counted from the start of the shard
+type fakeIterator struct {
+	shardID  string
+	position int
+}
+
+func newFakeStream(arn string) *fakeStream {
+	// ARNs look like arn:aws:dynamodb:region:account:table/orders/stream/label
+	_, table, _ := strings.Cut(arn, ":table/")
+	table, _, _ = strings.Cut(table, "/")
+	s := &fakeStream{
+		arn:       arn,
+		iterators: make(map[string]fakeIterator),
+		pageSize:  100,
+		table:     table,
+	}
+	s.shards = append(s.shards, &fakeShard{id: s.nextShardID()})
+	return s
+}
+
+// nextShardID returns the ID of the next shard created
+func (s *fakeStream) nextShardID() string {
+	return fmt.Sprintf("shardId-%08d", len(s.shards)+1)
+}
+
+// append adds a record for item to one of the open shards, chosen by its key
+func (s *fakeStream) append(eventName streamtypes.OperationType, keys, newImage map[string]types.AttributeValue) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var open []*fakeShard
+	for _, shard := range s.shards {
+		if !shard.closed {
+			open = append(open, shard)
+		}
+	}
+	h := fnv.New32a()
+	h.Write([]byte(AttributeMap(keys).String()))
+	shard := open[int(h.Sum32())%len(open)]
+
+	s.sequence++
+	shard.records = append(shard.records, streamtypes.Record{
+		EventID:   aws.String(fmt.Sprintf("event-%d", s.sequence)),
+		EventName: eventName,
+		Dynamodb: &streamtypes.StreamRecord{
+			ApproximateCreationDateTime: aws.Time(time.Now()),
+			Keys:                        toStreamImage(keys),
+			NewImage:                    toStreamImage(newImage),
+			SequenceNumber:              aws.String(strconv.Itoa(s.sequence)),
+		},
+	})
+}
+
+// split closes an open shard and opens two children
+func (s *fakeStream) split(shardID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, shard := range s.shards {
+		if shard.id == shardID && !shard.closed {
+			shard.closed = true
+			for range 2 {
+				s.shards = append(s.shards, &fakeShard{id: s.nextShardID(), parentID: shardID})
+			}
+			return
+		}
+	}
+}
+
+// trim removes the oldest n records of a shard
+func (s *fakeStream) trim(shardID string, n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if shard := s.findShard(shardID); shard != nil {
+		shard.trimmed = min(shard.trimmed+n, len(shard.records))
+	}
+}
+
+// expire makes the next n GetRecords calls fail with an expired iterator
+func (s *fakeStream) expire(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expireNext = n
+}
+
+// iteratorCount returns the number of shard iterators handed out
+func (s *fakeStream) iteratorCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.iterators)
+}
+
+// findShard returns the shard with the given ID, or nil. The caller holds the lock.
+func (s *fakeStream) findShard(shardID string) *fakeShard {
+	for _, shard := range s.shards {
+		if shard.id == shardID {
+			return shard
+		}
+	}
+	return nil
+}
+
+// newIterator hands out an iterator. The caller holds the lock.
+func (s *fakeStream) newIterator(shardID string, position int) *string {
+	id := fmt.Sprintf("iterator-%d", len(s.iterators)+1)
+	s.iterators[id] = fakeIterator{shardID: shardID, position: position}
+	return aws.String(id)
+}
+
+// DescribeStream implements StreamAPI
+func (s *fakeStream) DescribeStream(_ context.Context, params *dynamodbstreams.DescribeStreamInput, _ ...func(*dynamodbstreams.Options)) (*dynamodbstreams.DescribeStreamOutput, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if aws.ToString(params.StreamArn) != s.arn {
+		return nil, &streamtypes.ResourceNotFoundException{Message: aws.String("Requested resource not found: " + aws.ToString(params.StreamArn))}
+	}
+
+	start := 0
+	if params.ExclusiveStartShardId != nil {
+		for i, shard := range s.shards {
+			if shard.id == *params.ExclusiveStartShardId {
+				start = i + 1
+			}
+		}
+	}
+	end := min(start+s.pageSize, len(s.shards))
+
+	desc := &streamtypes.StreamDescription{
+		StreamArn:      aws.String(s.arn),
+		StreamStatus:   streamtypes.StreamStatusEnabled,
+		StreamViewType: streamtypes.StreamViewTypeNewAndOldImages,
+		TableName:      aws.String(s.table),
+	}
+	if s.status != "" {
+		desc.StreamStatus = s.status
+	}
+	if s.viewType != "" {
+		desc.StreamViewType = s.viewType
+	}
+	for _, shard := range s.shards[start:end] {
+		out := streamtypes.Shard{
+			ShardId:             aws.String(shard.id),
+			SequenceNumberRange: &streamtypes.SequenceNumberRange{},
+		}
+		if shard.parentID != "" {
+			out.ParentShardId = aws.String(shard.parentID)
+		}
+		if shard.closed && len(shard.records) > 0 {
+			out.SequenceNumberRange.EndingSequenceNumber = shard.records[len(shard.records)-1].Dynamodb.SequenceNumber
+		}
+		desc.Shards = append(desc.Shards, out)
+	}
+	if end < len(s.shards) {
+		desc.LastEvaluatedShardId = aws.String(s.shards[end-1].id)
+	}
+	return &dynamodbstreams.DescribeStreamOutput{StreamDescription: desc}, nil
+}
+
+// GetShardIterator implements StreamAPI
+func (s *fakeStream) GetShardIterator(_ context.Context, params *dynamodbstreams.GetShardIteratorInput, _ ...func(*dynamodbstreams.Options)) (*dynamodbstreams.GetShardIteratorOutput, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	shard := s.findShard(aws.ToString(params.ShardId))
+	if aws.ToString(params.StreamArn) != s.arn || shard == nil {
+		return nil, &streamtypes.ResourceNotFoundException{Message: aws.String("Requested resource not found")}
+	}
+
+	var position int
+	switch params.ShardIteratorType {
+	case streamtypes.ShardIteratorTypeTrimHorizon:
+		position = shard.trimmed
+	case streamtypes.ShardIteratorTypeLatest:
+		position = len(shard.records)
+	case streamtypes.ShardIteratorTypeAtSequenceNumber, streamtypes.ShardIteratorTypeAfterSequenceNumber:
+		position = -1
+		for i, rec := range shard.records {
+			if aws.ToString(rec.Dynamodb.SequenceNumber) == aws.ToString(params.SequenceNumber) {
+				position = i
+			}
+		}
+		if position < 0 {
+			return nil, &streamtypes.ResourceNotFoundException{Message: aws.String("Sequence number not found")}
+		}
+		if params.ShardIteratorType == streamtypes.ShardIteratorTypeAfterSequenceNumber {
+			position++
+		}
+		if position < shard.trimmed {
+			return nil, &streamtypes.TrimmedDataAccessException{Message: aws.String("Sequence number is beyond the trim horizon")}
+		}
+	default:
+		return nil, fmt.Errorf("unsupported iterator type %q", params.ShardIteratorType)
+	}
+
+	return &dynamodbstreams.GetShardIteratorOutput{ShardIterator: s.newIterator(shard.id, position)}, nil
+}
+
+// GetRecords implements StreamAPI
+func (s *fakeStream) GetRecords(_ context.Context, params *dynamodbstreams.GetRecordsInput, _ ...func(*dynamodbstreams.Options)) (*dynamodbstreams.GetRecordsOutput, error) {
+	s.mu.Lock()
+	iter, ok := s.iterators[aws.ToString(params.ShardIterator)]
+	if ok && s.expireNext > 0 {
+		s.expireNext--
+		ok = false
+	}
+	s.mu.Unlock()
+	if !ok {
+		return nil, &streamtypes.ExpiredIteratorException{Message: aws.String("Iterator expired")}
+	}
+
+	if s.onGetRecords != nil {
+		s.onGetRecords(iter.shardID)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	shard := s.findShard(iter.shardID)
+	if iter.position < shard.trimmed {
+		return nil, &streamtypes.TrimmedDataAccessException{Message: aws.String("Records are beyond the trim horizon")}
+	}
+
+	limit := 1000
+	if params.Limit != nil {
+		limit = int(*params.Limit)
+	}
+	end := min(iter.position+limit, len(shard.records))
+
+	out := &dynamodbstreams.GetRecordsOutput{
+		Records: append([]streamtypes.Record(nil), shard.records[iter.position:end]...),
+	}
+	// A closed shard ends once every record has been read
+	if !shard.closed || end < len(shard.records) {
+		out.NextShardIterator = s.newIterator(shard.id, end)
+	}
+	return out, nil
+}
+
+// toStreamImage converts an item to a stream image
+func toStreamImage(item map[string]types.AttributeValue) map[string]streamtypes.AttributeValue {
+	if item == nil {
+		return nil
+	}
+	image := make(map[string]streamtypes.AttributeValue, len(item))
+	for name, av := range item {
+		switch v := av.(type) {
+		case *types.AttributeValueMemberS:
+			image[name] = &streamtypes.AttributeValueMemberS{Value: v.Value}
+		case *types.AttributeValueMemberN:
+			image[name] = &streamtypes.AttributeValueMemberN{Value: v.Value}
+		case *types.AttributeValueMemberBOOL:
+			image[name] = &streamtypes.AttributeValueMemberBOOL{Value: v.Value}
+		default:
+			panic(fmt.Sprintf("fake stream: unsupported attribute type %T", av))
+		}
+	}
+	return image
+}
+
+// fakeMigration is a source table with a stream, replicated to a target table
+type fakeMigration struct {
+	source *fakeTable
+	target *fakeTable
+	stream *fakeStream
+
+	// replicationDelay is how long a write takes to become visible in the target table
+	replicationDelay time.Duration
+}
+
+func newFakeMigration() *fakeMigration {
+	const streamArn = "arn:aws:dynamodb:ap-northeast-1:123456789012:table/orders/stream/2025-01-01T00:00:00.000"
+	m := &fakeMigration{
+		source: newFakeTable("orders", "pk", "sk"),
+		target: newFakeTable("orders", "pk", "sk"),
+		stream: newFakeStream(streamArn),
+	}
+	m.source.streamArn = streamArn
+	return m
+}
+
+// write puts an item in the source table and the stream, and replicates it to the target
+// table after the replication delay
+func (m *fakeMigration) write(item map[string]types.AttributeValue) {
+	m.writeUnreplicated(item)
+	m.target.put(item, m.replicationDelay)
+}
+
+// writeUnreplicated puts an item in the source table and the stream only, as if
+// replication lost it
+func (m *fakeMigration) writeUnreplicated(item map[string]types.AttributeValue) {
+	eventName := streamtypes.OperationTypeInsert
+	if out, _ := m.source.GetItem(context.Background(), &dynamodb.GetItemInput{
+		TableName: aws.String(m.source.name),
+		Key:       item,
+	}); out.Item != nil {
+		eventName = streamtypes.OperationTypeModify
+	}
+
+	m.source.put(item, 0)
+	m.stream.append(eventName, m.keyOf(item), item)
+}
+
+// delete removes an item from both tables and adds a REMOVE record to the stream
+func (m *fakeMigration) delete(item map[string]types.AttributeValue) {
+	m.source.remove(item)
+	m.target.remove(item)
+	m.stream.append(streamtypes.OperationTypeRemove, m.keyOf(item), nil)
+}
+
+// keyOf returns the primary key of item
+func (m *fakeMigration) keyOf(item map[string]types.AttributeValue) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{"pk": item["pk"], "sk": item["sk"]}
+}
+
+// testItem returns item number n of the test data
+func testItem(n int) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"pk":   &types.AttributeValueMemberS{Value: fmt.Sprintf("TEST_PK_%d", n)},
+		"sk":   &types.AttributeValueMemberS{Value: fmt.Sprintf("TEST_SK_%d", n)},
+		"data": &types.AttributeValueMemberS{Value: fmt.Sprintf("This is test data #%d", n)},
+	}
+}
@@ -0,0 +1,258 @@
+package internal
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Rules that make a difference between the stream image and the verified item expected
+const (
+	CompareRuleIgnore           = "ignore"
+	CompareRuleRename           = "rename"
+	CompareRuleNumericTolerance = "numeric_tolerance"
+	CompareRuleTimeTolerance    = "time_tolerance"
+	CompareRuleCoerce           = "coerce"
+)
+
+// Types attributes can be coerced to before they are compared
+const (
+	CoerceString = "S"
+	CoerceNumber = "N"
+)
+
+// CompareRules describe the differences expected between the stream image of an item and
+// the item in the verified table. Rules apply to top-level attributes, named as in the
+// stream image.
+type CompareRules struct {
+	Ignore           []string                 // Attributes that are not compared
+	Rename           map[string]string        // Stream image attribute name to verified item attribute name
+	NumericTolerance map[string]float64       // Largest absolute difference accepted between two numbers
+	TimeTolerance    map[string]time.Duration // Largest difference accepted between two timestamps, RFC 3339 strings or epoch seconds
+	Coerce           map[string]string        // Type both values are converted to before comparing: S or N
+}
+
+// AttributeDiff is one attribute that differs between the stream image and the verified item
+type AttributeDiff struct {
+	Attribute string               // Name in the stream image
+	Expected  types.AttributeValue // Value in the stream image (nil if missing)
+	Actual    types.AttributeValue // Value in the verified item (nil if missing)
+	Rule      string               // Rule that expects the difference, empty if it is unexpected
+}
+
+// String describes the difference for logs and the failure file
+func (d AttributeDiff) String() string {
+	describe := func(av types.AttributeValue) string {
+		if av == nil {
+			return "<missing>"
+		}
+		return formatAttributeValue(av)
+	}
+	s := fmt.Sprintf("%s: expected %s, got %s", d.Attribute, describe(d.Expected), describe(d.Actual))
+	if d.Rule != "" {
+		s += " (" + d.Rule + ")"
+	}
+	return s
+}
+
+// ItemComparison is the outcome of comparing a stream image with the verified item
+type ItemComparison struct {
+	Mismatches []AttributeDiff // Differences no rule expects
+	Expected   []AttributeDiff // Differences covered by a rule
+}
+
+// Validate checks that the rules are consistent
+func (r *CompareRules) Validate() error {
+	for name, coerce := range r.Coerce {
+		if coerce != CoerceString && coerce != CoerceNumber {
+			return fmt.Errorf("attribute %s: coercion must be S or N, got %q", name, coerce)
+		}
+	}
+	for name, tolerance := range r.NumericTolerance {
+		if tolerance < 0 {
+			return fmt.Errorf("attribute %s: numeric tolerance must not be negative", name)
+		}
+	}
+	for name, tolerance := range r.TimeTolerance {
+		if tolerance < 0 {
+			return fmt.Errorf("attribute %s: time tolerance must not be negative", name)
+		}
+	}
+	targets := make(map[string]string, len(r.Rename))
+	for from, to := range r.Rename {
+		if other, ok := targets[to]; ok {
+			return fmt.Errorf("attributes %s and %s are both renamed to %s", other, from, to)
+		}
+		targets[to] = from
+	}
+	return nil
+}
+
+// Compare compares the stream image with the verified item attribute by attribute
+func (r *CompareRules) Compare(image, item map[string]types.AttributeValue) ItemComparison {
+	var result ItemComparison
+	add := func(diff AttributeDiff) {
+		if diff.Rule == "" {
+			result.Mismatches = append(result.Mismatches, diff)
+		} else {
+			result.Expected = append(result.Expected, diff)
+		}
+	}
+
+	// Attributes of the image, under their name in the verified item
+	compared := make(map[string]struct{}, len(image))
+	for _, name := range sortedNames(image) {
+		expected := image[name]
+		target, renamed := r.Rename[name]
+		if !renamed {
+			target = name
+		}
+		compared[target] = struct{}{}
+		actual := item[target]
+
+		switch {
+		case r.ignored(name) || r.ignored(target):
+			if actual == nil || !attributeValuesEqual(expected, actual) {
+				add(AttributeDiff{Attribute: name, Expected: expected, Actual: actual, Rule: CompareRuleIgnore})
+			}
+		case actual == nil:
+			add(AttributeDiff{Attribute: name, Expected: expected})
+		case attributeValuesEqual(expected, actual):
+			if renamed {
+				add(AttributeDiff{Attribute: name, Expected: expected, Actual: actual, Rule: CompareRuleRename})
+			}
+		default:
+			add(AttributeDiff{Attribute: name, Expected: expected, Actual: actual, Rule: r.expectedRule(name, expected, actual)})
+		}
+	}
+
+	// Attributes only found in the verified item
+	for _, name := range sortedNames(item) {
+		if _, ok := compared[name]; ok {
+			continue
+		}
+		diff := AttributeDiff{Attribute: name, Actual: item[name]}
+		if r.ignored(name) {
+			diff.Rule = CompareRuleIgnore
+		}
+		add(diff)
+	}
+	return result
+}
+
+// ignored reports whether the attribute is not compared
+func (r *CompareRules) ignored(name string) bool {
+	for _, ignored := range r.Ignore {
+		if ignored == name {
+			return true
+		}
+	}
+	return false
+}
+
+// expectedRule returns the rule that accepts two different values of an attribute, or
+// an empty string if none does
+func (r *CompareRules) expectedRule(name string, expected, actual types.AttributeValue) string {
+	if tolerance, ok := r.TimeTolerance[name]; ok {
+		a, okA := parseTimestamp(expected)
+		b, okB := parseTimestamp(actual)
+		if okA && okB && absDuration(a.Sub(b)) <= tolerance {
+			return CompareRuleTimeTolerance
+		}
+		return ""
+	}
+
+	coerce := r.Coerce[name]
+	if tolerance, ok := r.NumericTolerance[name]; ok {
+		if _, isNumber := expected.(*types.AttributeValueMemberN); !isNumber && coerce != CoerceNumber {
+			return ""
+		}
+		a, okA := parseNumber(expected)
+		b, okB := parseNumber(actual)
+		if okA && okB && math.Abs(a-b) <= tolerance {
+			return CompareRuleNumericTolerance
+		}
+		return ""
+	}
+
+	switch coerce {
+	case CoerceNumber:
+		a, okA := parseNumber(expected)
+		b, okB := parseNumber(actual)
+		if okA && okB && a == b {
+			return CompareRuleCoerce
+		}
+	case CoerceString:
+		if scalarString(expected) != "" && scalarString(expected) == scalarString(actual) {
+			return CompareRuleCoerce
+		}
+	}
+	return ""
+}
+
+// parseNumber reads a number stored as a number or a string
+func parseNumber(av types.AttributeValue) (float64, bool) {
+	var s string
+	switch v := av.(type) {
+	case *types.AttributeValueMemberN:
+		s = v.Value
+	case *types.AttributeValueMemberS:
+		s = v.Value
+	default:
+		return 0, false
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	return f, err == nil
+}
+
+// parseTimestamp reads an RFC 3339 string or a number of seconds since the epoch
+func parseTimestamp(av types.AttributeValue) (time.Time, bool) {
+	switch v := av.(type) {
+	case *types.AttributeValueMemberS:
+		t, err := time.Parse(time.RFC3339Nano, v.Value)
+		return t, err == nil
+	case *types.AttributeValueMemberN:
+		seconds, err := strconv.ParseFloat(v.Value, 64)
+		if err != nil {
+			return time.Time{}, false
+		}
+		return time.Unix(0, int64(seconds*float64(time.Second))), true
+	}
+	return time.Time{}, false
+}
+
+// scalarString returns a string, number or boolean value as a string, or an empty string
+// for other types
+func scalarString(av types.AttributeValue) string {
+	switch v := av.(type) {
+	case *types.AttributeValueMemberS:
+		return v.Value
+	case *types.AttributeValueMemberN:
+		return v.Value
+	case *types.AttributeValueMemberBOOL:
+		return strconv.FormatBool(v.Value)
+	}
+	return ""
+}
+
+// absDuration returns the absolute value of d
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+// sortedNames returns the attribute names of an item, sorted
+func sortedNames(item map[string]types.AttributeValue) []string {
+	names := make([]string, 0, len(item))
+	for name := range item {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,79 @@
+package internal
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// diffSummary returns the attribute and rule of every difference
+func diffSummary(diffs []AttributeDiff) []string {
+	var summary []string
+	for _, diff := range diffs {
+		summary = append(summary, diff.Attribute+":"+diff.Rule)
+	}
+	return summary
+}
+
+func TestCompareRules(t *testing.T) {
+	s := func(v string) types.AttributeValue { return &types.AttributeValueMemberS{Value: v} }
+	n := func(v string) types.AttributeValue { return &types.AttributeValueMemberN{Value: v} }
+	rules := &CompareRules{
+		Ignore:           []string{"etag"},
+		Rename:           map[string]string{"userName": "user_name"},
+		NumericTolerance: map[string]float64{"price": 0.01},
+		TimeTolerance:    map[string]time.Duration{"updatedAt": 2 * time.Second, "syncedAt": time.Minute},
+		Coerce:           map[string]string{"amount": CoerceNumber, "zip": CoerceString},
+	}
+
+	tests := []struct {
+		name           string
+		image, item    map[string]types.AttributeValue
+		wantMismatches []string
+		wantExpected   []string
+	}{
+		{"equal", map[string]types.AttributeValue{"a": s("x")}, map[string]types.AttributeValue{"a": s("x")}, nil, nil},
+		{"different value", map[string]types.AttributeValue{"a": s("x")}, map[string]types.AttributeValue{"a": s("y")}, []string{"a:"}, nil},
+		{"missing", map[string]types.AttributeValue{"a": s("x")}, map[string]types.AttributeValue{}, []string{"a:"}, nil},
+		{"extra", map[string]types.AttributeValue{}, map[string]types.AttributeValue{"a": s("x")}, []string{"a:"}, nil},
+		{"ignored", map[string]types.AttributeValue{"etag": s("1")}, map[string]types.AttributeValue{"etag": s("2")}, nil, []string{"etag:ignore"}},
+		{"ignored extra", map[string]types.AttributeValue{}, map[string]types.AttributeValue{"etag": s("2")}, nil, []string{"etag:ignore"}},
+		{"renamed", map[string]types.AttributeValue{"userName": s("ann")}, map[string]types.AttributeValue{"user_name": s("ann")}, nil, []string{"userName:rename"}},
+		{"renamed but different", map[string]types.AttributeValue{"userName": s("ann")}, map[string]types.AttributeValue{"user_name": s("bob")}, []string{"userName:"}, nil},
+		{"within numeric tolerance", map[string]types.AttributeValue{"price": n("10.5")}, map[string]types.AttributeValue{"price": n("10.495")}, nil, []string{"price:numeric_tolerance"}},
+		{"over numeric tolerance", map[string]types.AttributeValue{"price": n("10.5")}, map[string]types.AttributeValue{"price": n("10.4")}, []string{"price:"}, nil},
+		{"within time tolerance", map[string]types.AttributeValue{"updatedAt": s("2024-05-01T10:00:00Z")}, map[string]types.AttributeValue{"updatedAt": s("2024-05-01T10:00:01.5Z")}, nil, []string{"updatedAt:time_tolerance"}},
+		{"over time tolerance", map[string]types.AttributeValue{"updatedAt": s("2024-05-01T10:00:00Z")}, map[string]types.AttributeValue{"updatedAt": s("2024-05-01T10:00:03Z")}, []string{"updatedAt:"}, nil},
+		{"epoch and RFC 3339", map[string]types.AttributeValue{"syncedAt": n("1714557600")}, map[string]types.AttributeValue{"syncedAt": s("2024-05-01T10:00:30Z")}, nil, []string{"syncedAt:time_tolerance"}},
+		{"coerced number", map[string]types.AttributeValue{"amount": n("5")}, map[string]types.AttributeValue{"amount": s("5.00")}, nil, []string{"amount:coerce"}},
+		{"coerced string", map[string]types.AttributeValue{"zip": n("1234")}, map[string]types.AttributeValue{"zip": s("1234")}, nil, []string{"zip:coerce"}},
+		{"coerced but different", map[string]types.AttributeValue{"amount": n("5")}, map[string]types.AttributeValue{"amount": s("6")}, []string{"amount:"}, nil},
+		{"type change without rule", map[string]types.AttributeValue{"a": n("5")}, map[string]types.AttributeValue{"a": s("5")}, []string{"a:"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := rules.Compare(tt.image, tt.item)
+			if mismatches := diffSummary(got.Mismatches); !slices.Equal(mismatches, tt.wantMismatches) {
+				t.Errorf("mismatches = %v, want %v", mismatches, tt.wantMismatches)
+			}
+			if expected := diffSummary(got.Expected); !slices.Equal(expected, tt.wantExpected) {
+				t.Errorf("expected differences = %v, want %v", expected, tt.wantExpected)
+			}
+		})
+	}
+}
+
+func TestCompareRulesValidate(t *testing.T) {
+	for name, rules := range map[string]CompareRules{
+		"coercion":          {Coerce: map[string]string{"a": "BOOL"}},
+		"numeric tolerance": {NumericTolerance: map[string]float64{"a": -1}},
+		"time tolerance":    {TimeTolerance: map[string]time.Duration{"a": -time.Second}},
+		"rename collision":  {Rename: map[string]string{"a": "c", "b": "c"}},
+	} {
+		if err := rules.Validate(); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// jsonLinesFile appends JSON values to a file, one per line. It is safe for concurrent use.
+type jsonLinesFile struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+	enc  *json.Encoder
+}
+
+// openJSONLinesFile opens (or creates) a JSON-lines file in append mode
+func openJSONLinesFile(path string) (*jsonLinesFile, error) {
+	// Create directory if it doesn't exist
+	dir := filepath.Dir(path)
+	if dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create directory for %s: %w", path, err)
+		}
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+
+	return &jsonLinesFile{
+		path: path,
+		file: file,
+		enc:  json.NewEncoder(file),
+	}, nil
+}
+
+// Path returns the location of the file
+func (f *jsonLinesFile) Path() string {
+	return f.path
+}
+
+func (f *jsonLinesFile) write(v interface{}) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.enc.Encode(v)
+}
+
+// Close closes the underlying file
+func (f *jsonLinesFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.file.Close()
+}
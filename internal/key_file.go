@@ -0,0 +1,63 @@
+package internal
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// ReadKeyFile reads primary keys from a CSV file such as the one written by datagen.
+// Format: pk[,sk], with an optional header row. Key values are read as strings.
+func ReadKeyFile(path, partitionKey, sortKey string) ([]map[string]types.AttributeValue, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open key file: %w", err)
+	}
+	defer file.Close()
+
+	fields := 1
+	if sortKey != "" {
+		fields = 2
+	}
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = fields
+	reader.TrimLeadingSpace = true
+
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+
+	// Skip the header row if present
+	if len(rows) > 0 && isKeyHeaderRow(rows[0], partitionKey, sortKey) {
+		rows = rows[1:]
+	}
+
+	keys := make([]map[string]types.AttributeValue, 0, len(rows))
+	for _, row := range rows {
+		key := map[string]types.AttributeValue{
+			partitionKey: &types.AttributeValueMemberS{Value: row[0]},
+		}
+		if sortKey != "" {
+			key[sortKey] = &types.AttributeValueMemberS{Value: row[1]}
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// isKeyHeaderRow checks if a row holds the key attribute names rather than values
+func isKeyHeaderRow(row []string, partitionKey, sortKey string) bool {
+	if !strings.EqualFold(strings.TrimSpace(row[0]), partitionKey) {
+		return false
+	}
+	if sortKey != "" && !strings.EqualFold(strings.TrimSpace(row[1]), sortKey) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,81 @@
+package internal
+
+import (
+	"hash/fnv"
+	"math"
+	"sort"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// KeySampler selects primary keys from a stable hash of the key, so a selected key is
+// sampled on every one of its events, and the same keys are selected across restarts and
+// by every instance using the same rate and salt. The keys selected at a rate are also
+// selected at every lower rate, so changing the rate keeps following the keys it can.
+type KeySampler struct {
+	rate int
+	salt string
+}
+
+// NewKeySampler creates a sampler that selects 1 out of every rate keys. Changing the salt
+// selects a different set of keys.
+func NewKeySampler(rate int, salt string) KeySampler {
+	return KeySampler{rate: rate, salt: salt}
+}
+
+// Sampled reports whether the key is selected
+func (s KeySampler) Sampled(key map[string]types.AttributeValue) bool {
+	if s.rate <= 1 {
+		return true
+	}
+	return keyHash(key, s.salt) <= math.MaxUint64/uint64(s.rate)
+}
+
+// Rate returns the sample rate of the sampler
+func (s KeySampler) Rate() int {
+	return s.rate
+}
+
+// keyHash returns the 64-bit FNV-1a hash of the salt and the key attributes sorted by name,
+// mixed so every bit depends on the whole key. The attribute type is part of the hash, so
+// the string "1" and the number 1 differ.
+func keyHash(key map[string]types.AttributeValue, salt string) uint64 {
+	names := make([]string, 0, len(key))
+	for name := range key {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	h := fnv.New64a()
+	h.Write([]byte(salt))
+	for _, name := range names {
+		h.Write([]byte{0})
+		h.Write([]byte(name))
+		h.Write([]byte{0})
+		switch v := key[name].(type) {
+		case *types.AttributeValueMemberS:
+			h.Write([]byte("S"))
+			h.Write([]byte(v.Value))
+		case *types.AttributeValueMemberN:
+			h.Write([]byte("N"))
+			h.Write([]byte(v.Value))
+		case *types.AttributeValueMemberB:
+			h.Write([]byte("B"))
+			h.Write(v.Value)
+		default:
+			// Key attributes are always S, N or B
+			h.Write([]byte(formatAttributeValue(v)))
+		}
+	}
+	return mix64(h.Sum64())
+}
+
+// mix64 is the 64-bit finalizer of MurmurHash3, it spreads the FNV hash over the high bits
+func mix64(h uint64) uint64 {
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
@@ -0,0 +1,97 @@
+package internal
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// testKey returns a key with a string partition key and a number sort key
+func testKey(pk string, sk int) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"pk": &types.AttributeValueMemberS{Value: pk},
+		"sk": &types.AttributeValueMemberN{Value: fmt.Sprint(sk)},
+	}
+}
+
+func TestKeySamplerIsStable(t *testing.T) {
+	a := NewKeySampler(10, "salt")
+	b := NewKeySampler(10, "salt")
+	for i := range 1000 {
+		key := testKey(fmt.Sprintf("user#%d", i), i)
+		if a.Sampled(key) != b.Sampled(key) {
+			t.Fatalf("key %v sampled differently by two samplers with the same settings", key)
+		}
+	}
+}
+
+func TestKeySamplerRate(t *testing.T) {
+	tests := []struct {
+		rate     int
+		min, max int // Expected number of sampled keys out of 10000
+	}{
+		{rate: 1, min: 10000, max: 10000},
+		{rate: 10, min: 900, max: 1100},
+		{rate: 100, min: 70, max: 130},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.rate), func(t *testing.T) {
+			s := NewKeySampler(tt.rate, "")
+			sampled := 0
+			for i := range 10000 {
+				if s.Sampled(testKey(fmt.Sprintf("user#%d", i), 0)) {
+					sampled++
+				}
+			}
+			if sampled < tt.min || sampled > tt.max {
+				t.Errorf("sampled %d keys, want between %d and %d", sampled, tt.min, tt.max)
+			}
+		})
+	}
+}
+
+func TestKeySamplerSalt(t *testing.T) {
+	a := NewKeySampler(10, "")
+	b := NewKeySampler(10, "another salt")
+	same := 0
+	for i := range 1000 {
+		key := testKey(fmt.Sprintf("user#%d", i), 0)
+		if a.Sampled(key) && b.Sampled(key) {
+			same++
+		}
+	}
+	// Independent selections of 1 in 10 keys share about 1 in 100 keys
+	if same > 30 {
+		t.Errorf("%d keys sampled with both salts, want the salt to change the selection", same)
+	}
+}
+
+func TestKeyHashAttributeTypes(t *testing.T) {
+	str := map[string]types.AttributeValue{"pk": &types.AttributeValueMemberS{Value: "1"}}
+	num := map[string]types.AttributeValue{"pk": &types.AttributeValueMemberN{Value: "1"}}
+	bin := map[string]types.AttributeValue{"pk": &types.AttributeValueMemberB{Value: []byte("1")}}
+
+	if keyHash(str, "") == keyHash(num, "") || keyHash(str, "") == keyHash(bin, "") {
+		t.Error("keys of different attribute types have the same hash")
+	}
+
+	// Splitting the same bytes differently between names must not collide
+	ab := map[string]types.AttributeValue{"a": &types.AttributeValueMemberS{Value: "bc"}}
+	abc := map[string]types.AttributeValue{"ab": &types.AttributeValueMemberS{Value: "c"}}
+	if keyHash(ab, "") == keyHash(abc, "") {
+		t.Error("keys with different names and values have the same hash")
+	}
+}
+
+func TestKeySamplerNestedRates(t *testing.T) {
+	high := NewKeySampler(20, "salt")
+	low := NewKeySampler(5, "salt")
+	for i := range 1000 {
+		key := testKey(fmt.Sprintf("user#%d", i), 0)
+		if high.Sampled(key) && !low.Sampled(key) {
+			t.Fatalf("key %v sampled at 1 in 20 but not at 1 in 5", key)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// TableConfig describes one source/target/stream tuple of a multi-table run.
+// Empty fields inherit the top-level option of the same name.
+type TableConfig struct {
+	Name                   string `yaml:"name"`                     // Label used in logs and statistics (optional, defaults to target table)
+	StreamArn              string `yaml:"stream_arn"`               // DynamoDB Stream ARN
+	SourceTable            string `yaml:"source_table"`             // Source table name (optional, defaults to target table)
+	TargetTable            string `yaml:"target_table"`             // Target table name
+	PartitionKey           string `yaml:"partition_key"`            // Name of the partition key
+	SortKey                string `yaml:"sort_key"`                 // Name of the sort key (optional)
+	SampleRate             int    `yaml:"sample_rate"`              // Validate every event of 1 out of every SampleRate keys
+	IteratorType           string `yaml:"iterator_type"`            // DynamoDB Stream Iterator Type
+	VerifyOn               string `yaml:"verify_on"`                // Which table to verify against: source or target
+	RepairVersionAttribute string `yaml:"repair_version_attribute"` // Attribute used to decide whether the source item is newer
+	Transform              string `yaml:"transform"`                // Steps computing the expected target item from the stream image
+
+	// ParsedTransform is the parsed Transform, set when the table is resolved
+	ParsedTransform *Transform `yaml:"-"`
+}
+
+// MultiTableConfig contains the configuration for monitoring several tables in one process
+type MultiTableConfig struct {
+	// Base holds the clients and settings shared by every table
+	Base StreamVerificationConfig
+
+	Tables []TableConfig
+
+	// Repair holds the repair settings shared by every table, nil disables repair.
+	// Table names, keys and the version attribute are filled in per table.
+	Repair *RepairConfig
+}
+
+// RunMultiTableVerification runs one stream verification per table on shared clients until
+// ctx is canceled. A table that stops early does not affect the others. Per-table and
+// aggregated statistics are printed periodically and once more before returning.
+func RunMultiTableVerification(ctx context.Context, cfg MultiTableConfig) []StatsSummary {
+	// Set default validation config if not provided
+	if cfg.Base.ValidationConfig.BufferSize == 0 {
+		cfg.Base.ValidationConfig = DefaultValidationConfig()
+	}
+
+	var mu sync.Mutex
+	latest := make([]StatsSummary, len(cfg.Tables))
+	stopped := make([]bool, len(cfg.Tables))
+
+	var wg sync.WaitGroup
+	for i, table := range cfg.Tables {
+		tableCfg := cfg.Base
+		tableCfg.Name = table.Name
+		tableCfg.StreamArn = table.StreamArn
+		tableCfg.SourceTable = table.SourceTable
+		tableCfg.TargetTable = table.TargetTable
+		tableCfg.PartitionKey = table.PartitionKey
+		tableCfg.SortKey = table.SortKey
+		tableCfg.SampleRate = table.SampleRate
+		tableCfg.IteratorType = table.IteratorType
+		tableCfg.VerifyOn = table.VerifyOn
+		tableCfg.Transform = table.ParsedTransform
+
+		if cfg.Repair != nil {
+			repairCfg := *cfg.Repair
+			repairCfg.SourceTable = table.SourceTable
+			repairCfg.TargetTable = table.TargetTable
+			repairCfg.PartitionKey = table.PartitionKey
+			repairCfg.VersionAttribute = table.RepairVersionAttribute
+			tableCfg.Repairer = NewRepairer(repairCfg)
+		}
+
+		latest[i] = StatsSummary{Table: table.Name}
+		tableCfg.OnStats = func(summary StatsSummary) {
+			mu.Lock()
+			latest[i] = summary
+			mu.Unlock()
+		}
+
+		wg.Add(1)
+		go func(i int, tableCfg StreamVerificationConfig) {
+			defer wg.Done()
+			summary, ok := runTableVerification(ctx, &tableCfg)
+			mu.Lock()
+			if ok || summary.StopErr != nil {
+				latest[i] = summary
+			} else {
+				// A panic leaves no final statistics, keep the last printed ones
+				latest[i].StopErr = errors.New("table monitor panicked")
+			}
+			stopped[i] = !ok
+			mu.Unlock()
+		}(i, tableCfg)
+	}
+
+	log.Infof("[MULTI] Monitoring %d tables", len(cfg.Tables))
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	ticker := time.NewTicker(cfg.Base.ValidationConfig.StatsInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			mu.Lock()
+			logAggregatedStats(latest, stopped)
+			mu.Unlock()
+		case <-done:
+			logAggregatedStats(latest, stopped)
+			return latest
+		}
+	}
+}
+
+// runTableVerification runs the verification of a single table and recovers from a panic
+// so it cannot take down the other tables. The goroutines of the verification recover on
+// their own and stop it with StopErr. It returns false if the table stopped early.
+func runTableVerification(ctx context.Context, cfg *StreamVerificationConfig) (summary StatsSummary, ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.WithField("table", cfg.Name).Errorf("[MULTI] Table monitor stopped unexpectedly: %v ❌", r)
+			ok = false
+		}
+	}()
+	summary = RunStreamStyleVerification(ctx, cfg)
+	if summary.StopErr != nil {
+		log.WithField("table", cfg.Name).Errorf("[MULTI] Table monitor stopped: %v ❌", summary.StopErr)
+		return summary, false
+	}
+	return summary, true
+}
+
+// logAggregatedStats prints one line per table followed by the totals across all tables
+func logAggregatedStats(tables []StatsSummary, stopped []bool) {
+	var total StatsSummary
+	log.Infof("========= Per-Table Statistics (%d tables) =========", len(tables))
+	for i, s := range tables {
+		status := "ok"
+		if stopped[i] {
+			status = "STOPPED ❌"
+		}
+		log.Infof("  %-24s events=%-8d sampled=%-6d success=%-6d failed=%-6d repaired=%-6d stream_errors=%-4d %s",
+			s.Table, s.TotalCount, s.ValidationCount, s.ValidationSuccess, s.ValidationFailed, s.RepairWritten, s.StreamErrors, status)
+		total.Add(s)
+	}
+	logStatsSummary(log.NewEntry(log.StandardLogger()), "Aggregated Statistics", total)
+}
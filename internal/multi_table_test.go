@@ -0,0 +1,132 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testTableConfig returns a table of a multi-table run on the fake migration's tables
+func testTableConfig(name, streamArn string) TableConfig {
+	return TableConfig{
+		Name:         name,
+		StreamArn:    streamArn,
+		SourceTable:  "orders",
+		TargetTable:  "orders",
+		PartitionKey: "pk",
+		SortKey:      "sk",
+		SampleRate:   1,
+		IteratorType: "TRIM_HORIZON",
+		VerifyOn:     "target",
+	}
+}
+
+// runMultiTable runs a multi-table verification in the background and returns a channel
+// receiving its final statistics
+func runMultiTable(ctx context.Context, cfg MultiTableConfig) <-chan []StatsSummary {
+	done := make(chan []StatsSummary, 1)
+	go func() {
+		done <- RunMultiTableVerification(ctx, cfg)
+	}()
+	return done
+}
+
+// waitForSummaries waits for a multi-table verification to return
+func waitForSummaries(t *testing.T, done <-chan []StatsSummary) []StatsSummary {
+	t.Helper()
+	select {
+	case summaries := <-done:
+		return summaries
+	case <-time.After(5 * time.Second):
+		t.Fatal("multi-table verification did not return")
+		return nil
+	}
+}
+
+func TestMultiTableVerificationStopsBrokenTable(t *testing.T) {
+	m := newFakeMigration()
+	for i := 1; i <= 3; i++ {
+		m.write(testItem(i))
+	}
+
+	var mu sync.Mutex
+	validated := make(map[string]int)
+	base := testVerificationConfig(m)
+	base.OnValidation = func(result ValidationResult) {
+		mu.Lock()
+		validated[result.Table]++
+		mu.Unlock()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := runMultiTable(ctx, MultiTableConfig{
+		Base: base,
+		Tables: []TableConfig{
+			testTableConfig("orders", m.stream.arn),
+			testTableConfig("broken", "arn:aws:dynamodb:ap-northeast-1:123456789012:table/missing/stream/2025-01-01T00:00:00.000"),
+		},
+	})
+
+	// The healthy table keeps running after the broken one stopped
+	waitFor(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return validated["orders"] == 3
+	})
+	select {
+	case <-done:
+		t.Fatal("verification returned while a table was still running")
+	default:
+	}
+	cancel()
+	summaries := waitForSummaries(t, done)
+
+	if summaries[0].StopErr != nil || summaries[0].ValidationSuccess != 3 {
+		t.Errorf("orders: stop error = %v, %d succeeded, want none and 3", summaries[0].StopErr, summaries[0].ValidationSuccess)
+	}
+	if !errors.Is(summaries[1].StopErr, ErrStreamStopped) {
+		t.Errorf("broken: stop error = %v, want the stream to be stopped", summaries[1].StopErr)
+	}
+}
+
+func TestMultiTableVerificationRecoversPanics(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(m *fakeMigration, cfg *StreamVerificationConfig)
+	}{
+		{
+			name: "validation worker",
+			setup: func(_ *fakeMigration, cfg *StreamVerificationConfig) {
+				cfg.OnValidation = func(ValidationResult) { panic("observer failed") }
+			},
+		},
+		{
+			name: "stream subscriber",
+			setup: func(m *fakeMigration, _ *StreamVerificationConfig) {
+				m.stream.onGetRecords = func(string) { panic("shard reader failed") }
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newFakeMigration()
+			m.write(testItem(1))
+			base := testVerificationConfig(m)
+			tt.setup(m, &base)
+
+			// The table stops on its own, without the context being canceled
+			done := runMultiTable(context.Background(), MultiTableConfig{
+				Base:   base,
+				Tables: []TableConfig{testTableConfig("orders", m.stream.arn)},
+			})
+			summaries := waitForSummaries(t, done)
+
+			if summaries[0].StopErr == nil {
+				t.Error("expected the table to be stopped with an error")
+			}
+		})
+	}
+}
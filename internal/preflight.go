@@ -0,0 +1,354 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodbstreams"
+	streamtypes "github.com/aws/aws-sdk-go-v2/service/dynamodbstreams/types"
+	"github.com/aws/smithy-go"
+	log "github.com/sirupsen/logrus"
+)
+
+// streamRetention is how long DynamoDB Streams keep a record before trimming it
+const streamRetention = 24 * time.Hour
+
+// streamRetentionMargin is how close to the retention limit a warning is emitted
+const streamRetentionMargin = 4 * time.Hour
+
+// preflightKeyValue is used to build a primary key that is not expected to exist
+const preflightKeyValue = "__preflight__"
+
+// PreflightStatus is the outcome of a single preflight check
+type PreflightStatus string
+
+const (
+	PreflightPass PreflightStatus = "pass"
+	PreflightWarn PreflightStatus = "warn"
+	PreflightFail PreflightStatus = "fail"
+)
+
+// PreflightCheck is the result of a single preflight check
+type PreflightCheck struct {
+	Table  string // Empty for checks that do not belong to a table
+	Name   string
+	Status PreflightStatus
+	Detail string
+}
+
+// PreflightConfig contains the configuration of a preflight run
+type PreflightConfig struct {
+	Clients *DynamoDBClients
+	Tables  []TableConfig
+
+	// Expected time from the start of the migration until the monitor has caught up (optional)
+	MigrationDuration time.Duration
+}
+
+// PreflightReport holds the results of every preflight check
+type PreflightReport struct {
+	Checks []PreflightCheck
+}
+
+// Count returns the number of checks with the given status
+func (r *PreflightReport) Count(status PreflightStatus) int {
+	n := 0
+	for _, c := range r.Checks {
+		if c.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+func (r *PreflightReport) add(table, name string, status PreflightStatus, format string, args ...interface{}) {
+	r.Checks = append(r.Checks, PreflightCheck{
+		Table:  table,
+		Name:   name,
+		Status: status,
+		Detail: fmt.Sprintf(format, args...),
+	})
+}
+
+// RunPreflight checks that the clients can do everything a monitoring run needs on every
+// table, without writing anything, and prints a report
+func RunPreflight(ctx context.Context, cfg PreflightConfig) *PreflightReport {
+	report := &PreflightReport{}
+
+	checkClientIdentity(report, "source", cfg.Clients.SourceRegion, cfg.Clients.SourceIdentity)
+	checkClientIdentity(report, "target", cfg.Clients.TargetRegion, cfg.Clients.TargetIdentity)
+	checkClientIdentity(report, "stream", cfg.Clients.StreamRegion, cfg.Clients.StreamIdentity)
+
+	if cfg.MigrationDuration > streamRetention-streamRetentionMargin {
+		report.add("", "stream retention", PreflightWarn,
+			"expected migration duration %s is close to or above the %s stream retention, records written early may be trimmed before they are verified",
+			cfg.MigrationDuration, streamRetention)
+	}
+
+	for _, table := range cfg.Tables {
+		log.Infof("[PREFLIGHT] Checking table %s", table.Name)
+		preflightStream(ctx, report, cfg.Clients.StreamClient, table)
+
+		source := preflightTable(ctx, report, cfg.Clients.SourceClient, table.Name, "source", table.SourceTable)
+		target := preflightTable(ctx, report, cfg.Clients.TargetClient, table.Name, "target", table.TargetTable)
+		if source != nil && target != nil {
+			preflightKeySchema(report, table, source, target)
+		}
+	}
+
+	logPreflightReport(report)
+	return report
+}
+
+// checkClientIdentity reports the region and the account each client uses
+func checkClientIdentity(report *PreflightReport, name, region string, identity *CallerIdentity) {
+	if identity == nil {
+		report.add("", name+" client", PreflightWarn, "region %s, account unknown (static credentials or identity check failed)", region)
+		return
+	}
+	report.add("", name+" client", PreflightPass, "region %s, account %s, principal %s", region, identity.Account, identity.Arn)
+}
+
+// preflightStream checks the stream status and view type, and that shards can be read
+func preflightStream(ctx context.Context, report *PreflightReport, client StreamAPI, table TableConfig) {
+	if table.StreamArn == "" {
+		report.add(table.Name, "DescribeStream", PreflightFail, "no stream ARN configured")
+		return
+	}
+
+	out, err := client.DescribeStream(ctx, &dynamodbstreams.DescribeStreamInput{
+		StreamArn: aws.String(table.StreamArn),
+	})
+	if err != nil {
+		report.add(table.Name, "DescribeStream", PreflightFail, "%s", describeAWSError(err))
+		return
+	}
+	desc := out.StreamDescription
+	if desc == nil {
+		report.add(table.Name, "DescribeStream", PreflightFail, "empty stream description")
+		return
+	}
+
+	// The stream must be enabled and belong to the source table
+	switch desc.StreamStatus {
+	case streamtypes.StreamStatusEnabled:
+		report.add(table.Name, "stream status", PreflightPass, "%s", desc.StreamStatus)
+	case streamtypes.StreamStatusEnabling:
+		report.add(table.Name, "stream status", PreflightWarn, "%s, wait until the stream is enabled", desc.StreamStatus)
+	default:
+		report.add(table.Name, "stream status", PreflightFail, "%s, no new records will be delivered", desc.StreamStatus)
+	}
+
+	if streamTable := aws.ToString(desc.TableName); streamTable != table.SourceTable {
+		report.add(table.Name, "stream table", PreflightFail, "stream belongs to table %s, not to source table %s", streamTable, table.SourceTable)
+	}
+
+	// Failure records and replication use the new image when the stream provides it
+	switch desc.StreamViewType {
+	case streamtypes.StreamViewTypeNewImage, streamtypes.StreamViewTypeNewAndOldImages:
+		report.add(table.Name, "stream view type", PreflightPass, "%s", desc.StreamViewType)
+	default:
+		report.add(table.Name, "stream view type", PreflightWarn,
+			"%s has no new image, failure records will not include the item and replication reads every item from the source table", desc.StreamViewType)
+	}
+
+	if len(desc.Shards) == 0 {
+		report.add(table.Name, "GetShardIterator", PreflightWarn, "stream has no shards yet")
+		return
+	}
+
+	// Read the oldest record of the first shard, where TRIM_HORIZON starts
+	shard := desc.Shards[0]
+	iterOut, err := client.GetShardIterator(ctx, &dynamodbstreams.GetShardIteratorInput{
+		StreamArn:         aws.String(table.StreamArn),
+		ShardId:           shard.ShardId,
+		ShardIteratorType: streamtypes.ShardIteratorTypeTrimHorizon,
+	})
+	if err != nil {
+		report.add(table.Name, "GetShardIterator", PreflightFail, "%s", describeAWSError(err))
+		return
+	}
+	report.add(table.Name, "GetShardIterator", PreflightPass, "shard %s", aws.ToString(shard.ShardId))
+
+	recOut, err := client.GetRecords(ctx, &dynamodbstreams.GetRecordsInput{
+		ShardIterator: iterOut.ShardIterator,
+		Limit:         aws.Int32(1),
+	})
+	if err != nil {
+		report.add(table.Name, "GetRecords", PreflightFail, "%s", describeAWSError(err))
+		return
+	}
+	report.add(table.Name, "GetRecords", PreflightPass, "%d record(s) read", len(recOut.Records))
+
+	// Records older than the retention are trimmed before TRIM_HORIZON reaches them
+	if len(recOut.Records) > 0 && recOut.Records[0].Dynamodb != nil && recOut.Records[0].Dynamodb.ApproximateCreationDateTime != nil {
+		age := time.Since(*recOut.Records[0].Dynamodb.ApproximateCreationDateTime)
+		if table.IteratorType == string(streamtypes.ShardIteratorTypeTrimHorizon) && age > streamRetention-streamRetentionMargin {
+			report.add(table.Name, "stream retention", PreflightWarn,
+				"oldest record is %s old and will be trimmed after %s, start soon or records will be skipped",
+				age.Round(time.Minute), streamRetention)
+		}
+	}
+}
+
+// preflightTable checks that the table can be described, read and scanned, and returns
+// its description
+func preflightTable(ctx context.Context, report *PreflightReport, client TableAPI, label, role, tableName string) *types.TableDescription {
+	out, err := client.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(tableName)})
+	if err != nil {
+		report.add(label, role+" DescribeTable", PreflightFail, "%s: %s", tableName, describeAWSError(err))
+		return nil
+	}
+	desc := out.Table
+	report.add(label, role+" DescribeTable", PreflightPass, "%s is %s", tableName, desc.TableStatus)
+
+	// Look up a key that should not exist, only the permission matters
+	_, err = client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(tableName),
+		Key:       preflightKey(desc),
+	})
+	if err != nil {
+		report.add(label, role+" GetItem", PreflightFail, "%s: %s", tableName, describeAWSError(err))
+	} else {
+		report.add(label, role+" GetItem", PreflightPass, "%s", tableName)
+	}
+
+	// Validation lookups are batched
+	_, err = client.BatchGetItem(ctx, &dynamodb.BatchGetItemInput{
+		RequestItems: map[string]types.KeysAndAttributes{
+			tableName: {Keys: []map[string]types.AttributeValue{preflightKey(desc)}},
+		},
+	})
+	if err != nil {
+		report.add(label, role+" BatchGetItem", PreflightFail, "%s: %s", tableName, describeAWSError(err))
+	} else {
+		report.add(label, role+" BatchGetItem", PreflightPass, "%s", tableName)
+	}
+
+	_, err = client.Scan(ctx, &dynamodb.ScanInput{
+		TableName: aws.String(tableName),
+		Limit:     aws.Int32(1),
+	})
+	if err != nil {
+		report.add(label, role+" Scan", PreflightFail, "%s: %s", tableName, describeAWSError(err))
+	} else {
+		report.add(label, role+" Scan", PreflightPass, "%s", tableName)
+	}
+
+	return desc
+}
+
+// preflightKeySchema checks that the source and target key schemas match each other and
+// the configured key names
+func preflightKeySchema(report *PreflightReport, table TableConfig, source, target *types.TableDescription) {
+	sourceSchema := formatKeySchema(source)
+	targetSchema := formatKeySchema(target)
+	if sourceSchema != targetSchema {
+		report.add(table.Name, "key schema", PreflightFail, "source %s does not match target %s", sourceSchema, targetSchema)
+		return
+	}
+
+	partitionKey, sortKey := keySchemaNames(source)
+	if partitionKey != table.PartitionKey || sortKey != table.SortKey {
+		report.add(table.Name, "key schema", PreflightFail,
+			"tables use %s, but partition-key=%q and sort-key=%q are configured", sourceSchema, table.PartitionKey, table.SortKey)
+		return
+	}
+	report.add(table.Name, "key schema", PreflightPass, "%s", sourceSchema)
+}
+
+// keySchemaNames returns the partition and sort key names of a table
+func keySchemaNames(desc *types.TableDescription) (partitionKey, sortKey string) {
+	for _, k := range desc.KeySchema {
+		switch k.KeyType {
+		case types.KeyTypeHash:
+			partitionKey = aws.ToString(k.AttributeName)
+		case types.KeyTypeRange:
+			sortKey = aws.ToString(k.AttributeName)
+		}
+	}
+	return partitionKey, sortKey
+}
+
+// keyAttributeType returns the scalar type of a key attribute
+func keyAttributeType(desc *types.TableDescription, name string) types.ScalarAttributeType {
+	for _, def := range desc.AttributeDefinitions {
+		if aws.ToString(def.AttributeName) == name {
+			return def.AttributeType
+		}
+	}
+	return ""
+}
+
+// formatKeySchema describes a key schema, e.g. "user_id (S, HASH), created_at (N, RANGE)"
+func formatKeySchema(desc *types.TableDescription) string {
+	return formatKeySchemaElements(desc, desc.KeySchema)
+}
+
+// formatKeySchemaElements describes the key schema of a table or index, using the
+// attribute types of the table
+func formatKeySchemaElements(desc *types.TableDescription, keys []types.KeySchemaElement) string {
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		name := aws.ToString(k.AttributeName)
+		parts = append(parts, fmt.Sprintf("%s (%s, %s)", name, keyAttributeType(desc, name), k.KeyType))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// preflightKey builds a primary key of the right types that is not expected to exist
+func preflightKey(desc *types.TableDescription) map[string]types.AttributeValue {
+	key := make(map[string]types.AttributeValue, len(desc.KeySchema))
+	for _, k := range desc.KeySchema {
+		name := aws.ToString(k.AttributeName)
+		switch keyAttributeType(desc, name) {
+		case types.ScalarAttributeTypeN:
+			key[name] = &types.AttributeValueMemberN{Value: "0"}
+		case types.ScalarAttributeTypeB:
+			key[name] = &types.AttributeValueMemberB{Value: []byte(preflightKeyValue)}
+		default:
+			key[name] = &types.AttributeValueMemberS{Value: preflightKeyValue}
+		}
+	}
+	return key
+}
+
+// describeAWSError turns an AWS error into a short message, calling out missing permissions
+func describeAWSError(err error) string {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		if apiErr.ErrorCode() == "AccessDeniedException" {
+			return "not permitted: " + apiErr.ErrorMessage()
+		}
+		return apiErr.ErrorCode() + ": " + apiErr.ErrorMessage()
+	}
+	return err.Error()
+}
+
+// logPreflightReport prints every check followed by a summary
+func logPreflightReport(report *PreflightReport) {
+	log.Infof("========= Preflight Report =========")
+	for _, c := range report.Checks {
+		name := c.Name
+		if c.Table != "" {
+			name = fmt.Sprintf("[%s] %s", c.Table, c.Name)
+		}
+		switch c.Status {
+		case PreflightPass:
+			log.Infof("✅ %s: %s", name, c.Detail)
+		case PreflightWarn:
+			log.Warnf("⚠️ %s: %s", name, c.Detail)
+		default:
+			log.Errorf("❌ %s: %s", name, c.Detail)
+		}
+	}
+	log.Infof("Summary: %d passed, %d warnings, %d failed",
+		report.Count(PreflightPass), report.Count(PreflightWarn), report.Count(PreflightFail))
+	log.Infof("========================================")
+}
@@ -0,0 +1,154 @@
+package internal
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	streamtypes "github.com/aws/aws-sdk-go-v2/service/dynamodbstreams/types"
+	"github.com/aws/smithy-go"
+)
+
+func TestRunPreflight(t *testing.T) {
+	accessDenied := &smithy.GenericAPIError{Code: "AccessDeniedException", Message: "no read access"}
+
+	tests := []struct {
+		name     string
+		modify   func(m *fakeMigration, clients *DynamoDBClients, cfg *PreflightConfig)
+		wantWarn []string // Names of the checks expected to warn
+		wantFail []string // Names of the checks expected to fail
+	}{
+		{
+			name: "ready",
+		},
+		{
+			name: "unknown stream identity",
+			modify: func(_ *fakeMigration, clients *DynamoDBClients, _ *PreflightConfig) {
+				clients.StreamIdentity = nil
+			},
+			wantWarn: []string{"stream client"},
+		},
+		{
+			name: "migration close to the stream retention",
+			modify: func(_ *fakeMigration, _ *DynamoDBClients, cfg *PreflightConfig) {
+				cfg.MigrationDuration = 22 * time.Hour
+			},
+			wantWarn: []string{"stream retention"},
+		},
+		{
+			name: "no stream ARN",
+			modify: func(_ *fakeMigration, _ *DynamoDBClients, cfg *PreflightConfig) {
+				cfg.Tables[0].StreamArn = ""
+			},
+			wantFail: []string{"DescribeStream"},
+		},
+		{
+			name: "unknown stream ARN",
+			modify: func(_ *fakeMigration, _ *DynamoDBClients, cfg *PreflightConfig) {
+				cfg.Tables[0].StreamArn += "-old"
+			},
+			wantFail: []string{"DescribeStream"},
+		},
+		{
+			name: "stream disabled",
+			modify: func(m *fakeMigration, _ *DynamoDBClients, _ *PreflightConfig) {
+				m.stream.status = streamtypes.StreamStatusDisabled
+			},
+			wantFail: []string{"stream status"},
+		},
+		{
+			name: "stream enabling",
+			modify: func(m *fakeMigration, _ *DynamoDBClients, _ *PreflightConfig) {
+				m.stream.status = streamtypes.StreamStatusEnabling
+			},
+			wantWarn: []string{"stream status"},
+		},
+		{
+			name: "stream of another table",
+			modify: func(m *fakeMigration, _ *DynamoDBClients, _ *PreflightConfig) {
+				m.stream.table = "customers"
+			},
+			wantFail: []string{"stream table"},
+		},
+		{
+			name: "stream without new image",
+			modify: func(m *fakeMigration, _ *DynamoDBClients, _ *PreflightConfig) {
+				m.stream.viewType = streamtypes.StreamViewTypeKeysOnly
+			},
+			wantWarn: []string{"stream view type"},
+		},
+		{
+			name: "target not readable",
+			modify: func(m *fakeMigration, _ *DynamoDBClients, _ *PreflightConfig) {
+				m.target.readErr = accessDenied
+			},
+			wantFail: []string{"target GetItem", "target BatchGetItem", "target Scan"},
+		},
+		{
+			name: "target without sort key",
+			modify: func(m *fakeMigration, _ *DynamoDBClients, _ *PreflightConfig) {
+				m.target.sortKey = ""
+			},
+			wantFail: []string{"key schema"},
+		},
+		{
+			name: "sort key not configured",
+			modify: func(_ *fakeMigration, _ *DynamoDBClients, cfg *PreflightConfig) {
+				cfg.Tables[0].SortKey = ""
+			},
+			wantFail: []string{"key schema"},
+		},
+		{
+			name: "missing target table",
+			modify: func(_ *fakeMigration, _ *DynamoDBClients, cfg *PreflightConfig) {
+				cfg.Tables[0].TargetTable = "orders_v2"
+			},
+			wantFail: []string{"target DescribeTable"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newFakeMigration()
+			m.write(testItem(1))
+
+			identity := &CallerIdentity{Account: "123456789012", Arn: "arn:aws:iam::123456789012:role/migration"}
+			clients := &DynamoDBClients{
+				SourceClient:   m.source,
+				TargetClient:   m.target,
+				StreamClient:   m.stream,
+				SourceRegion:   "ap-northeast-1",
+				TargetRegion:   "ap-northeast-1",
+				StreamRegion:   "ap-northeast-1",
+				SourceIdentity: identity,
+				TargetIdentity: identity,
+				StreamIdentity: identity,
+			}
+			cfg := PreflightConfig{
+				Clients: clients,
+				Tables:  []TableConfig{testTableConfig("orders", m.stream.arn)},
+			}
+			if tt.modify != nil {
+				tt.modify(m, clients, &cfg)
+			}
+
+			report := RunPreflight(context.Background(), cfg)
+
+			var warned, failed []string
+			for _, c := range report.Checks {
+				switch c.Status {
+				case PreflightWarn:
+					warned = append(warned, c.Name)
+				case PreflightFail:
+					failed = append(failed, c.Name)
+				}
+			}
+			if !reflect.DeepEqual(warned, tt.wantWarn) || !reflect.DeepEqual(failed, tt.wantFail) {
+				t.Errorf("warned %v and failed %v, want %v and %v", warned, failed, tt.wantWarn, tt.wantFail)
+			}
+			if report.Count(PreflightPass) == 0 {
+				t.Error("no check passed")
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package internal
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RateLimiter is a token bucket that refills at a fixed rate per second.
+// A nil *RateLimiter never blocks.
+type RateLimiter struct {
+	mu       sync.Mutex
+	rate     float64 // Tokens added per second
+	burst    float64 // Maximum number of tokens in the bucket
+	tokens   float64
+	lastFill time.Time
+}
+
+// NewRateLimiter creates a limiter that allows ratePerSecond tokens per second with
+// the given burst. A non-positive rate disables limiting and returns nil.
+func NewRateLimiter(ratePerSecond float64, burst float64) *RateLimiter {
+	if ratePerSecond <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{
+		rate:     ratePerSecond,
+		burst:    burst,
+		tokens:   burst,
+		lastFill: time.Now(),
+	}
+}
+
+// Wait blocks until n tokens are available or the context is canceled
+func (l *RateLimiter) Wait(ctx context.Context, n float64) error {
+	if l == nil {
+		return nil
+	}
+
+	for {
+		wait := l.reserve(n)
+		if wait <= 0 {
+			return nil
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// Adjust adds n tokens to the bucket, or takes them when n is negative, without waiting.
+// It corrects a Wait made with an estimated cost once the actual cost is known. The bucket
+// may go into debt, which later calls to Wait pay back.
+func (l *RateLimiter) Adjust(n float64) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refill()
+	l.tokens = min(l.tokens+n, l.burst)
+}
+
+// reserve takes n tokens if available, otherwise returns how long to wait
+func (l *RateLimiter) reserve(n float64) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.refill()
+
+	// Requests larger than the bucket are allowed once the bucket is full
+	need := n
+	if need > l.burst {
+		need = l.burst
+	}
+
+	if l.tokens >= need {
+		l.tokens -= n
+		return 0
+	}
+
+	missing := need - l.tokens
+	return time.Duration(missing / l.rate * float64(time.Second))
+}
+
+func (l *RateLimiter) refill() {
+	now := time.Now()
+	elapsed := now.Sub(l.lastFill).Seconds()
+	l.lastFill = now
+
+	l.tokens += elapsed * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+}
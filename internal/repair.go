@@ -0,0 +1,242 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	log "github.com/sirupsen/logrus"
+)
+
+// RepairOutcome describes what the repairer did with a single key
+type RepairOutcome string
+
+const (
+	RepairOutcomeInSync        RepairOutcome = "in_sync"              // Target already matches source
+	RepairOutcomeWritten       RepairOutcome = "written"              // Source item was copied to target
+	RepairOutcomeDryRun        RepairOutcome = "dry_run"              // Would have been written
+	RepairOutcomeTargetNewer   RepairOutcome = "skipped_target_newer" // Condition check failed, target has a newer version
+	RepairOutcomeNoVersion     RepairOutcome = "skipped_no_version"   // Drifted, but no version to decide which side is newer
+	RepairOutcomeSourceMissing RepairOutcome = "skipped_source_missing"
+	RepairOutcomeError         RepairOutcome = "error"
+)
+
+// RepairConfig contains the configuration for repairing target items from the source table
+type RepairConfig struct {
+	SourceClient TableAPI
+	TargetClient TableAPI
+	SourceTable  string
+	TargetTable  string
+	PartitionKey string
+
+	// VersionAttribute is a numeric or string attribute that increases on every write
+	// (e.g. version or updatedAt). Drifted items are only overwritten when the source
+	// version is greater than the target version. Without it only missing items are repaired.
+	VersionAttribute string
+
+	DryRun          bool      // Log what would be written without writing
+	WritesPerSecond float64   // Maximum writes per second (0 = unlimited)
+	AuditLog        *AuditLog // Receives every write attempt (optional)
+}
+
+// AuditRecord is one entry of the repair audit log
+type AuditRecord struct {
+	Timestamp    time.Time     `json:"timestamp"`
+	SourceTable  string        `json:"source_table"`
+	TargetTable  string        `json:"target_table"`
+	Key          AttributeMap  `json:"key"`
+	Outcome      RepairOutcome `json:"outcome"`
+	DryRun       bool          `json:"dry_run"`
+	SourceItem   AttributeMap  `json:"source_item,omitempty"`
+	PreviousItem AttributeMap  `json:"previous_item,omitempty"` // Target item before the write
+	Error        string        `json:"error,omitempty"`
+}
+
+// AuditLog appends repair audit records to a JSON-lines file. It is safe for concurrent use.
+type AuditLog struct {
+	*jsonLinesFile
+}
+
+// OpenAuditLog opens (or creates) a repair audit log in append mode
+func OpenAuditLog(path string) (*AuditLog, error) {
+	file, err := openJSONLinesFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return &AuditLog{file}, nil
+}
+
+// Write appends a single audit record as one JSON line
+func (l *AuditLog) Write(rec AuditRecord) error {
+	if rec.Timestamp.IsZero() {
+		rec.Timestamp = time.Now()
+	}
+	return l.write(rec)
+}
+
+// Repairer copies missing or drifted items from the source table to the target table
+type Repairer struct {
+	cfg     RepairConfig
+	limiter *RateLimiter
+}
+
+// NewRepairer creates a new Repairer
+func NewRepairer(cfg RepairConfig) *Repairer {
+	if cfg.SourceTable == "" {
+		cfg.SourceTable = cfg.TargetTable
+	}
+	return &Repairer{
+		cfg:     cfg,
+		limiter: NewRateLimiter(cfg.WritesPerSecond, cfg.WritesPerSecond),
+	}
+}
+
+// Repair reads the current source item and, if the target item is missing or
+// differs, writes it to the target with a condition that never overwrites a newer version
+func (r *Repairer) Repair(ctx context.Context, key map[string]types.AttributeValue) (RepairOutcome, error) {
+	sourceItem, err := r.getItem(ctx, r.cfg.SourceClient, r.cfg.SourceTable, key)
+	if err != nil {
+		return r.finish(key, RepairOutcomeError, nil, nil, fmt.Errorf("failed to read source item: %w", err))
+	}
+	if sourceItem == nil {
+		return r.finish(key, RepairOutcomeSourceMissing, nil, nil, nil)
+	}
+
+	targetItem, err := r.getItem(ctx, r.cfg.TargetClient, r.cfg.TargetTable, key)
+	if err != nil {
+		return r.finish(key, RepairOutcomeError, sourceItem, nil, fmt.Errorf("failed to read target item: %w", err))
+	}
+	if targetItem != nil && itemsEqual(sourceItem, targetItem) {
+		return RepairOutcomeInSync, nil
+	}
+
+	input := &dynamodb.PutItemInput{
+		TableName: aws.String(r.cfg.TargetTable),
+		Item:      sourceItem,
+		ExpressionAttributeNames: map[string]string{
+			"#pk": r.cfg.PartitionKey,
+		},
+	}
+
+	if targetItem == nil {
+		// Missing in target: only write if it is still missing
+		input.ConditionExpression = aws.String("attribute_not_exists(#pk)")
+	} else {
+		// Drifted: only write if the source version is newer
+		version, ok := sourceItem[r.cfg.VersionAttribute]
+		if r.cfg.VersionAttribute == "" || !ok {
+			return r.finish(key, RepairOutcomeNoVersion, sourceItem, targetItem, nil)
+		}
+		input.ConditionExpression = aws.String("attribute_not_exists(#pk) OR attribute_not_exists(#ver) OR #ver < :ver")
+		input.ExpressionAttributeNames["#ver"] = r.cfg.VersionAttribute
+		input.ExpressionAttributeValues = map[string]types.AttributeValue{
+			":ver": version,
+		}
+	}
+
+	if r.cfg.DryRun {
+		return r.finish(key, RepairOutcomeDryRun, sourceItem, targetItem, nil)
+	}
+
+	if err := r.limiter.Wait(ctx, 1); err != nil {
+		return RepairOutcomeError, err
+	}
+
+	_, err = r.cfg.TargetClient.PutItem(ctx, input)
+	if err != nil {
+		var condErr *types.ConditionalCheckFailedException
+		if errors.As(err, &condErr) {
+			return r.finish(key, RepairOutcomeTargetNewer, sourceItem, targetItem, nil)
+		}
+		return r.finish(key, RepairOutcomeError, sourceItem, targetItem, fmt.Errorf("failed to write target item: %w", err))
+	}
+
+	return r.finish(key, RepairOutcomeWritten, sourceItem, targetItem, nil)
+}
+
+// getItem reads an item with a strongly consistent read so the latest version is compared
+func (r *Repairer) getItem(ctx context.Context, client TableAPI, tableName string, key map[string]types.AttributeValue) (map[string]types.AttributeValue, error) {
+	result, err := client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(tableName),
+		Key:            key,
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Item) == 0 {
+		return nil, nil
+	}
+	return result.Item, nil
+}
+
+// finish logs the outcome and writes it to the audit log
+func (r *Repairer) finish(key map[string]types.AttributeValue, outcome RepairOutcome, sourceItem, targetItem map[string]types.AttributeValue, repairErr error) (RepairOutcome, error) {
+	fields := log.Fields{
+		"key":     AttributeMap(key).String(),
+		"outcome": outcome,
+		"dry_run": r.cfg.DryRun,
+	}
+	if repairErr != nil {
+		fields["error"] = repairErr
+		log.WithFields(fields).Warn("[REPAIR] Failed to repair item ❌")
+	} else if outcome == RepairOutcomeWritten || outcome == RepairOutcomeDryRun {
+		log.WithFields(fields).Info("[REPAIR] Copied item from source to target 🔧")
+	} else {
+		log.WithFields(fields).Info("[REPAIR] Skipped item")
+	}
+
+	if r.cfg.AuditLog != nil {
+		rec := AuditRecord{
+			SourceTable:  r.cfg.SourceTable,
+			TargetTable:  r.cfg.TargetTable,
+			Key:          key,
+			Outcome:      outcome,
+			DryRun:       r.cfg.DryRun,
+			SourceItem:   sourceItem,
+			PreviousItem: targetItem,
+		}
+		if repairErr != nil {
+			rec.Error = repairErr.Error()
+		}
+		if err := r.cfg.AuditLog.Write(rec); err != nil {
+			log.Errorf("[REPAIR] Failed to write audit record: %v", err)
+		}
+	}
+
+	return outcome, repairErr
+}
+
+// RepairSummary counts repair outcomes
+type RepairSummary map[RepairOutcome]int
+
+// RunRepair repairs every key in the list and returns a count per outcome
+func RunRepair(ctx context.Context, repairer *Repairer, keys []map[string]types.AttributeValue) (RepairSummary, error) {
+	summary := RepairSummary{}
+
+	log.Infof("[REPAIR] Checking %d keys", len(keys))
+
+	for _, key := range keys {
+		if ctx.Err() != nil {
+			return summary, ctx.Err()
+		}
+		outcome, _ := repairer.Repair(ctx, key)
+		summary[outcome]++
+	}
+
+	log.Infof("[REPAIR] Done: %d keys, %d in sync, %d written, %d dry run, %d target newer, %d no version, %d source missing, %d errors",
+		len(keys),
+		summary[RepairOutcomeInSync],
+		summary[RepairOutcomeWritten],
+		summary[RepairOutcomeDryRun],
+		summary[RepairOutcomeTargetNewer],
+		summary[RepairOutcomeNoVersion],
+		summary[RepairOutcomeSourceMissing],
+		summary[RepairOutcomeError],
+	)
+	return summary, nil
+}
@@ -0,0 +1,239 @@
+package internal
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// versionedItem returns item number n of the test data with a version attribute
+func versionedItem(n, version int, data string) map[string]types.AttributeValue {
+	item := testItem(n)
+	item["version"] = &types.AttributeValueMemberN{Value: strconv.Itoa(version)}
+	item["data"] = &types.AttributeValueMemberS{Value: data}
+	return item
+}
+
+func TestRepairer(t *testing.T) {
+	tests := []struct {
+		name             string
+		source           map[string]types.AttributeValue // Source item, nil if missing
+		target           map[string]types.AttributeValue // Target item, nil if missing
+		targetHidden     bool                            // Target item is written after the repairer read it
+		versionAttribute string
+		writeErr         error
+		want             RepairOutcome
+		wantTarget       map[string]types.AttributeValue // Target item after the repair, nil if missing
+	}{
+		{
+			name:       "missing in target",
+			source:     versionedItem(1, 1, "a"),
+			want:       RepairOutcomeWritten,
+			wantTarget: versionedItem(1, 1, "a"),
+		},
+		{
+			name:         "written to target concurrently",
+			source:       versionedItem(1, 1, "a"),
+			target:       versionedItem(1, 2, "b"),
+			targetHidden: true,
+			want:         RepairOutcomeTargetNewer,
+			wantTarget:   versionedItem(1, 2, "b"),
+		},
+		{
+			name:       "in sync",
+			source:     versionedItem(1, 1, "a"),
+			target:     versionedItem(1, 1, "a"),
+			want:       RepairOutcomeInSync,
+			wantTarget: versionedItem(1, 1, "a"),
+		},
+		{
+			name:             "source newer",
+			source:           versionedItem(1, 5, "new"),
+			target:           versionedItem(1, 3, "old"),
+			versionAttribute: "version",
+			want:             RepairOutcomeWritten,
+			wantTarget:       versionedItem(1, 5, "new"),
+		},
+		{
+			name:             "target newer",
+			source:           versionedItem(1, 3, "old"),
+			target:           versionedItem(1, 5, "new"),
+			versionAttribute: "version",
+			want:             RepairOutcomeTargetNewer,
+			wantTarget:       versionedItem(1, 5, "new"),
+		},
+		{
+			name:             "same version",
+			source:           versionedItem(1, 3, "a"),
+			target:           versionedItem(1, 3, "b"),
+			versionAttribute: "version",
+			want:             RepairOutcomeTargetNewer,
+			wantTarget:       versionedItem(1, 3, "b"),
+		},
+		{
+			name:       "drifted without version attribute",
+			source:     versionedItem(1, 5, "new"),
+			target:     versionedItem(1, 3, "old"),
+			want:       RepairOutcomeNoVersion,
+			wantTarget: versionedItem(1, 3, "old"),
+		},
+		{
+			name:       "source missing",
+			target:     versionedItem(1, 3, "old"),
+			want:       RepairOutcomeSourceMissing,
+			wantTarget: versionedItem(1, 3, "old"),
+		},
+		{
+			name:     "write error",
+			source:   versionedItem(1, 1, "a"),
+			writeErr: errors.New("throttled"),
+			want:     RepairOutcomeError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newFakeMigration()
+			if tt.source != nil {
+				m.source.put(tt.source, 0)
+			}
+			if tt.target != nil {
+				delay := time.Duration(0)
+				if tt.targetHidden {
+					delay = time.Hour
+				}
+				m.target.put(tt.target, delay)
+			}
+			m.target.writeErr = tt.writeErr
+
+			repairer := NewRepairer(RepairConfig{
+				SourceClient:     m.source,
+				TargetClient:     m.target,
+				TargetTable:      "orders",
+				PartitionKey:     "pk",
+				VersionAttribute: tt.versionAttribute,
+			})
+			outcome, err := repairer.Repair(context.Background(), m.keyOf(testItem(1)))
+			if outcome != tt.want {
+				t.Errorf("outcome = %s, want %s", outcome, tt.want)
+			}
+			if (err != nil) != (tt.want == RepairOutcomeError) {
+				t.Errorf("error = %v", err)
+			}
+			if got := m.target.get(testItem(1)); !itemsEqual(got, tt.wantTarget) {
+				t.Errorf("target item = %v, want %v", AttributeMap(got), AttributeMap(tt.wantTarget))
+			}
+		})
+	}
+}
+
+func TestRepairerDryRunAndAuditLog(t *testing.T) {
+	auditFile := filepath.Join(t.TempDir(), "audit.jsonl")
+	auditLog, err := OpenAuditLog(auditFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := newFakeMigration()
+	m.source.put(versionedItem(1, 1, "a"), 0)
+	m.source.put(versionedItem(2, 5, "new"), 0)
+	m.target.put(versionedItem(2, 3, "old"), 0)
+
+	cfg := RepairConfig{
+		SourceClient:     m.source,
+		TargetClient:     m.target,
+		TargetTable:      "orders",
+		PartitionKey:     "pk",
+		VersionAttribute: "version",
+		DryRun:           true,
+		AuditLog:         auditLog,
+	}
+	keys := []map[string]types.AttributeValue{m.keyOf(testItem(1)), m.keyOf(testItem(2))}
+
+	summary, err := RunRepair(context.Background(), NewRepairer(cfg), keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary[RepairOutcomeDryRun] != 2 || m.target.writes != 0 {
+		t.Fatalf("dry run: summary = %v, %d writes, want 2 dry runs and no writes", summary, m.target.writes)
+	}
+
+	cfg.DryRun = false
+	summary, err = RunRepair(context.Background(), NewRepairer(cfg), keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary[RepairOutcomeWritten] != 2 || m.target.writes != 2 {
+		t.Fatalf("repair: summary = %v, %d writes, want 2 written", summary, m.target.writes)
+	}
+	if err := auditLog.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.Open(auditFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var records []AuditRecord
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var rec AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, rec)
+	}
+
+	want := []struct {
+		outcome  RepairOutcome
+		dryRun   bool
+		previous map[string]types.AttributeValue
+	}{
+		{RepairOutcomeDryRun, true, nil},
+		{RepairOutcomeDryRun, true, versionedItem(2, 3, "old")},
+		{RepairOutcomeWritten, false, nil},
+		{RepairOutcomeWritten, false, versionedItem(2, 3, "old")},
+	}
+	if len(records) != len(want) {
+		t.Fatalf("audit log has %d records, want %d", len(records), len(want))
+	}
+	for i, rec := range records {
+		if rec.Outcome != want[i].outcome || rec.DryRun != want[i].dryRun || rec.TargetTable != "orders" || rec.Timestamp.IsZero() {
+			t.Errorf("audit record %d = %+v, want outcome %s and dry run %t", i, rec, want[i].outcome, want[i].dryRun)
+		}
+		if !itemsEqual(rec.Key, keys[i%2]) || rec.SourceItem == nil {
+			t.Errorf("audit record %d: key = %v, source item = %v", i, rec.Key, rec.SourceItem)
+		}
+		if !itemsEqual(rec.PreviousItem, want[i].previous) {
+			t.Errorf("audit record %d: previous item = %v, want %v", i, rec.PreviousItem, AttributeMap(want[i].previous))
+		}
+	}
+}
+
+func TestTableRecords(t *testing.T) {
+	records := []FailureRecord{
+		{Table: "orders_v2", VerifyOn: "target", EventID: "1"},
+		{Table: "users_v2", VerifyOn: "target", EventID: "2"},
+		{Table: "orders", VerifyOn: "source", EventID: "3"},
+		{Table: "users", VerifyOn: "source", EventID: "4"},
+		{Table: "orders_v2", EventID: "5"},
+	}
+
+	kept, skipped := tableRecords(records, "orders", "orders_v2")
+	var ids []string
+	for _, rec := range kept {
+		ids = append(ids, rec.EventID)
+	}
+	if !slices.Equal(ids, []string{"1", "3", "5"}) || skipped != 2 {
+		t.Errorf("kept %v and skipped %d, want [1 3 5] and 2", ids, skipped)
+	}
+}
@@ -0,0 +1,559 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodbstreams"
+	streamtypes "github.com/aws/aws-sdk-go-v2/service/dynamodbstreams/types"
+	"github.com/aws/smithy-go"
+	log "github.com/sirupsen/logrus"
+)
+
+// ReplicatorConfig contains all the configuration needed to replicate a source stream to a target table
+type ReplicatorConfig struct {
+	SourceClient TableAPI // Used to read the source item when the stream has no new image
+	TargetClient TableAPI
+	StreamClient StreamAPI
+	StreamArn    string
+	SourceTable  string // Source table name (optional, defaults to TargetTable)
+	TargetTable  string
+	PartitionKey string // Name of the partition key
+
+	// VersionAttribute is a numeric or string attribute that increases on every write.
+	// Writes are conditional so a newer target version is never overwritten. Records whose
+	// image has no version, e.g. REMOVE records of a KEYS_ONLY stream, are written without
+	// a condition. Required unless UnconditionalWrites is set.
+	VersionAttribute string
+
+	// UnconditionalWrites allows running without VersionAttribute. Every write then
+	// overwrites the target item, even one written by someone else after the record.
+	UnconditionalWrites bool
+
+	IteratorType        string        // Where to start shards without a checkpoint or parent: TRIM_HORIZON or LATEST
+	CheckpointFile      string        // File that stores per-shard progress (optional)
+	CheckpointInterval  time.Duration // How often to save the checkpoint file
+	WritesPerSecond     float64       // Maximum writes per second (0 = unlimited)
+	MaxConcurrentShards int           // Maximum number of shards replicated at the same time
+	BatchSize           int32         // Size of stream batch
+	RefreshInterval     time.Duration // How often to look for new shards
+	PollInterval        time.Duration // How long to wait when a shard has no new records
+	MaxRetries          int           // How many times a failed write is retried
+	RetryWaitTime       time.Duration // How long to wait before the first retry of a write or a failed shard (doubles every retry)
+	StatsInterval       time.Duration // How often to show statistics
+}
+
+// ReplicationStats tracks replication statistics
+type ReplicationStats struct {
+	mu          sync.Mutex
+	StartTime   time.Time
+	Puts        int       // Items written to the target
+	Deletes     int       // Items deleted from the target
+	Skipped     int       // Writes skipped because the target has a newer version
+	Errors      int       // Writes that failed after all retries
+	LastEventAt time.Time // ApproximateCreationDateTime of the last applied record
+}
+
+// Replicator applies source stream records to the target table
+type Replicator struct {
+	cfg         *ReplicatorConfig
+	subscriber  *StreamSubscriberV2
+	checkpoints *CheckpointStore
+	limiter     *RateLimiter
+	stats       *ReplicationStats
+}
+
+// shardResult is sent by a shard worker when it stops
+type shardResult struct {
+	shardID string
+	err     error
+}
+
+// maxShardRestartDelay caps the exponential delay before a failed shard is restarted
+const maxShardRestartDelay = 5 * time.Minute
+
+// shardRestart tracks the failures of a shard to delay its next start
+type shardRestart struct {
+	failures       int       // Consecutive failures without progress
+	sequenceNumber string    // Checkpoint when the shard last failed
+	notBefore      time.Time // The shard is not restarted before this time
+}
+
+// RunReplication replicates the source stream to the target table until the context is canceled
+func RunReplication(ctx context.Context, cfg *ReplicatorConfig) (*ReplicationStats, error) {
+	// Set defaults if not provided
+	if cfg.SourceTable == "" {
+		cfg.SourceTable = cfg.TargetTable
+	}
+	if cfg.IteratorType == "" {
+		cfg.IteratorType = "TRIM_HORIZON"
+	}
+	if cfg.CheckpointInterval <= 0 {
+		cfg.CheckpointInterval = 10 * time.Second
+	}
+	if cfg.MaxConcurrentShards <= 0 {
+		cfg.MaxConcurrentShards = 5
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 100
+	}
+	if cfg.RefreshInterval <= 0 {
+		cfg.RefreshInterval = time.Minute
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = time.Second
+	}
+	if cfg.MaxRetries <= 0 {
+		cfg.MaxRetries = 5
+	}
+	if cfg.RetryWaitTime <= 0 {
+		cfg.RetryWaitTime = time.Second
+	}
+	if cfg.StatsInterval <= 0 {
+		cfg.StatsInterval = 30 * time.Second
+	}
+
+	if cfg.VersionAttribute == "" && !cfg.UnconditionalWrites {
+		return nil, errors.New("a version attribute is required to never overwrite a newer target item, allow unconditional writes to run without one")
+	}
+
+	checkpoints, err := LoadCheckpointStore(cfg.CheckpointFile, cfg.StreamArn)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Replicator{
+		cfg:         cfg,
+		subscriber:  NewStreamSubscriberV2WithArn(cfg.SourceClient, cfg.StreamClient, cfg.SourceTable, cfg.StreamArn),
+		checkpoints: checkpoints,
+		limiter:     NewRateLimiter(cfg.WritesPerSecond, cfg.WritesPerSecond),
+		stats:       &ReplicationStats{StartTime: time.Now()},
+	}
+
+	return r.stats, r.run(ctx)
+}
+
+func (r *Replicator) run(ctx context.Context) error {
+	active := make(map[string]struct{})
+	restarts := make(map[string]*shardRestart)
+	resultCh := make(chan shardResult, r.cfg.MaxConcurrentShards)
+	retryCh := make(chan struct{}, 1)
+	var wg sync.WaitGroup
+
+	refreshTicker := time.NewTicker(r.cfg.RefreshInterval)
+	defer refreshTicker.Stop()
+	checkpointTicker := time.NewTicker(r.cfg.CheckpointInterval)
+	defer checkpointTicker.Stop()
+	statsTicker := time.NewTicker(r.cfg.StatsInterval)
+	defer statsTicker.Stop()
+
+	// Start every shard that is ready: not closed, not running, and whose parent is done
+	startShards := func() {
+		arn, err := r.subscriber.getLatestStreamArn(ctx)
+		if err != nil {
+			log.Errorf("[REPLICATE] Error: %v", err)
+			return
+		}
+		shards, err := r.subscriber.getShardIDs(ctx, arn)
+		if err != nil {
+			log.Errorf("[REPLICATE] Error: %v", err)
+			return
+		}
+
+		known := make(map[string]struct{}, len(shards))
+		for _, shard := range shards {
+			known[aws.ToString(shard.ShardId)] = struct{}{}
+		}
+
+		for _, shard := range shards {
+			if len(active) >= r.cfg.MaxConcurrentShards {
+				return
+			}
+
+			shardID := aws.ToString(shard.ShardId)
+			if _, running := active[shardID]; running {
+				continue
+			}
+			if cp, ok := r.checkpoints.Get(shardID); ok && cp.Closed {
+				continue
+			}
+			if restart, ok := restarts[shardID]; ok && time.Now().Before(restart.notBefore) {
+				continue
+			}
+
+			// Children must wait until the parent shard has been fully applied to keep per-key order.
+			// They continue their parent, so they are read from the start, as records written
+			// while the parent was being applied are already in them.
+			iteratorType := streamtypes.ShardIteratorType(r.cfg.IteratorType)
+			if parentID := aws.ToString(shard.ParentShardId); parentID != "" {
+				_, parentKnown := known[parentID]
+				cp, parentCheckpointed := r.checkpoints.Get(parentID)
+				if parentKnown && (!parentCheckpointed || !cp.Closed) {
+					continue
+				}
+				if parentKnown || parentCheckpointed {
+					iteratorType = streamtypes.ShardIteratorTypeTrimHorizon
+				}
+			}
+
+			active[shardID] = struct{}{}
+			wg.Add(1)
+			go func(shardID string) {
+				defer wg.Done()
+				resultCh <- shardResult{shardID: shardID, err: r.replicateShard(ctx, arn, shardID, iteratorType)}
+			}(shardID)
+		}
+	}
+
+	saveCheckpoint := func() {
+		if err := r.checkpoints.Save(); err != nil {
+			log.Errorf("[REPLICATE] Failed to save checkpoint: %v", err)
+		}
+	}
+
+	startShards()
+
+	for {
+		select {
+		case res := <-resultCh:
+			delete(active, res.shardID)
+			if res.err != nil && ctx.Err() == nil {
+				delay := r.scheduleRestart(restarts, res.shardID)
+				log.WithFields(log.Fields{
+					"shard_id": res.shardID,
+					"error":    res.err,
+					"delay":    delay,
+				}).Error("[REPLICATE] Shard stopped, it will be resumed from its checkpoint")
+				time.AfterFunc(delay, func() {
+					select {
+					case retryCh <- struct{}{}:
+					default:
+					}
+				})
+			} else {
+				delete(restarts, res.shardID)
+			}
+			// A closed shard may unblock its children
+			if ctx.Err() == nil {
+				startShards()
+			}
+
+		case <-retryCh:
+			startShards()
+
+		case <-refreshTicker.C:
+			startShards()
+
+		case <-checkpointTicker.C:
+			saveCheckpoint()
+
+		case <-statsTicker.C:
+			r.printStats()
+
+		case <-ctx.Done():
+			log.Info("Context canceled, waiting for shard workers to stop...")
+			go func() {
+				wg.Wait()
+				close(resultCh)
+			}()
+			for range resultCh {
+			}
+			saveCheckpoint()
+			r.printStats()
+			return nil
+		}
+	}
+}
+
+// scheduleRestart records a shard failure and returns how long to wait before restarting
+// the shard. The delay doubles with every failure that made no progress.
+func (r *Replicator) scheduleRestart(restarts map[string]*shardRestart, shardID string) time.Duration {
+	cp, _ := r.checkpoints.Get(shardID)
+	restart, ok := restarts[shardID]
+	if !ok || restart.sequenceNumber != cp.SequenceNumber {
+		restart = &shardRestart{sequenceNumber: cp.SequenceNumber}
+		restarts[shardID] = restart
+	}
+	restart.failures++
+
+	delay := r.cfg.RetryWaitTime
+	for i := 1; i < restart.failures && delay < maxShardRestartDelay; i++ {
+		delay *= 2
+	}
+	delay = min(delay, maxShardRestartDelay)
+	restart.notBefore = time.Now().Add(delay)
+	return delay
+}
+
+// replicateShard applies every record of a shard in order, starting after its checkpoint
+// or at iteratorType
+func (r *Replicator) replicateShard(ctx context.Context, arn *string, shardID string, iteratorType streamtypes.ShardIteratorType) error {
+	next, err := r.shardIterator(ctx, arn, shardID, iteratorType)
+	if err != nil {
+		return err
+	}
+
+	renewed := false
+	for next != nil {
+		recOut, err := r.cfg.StreamClient.GetRecords(ctx, &dynamodbstreams.GetRecordsInput{
+			ShardIterator: next,
+			Limit:         aws.Int32(r.cfg.BatchSize),
+		})
+		if err != nil && isExpiredIterator(err) && !renewed && ctx.Err() == nil {
+			// Iterators expire after 15 minutes, e.g. while writes were retried
+			log.WithField("shard_id", shardID).Warn("[REPLICATE] Shard iterator expired, resuming from the checkpoint")
+			if next, err = r.shardIterator(ctx, arn, shardID, iteratorType); err != nil {
+				return err
+			}
+			renewed = true
+			continue
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("failed to get records: %w", err)
+		}
+		renewed = false
+
+		for i := range recOut.Records {
+			rec := recOut.Records[i]
+			if err := r.applyWithRetry(ctx, &rec); err != nil {
+				return err
+			}
+			if rec.Dynamodb != nil {
+				r.checkpoints.Advance(shardID, aws.ToString(rec.Dynamodb.SequenceNumber))
+			}
+		}
+
+		next = recOut.NextShardIterator
+		if next == nil {
+			break
+		}
+
+		if len(recOut.Records) == 0 {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(r.cfg.PollInterval):
+			}
+		}
+	}
+
+	r.checkpoints.MarkClosed(shardID)
+	log.WithField("shard_id", shardID).Info("[REPLICATE] Shard closed and fully applied")
+	return nil
+}
+
+// shardIterator returns an iterator after the checkpoint of the shard, or at iteratorType
+// if the shard has no checkpoint yet
+func (r *Replicator) shardIterator(ctx context.Context, arn *string, shardID string, iteratorType streamtypes.ShardIteratorType) (*string, error) {
+	input := &dynamodbstreams.GetShardIteratorInput{
+		StreamArn:         arn,
+		ShardId:           aws.String(shardID),
+		ShardIteratorType: iteratorType,
+	}
+	if cp, ok := r.checkpoints.Get(shardID); ok && cp.SequenceNumber != "" {
+		input.ShardIteratorType = streamtypes.ShardIteratorTypeAfterSequenceNumber
+		input.SequenceNumber = aws.String(cp.SequenceNumber)
+	}
+
+	iterOut, err := r.cfg.StreamClient.GetShardIterator(ctx, input)
+	if err != nil && isTrimmedDataAccess(err) && input.SequenceNumber != nil {
+		// The checkpoint is older than the stream retention, records in between are lost
+		log.WithFields(log.Fields{
+			"shard_id":        shardID,
+			"sequence_number": aws.ToString(input.SequenceNumber),
+		}).Error("[REPLICATE] Checkpoint has been trimmed from the stream, restarting shard from TRIM_HORIZON ❌")
+		input.ShardIteratorType = streamtypes.ShardIteratorTypeTrimHorizon
+		input.SequenceNumber = nil
+		iterOut, err = r.cfg.StreamClient.GetShardIterator(ctx, input)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get shard iterator: %w", err)
+	}
+	return iterOut.ShardIterator, nil
+}
+
+// applyWithRetry applies a record, retrying with exponential backoff
+func (r *Replicator) applyWithRetry(ctx context.Context, rec *streamtypes.Record) error {
+	wait := r.cfg.RetryWaitTime
+
+	var err error
+	for attempt := 0; attempt <= r.cfg.MaxRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(wait):
+			}
+			wait *= 2
+		}
+
+		if err = r.apply(ctx, rec); err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		log.WithFields(log.Fields{
+			"event_id": aws.ToString(rec.EventID),
+			"attempt":  attempt + 1,
+			"error":    err,
+		}).Warn("[REPLICATE] Failed to apply record, retrying")
+	}
+
+	r.stats.mu.Lock()
+	r.stats.Errors++
+	r.stats.mu.Unlock()
+	return fmt.Errorf("failed to apply event %s after %d retries: %w", aws.ToString(rec.EventID), r.cfg.MaxRetries, err)
+}
+
+// apply writes a single stream record to the target table. Writes are idempotent:
+// applying the same record twice leaves the target in the same state.
+func (r *Replicator) apply(ctx context.Context, rec *streamtypes.Record) error {
+	if rec.Dynamodb == nil {
+		return nil
+	}
+
+	var write func() error
+	counter := &r.stats.Puts
+
+	switch rec.EventName {
+	case streamtypes.OperationTypeInsert, streamtypes.OperationTypeModify:
+		item := streamImageToItem(rec.Dynamodb.NewImage)
+		if item == nil {
+			// KEYS_ONLY or OLD_IMAGE stream: read the current source item instead
+			key := streamImageToItem(rec.Dynamodb.Keys)
+			source, err := r.getSourceItem(ctx, key)
+			if err != nil {
+				return fmt.Errorf("failed to read source item: %w", err)
+			}
+			if source == nil {
+				// Deleted since, the REMOVE record will follow
+				return nil
+			}
+			item = source
+		}
+
+		input := &dynamodb.PutItemInput{
+			TableName: aws.String(r.cfg.TargetTable),
+			Item:      item,
+		}
+		r.addVersionCondition(item, &input.ConditionExpression, &input.ExpressionAttributeNames, &input.ExpressionAttributeValues)
+		write = func() error {
+			_, err := r.cfg.TargetClient.PutItem(ctx, input)
+			return err
+		}
+
+	case streamtypes.OperationTypeRemove:
+		input := &dynamodb.DeleteItemInput{
+			TableName: aws.String(r.cfg.TargetTable),
+			Key:       streamImageToItem(rec.Dynamodb.Keys),
+		}
+		r.addVersionCondition(streamImageToItem(rec.Dynamodb.OldImage), &input.ConditionExpression, &input.ExpressionAttributeNames, &input.ExpressionAttributeValues)
+		write = func() error {
+			_, err := r.cfg.TargetClient.DeleteItem(ctx, input)
+			return err
+		}
+		counter = &r.stats.Deletes
+
+	default:
+		return nil
+	}
+
+	if err := r.limiter.Wait(ctx, 1); err != nil {
+		return err
+	}
+
+	err := write()
+	var condErr *types.ConditionalCheckFailedException
+	if errors.As(err, &condErr) {
+		// The target already holds a newer version
+		counter = &r.stats.Skipped
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+
+	r.stats.mu.Lock()
+	*counter++
+	if rec.Dynamodb.ApproximateCreationDateTime != nil {
+		r.stats.LastEventAt = *rec.Dynamodb.ApproximateCreationDateTime
+	}
+	r.stats.mu.Unlock()
+	return nil
+}
+
+// addVersionCondition makes a write conditional on the target version not being newer than the image's version
+func (r *Replicator) addVersionCondition(image map[string]types.AttributeValue, condition **string, names *map[string]string, values *map[string]types.AttributeValue) {
+	if r.cfg.VersionAttribute == "" {
+		return
+	}
+	version, ok := image[r.cfg.VersionAttribute]
+	if !ok {
+		log.WithField("version_attribute", r.cfg.VersionAttribute).Debug("[REPLICATE] Record has no version, writing without a condition")
+		return
+	}
+
+	*condition = aws.String("attribute_not_exists(#pk) OR attribute_not_exists(#ver) OR #ver <= :ver")
+	*names = map[string]string{
+		"#pk":  r.cfg.PartitionKey,
+		"#ver": r.cfg.VersionAttribute,
+	}
+	*values = map[string]types.AttributeValue{
+		":ver": version,
+	}
+}
+
+// getSourceItem reads the current source item with a strongly consistent read
+func (r *Replicator) getSourceItem(ctx context.Context, key map[string]types.AttributeValue) (map[string]types.AttributeValue, error) {
+	result, err := r.cfg.SourceClient.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(r.cfg.SourceTable),
+		Key:            key,
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Item) == 0 {
+		return nil, nil
+	}
+	return result.Item, nil
+}
+
+func (r *Replicator) printStats() {
+	r.stats.mu.Lock()
+	defer r.stats.mu.Unlock()
+
+	duration := time.Since(r.stats.StartTime)
+	applied := r.stats.Puts + r.stats.Deletes
+	log.Infof("========= Replication Statistics (Total %s) =========", duration.Round(time.Second))
+	log.Infof("Applied: %d (PUT: %d, DELETE: %d), Skipped (target newer): %d, Errors: %d",
+		applied, r.stats.Puts, r.stats.Deletes, r.stats.Skipped, r.stats.Errors)
+	log.Infof("Average: %.2f writes/sec", float64(applied)/duration.Seconds())
+	if !r.stats.LastEventAt.IsZero() {
+		log.Infof("Replication lag: %s", time.Since(r.stats.LastEventAt).Round(time.Second))
+	}
+	log.Infof("========================================")
+}
+
+// isTrimmedDataAccess reports whether err is a TrimmedDataAccessException
+func isTrimmedDataAccess(err error) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode() == "TrimmedDataAccessException"
+}
+
+// isExpiredIterator reports whether err is an ExpiredIteratorException
+func isExpiredIterator(err error) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode() == "ExpiredIteratorException"
+}
@@ -0,0 +1,269 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	streamtypes "github.com/aws/aws-sdk-go-v2/service/dynamodbstreams/types"
+)
+
+// testReplicatorConfig returns a replicator configuration for the fake migration with
+// short intervals
+func testReplicatorConfig(m *fakeMigration) ReplicatorConfig {
+	return ReplicatorConfig{
+		SourceClient:       m.source,
+		TargetClient:       m.target,
+		StreamClient:       m.stream,
+		StreamArn:          m.stream.arn,
+		TargetTable:        "orders",
+		PartitionKey:       "pk",
+		VersionAttribute:   "version",
+		CheckpointInterval: 10 * time.Millisecond,
+		RefreshInterval:    20 * time.Millisecond,
+		PollInterval:       5 * time.Millisecond,
+		MaxRetries:         1,
+		RetryWaitTime:      5 * time.Millisecond,
+		StatsInterval:      time.Hour,
+	}
+}
+
+// startReplication runs the replication in the background and returns a function that
+// stops it and returns its statistics
+func startReplication(t *testing.T, cfg ReplicatorConfig) func() *ReplicationStats {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	done := make(chan struct{})
+	var stats *ReplicationStats
+	var err error
+	go func() {
+		defer close(done)
+		stats, err = RunReplication(ctx, &cfg)
+	}()
+
+	return func() *ReplicationStats {
+		t.Helper()
+		cancel()
+		<-done
+		if err != nil {
+			t.Fatalf("replication failed: %v", err)
+		}
+		return stats
+	}
+}
+
+func TestReplicatorResumesFromCheckpoint(t *testing.T) {
+	m := newFakeMigration()
+	for n := 1; n <= 5; n++ {
+		m.writeUnreplicated(versionedItem(n, 1, "a"))
+	}
+
+	cfg := testReplicatorConfig(m)
+	cfg.CheckpointFile = filepath.Join(t.TempDir(), "checkpoint.json")
+
+	stop := startReplication(t, cfg)
+	waitFor(t, func() bool { return m.target.count() == 5 })
+	if stats := stop(); stats.Puts != 5 {
+		t.Fatalf("first run applied %d puts, want 5", stats.Puts)
+	}
+
+	store, err := LoadCheckpointStore(cfg.CheckpointFile, m.stream.arn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cp, _ := store.Get("shardId-00000001"); cp.SequenceNumber != "5" || cp.Closed {
+		t.Fatalf("checkpoint = %+v, want sequence number 5 and open", cp)
+	}
+
+	// Only the records written after the checkpoint are applied on restart
+	for n := 6; n <= 8; n++ {
+		m.writeUnreplicated(versionedItem(n, 1, "a"))
+	}
+	stop = startReplication(t, cfg)
+	waitFor(t, func() bool { return m.target.count() == 8 })
+	if stats := stop(); stats.Puts != 3 {
+		t.Errorf("resumed run applied %d puts, want 3", stats.Puts)
+	}
+	if writes := m.target.writeCount(); writes != 8 {
+		t.Errorf("target received %d writes, want 8", writes)
+	}
+}
+
+func TestReplicatorAppliesParentShardFirst(t *testing.T) {
+	m := newFakeMigration()
+	m.writeUnreplicated(versionedItem(1, 1, "parent"))
+	m.stream.split("shardId-00000001")
+	m.writeUnreplicated(versionedItem(1, 2, "child"))
+
+	// A slow parent shard would be overtaken by its children if they started together
+	m.stream.onGetRecords = func(shardID string) {
+		if shardID == "shardId-00000001" {
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+
+	// Without conditions, only the order of the writes decides the final item
+	cfg := testReplicatorConfig(m)
+	cfg.VersionAttribute = ""
+	cfg.UnconditionalWrites = true
+
+	stop := startReplication(t, cfg)
+	waitFor(t, func() bool { return m.target.writeCount() == 2 })
+	stop()
+
+	if got := m.target.get(testItem(1)); !itemsEqual(got, versionedItem(1, 2, "child")) {
+		t.Errorf("target item = %v, want the child shard's version", AttributeMap(got))
+	}
+}
+
+func TestReplicatorReadsSplitShardsFromStart(t *testing.T) {
+	m := newFakeMigration()
+	m.writeUnreplicated(versionedItem(1, 1, "before"))
+
+	// The parent is slow, so its children receive records before they can start
+	m.stream.onGetRecords = func(shardID string) {
+		if shardID == "shardId-00000001" {
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+
+	cfg := testReplicatorConfig(m)
+	cfg.IteratorType = "LATEST"
+	stop := startReplication(t, cfg)
+	waitFor(t, func() bool { return m.stream.iteratorCount() > 0 })
+
+	m.writeUnreplicated(versionedItem(2, 1, "parent"))
+	m.stream.split("shardId-00000001")
+	for n := 3; n <= 6; n++ {
+		m.writeUnreplicated(versionedItem(n, 1, "child"))
+	}
+
+	// Only the item written before the start is skipped
+	waitFor(t, func() bool { return m.target.count() == 5 })
+	stop()
+	if item := m.target.get(testItem(1)); item != nil {
+		t.Errorf("item written before LATEST was replicated: %v", AttributeMap(item))
+	}
+}
+
+func TestReplicatorConditionalWrites(t *testing.T) {
+	m := newFakeMigration()
+	m.target.put(versionedItem(1, 5, "newer"), 0) // Newer than the record: skipped
+	m.target.put(versionedItem(2, 3, "older"), 0) // Older than the record: overwritten
+	m.target.put(versionedItem(3, 4, "same"), 0)  // Same version, e.g. a replay: overwritten
+	m.target.put(versionedItem(5, 9, "x"), 0)     // Deleted without an old image: unconditional
+
+	for n := 1; n <= 4; n++ {
+		m.writeUnreplicated(versionedItem(n, 4, "stream"))
+	}
+	m.stream.append(streamtypes.OperationTypeRemove, m.keyOf(testItem(5)), nil)
+
+	stop := startReplication(t, testReplicatorConfig(m))
+	waitFor(t, func() bool { return m.target.get(testItem(5)) == nil })
+	stats := stop()
+
+	if stats.Puts != 3 || stats.Skipped != 1 || stats.Deletes != 1 || stats.Errors != 0 {
+		t.Errorf("stats = %d puts, %d skipped, %d deletes, %d errors, want 3, 1, 1, 0",
+			stats.Puts, stats.Skipped, stats.Deletes, stats.Errors)
+	}
+	want := map[int]map[string]types.AttributeValue{
+		1: versionedItem(1, 5, "newer"),
+		2: versionedItem(2, 4, "stream"),
+		3: versionedItem(3, 4, "stream"),
+		4: versionedItem(4, 4, "stream"),
+	}
+	for n, item := range want {
+		if got := m.target.get(testItem(n)); !itemsEqual(got, item) {
+			t.Errorf("target item %d = %v, want %v", n, AttributeMap(got), AttributeMap(item))
+		}
+	}
+}
+
+func TestReplicatorRequiresVersionAttribute(t *testing.T) {
+	cfg := testReplicatorConfig(newFakeMigration())
+	cfg.VersionAttribute = ""
+	if _, err := RunReplication(context.Background(), &cfg); err == nil {
+		t.Error("expected an error without a version attribute or unconditional writes")
+	}
+}
+
+func TestReplicatorRenewsExpiredIterator(t *testing.T) {
+	m := newFakeMigration()
+	for n := 1; n <= 3; n++ {
+		m.writeUnreplicated(versionedItem(n, 1, "a"))
+	}
+
+	// The iterator expires after the second record was applied
+	calls := 0
+	m.stream.onGetRecords = func(string) {
+		if calls++; calls == 2 {
+			m.stream.expire(1)
+		}
+	}
+
+	// Restarting the shard instead of renewing the iterator would take an hour
+	cfg := testReplicatorConfig(m)
+	cfg.BatchSize = 1
+	cfg.RetryWaitTime = time.Hour
+	stop := startReplication(t, cfg)
+	waitFor(t, func() bool { return m.target.count() == 3 })
+	stats := stop()
+
+	if stats.Puts != 3 || m.target.writeCount() != 3 {
+		t.Errorf("applied %d puts with %d writes, want 3 without replays", stats.Puts, m.target.writeCount())
+	}
+}
+
+func TestReplicatorRestartsFailedShard(t *testing.T) {
+	m := newFakeMigration()
+	m.writeUnreplicated(versionedItem(1, 1, "a"))
+	m.target.writeErr = errors.New("throttled")
+
+	stop := startReplication(t, testReplicatorConfig(m))
+	waitFor(t, func() bool { return m.stream.iteratorCount() >= 3 })
+	m.target.mu.Lock()
+	m.target.writeErr = nil
+	m.target.mu.Unlock()
+	waitFor(t, func() bool { return m.target.count() == 1 })
+	stats := stop()
+
+	if stats.Puts != 1 || stats.Errors == 0 {
+		t.Errorf("stats = %d puts, %d errors, want 1 put after failed attempts", stats.Puts, stats.Errors)
+	}
+}
+
+func TestReplicatorRestartDelay(t *testing.T) {
+	store, err := LoadCheckpointStore("", "arn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &Replicator{cfg: &ReplicatorConfig{RetryWaitTime: time.Second}, checkpoints: store}
+	restarts := make(map[string]*shardRestart)
+
+	for _, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second} {
+		if delay := r.scheduleRestart(restarts, "shard"); delay != want {
+			t.Errorf("delay = %s, want %s", delay, want)
+		}
+	}
+
+	// Progress since the last failure starts over
+	store.Advance("shard", "10")
+	if delay := r.scheduleRestart(restarts, "shard"); delay != time.Second {
+		t.Errorf("delay after progress = %s, want 1s", delay)
+	}
+
+	for range 20 {
+		r.scheduleRestart(restarts, "shard")
+	}
+	if delay := r.scheduleRestart(restarts, "shard"); delay != maxShardRestartDelay {
+		t.Errorf("delay after many failures = %s, want %s", delay, maxShardRestartDelay)
+	}
+	if other := r.scheduleRestart(restarts, "other"); other != time.Second {
+		t.Errorf("delay of another shard = %s, want 1s", other)
+	}
+}
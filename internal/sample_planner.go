@@ -0,0 +1,93 @@
+package internal
+
+import (
+	"math"
+	"time"
+)
+
+// defaultConfidence is the confidence level of the reported success interval
+const defaultConfidence = 0.95
+
+// minPlanningValidations is the number of validations needed before the planner uses the
+// observed success rate instead of the worst case
+const minPlanningValidations = 100
+
+// WilsonInterval returns the Wilson score interval of a success rate of successes out of n
+// at the given confidence level, e.g. 0.95. It returns 0, 1 when n is 0.
+func WilsonInterval(successes, n int, confidence float64) (low, high float64) {
+	if n <= 0 {
+		return 0, 1
+	}
+
+	z := zScore(confidence)
+	p := float64(successes) / float64(n)
+	nf := float64(n)
+
+	denominator := 1 + z*z/nf
+	center := (p + z*z/(2*nf)) / denominator
+	half := z * math.Sqrt(p*(1-p)/nf+z*z/(4*nf*nf)) / denominator
+	return math.Max(0, center-half), math.Min(1, center+half)
+}
+
+// zScore returns the two-sided standard normal quantile of a confidence level
+func zScore(confidence float64) float64 {
+	return math.Sqrt2 * math.Erfinv(confidence)
+}
+
+// RequiredSamples returns the number of validations needed to estimate a success rate close
+// to p within ±margin at the given confidence level, out of a population of events (0 for
+// an unbounded population)
+func RequiredSamples(margin, confidence, p, population float64) float64 {
+	z := zScore(confidence)
+	n := z * z * p * (1 - p) / (margin * margin)
+
+	// Finite population correction
+	if population > 0 {
+		n = n / (1 + (n-1)/population)
+	}
+	return n
+}
+
+// SamplePlanConfig describes the precision wanted from the validated sample. The planner
+// chooses the sample rate that validates enough events per window to reach it.
+type SamplePlanConfig struct {
+	TargetMargin      float64       // Wanted half-width of the success rate interval, e.g. 0.005 for ±0.5%
+	ExpectedEventRate float64       // Expected events per second, used until traffic is observed (optional)
+	Window            time.Duration // Period the margin should be reached in (optional, defaults to 1h)
+}
+
+// SamplePlanner chooses the sample rate from the event rate and the observed success rate
+type SamplePlanner struct {
+	cfg        SamplePlanConfig
+	confidence float64
+}
+
+// NewSamplePlanner creates a planner for the interval at the given confidence level
+func NewSamplePlanner(cfg SamplePlanConfig, confidence float64) *SamplePlanner {
+	if cfg.Window <= 0 {
+		cfg.Window = time.Hour
+	}
+	return &SamplePlanner{cfg: cfg, confidence: confidence}
+}
+
+// Plan returns the sample rate for an event rate in events per second, given the
+// validations so far. Until enough records are validated the worst case success rate of
+// 50% is assumed. Afterwards the observed rate is used, kept between 1% and 99% so a run
+// without failures still samples enough to notice a 1% failure rate.
+func (p *SamplePlanner) Plan(eventRate float64, validations, successes int) int {
+	if eventRate <= 0 {
+		eventRate = p.cfg.ExpectedEventRate
+	}
+	population := eventRate * p.cfg.Window.Seconds()
+	if population < 1 {
+		return 1
+	}
+
+	successRate := 0.5
+	if validations >= minPlanningValidations {
+		successRate = math.Min(0.99, math.Max(0.01, float64(successes)/float64(validations)))
+	}
+
+	needed := RequiredSamples(p.cfg.TargetMargin, p.confidence, successRate, population)
+	return max(1, int(population/math.Max(needed, 1)))
+}
@@ -0,0 +1,74 @@
+package internal
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestWilsonInterval(t *testing.T) {
+	tests := []struct {
+		name          string
+		successes, n  int
+		wantLow, want float64 // Expected bounds, to 4 decimal places
+	}{
+		{name: "99 of 100", successes: 99, n: 100, wantLow: 0.9455, want: 0.9982},
+		{name: "none of 10", successes: 0, n: 10, wantLow: 0, want: 0.2775},
+		{name: "99% of 300000", successes: 297000, n: 300000, wantLow: 0.9896, want: 0.9904},
+		{name: "no validations", successes: 0, n: 0, wantLow: 0, want: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			low, high := WilsonInterval(tt.successes, tt.n, 0.95)
+			if math.Abs(low-tt.wantLow) > 1e-4 || math.Abs(high-tt.want) > 1e-4 {
+				t.Errorf("interval = %.4f-%.4f, want %.4f-%.4f", low, high, tt.wantLow, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequiredSamples(t *testing.T) {
+	if n := RequiredSamples(0.05, 0.95, 0.5, 0); math.Abs(n-384.15) > 0.01 {
+		t.Errorf("unbounded population needs %.2f samples, want 384.15", n)
+	}
+	if n := RequiredSamples(0.05, 0.95, 0.5, 1000); n >= 384 || n < 277 {
+		t.Errorf("population of 1000 needs %.2f samples, want fewer than the unbounded population", n)
+	}
+}
+
+func TestSamplePlanner(t *testing.T) {
+	planner := NewSamplePlanner(SamplePlanConfig{TargetMargin: 0.05, ExpectedEventRate: 100}, 0.95)
+
+	// 360000 events an hour, of which about 384 are needed at the worst case success rate
+	if rate := planner.Plan(0, 0, 0); rate != 938 {
+		t.Errorf("rate at the expected traffic = %d, want 938", rate)
+	}
+
+	// Twice the observed traffic halves the share of events needed
+	if rate := planner.Plan(200, 0, 0); rate < 1870 || rate > 1880 {
+		t.Errorf("rate at twice the traffic = %d, want about 1875", rate)
+	}
+
+	// A high observed success rate narrows the interval, so fewer samples are needed
+	if rate := planner.Plan(100, 1000, 990); rate <= 938 {
+		t.Errorf("rate at 99%% success = %d, want more than 938", rate)
+	}
+
+	// Too little traffic to sample, validate everything
+	if rate := NewSamplePlanner(SamplePlanConfig{TargetMargin: 0.01, Window: time.Minute}, 0.95).Plan(0.001, 0, 0); rate != 1 {
+		t.Errorf("rate without traffic = %d, want 1", rate)
+	}
+}
+
+func TestStatsSummaryAddKeepsConfidence(t *testing.T) {
+	var total StatsSummary
+	total.Add(StatsSummary{ValidationCount: 10, ValidationSuccess: 9, Confidence: 0.99})
+	total.Add(StatsSummary{ValidationCount: 10, ValidationSuccess: 10, Confidence: 0.99})
+
+	low, high := total.SuccessInterval()
+	wantLow, wantHigh := WilsonInterval(19, 20, 0.99)
+	if low != wantLow || high != wantHigh {
+		t.Errorf("aggregated interval = %.4f-%.4f, want %.4f-%.4f", low, high, wantLow, wantHigh)
+	}
+}
@@ -0,0 +1,271 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	log "github.com/sirupsen/logrus"
+)
+
+// ScanCompareConfig contains the configuration for comparing every item of the source
+// table with the target table
+type ScanCompareConfig struct {
+	SourceClient TableAPI
+	TargetClient TableAPI
+	SourceTable  string
+	TargetTable  string
+	PartitionKey string
+	SortKey      string
+
+	SampleRate int    // Compare 1 out of every SampleRate keys, the keys the monitor samples with the same salt (optional, defaults to 1)
+	SampleSalt string // Changes which keys are sampled (optional)
+
+	Segments   int     // Number of parallel scan segments (optional, defaults to 1)
+	PageSize   int32   // Items per Scan call (optional, up to 1 MB of items by default)
+	ScanBudget float64 // Maximum read capacity units per second of the source scan (optional, 0 = unlimited)
+	ReadBudget float64 // Maximum read capacity units per second of the target lookups (optional, 0 = unlimited)
+
+	ConsistentRead bool          // Use strongly consistent scans and lookups
+	Transform      *Transform    // Computes the expected target item from the source item (optional)
+	CompareRules   *CompareRules // Attribute comparison rules, nil to only check that items exist (optional)
+	FailureLog     *FailureLog   // Receives the items that are missing or differ (optional)
+
+	StatsInterval time.Duration // How often to show progress (optional, defaults to 30s)
+	Verbose       bool          // Whether to show success logs
+}
+
+// ScanCompareResult summarizes a scan comparison
+type ScanCompareResult struct {
+	Scanned    int // Items read from the source table
+	Sampled    int // Items looked up in the target table
+	Matched    int // Items found in the target table, with no unexpected differences when comparing
+	Missing    int // Items not found in the target table
+	Mismatched int // Items found with unexpected differences
+	Errors     int // Items the transform or the lookup failed on
+}
+
+// Failed returns the number of items that did not match
+func (r ScanCompareResult) Failed() int {
+	return r.Missing + r.Mismatched + r.Errors
+}
+
+// RunScanCompare scans the source table and looks up every sampled item in the target
+// table. Items that are missing, differ or could not be checked are logged and written to
+// the failure log. It stops at the first scan error.
+func RunScanCompare(ctx context.Context, cfg *ScanCompareConfig) (*ScanCompareResult, error) {
+	segments := max(cfg.Segments, 1)
+	statsInterval := cfg.StatsInterval
+	if statsInterval <= 0 {
+		statsInterval = 30 * time.Second
+	}
+
+	lookup := &batchLookup{
+		client:       cfg.TargetClient,
+		table:        cfg.TargetTable,
+		partitionKey: cfg.PartitionKey,
+		sortKey:      cfg.SortKey,
+		wholeItem:    cfg.CompareRules != nil,
+		consistent:   cfg.ConsistentRead,
+		limiter:      NewRateLimiter(cfg.ReadBudget, cfg.ReadBudget),
+	}
+	scanLimiter := NewRateLimiter(cfg.ScanBudget, cfg.ScanBudget)
+	sampler := NewKeySampler(cfg.SampleRate, cfg.SampleSalt)
+
+	if cfg.Transform != nil {
+		log.Infof("[SCAN] Computing the expected item in the target table with: %s", cfg.Transform)
+	}
+	log.Infof("[SCAN] Comparing 1 in %d keys of %s with %s in %d segments", max(sampler.Rate(), 1), cfg.SourceTable, cfg.TargetTable, segments)
+
+	var mu sync.Mutex
+	result := &ScanCompareResult{}
+
+	// Show progress until every segment is done
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(statsInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				mu.Lock()
+				log.Infof("[SCAN] Progress: %d scanned, %d sampled, %d matched, %d missing, %d mismatched, %d errors",
+					result.Scanned, result.Sampled, result.Matched, result.Missing, result.Mismatched, result.Errors)
+				mu.Unlock()
+			}
+		}
+	}()
+
+	// comparePage looks up the sampled items of one page in the target table and counts the
+	// outcomes. Keys are sampled by their source key, as the monitor samples stream records.
+	comparePage := func(items []map[string]types.AttributeValue) {
+		records := make([]ValidationRecord, 0, len(items))
+		for _, item := range items {
+			key := map[string]types.AttributeValue{cfg.PartitionKey: item[cfg.PartitionKey]}
+			if cfg.SortKey != "" {
+				key[cfg.SortKey] = item[cfg.SortKey]
+			}
+			if !sampler.Sampled(key) {
+				continue
+			}
+			records = append(records, ValidationRecord{
+				PartitionKeyValue: formatAttributeValue(item[cfg.PartitionKey]),
+				SortKeyValue:      formatAttributeValue(item[cfg.SortKey]),
+				Key:               key,
+				NewImage:          item,
+			})
+		}
+		sampled := len(records)
+
+		var transformFailed []ValidationRecord
+		var transformErrs []error
+		if cfg.Transform != nil {
+			records, transformFailed, transformErrs = transformRecords(cfg.Transform, records, cfg.PartitionKey, cfg.SortKey)
+		}
+
+		keys := make([]map[string]types.AttributeValue, len(records))
+		for i, record := range records {
+			keys[i] = record.lookupKey()
+		}
+		results, _ := lookup.Lookup(ctx, keys)
+
+		mu.Lock()
+		defer mu.Unlock()
+		result.Scanned += len(items)
+		result.Sampled += sampled
+		for i, record := range transformFailed {
+			result.Errors++
+			cfg.reportFailure(record, nil, nil, transformErrs[i])
+		}
+		for i, record := range records {
+			comparison := compareRecord(cfg.CompareRules, record, results[i].Item)
+			switch {
+			case results[i].Err != nil:
+				result.Errors++
+				cfg.reportFailure(record, nil, nil, results[i].Err)
+			case results[i].Item == nil:
+				result.Missing++
+				cfg.reportFailure(record, nil, nil, nil)
+			case len(comparison.Mismatches) > 0:
+				result.Mismatched++
+				cfg.reportFailure(record, results[i].Item, comparison.Mismatches, nil)
+			default:
+				result.Matched++
+				if cfg.Verbose {
+					log.WithField("key", AttributeMap(record.Key).String()).Info("[SCAN] SUCCESS: Item matches in target table ✅")
+				}
+			}
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	errs := make([]error, segments)
+	var wg sync.WaitGroup
+	for segment := 0; segment < segments; segment++ {
+		wg.Add(1)
+		go func(segment int) {
+			defer wg.Done()
+			if err := cfg.scanSegment(ctx, segment, segments, scanLimiter, comparePage); err != nil {
+				errs[segment] = err
+				cancel()
+			}
+		}(segment)
+	}
+	wg.Wait()
+
+	log.Infof("[SCAN] Done: %d scanned, %d sampled, %d matched, %d missing, %d mismatched, %d errors",
+		result.Scanned, result.Sampled, result.Matched, result.Missing, result.Mismatched, result.Errors)
+	return result, errors.Join(errs...)
+}
+
+// scanSegment reads one segment of the source table page by page and passes every page to
+// compare. With a limiter, every call first waits for the capacity the previous page
+// consumed.
+func (cfg *ScanCompareConfig) scanSegment(ctx context.Context, segment, segments int, limiter *RateLimiter, compare func([]map[string]types.AttributeValue)) error {
+	input := &dynamodb.ScanInput{
+		TableName:              aws.String(cfg.SourceTable),
+		ConsistentRead:         aws.Bool(cfg.ConsistentRead),
+		ReturnConsumedCapacity: types.ReturnConsumedCapacityTotal,
+	}
+	if cfg.PageSize > 0 {
+		input.Limit = aws.Int32(cfg.PageSize)
+	}
+	if segments > 1 {
+		input.Segment = aws.Int32(int32(segment))
+		input.TotalSegments = aws.Int32(int32(segments))
+	}
+
+	estimate := 1.0
+	for {
+		if err := limiter.Wait(ctx, estimate); err != nil {
+			return err
+		}
+		out, err := cfg.SourceClient.Scan(ctx, input)
+		if err != nil {
+			return fmt.Errorf("failed to scan segment %d of %s: %w", segment, cfg.SourceTable, err)
+		}
+		if out.ConsumedCapacity != nil {
+			consumed := aws.ToFloat64(out.ConsumedCapacity.CapacityUnits)
+			limiter.Adjust(estimate - consumed)
+			estimate = max(consumed, 1)
+		}
+
+		if len(out.Items) > 0 {
+			compare(out.Items)
+		}
+		if len(out.LastEvaluatedKey) == 0 {
+			return nil
+		}
+		input.ExclusiveStartKey = out.LastEvaluatedKey
+	}
+}
+
+// reportFailure logs an item that did not match and appends it to the failure log
+func (cfg *ScanCompareConfig) reportFailure(record ValidationRecord, item map[string]types.AttributeValue, mismatches []AttributeDiff, err error) {
+	failure := FailureRecord{
+		Table:        cfg.TargetTable,
+		VerifyOn:     "target",
+		Key:          record.Key,
+		StreamImage:  record.NewImage,
+		ExpectedKey:  record.ExpectedKey,
+		ExpectedItem: record.ExpectedImage,
+		VerifiedItem: item,
+		Reason:       FailureReasonNotFound,
+	}
+	fields := log.Fields{"key": AttributeMap(record.Key).String()}
+	switch {
+	case errors.Is(err, errTransformFailed):
+		failure.Error = err.Error()
+		failure.Reason = FailureReasonTransformError
+		fields["error"] = err
+		log.WithFields(fields).Warn("[SCAN] ERROR: Expected item could not be computed ❌")
+	case err != nil:
+		failure.Error = err.Error()
+		failure.Reason = FailureReasonQueryError
+		fields["error"] = err
+		log.WithFields(fields).Warn("[SCAN] ERROR: Could not look up item in target table ❌")
+	case item != nil:
+		failure.Reason = FailureReasonMismatch
+		failure.Differences = diffStrings(mismatches)
+		fields["differences"] = failure.Differences
+		log.WithFields(fields).Warn("[SCAN] MISMATCH: Item differs in target table ❌")
+	default:
+		log.WithFields(fields).Warn("[SCAN] FAILED: Item not found in target table ❌")
+	}
+
+	if cfg.FailureLog == nil {
+		return
+	}
+	if err := cfg.FailureLog.Write(failure); err != nil {
+		log.Errorf("[SCAN] Failed to write failure record for key %s: %v", AttributeMap(record.Key), err)
+	}
+}
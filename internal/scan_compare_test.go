@@ -0,0 +1,179 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// testScanCompareConfig returns a scan comparison of the fake migration's tables
+func testScanCompareConfig(m *fakeMigration) *ScanCompareConfig {
+	return &ScanCompareConfig{
+		SourceClient: m.source,
+		TargetClient: m.target,
+		SourceTable:  "orders",
+		TargetTable:  "orders",
+		PartitionKey: "pk",
+		SortKey:      "sk",
+	}
+}
+
+func TestScanCompare(t *testing.T) {
+	m := newFakeMigration()
+	for n := 1; n <= 20; n++ {
+		m.write(testItem(n))
+	}
+	m.writeUnreplicated(testItem(21))
+	m.writeUnreplicated(testItem(22))
+	m.source.put(testItem(23), 0)
+	stale := testItem(23)
+	stale["data"] = &types.AttributeValueMemberS{Value: "stale"}
+	m.target.put(stale, 0)
+
+	failureFile := filepath.Join(t.TempDir(), "failures.jsonl")
+	failureLog, err := OpenFailureLog(failureFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Pages of a few items over several segments must still cover every item once
+	cfg := testScanCompareConfig(m)
+	cfg.Segments = 3
+	cfg.PageSize = 2
+	cfg.CompareRules = &CompareRules{}
+	cfg.FailureLog = failureLog
+	result, err := RunScanCompare(context.Background(), cfg)
+	failureLog.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := ScanCompareResult{Scanned: 23, Sampled: 23, Matched: 20, Missing: 2, Mismatched: 1}
+	if *result != want {
+		t.Errorf("result = %+v, want %+v", *result, want)
+	}
+
+	records, err := ReadFailureRecords(failureFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reasons := make(map[string]int)
+	for _, rec := range records {
+		reasons[rec.Reason]++
+		if rec.Table != "orders" || rec.VerifyOn != "target" || rec.StreamImage == nil {
+			t.Errorf("record = %+v, want the target table and the source item", rec)
+		}
+	}
+	if reasons[FailureReasonNotFound] != 2 || reasons[FailureReasonMismatch] != 1 {
+		t.Errorf("failure reasons = %v, want 2 not found and 1 mismatch", reasons)
+	}
+}
+
+func TestScanCompareTransform(t *testing.T) {
+	m := newFakeMigration()
+	m.source.put(testItem(1), 0)
+	m.source.put(testItem(2), 0)
+	moved := testItem(1)
+	moved["pk"] = &types.AttributeValueMemberS{Value: "tenant42#TEST_PK_1"}
+	m.target.put(moved, 0)
+
+	transform, err := ParseTransform("pk=tenant42#${pk}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := testScanCompareConfig(m)
+	cfg.Transform = transform
+	cfg.CompareRules = &CompareRules{}
+	result, err := RunScanCompare(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Matched != 1 || result.Missing != 1 {
+		t.Errorf("result = %+v, want item 1 matched at its new key and item 2 missing", *result)
+	}
+}
+
+func TestScanCompareErrors(t *testing.T) {
+	t.Run("scan error", func(t *testing.T) {
+		m := newFakeMigration()
+		m.source.readErr = errors.New("access denied")
+		if _, err := RunScanCompare(context.Background(), testScanCompareConfig(m)); err == nil {
+			t.Error("expected the scan error to be returned")
+		}
+	})
+
+	t.Run("lookup error", func(t *testing.T) {
+		m := newFakeMigration()
+		m.write(testItem(1))
+		m.target.readErr = errors.New("throttled")
+		result, err := RunScanCompare(context.Background(), testScanCompareConfig(m))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Errors != 1 || result.Failed() != 1 {
+			t.Errorf("result = %+v, want 1 error", *result)
+		}
+	})
+}
+
+func TestScanCompareSamplesMonitorKeys(t *testing.T) {
+	const rate, salt = 5, "rehearsal-2"
+	m := newFakeMigration()
+	for n := 1; n <= 200; n++ {
+		m.write(testItem(n))
+	}
+
+	// Keys validated by the stream monitor
+	cfg := testVerificationConfig(m)
+	cfg.SampleRate = rate
+	cfg.SampleSalt = salt
+	run := startVerification(t, cfg)
+	waitFor(t, func() bool {
+		run.mu.Lock()
+		defer run.mu.Unlock()
+		return run.records == 200
+	})
+	run.stop(t)
+	monitored := make(map[string]bool)
+	for _, result := range run.results {
+		monitored[AttributeMap(result.Record.Key).String()] = true
+	}
+
+	// Keys compared by the scan, every one of them missing from an empty target table
+	failureFile := filepath.Join(t.TempDir(), "failures.jsonl")
+	failureLog, err := OpenFailureLog(failureFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scan := testScanCompareConfig(m)
+	scan.TargetClient = newFakeTable("orders", "pk", "sk")
+	scan.SampleRate = rate
+	scan.SampleSalt = salt
+	scan.Segments = 2
+	scan.FailureLog = failureLog
+	result, err := RunScanCompare(context.Background(), scan)
+	failureLog.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := ReadFailureRecords(failureFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scanned := make(map[string]bool)
+	for _, rec := range records {
+		scanned[rec.Key.String()] = true
+	}
+
+	if len(monitored) == 0 || len(monitored) == 200 {
+		t.Fatalf("monitor sampled %d of 200 keys, want a fraction", len(monitored))
+	}
+	if result.Sampled != len(monitored) || !reflect.DeepEqual(scanned, monitored) {
+		t.Errorf("scan compared %d keys, monitor validated %d, want the same keys", result.Sampled, len(monitored))
+	}
+}
@@ -0,0 +1,171 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	log "github.com/sirupsen/logrus"
+)
+
+// CloneConfig contains the configuration for creating a target table that mirrors a source table
+type CloneConfig struct {
+	SourceClient TableAdminAPI
+	TargetClient TableAdminAPI
+	SourceTable  string
+	TargetTable  string // Optional, defaults to SourceTable
+
+	// Capacity overrides (optional), applied to the table and every GSI
+	BillingMode   string // PAY_PER_REQUEST or PROVISIONED, defaults to the source billing mode
+	ReadCapacity  int64  // Read capacity units of a provisioned table, defaults to the source capacity
+	WriteCapacity int64  // Write capacity units of a provisioned table, defaults to the source capacity
+
+	WaitTimeout time.Duration // How long to wait for the table to become ACTIVE (optional, defaults to 10m)
+}
+
+// CloneTableSchema creates the target table with the key schema, indexes, capacity, stream
+// and TTL settings of the source table, and waits until it is ACTIVE. Items are not copied.
+func CloneTableSchema(ctx context.Context, cfg CloneConfig) error {
+	// Set default target table and wait timeout if not provided
+	if cfg.TargetTable == "" {
+		cfg.TargetTable = cfg.SourceTable
+	}
+	if cfg.WaitTimeout <= 0 {
+		cfg.WaitTimeout = 10 * time.Minute
+	}
+
+	out, err := cfg.SourceClient.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(cfg.SourceTable)})
+	if err != nil {
+		return fmt.Errorf("failed to describe source table %s: %w", cfg.SourceTable, err)
+	}
+
+	input, err := buildCreateTableInput(out.Table, cfg)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("[CLONE] Creating table %s from %s (%s, key schema %s)",
+		cfg.TargetTable, cfg.SourceTable, input.BillingMode, formatKeySchema(out.Table))
+	if _, err := cfg.TargetClient.CreateTable(ctx, input); err != nil {
+		return fmt.Errorf("failed to create target table %s: %w", cfg.TargetTable, err)
+	}
+
+	// Wait until the table and its indexes can be used
+	log.Infof("[CLONE] Waiting up to %s for table %s to become ACTIVE", cfg.WaitTimeout, cfg.TargetTable)
+	waiter := dynamodb.NewTableExistsWaiter(cfg.TargetClient)
+	if err := waiter.Wait(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(cfg.TargetTable)}, cfg.WaitTimeout); err != nil {
+		return fmt.Errorf("table %s did not become ACTIVE: %w", cfg.TargetTable, err)
+	}
+
+	// TTL can only be enabled once the table exists
+	if err := cloneTimeToLive(ctx, cfg); err != nil {
+		return err
+	}
+
+	log.Infof("✅ [CLONE] Table %s is ACTIVE", cfg.TargetTable)
+	return nil
+}
+
+// buildCreateTableInput turns a source table description into a CreateTable request
+func buildCreateTableInput(desc *types.TableDescription, cfg CloneConfig) (*dynamodb.CreateTableInput, error) {
+	billingMode := types.BillingModeProvisioned
+	if desc.BillingModeSummary != nil && desc.BillingModeSummary.BillingMode != "" {
+		billingMode = desc.BillingModeSummary.BillingMode
+	}
+	sourceBillingMode := billingMode
+	if cfg.BillingMode != "" {
+		billingMode = types.BillingMode(cfg.BillingMode)
+	}
+	if billingMode != types.BillingModeProvisioned && billingMode != types.BillingModePayPerRequest {
+		return nil, fmt.Errorf("billing mode must be either %s or %s", types.BillingModeProvisioned, types.BillingModePayPerRequest)
+	}
+
+	// An on-demand source has no capacity to copy
+	if billingMode == types.BillingModeProvisioned && sourceBillingMode != billingMode &&
+		(cfg.ReadCapacity <= 0 || cfg.WriteCapacity <= 0) {
+		return nil, errors.New("read and write capacity are required when switching to PROVISIONED")
+	}
+
+	throughput := func(source *types.ProvisionedThroughputDescription) *types.ProvisionedThroughput {
+		if billingMode != types.BillingModeProvisioned {
+			return nil
+		}
+		t := &types.ProvisionedThroughput{ReadCapacityUnits: aws.Int64(cfg.ReadCapacity), WriteCapacityUnits: aws.Int64(cfg.WriteCapacity)}
+		if source != nil && cfg.ReadCapacity <= 0 {
+			t.ReadCapacityUnits = source.ReadCapacityUnits
+		}
+		if source != nil && cfg.WriteCapacity <= 0 {
+			t.WriteCapacityUnits = source.WriteCapacityUnits
+		}
+		return t
+	}
+
+	input := &dynamodb.CreateTableInput{
+		TableName:             aws.String(cfg.TargetTable),
+		AttributeDefinitions:  desc.AttributeDefinitions,
+		KeySchema:             desc.KeySchema,
+		BillingMode:           billingMode,
+		ProvisionedThroughput: throughput(desc.ProvisionedThroughput),
+	}
+
+	for _, gsi := range desc.GlobalSecondaryIndexes {
+		input.GlobalSecondaryIndexes = append(input.GlobalSecondaryIndexes, types.GlobalSecondaryIndex{
+			IndexName:             gsi.IndexName,
+			KeySchema:             gsi.KeySchema,
+			Projection:            gsi.Projection,
+			ProvisionedThroughput: throughput(gsi.ProvisionedThroughput),
+		})
+	}
+
+	for _, lsi := range desc.LocalSecondaryIndexes {
+		input.LocalSecondaryIndexes = append(input.LocalSecondaryIndexes, types.LocalSecondaryIndex{
+			IndexName:  lsi.IndexName,
+			KeySchema:  lsi.KeySchema,
+			Projection: lsi.Projection,
+		})
+	}
+
+	if desc.StreamSpecification != nil && aws.ToBool(desc.StreamSpecification.StreamEnabled) {
+		input.StreamSpecification = &types.StreamSpecification{
+			StreamEnabled:  aws.Bool(true),
+			StreamViewType: desc.StreamSpecification.StreamViewType,
+		}
+	}
+
+	if desc.TableClassSummary != nil && desc.TableClassSummary.TableClass != "" {
+		input.TableClass = desc.TableClassSummary.TableClass
+	}
+
+	return input, nil
+}
+
+// cloneTimeToLive enables TTL on the target table if it is enabled on the source table
+func cloneTimeToLive(ctx context.Context, cfg CloneConfig) error {
+	out, err := cfg.SourceClient.DescribeTimeToLive(ctx, &dynamodb.DescribeTimeToLiveInput{TableName: aws.String(cfg.SourceTable)})
+	if err != nil {
+		return fmt.Errorf("failed to describe TTL of source table %s: %w", cfg.SourceTable, err)
+	}
+
+	ttl := out.TimeToLiveDescription
+	if ttl == nil || (ttl.TimeToLiveStatus != types.TimeToLiveStatusEnabled && ttl.TimeToLiveStatus != types.TimeToLiveStatusEnabling) {
+		return nil
+	}
+
+	_, err = cfg.TargetClient.UpdateTimeToLive(ctx, &dynamodb.UpdateTimeToLiveInput{
+		TableName: aws.String(cfg.TargetTable),
+		TimeToLiveSpecification: &types.TimeToLiveSpecification{
+			AttributeName: ttl.AttributeName,
+			Enabled:       aws.Bool(true),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to enable TTL on target table %s: %w", cfg.TargetTable, err)
+	}
+
+	log.Infof("[CLONE] Enabled TTL on attribute %s", aws.ToString(ttl.AttributeName))
+	return nil
+}
@@ -0,0 +1,173 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// provisioned makes a settings table provisioned, with rcu/wcu on the table and half of
+// it on the index
+func provisioned(rcu, wcu int64) func(t *fakeTable) {
+	return func(t *fakeTable) {
+		t.definition.BillingModeSummary = &types.BillingModeSummary{BillingMode: types.BillingModeProvisioned}
+		t.definition.ProvisionedThroughput = &types.ProvisionedThroughputDescription{
+			ReadCapacityUnits:  aws.Int64(rcu),
+			WriteCapacityUnits: aws.Int64(wcu),
+		}
+		t.definition.GlobalSecondaryIndexes[0].ProvisionedThroughput = &types.ProvisionedThroughputDescription{
+			ReadCapacityUnits:  aws.Int64(rcu / 2),
+			WriteCapacityUnits: aws.Int64(wcu / 2),
+		}
+	}
+}
+
+// formatCapacity describes the capacity of a CreateTable request, "none" if it has none
+func formatCapacity(t *types.ProvisionedThroughput) string {
+	if t == nil {
+		return "none"
+	}
+	return fmt.Sprintf("RCU=%d WCU=%d", aws.ToInt64(t.ReadCapacityUnits), aws.ToInt64(t.WriteCapacityUnits))
+}
+
+func TestBuildCreateTableInput(t *testing.T) {
+	tests := []struct {
+		name        string
+		source      func(t *fakeTable)
+		cfg         CloneConfig
+		wantBilling types.BillingMode
+		wantTable   string // Capacity of the table
+		wantIndex   string // Capacity of the GSI
+		wantErr     bool
+	}{
+		{
+			name:        "provisioned source",
+			source:      provisioned(10, 4),
+			wantBilling: types.BillingModeProvisioned,
+			wantTable:   "RCU=10 WCU=4",
+			wantIndex:   "RCU=5 WCU=2",
+		},
+		{
+			name:        "capacity override",
+			source:      provisioned(10, 4),
+			cfg:         CloneConfig{ReadCapacity: 50, WriteCapacity: 20},
+			wantBilling: types.BillingModeProvisioned,
+			wantTable:   "RCU=50 WCU=20",
+			wantIndex:   "RCU=50 WCU=20",
+		},
+		{
+			name:        "read capacity override only",
+			source:      provisioned(10, 4),
+			cfg:         CloneConfig{ReadCapacity: 50},
+			wantBilling: types.BillingModeProvisioned,
+			wantTable:   "RCU=50 WCU=4",
+			wantIndex:   "RCU=50 WCU=2",
+		},
+		{
+			name:        "on-demand source",
+			wantBilling: types.BillingModePayPerRequest,
+			wantTable:   "none",
+			wantIndex:   "none",
+		},
+		{
+			name:        "provisioned source to on-demand",
+			source:      provisioned(10, 4),
+			cfg:         CloneConfig{BillingMode: "PAY_PER_REQUEST"},
+			wantBilling: types.BillingModePayPerRequest,
+			wantTable:   "none",
+			wantIndex:   "none",
+		},
+		{
+			name:        "on-demand source to provisioned",
+			cfg:         CloneConfig{BillingMode: "PROVISIONED", ReadCapacity: 8, WriteCapacity: 6},
+			wantBilling: types.BillingModeProvisioned,
+			wantTable:   "RCU=8 WCU=6",
+			wantIndex:   "RCU=8 WCU=6",
+		},
+		{
+			name:    "on-demand source to provisioned without capacity",
+			cfg:     CloneConfig{BillingMode: "PROVISIONED", ReadCapacity: 8},
+			wantErr: true,
+		},
+		{
+			name:    "unknown billing mode",
+			cfg:     CloneConfig{BillingMode: "ON_DEMAND"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := newSettingsTable("orders", tt.source)
+			tt.cfg.TargetTable = "orders_v2"
+
+			input, err := buildCreateTableInput(source.definition, tt.cfg)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if aws.ToString(input.TableName) != "orders_v2" || input.BillingMode != tt.wantBilling {
+				t.Errorf("table %s with %s, want orders_v2 with %s", aws.ToString(input.TableName), input.BillingMode, tt.wantBilling)
+			}
+			if got := formatCapacity(input.ProvisionedThroughput); got != tt.wantTable {
+				t.Errorf("table capacity = %s, want %s", got, tt.wantTable)
+			}
+			if len(input.GlobalSecondaryIndexes) != 1 {
+				t.Fatalf("got %d GSIs, want 1", len(input.GlobalSecondaryIndexes))
+			}
+			gsi := input.GlobalSecondaryIndexes[0]
+			if got := formatCapacity(gsi.ProvisionedThroughput); got != tt.wantIndex {
+				t.Errorf("GSI capacity = %s, want %s", got, tt.wantIndex)
+			}
+			if formatProjection(gsi.Projection) != "INCLUDE [name, status]" || len(input.AttributeDefinitions) != 3 {
+				t.Errorf("GSI projection %s and %d attribute definitions, want the source ones", formatProjection(gsi.Projection), len(input.AttributeDefinitions))
+			}
+			if input.StreamSpecification == nil || input.StreamSpecification.StreamViewType != types.StreamViewTypeNewAndOldImages {
+				t.Errorf("stream = %+v, want NEW_AND_OLD_IMAGES", input.StreamSpecification)
+			}
+		})
+	}
+}
+
+func TestCloneTableSchema(t *testing.T) {
+	for _, ttl := range []bool{true, false} {
+		t.Run(fmt.Sprintf("ttl %t", ttl), func(t *testing.T) {
+			source := newSettingsTable("orders", func(t *fakeTable) {
+				if !ttl {
+					t.ttl = nil
+				}
+			})
+			target := newFakeTable("orders_v2", "pk", "sk")
+
+			err := CloneTableSchema(context.Background(), CloneConfig{
+				SourceClient: source,
+				TargetClient: target,
+				SourceTable:  "orders",
+				TargetTable:  "orders_v2",
+				WaitTimeout:  time.Second,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if target.created == nil || aws.ToString(target.created.TableName) != "orders_v2" {
+				t.Fatalf("created %+v, want table orders_v2", target.created)
+			}
+			switch {
+			case ttl && (target.ttl == nil || aws.ToString(target.ttl.AttributeName) != "expiresAt"):
+				t.Errorf("target TTL = %+v, want enabled on expiresAt", target.ttl)
+			case !ttl && target.ttl != nil:
+				t.Errorf("target TTL = %+v, want none", target.ttl)
+			}
+		})
+	}
+}
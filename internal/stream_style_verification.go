@@ -2,36 +2,95 @@ package internal
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"os"
-	"os/signal"
-	"syscall"
+	"math"
+	"math/big"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
-	"github.com/aws/aws-sdk-go-v2/service/dynamodbstreams"
 	streamtypes "github.com/aws/aws-sdk-go-v2/service/dynamodbstreams/types"
 	log "github.com/sirupsen/logrus"
 )
 
+// validationDrainTimeout is how long validations may take to finish on shutdown, on top of
+// the replication and retry waits
+const validationDrainTimeout = 30 * time.Second
+
 // StreamVerificationConfig contains all the configuration needed for stream verification
 type StreamVerificationConfig struct {
-	SourceClient *dynamodb.Client
-	TargetClient *dynamodb.Client
-	StreamClient *dynamodbstreams.Client
+	Name         string // Label added to every log line, used when monitoring several tables (optional)
+	SourceClient TableAPI
+	TargetClient TableAPI
+	StreamClient StreamAPI
 	StreamArn    string
+	SourceTable  string // Source table name (optional, defaults to TargetTable)
 	TargetTable  string
-	SampleRate   int    // Validate 1 out of every SampleRate records
-	PartitionKey string // Name of the partition key
-	SortKey      string // Name of the sort key (optional)
-	IteratorType string // DynamoDB Stream Iterator Type
-	VerifyOn     string // Which table to verify against: source or target
-	Verbose      bool   // Whether to show success validation logs
+	SampleRate   int     // Validate every event of 1 out of every SampleRate keys
+	SampleSalt   string  // Changes which keys are sampled (optional)
+	Confidence   float64 // Confidence level of the reported success interval (optional, defaults to 0.95)
+	PartitionKey string  // Name of the partition key
+	SortKey      string  // Name of the sort key (optional)
+	IteratorType string  // DynamoDB Stream Iterator Type
+	VerifyOn     string  // Which table to verify against: source or target
+	Verbose      bool    // Whether to show success validation logs
+
+	// SamplePlan chooses the sample rate from the observed traffic, starting from SampleRate
+	// unless an expected event rate is set (optional)
+	SamplePlan *SamplePlanConfig
+
+	// AdaptiveSampling raises the sample rate while validations fail, and validates every
+	// event of the keys that failed (optional)
+	AdaptiveSampling *AdaptiveSamplingConfig
+
+	// EventFilter selects the records that are counted and validated by key, event type
+	// and creation time (optional)
+	EventFilter *EventFilter
+
+	// LookupAttributes are read from the verified table besides the primary key. Without
+	// them only the key is read, which is enough to check that the item exists (optional)
+	LookupAttributes []string
+
+	// ConsistentRead makes every lookup strongly consistent. ConsistentRecheck instead keeps
+	// eventually consistent lookups and reads the items still missing after retry again with
+	// a strongly consistent read before counting them as failed (optional)
+	ConsistentRead    bool
+	ConsistentRecheck bool
+
+	// Transform computes the item expected in the verified table, and so the key looked up,
+	// from the stream image of every sampled record (optional, the stream image as is)
+	Transform *Transform
+
+	// CompareRules compares the attributes of the verified item with the stream image, not
+	// only its existence. Differences the rules cover are reported as expected differences
+	// instead of failures (optional, existence only without rules)
+	CompareRules *CompareRules
+
+	// ReadBudget is the maximum read capacity units per second of validation lookups. When
+	// sampled keys need more, lookups wait and fewer keys are sampled (optional, 0 for no limit)
+	ReadBudget float64
+
+	// FailureLog receives every validation that still fails after retry (optional)
+	FailureLog *FailureLog
+
+	// Repairer copies the source item to the target when a validation still fails after retry (optional)
+	Repairer *Repairer
+
+	// Observer callbacks (optional). OnRecord is called for every stream record received,
+	// OnValidation for every sampled record once it has been validated, from the validation
+	// goroutines, and OnStats with a snapshot of the statistics every time they are printed.
+	OnRecord     func(*streamtypes.Record)
+	OnValidation func(ValidationResult)
+	OnStats      func(StatsSummary)
 
 	// Performance tuning parameters
 	ValidationConfig ValidationConfig
+
+	// StreamPolling controls how often the stream is read (optional, zero fields use the defaults)
+	StreamPolling StreamPolling
 }
 
 // ValidationConfig contains all the configuration for validation process
@@ -62,10 +121,31 @@ func DefaultValidationConfig() ValidationConfig {
 type ValidationRecord struct {
 	PartitionKeyValue string
 	SortKeyValue      string
+	Key               map[string]types.AttributeValue // Typed primary key
+	EventID           string
+	EventName         string
+	SequenceNumber    string
+	NewImage          map[string]types.AttributeValue // Stream image of the item (if available)
+	ExpectedKey       map[string]types.AttributeValue // Key looked up in the verified table, computed by the transform (nil for Key)
+	ExpectedImage     map[string]types.AttributeValue // Item expected in the verified table, computed by the transform (nil for NewImage)
+	CoalescedEvents   int                             // Earlier buffered events of the key this record replaced
+}
+
+// ValidationResult is the outcome of validating one sampled record
+type ValidationResult struct {
+	Table         string // Label of the table (Name), empty when monitoring a single table
+	Record        ValidationRecord
+	Success       bool                            // Whether the item exists in the verified table, with no unexpected differences when comparing
+	Item          map[string]types.AttributeValue // Primary key and LookupAttributes of the item found in the verified table, or the whole item when comparing (nil if missing)
+	Mismatches    []AttributeDiff                 // Differences from the stream image no compare rule expects
+	Expected      []AttributeDiff                 // Differences from the stream image covered by a compare rule
+	Err           error                           // Query error of the last attempt, if any
+	RepairOutcome RepairOutcome                   // What the repairer did with a failed record (empty without repair)
 }
 
 // Stats tracks stream processing statistics
 type Stats struct {
+	mu                sync.Mutex
 	InsertCount       int
 	ModifyCount       int
 	TotalCount        int
@@ -74,26 +154,236 @@ type Stats struct {
 	ValidationCount   int                 // Number of records validated
 	ValidationSuccess int                 // Records successfully validated
 	ValidationFailed  int                 // Records that failed validation
+	Coalesced         int                 // Buffered records replaced by a later record of the same key
+	ReadCapacity      float64             // Read capacity units consumed by validation lookups
+	BudgetWait        time.Duration       // Time validation lookups waited for the read budget
+	Rechecked         int                 // Missing or mismatched items read again with a strongly consistent read
+	RecheckResolved   int                 // Rechecked items the strongly consistent read found
+	Mismatched        int                 // Items found with differences no compare rule expects
+	ExpectedDiffs     int                 // Items found with only differences covered by a compare rule
+	TransformErrors   int                 // Records the transform could not compute the expected item of
+	RepairAttempted   int                 // Failed records handed to the repairer
+	RepairWritten     int                 // Records copied from source to target (or would be, in dry run)
+	StreamErrors      int                 // Errors reported by the stream subscriber
+	FilteredKey       int                 // Records dropped by the key filters
+	FilteredEventType int                 // Records dropped by the event type filter
+	FilteredTime      int                 // Records dropped by the time window
+}
+
+// StatsSummary is a point-in-time copy of the counters in Stats
+type StatsSummary struct {
+	Table             string
+	Duration          time.Duration
+	TotalCount        int
+	UniqueEvents      int
+	InsertCount       int
+	ModifyCount       int
+	ValidationCount   int
+	ValidationSuccess int
+	ValidationFailed  int
+	Coalesced         int
+	ReadCapacity      float64
+	ReadBudget        float64       // Read capacity units per second allowed, 0 without a budget
+	BudgetWait        time.Duration // Time validation lookups waited for the read budget
+	Rechecked         int
+	RecheckResolved   int
+	Mismatched        int
+	ExpectedDiffs     int
+	TransformErrors   int
+	RepairAttempted   int
+	RepairWritten     int
+	StreamErrors      int
+	FilteredKey       int
+	FilteredEventType int
+	FilteredTime      int
+	SampleRate        int     // Current sample rate, 0 in aggregated statistics
+	SampleRateReason  string  // Why the sample rate last changed, empty if it never did
+	UnlimitedRate     int     // Sample rate without the read budget, 0 unless the budget lowers sampling
+	FocusedKeys       int     // Keys or key prefixes whose every event is validated
+	Confidence        float64 // Confidence level of SuccessInterval
+	StopErr           error   // Why the verification stopped before ctx was canceled, nil otherwise
+}
+
+// Summary returns a snapshot of the current statistics
+func (s *Stats) Summary() StatsSummary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return StatsSummary{
+		Duration:          time.Since(s.StartTime),
+		TotalCount:        s.TotalCount,
+		UniqueEvents:      len(s.EventIDs),
+		InsertCount:       s.InsertCount,
+		ModifyCount:       s.ModifyCount,
+		ValidationCount:   s.ValidationCount,
+		ValidationSuccess: s.ValidationSuccess,
+		ValidationFailed:  s.ValidationFailed,
+		Coalesced:         s.Coalesced,
+		ReadCapacity:      s.ReadCapacity,
+		BudgetWait:        s.BudgetWait,
+		Rechecked:         s.Rechecked,
+		RecheckResolved:   s.RecheckResolved,
+		Mismatched:        s.Mismatched,
+		ExpectedDiffs:     s.ExpectedDiffs,
+		TransformErrors:   s.TransformErrors,
+		RepairAttempted:   s.RepairAttempted,
+		RepairWritten:     s.RepairWritten,
+		StreamErrors:      s.StreamErrors,
+		FilteredKey:       s.FilteredKey,
+		FilteredEventType: s.FilteredEventType,
+		FilteredTime:      s.FilteredTime,
+	}
+}
+
+// SuccessInterval returns the Wilson interval of the success rate at the confidence level
+// of the summary, 0.95 if it is not set
+func (s StatsSummary) SuccessInterval() (low, high float64) {
+	confidence := s.Confidence
+	if confidence == 0 {
+		confidence = defaultConfidence
+	}
+	return WilsonInterval(s.ValidationSuccess, s.ValidationCount, confidence)
+}
+
+// Add accumulates the counters of other into s, keeping the longest duration
+func (s *StatsSummary) Add(other StatsSummary) {
+	if other.Duration > s.Duration {
+		s.Duration = other.Duration
+	}
+	s.TotalCount += other.TotalCount
+	s.UniqueEvents += other.UniqueEvents
+	s.InsertCount += other.InsertCount
+	s.ModifyCount += other.ModifyCount
+	s.ValidationCount += other.ValidationCount
+	s.ValidationSuccess += other.ValidationSuccess
+	s.ValidationFailed += other.ValidationFailed
+	s.Coalesced += other.Coalesced
+	s.ReadCapacity += other.ReadCapacity
+	s.ReadBudget += other.ReadBudget
+	s.BudgetWait += other.BudgetWait
+	s.Rechecked += other.Rechecked
+	s.RecheckResolved += other.RecheckResolved
+	s.Mismatched += other.Mismatched
+	s.ExpectedDiffs += other.ExpectedDiffs
+	s.TransformErrors += other.TransformErrors
+	s.RepairAttempted += other.RepairAttempted
+	s.RepairWritten += other.RepairWritten
+	s.StreamErrors += other.StreamErrors
+	s.FilteredKey += other.FilteredKey
+	s.FilteredEventType += other.FilteredEventType
+	s.FilteredTime += other.FilteredTime
+	s.FocusedKeys += other.FocusedKeys
+	if s.Confidence == 0 {
+		s.Confidence = other.Confidence
+	}
 }
 
-// RunStreamStyleVerification sets up and runs the stream-based verification process
-func RunStreamStyleVerification(ctx context.Context, cfg *StreamVerificationConfig) {
+// logStatsSummary prints a statistics block under the given title
+func logStatsSummary(logger *log.Entry, title string, s StatsSummary) {
+	logger.Infof("========= %s (Total %s) =========", title, s.Duration.Round(time.Second))
+	logger.Infof("Total events: %d (Unique: %d)", s.TotalCount, s.UniqueEvents)
+	logger.Infof("INSERT: %d, MODIFY: %d", s.InsertCount, s.ModifyCount)
+	logger.Infof("Average: %.2f events/sec", float64(s.TotalCount)/s.Duration.Seconds())
+
+	if filtered := s.FilteredKey + s.FilteredEventType + s.FilteredTime; filtered > 0 {
+		logger.Infof("Filtered out: %d (key: %d, event type: %d, time: %d)",
+			filtered, s.FilteredKey, s.FilteredEventType, s.FilteredTime)
+	}
+
+	// Add validation statistics
+	if s.ValidationCount > 0 {
+		successRate := float64(s.ValidationSuccess) / float64(s.ValidationCount) * 100
+		low, high := s.SuccessInterval()
+		confidence := s.Confidence
+		if confidence == 0 {
+			confidence = defaultConfidence
+		}
+		logger.Infof("Validation: %d sampled, %d success (%.1f%%, %g%% CI %.2f%%-%.2f%%), %d failed",
+			s.ValidationCount, s.ValidationSuccess, successRate, math.Round(confidence*1000)/10, low*100, high*100, s.ValidationFailed)
+	}
+
+	if s.ReadCapacity > 0 && s.ValidationCount > 0 {
+		logger.Infof("Read cost: %.1f RCU (%.2f RCU per validation)", s.ReadCapacity, s.ReadCapacity/float64(s.ValidationCount))
+	}
+
+	if s.Rechecked > 0 {
+		logger.Infof("Consistent re-reads: %d missing items read again, %d found", s.Rechecked, s.RecheckResolved)
+	}
+
+	if s.Mismatched > 0 || s.ExpectedDiffs > 0 {
+		logger.Infof("Attribute comparison: %d items with unexpected differences, %d with only expected differences", s.Mismatched, s.ExpectedDiffs)
+	}
+
+	if s.TransformErrors > 0 {
+		logger.Warnf("Transform errors: %d records failed without a lookup", s.TransformErrors)
+	}
+
+	if s.ReadBudget > 0 {
+		logger.Infof("Read budget: %g RCU/sec, lookups waited %s for it", s.ReadBudget, s.BudgetWait.Round(time.Millisecond))
+	}
+
+	if s.Coalesced > 0 {
+		logger.Infof("Coalesced: %d events replaced by a later event of the same key before validation", s.Coalesced)
+	}
+
+	if s.SampleRate > 0 && s.SampleRateReason != "" {
+		logger.Infof("Sample rate: 1 in %d keys (%s)", s.SampleRate, s.SampleRateReason)
+	} else if s.SampleRate > 0 {
+		logger.Infof("Sample rate: 1 in %d keys", s.SampleRate)
+	}
+	if s.UnlimitedRate > 0 {
+		logger.Warnf("Sampling degraded by the read budget: 1 in %d keys instead of 1 in %d", s.SampleRate, s.UnlimitedRate)
+	}
+	if s.FocusedKeys > 0 {
+		logger.Warnf("Focused keys: %d, every event validated after a failure", s.FocusedKeys)
+	}
+
+	if s.RepairAttempted > 0 {
+		logger.Infof("Repair: %d attempted, %d copied from source to target", s.RepairAttempted, s.RepairWritten)
+	}
+
+	if s.StreamErrors > 0 {
+		logger.Warnf("Stream errors: %d", s.StreamErrors)
+	}
+
+	logger.Infof("========================================")
+}
+
+// RunStreamStyleVerification sets up and runs the stream-based verification process until
+// ctx is canceled, and returns the final statistics. It returns early with StopErr set when
+// the stream can no longer be read or a validation goroutine panics.
+func RunStreamStyleVerification(ctx context.Context, cfg *StreamVerificationConfig) StatsSummary {
 	// Set default sample rate if not provided
 	if cfg.SampleRate <= 0 {
 		cfg.SampleRate = 100 // Default: validate 1 out of every 100 records
 	}
 
+	// Set default confidence level if not provided
+	if cfg.Confidence <= 0 || cfg.Confidence >= 1 {
+		cfg.Confidence = defaultConfidence
+	}
+
 	// Set default validation config if not provided
 	if cfg.ValidationConfig.BufferSize == 0 {
 		cfg.ValidationConfig = DefaultValidationConfig()
 	}
 
+	// Source and target tables share the same name unless told otherwise
+	if cfg.SourceTable == "" {
+		cfg.SourceTable = cfg.TargetTable
+	}
+
+	// Tag every log line with the table label when one is set
+	logger := log.NewEntry(log.StandardLogger())
+	if cfg.Name != "" {
+		logger = logger.WithField("table", cfg.Name)
+	}
+
 	// Select client based on VerifyOn setting
 	verifiedClient := cfg.TargetClient // Default to target client
 	verifiedTable := cfg.TargetTable
 	if cfg.VerifyOn == "source" {
 		verifiedClient = cfg.SourceClient
-		// We still use the target table name since it's the same structure in both accounts
+		verifiedTable = cfg.SourceTable
 	}
 
 	// Using StreamSubscriberV2WithArn to directly listen to DynamoDB Stream
@@ -106,14 +396,14 @@ func RunStreamStyleVerification(ctx context.Context, cfg *StreamVerificationConf
 		subscriber.SetShardIteratorType(streamtypes.ShardIteratorTypeLatest)
 	}
 
-	// Set batch size
+	// Set batch size and polling intervals
 	subscriber.SetLimit(cfg.ValidationConfig.BatchSize)
+	subscriber.SetPolling(cfg.StreamPolling)
 
-	recCh, errCh := subscriber.GetStreamDataAsync()
-
-	// Listen for OS interrupt to gracefully shut down on Ctrl+C
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	// Stop reading the stream when the verification returns
+	streamCtx, stopStream := context.WithCancel(ctx)
+	defer stopStream()
+	recCh, errCh := subscriber.GetStreamDataAsync(streamCtx)
 
 	// Counters and statistics
 	stats := &Stats{
@@ -121,103 +411,306 @@ func RunStreamStyleVerification(ctx context.Context, cfg *StreamVerificationConf
 		EventIDs:  make(map[string]struct{}),
 	}
 
+	// Sample by key, so every event of a sampled key is validated. The planner starts from
+	// the expected event rate when one is set, and follows the observed one afterwards.
+	// Adaptive sampling raises the planned rate while validations fail.
+	sampleRate := cfg.SampleRate
+	var planner *SamplePlanner
+	if cfg.SamplePlan != nil {
+		planner = NewSamplePlanner(*cfg.SamplePlan, cfg.Confidence)
+		if cfg.SamplePlan.ExpectedEventRate > 0 {
+			sampleRate = planner.Plan(0, 0, 0)
+		}
+		logger.Infof("[PLAN] Planning the sample rate for ±%g%% at %g%% confidence, starting at 1 in %d keys",
+			cfg.SamplePlan.TargetMargin*100, math.Round(cfg.Confidence*1000)/10, sampleRate)
+	}
+	sampler := newAdaptiveSampler(cfg.AdaptiveSampling, sampleRate, cfg.SampleSalt, logger)
+
+	if cfg.EventFilter != nil {
+		logger.Infof("[FILTER] Only counting and validating records where %s", cfg.EventFilter.Describe())
+	}
+
 	// Timer to display statistics
 	ticker := time.NewTicker(cfg.ValidationConfig.StatsInterval)
 	defer ticker.Stop()
 
-	// Buffer for validation records
+	// Buffer for validation records, holding one record per key. A later event of a buffered
+	// key replaces its record, so only the latest stream image is validated.
 	validationBuffer := make([]ValidationRecord, 0, cfg.ValidationConfig.BufferSize)
+	bufferIndex := make(map[string]int, cfg.ValidationConfig.BufferSize)
 	validationTicker := time.NewTicker(cfg.ValidationConfig.ValidationInterval)
 	defer validationTicker.Stop()
 
 	// Channel for validation records
 	validationCh := make(chan []ValidationRecord, cfg.ValidationConfig.ChannelSize)
 
-	// Function to verify data in table
-	verifyInTable := func(ctx context.Context, partitionKeyValue, sortKeyValue string) bool {
-		// Create GetItem input for table
-		keys := map[string]types.AttributeValue{
-			cfg.PartitionKey: &types.AttributeValueMemberS{Value: partitionKeyValue},
+	// Select the table lookups are made against, based on VerifyOn setting
+	lookup := &batchLookup{
+		client:       cfg.SourceClient,
+		table:        cfg.SourceTable,
+		partitionKey: cfg.PartitionKey,
+		sortKey:      cfg.SortKey,
+		attributes:   cfg.LookupAttributes,
+		wholeItem:    cfg.CompareRules != nil,
+		consistent:   cfg.ConsistentRead,
+		limiter:      NewRateLimiter(cfg.ReadBudget, cfg.ReadBudget),
+	}
+	tableType := "source"
+	if cfg.VerifyOn == "target" {
+		lookup.client = cfg.TargetClient
+		lookup.table = cfg.TargetTable
+		tableType = "target"
+	}
+
+	// Strongly consistent lookups of the items still missing after retry, sharing the budget
+	var recheckLookup *batchLookup
+	if cfg.ConsistentRecheck && !cfg.ConsistentRead {
+		recheckLookup = &batchLookup{
+			client:       lookup.client,
+			table:        lookup.table,
+			partitionKey: lookup.partitionKey,
+			sortKey:      lookup.sortKey,
+			attributes:   lookup.attributes,
+			wholeItem:    lookup.wholeItem,
+			consistent:   true,
+			limiter:      lookup.limiter,
 		}
+	}
+
+	if cfg.Transform != nil {
+		logger.Infof("[TRANSFORM] Computing the expected item in the %s table with: %s", tableType, cfg.Transform)
+	}
+	if cfg.CompareRules != nil {
+		logger.Infof("[COMPARE] Comparing the attributes of every item found with its stream image")
+	}
 
-		// Add sort key if configured
-		if cfg.SortKey != "" && sortKeyValue != "" {
-			keys[cfg.SortKey] = &types.AttributeValueMemberS{Value: sortKeyValue}
+	// Function to verify data in table. Returns the item found (nil if missing) and any
+	// query error of every record, in the order of records.
+	verifyInTable := func(ctx context.Context, lookup *batchLookup, records []ValidationRecord) []lookupResult {
+		keys := make([]map[string]types.AttributeValue, len(records))
+		for i, record := range records {
+			keys[i] = record.lookupKey()
 		}
 
-		// Select client and table based on VerifyOn setting
-		client := cfg.SourceClient
-		tableType := "source"
-		tableName := cfg.TargetTable // We use target table name for both directions as it should have same structure
+		// Query the table
+		results, cost := lookup.Lookup(ctx, keys)
+		stats.mu.Lock()
+		stats.ReadCapacity += cost.Consumed
+		stats.BudgetWait += cost.Waited
+		stats.mu.Unlock()
+
+		for i, record := range records {
+			fields := log.Fields{
+				"partition_key": fmt.Sprintf("%s=%s", cfg.PartitionKey, record.PartitionKeyValue),
+				"sort_key":      fmt.Sprintf("%s=%s", cfg.SortKey, record.SortKeyValue),
+			}
 
+			// Check if item exists in table, and matches the stream image when comparing
+			comparison := compareRecord(cfg.CompareRules, record, results[i].Item)
+			switch {
+			case results[i].Err != nil:
+				fields["error"] = results[i].Err
+				logger.WithFields(fields).Warn("[VALIDATION] Error querying " + tableType + " table")
+			case len(comparison.Mismatches) > 0:
+				fields["differences"] = diffStrings(comparison.Mismatches)
+				logger.WithFields(fields).Warn("[VALIDATION] MISMATCH: Item differs from the stream image in " + tableType + " table ❌")
+			case results[i].Item != nil:
+				if len(comparison.Expected) > 0 {
+					fields["expected_differences"] = diffStrings(comparison.Expected)
+				}
+				if cfg.Verbose {
+					logger.WithFields(fields).Info("[VALIDATION] SUCCESS: Item exists in " + tableType + " table ✅")
+				}
+			default:
+				logger.WithFields(fields).Warn("[VALIDATION] FAILED: Item not found in " + tableType + " table ❌")
+			}
+		}
+		return results
+	}
+
+	// Function to append a final failure to the dead-letter file
+	recordFailure := func(record ValidationRecord, item map[string]types.AttributeValue, mismatches []AttributeDiff, queryErr error) {
+		if cfg.FailureLog == nil {
+			return
+		}
+
+		tableName := cfg.SourceTable
 		if cfg.VerifyOn == "target" {
-			client = cfg.TargetClient
-			tableType = "target"
+			tableName = cfg.TargetTable
 		}
 
-		input := &dynamodb.GetItemInput{
-			TableName: aws.String(tableName),
-			Key:       keys,
+		failure := FailureRecord{
+			Table:          tableName,
+			VerifyOn:       cfg.VerifyOn,
+			Key:            record.Key,
+			EventID:        record.EventID,
+			EventName:      record.EventName,
+			SequenceNumber: record.SequenceNumber,
+			StreamImage:    record.NewImage,
+			ExpectedKey:    record.ExpectedKey,
+			ExpectedItem:   record.ExpectedImage,
+			VerifiedItem:   item,
+			Reason:         FailureReasonNotFound,
+		}
+		if errors.Is(queryErr, errTransformFailed) {
+			failure.Error = queryErr.Error()
+			failure.Reason = FailureReasonTransformError
+		} else if queryErr != nil {
+			failure.Error = queryErr.Error()
+			failure.Reason = FailureReasonQueryError
+		} else if item != nil {
+			failure.Reason = FailureReasonMismatch
+			failure.Differences = diffStrings(mismatches)
 		}
 
-		// Query the table
-		result, err := client.GetItem(ctx, input)
-		if err != nil {
-			log.WithFields(log.Fields{
-				"partition_key": fmt.Sprintf("%s=%s", cfg.PartitionKey, partitionKeyValue),
-				"sort_key":      fmt.Sprintf("%s=%s", cfg.SortKey, sortKeyValue),
-				"error":         err,
-			}).Warn("[VALIDATION] Error querying " + tableType + " table")
-			return false
+		if err := cfg.FailureLog.Write(failure); err != nil {
+			logger.Errorf("[VALIDATION] Failed to write failure record for event %s: %v", record.EventID, err)
 		}
+	}
 
-		// Check if item exists in table
-		exists := len(result.Item) > 0
+	// Validations run on a context detached from ctx, so the records already read are not
+	// turned into query errors when ctx is canceled. On shutdown they get a bounded time
+	// to finish before it is canceled too.
+	validationCtx, cancelValidation := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelValidation()
+	var validationWorkers sync.WaitGroup
 
-		if exists {
-			if cfg.Verbose {
-				log.WithFields(log.Fields{
-					"partition_key": fmt.Sprintf("%s=%s", cfg.PartitionKey, partitionKeyValue),
-					"sort_key":      fmt.Sprintf("%s=%s", cfg.SortKey, sortKeyValue),
-				}).Info("[VALIDATION] SUCCESS: Item exists in " + tableType + " table ✅")
+	// A panic of a validation goroutine stops the verification instead of the process
+	stopCh := make(chan error, 1)
+	recoverValidation := func() {
+		if r := recover(); r != nil {
+			select {
+			case stopCh <- fmt.Errorf("validation panicked: %v", r):
+			default: // Already stopping
 			}
-		} else {
-			log.WithFields(log.Fields{
-				"partition_key": fmt.Sprintf("%s=%s", cfg.PartitionKey, partitionKeyValue),
-				"sort_key":      fmt.Sprintf("%s=%s", cfg.SortKey, sortKeyValue),
-			}).Warn("[VALIDATION] FAILED: Item not found in " + tableType + " table ❌")
 		}
-
-		return exists
 	}
 
 	// Function to process a batch of validation records
 	processValidationBatch := func(batch []ValidationRecord) {
-		log.Infof("[VALIDATION] Processing batch of %d records", len(batch))
+		logger.Infof("[VALIDATION] Processing batch of %d records", len(batch))
 
 		// Wait for data replication
-		time.Sleep(cfg.ValidationConfig.ReplicationWaitTime)
+		sleepContext(validationCtx, cfg.ValidationConfig.ReplicationWaitTime)
 
-		for _, record := range batch {
-			stats.ValidationCount++
+		// Compute the key and item expected in the verified table. Records the transform
+		// fails on are not looked up and fail right away.
+		var transformFailed []ValidationRecord
+		var transformErrs []error
+		if cfg.Transform != nil {
+			batch, transformFailed, transformErrs = transformRecords(cfg.Transform, batch, cfg.PartitionKey, cfg.SortKey)
+			for i, record := range transformFailed {
+				logger.WithFields(log.Fields{
+					"partition_key": fmt.Sprintf("%s=%s", cfg.PartitionKey, record.PartitionKeyValue),
+					"sort_key":      fmt.Sprintf("%s=%s", cfg.SortKey, record.SortKeyValue),
+					"error":         transformErrs[i],
+				}).Warn("[VALIDATION] FAILED: Transform could not compute the expected item ❌")
 
-			// First attempt
-			success := verifyInTable(ctx, record.PartitionKeyValue, record.SortKeyValue)
+				recordFailure(record, nil, nil, transformErrs[i])
+				stats.mu.Lock()
+				stats.ValidationCount++
+				stats.ValidationFailed++
+				stats.TransformErrors++
+				stats.mu.Unlock()
+				sampler.Observe(record.PartitionKeyValue, false)
+				if cfg.OnValidation != nil {
+					cfg.OnValidation(ValidationResult{Table: cfg.Name, Record: record, Err: transformErrs[i]})
+				}
+			}
+		}
+
+		// First attempt
+		results := verifyInTable(validationCtx, lookup, batch)
 
-			// If first attempt fails, wait and try again
-			if !success {
-				time.Sleep(cfg.ValidationConfig.RetryWaitTime)
-				success = verifyInTable(ctx, record.PartitionKeyValue, record.SortKeyValue)
+		// If first attempt fails, wait and try again
+		retries, retryIndexes := failedRecords(cfg.CompareRules, batch, results)
+		if len(retries) > 0 {
+			sleepContext(validationCtx, cfg.ValidationConfig.RetryWaitTime)
+			for j, result := range verifyInTable(validationCtx, lookup, retries) {
+				results[retryIndexes[j]] = result
 			}
+		}
+
+		// Rule out stale eventually consistent reads before counting failures, an item
+		// that differs from the stream image may also be an older version
+		if recheckLookup != nil {
+			rechecks, recheckIndexes := failedRecords(cfg.CompareRules, batch, results)
+			if len(rechecks) > 0 {
+				resolved := 0
+				for j, result := range verifyInTable(validationCtx, recheckLookup, rechecks) {
+					results[recheckIndexes[j]] = result
+					if recordMatches(cfg.CompareRules, rechecks[j], result.Item) {
+						resolved++
+					}
+				}
+				stats.mu.Lock()
+				stats.Rechecked += len(rechecks)
+				stats.RecheckResolved += resolved
+				stats.mu.Unlock()
+			}
+		}
+
+		for i, record := range batch {
+			item, err := results[i].Item, results[i].Err
+			comparison := compareRecord(cfg.CompareRules, record, item)
 
-			if success {
+			result := ValidationResult{
+				Table:      cfg.Name,
+				Record:     record,
+				Success:    item != nil && len(comparison.Mismatches) == 0,
+				Item:       item,
+				Mismatches: comparison.Mismatches,
+				Expected:   comparison.Expected,
+				Err:        err,
+			}
+			sampler.Observe(record.PartitionKeyValue, result.Success)
+
+			if result.Success {
+				stats.mu.Lock()
+				stats.ValidationCount++
 				stats.ValidationSuccess++
-			} else {
-				stats.ValidationFailed++
+				if len(comparison.Expected) > 0 {
+					stats.ExpectedDiffs++
+				}
+				stats.mu.Unlock()
+				if cfg.OnValidation != nil {
+					cfg.OnValidation(result)
+				}
+				continue
+			}
+
+			recordFailure(record, item, comparison.Mismatches, err)
+
+			// Copy the item from source to target if repair is enabled
+			repaired := false
+			if cfg.Repairer != nil {
+				result.RepairOutcome, _ = cfg.Repairer.Repair(validationCtx, record.Key)
+				repaired = result.RepairOutcome == RepairOutcomeWritten || result.RepairOutcome == RepairOutcomeDryRun
+			}
+
+			stats.mu.Lock()
+			stats.ValidationCount++
+			stats.ValidationFailed++
+			if item != nil {
+				stats.Mismatched++
+			}
+			if cfg.Repairer != nil {
+				stats.RepairAttempted++
+			}
+			if repaired {
+				stats.RepairWritten++
+			}
+			stats.mu.Unlock()
+
+			if cfg.OnValidation != nil {
+				cfg.OnValidation(result)
 			}
 		}
 	}
 
+	// Keys handed to validation since the read budget was last checked
+	requestedKeys := 0
+
 	// Function to process validation buffer
 	processValidationBuffer := func() {
 		if len(validationBuffer) == 0 {
@@ -230,85 +723,177 @@ func RunStreamStyleVerification(ctx context.Context, cfg *StreamVerificationConf
 
 		// Clear the buffer
 		validationBuffer = validationBuffer[:0]
+		clear(bufferIndex)
+		requestedKeys += len(batch)
 
 		// Send batch to validation channel
 		select {
 		case validationCh <- batch:
 		default:
-			// If channel is full, process in current goroutine
-			go processValidationBatch(batch)
+			// If channel is full, process in a new goroutine
+			validationWorkers.Add(1)
+			go func() {
+				defer validationWorkers.Done()
+				defer recoverValidation()
+				processValidationBatch(batch)
+			}()
 		}
 	}
 
 	// Start validation processor goroutine
+	validationWorkers.Add(1)
 	go func() {
+		defer validationWorkers.Done()
+		defer recoverValidation()
 		for batch := range validationCh {
 			processValidationBatch(batch)
 		}
 	}()
 
-	// Print statistics
-	printStats := func() {
-		duration := time.Since(stats.StartTime)
-		log.Infof("========= Stream Event Statistics (Total %s) =========", duration.Round(time.Second))
-		log.Infof("Total events: %d (Unique: %d)", stats.TotalCount, len(stats.EventIDs))
-		log.Infof("INSERT: %d, MODIFY: %d", stats.InsertCount, stats.ModifyCount)
-		log.Infof("Average: %.2f events/sec", float64(stats.TotalCount)/duration.Seconds())
+	// Choose the sample rate for the traffic since the last plan, kept while there is none
+	lastPlanAt, lastPlanCount := time.Now(), 0
+	replanSampleRate := func() {
+		summary := stats.Summary()
+		eventRate := float64(summary.TotalCount-lastPlanCount) / time.Since(lastPlanAt).Seconds()
+		lastPlanAt, lastPlanCount = time.Now(), summary.TotalCount
+		if eventRate == 0 {
+			return
+		}
+
+		rate := planner.Plan(eventRate, summary.ValidationCount, summary.ValidationSuccess)
+		if rate != sampler.BaseRate() {
+			sampler.SetBaseRate(rate, fmt.Sprintf("planned for %.1f events/sec", eventRate))
+		}
+	}
+
+	// Sample fewer keys while the sampled keys need more read capacity than the budget, and
+	// more again once they fit. Kept while there is no traffic.
+	lastBudgetAt, budgetMinRate := time.Now(), 0
+	limitSampleRate := func() {
+		demand := float64(requestedKeys) * lookup.CostPerKey() / time.Since(lastBudgetAt).Seconds()
+		lastBudgetAt, requestedKeys = time.Now(), 0
+		if demand == 0 {
+			return
+		}
 
-		// Add validation statistics
-		if stats.ValidationCount > 0 {
-			successRate := float64(stats.ValidationSuccess) / float64(stats.ValidationCount) * 100
-			log.Infof("Validation: %d sampled, %d success (%.1f%%), %d failed",
-				stats.ValidationCount, stats.ValidationSuccess, successRate, stats.ValidationFailed)
+		rate, _, _ := sampler.Status()
+		minRate := int(math.Ceil(float64(rate) * demand / cfg.ReadBudget))
+		if minRate <= sampler.UnlimitedRate() {
+			minRate = 0
+		}
+		if minRate == budgetMinRate {
+			return
 		}
 
-		log.Infof("========================================")
+		reason := fmt.Sprintf("limited by the read budget of %g RCU/sec, sampled keys needed %.1f RCU/sec", cfg.ReadBudget, demand)
+		if minRate == 0 {
+			reason = fmt.Sprintf("sampled keys fit in the read budget of %g RCU/sec again", cfg.ReadBudget)
+		} else if budgetMinRate == 0 {
+			logger.Warnf("[BUDGET] Sampled keys need %.1f RCU/sec, over the read budget of %g RCU/sec, sampling fewer keys", demand, cfg.ReadBudget)
+		}
+		budgetMinRate = minRate
+		sampler.SetMinRate(minRate, reason)
+	}
+
+	// Print statistics and pass them on to the observer
+	var stopErr error
+	printStats := func() StatsSummary {
+		summary := stats.Summary()
+		summary.Table = cfg.Name
+		summary.StopErr = stopErr
+		summary.Confidence = cfg.Confidence
+		summary.SampleRate, summary.SampleRateReason, summary.FocusedKeys = sampler.Status()
+		summary.ReadBudget = cfg.ReadBudget
+		if unlimited := sampler.UnlimitedRate(); unlimited < summary.SampleRate {
+			summary.UnlimitedRate = unlimited
+		}
+		logStatsSummary(logger, "Stream Event Statistics", summary)
+		if cfg.OnStats != nil {
+			cfg.OnStats(summary)
+		}
+		return summary
+	}
+
+	// Validate the buffered and queued records, which may still sleep for replication and
+	// retry, before showing the final statistics
+	shutdown := func() StatsSummary {
+		processValidationBuffer()
+		close(validationCh)
+
+		drainTimeout := cfg.ValidationConfig.ReplicationWaitTime + cfg.ValidationConfig.RetryWaitTime + validationDrainTimeout
+		drainCtx, cancelDrain := context.WithTimeout(context.WithoutCancel(ctx), drainTimeout)
+		stopDrain := context.AfterFunc(drainCtx, func() {
+			logger.Warnf("[VALIDATION] Validations still running after %s, canceling them", drainTimeout)
+			cancelValidation()
+		})
+		validationWorkers.Wait()
+		stopDrain()
+		cancelDrain()
+		return printStats()
 	}
 
 	for {
 		select {
 		case rec := <-recCh:
+			if cfg.OnRecord != nil {
+				cfg.OnRecord(rec)
+			}
+
 			// Ignore Remove type events
 			if rec.EventName == streamtypes.OperationTypeRemove {
 				continue
 			}
 
-			stats.TotalCount++
 			eventID := aws.ToString(rec.EventID)
 
-			// Count event types
-			switch rec.EventName {
-			case streamtypes.OperationTypeInsert:
-				stats.InsertCount++
-			case streamtypes.OperationTypeModify:
-				stats.ModifyCount++
-			}
-
-			// Record unique events
-			stats.EventIDs[eventID] = struct{}{}
-
 			// Extract keys from the record
+			var key map[string]types.AttributeValue
 			var partitionKeyValue, sortKeyValue string
 
 			if rec.Dynamodb != nil && rec.Dynamodb.Keys != nil {
-				// Extract Partition Key
-				if pkAttr, ok := rec.Dynamodb.Keys[cfg.PartitionKey]; ok {
-					if pkStr, ok := pkAttr.(*streamtypes.AttributeValueMemberS); ok {
-						partitionKeyValue = pkStr.Value
+				key = extractPrimaryKey(rec.Dynamodb.Keys, cfg.PartitionKey, cfg.SortKey)
+				if key != nil {
+					partitionKeyValue = formatAttributeValue(key[cfg.PartitionKey])
+					if cfg.SortKey != "" {
+						sortKeyValue = formatAttributeValue(key[cfg.SortKey])
 					}
 				}
+			}
 
-				// Extract Sort Key if configured
-				if cfg.SortKey != "" {
-					if skAttr, ok := rec.Dynamodb.Keys[cfg.SortKey]; ok {
-						if skStr, ok := skAttr.(*streamtypes.AttributeValueMemberS); ok {
-							sortKeyValue = skStr.Value
-						}
+			// Drop records outside the filters before they are counted
+			if cfg.EventFilter != nil {
+				reason := cfg.EventFilter.Match(rec, partitionKeyValue)
+				if reason != FilterReasonNone {
+					stats.mu.Lock()
+					switch reason {
+					case FilterReasonKey:
+						stats.FilteredKey++
+					case FilterReasonEventType:
+						stats.FilteredEventType++
+					case FilterReasonTime:
+						stats.FilteredTime++
 					}
+					stats.mu.Unlock()
+					continue
 				}
 			}
 
-			log.WithFields(log.Fields{
+			stats.mu.Lock()
+			stats.TotalCount++
+
+			// Count event types
+			switch rec.EventName {
+			case streamtypes.OperationTypeInsert:
+				stats.InsertCount++
+			case streamtypes.OperationTypeModify:
+				stats.ModifyCount++
+			}
+
+			// Record unique events
+			stats.EventIDs[eventID] = struct{}{}
+			stats.mu.Unlock()
+
+			logger.WithFields(log.Fields{
 				"event_id":      eventID,
 				"event_type":    rec.EventName,
 				"partition_key": fmt.Sprintf("%s=%s", cfg.PartitionKey, partitionKeyValue),
@@ -316,30 +901,183 @@ func RunStreamStyleVerification(ctx context.Context, cfg *StreamVerificationConf
 			}).Info("[STREAM] Record received")
 
 			// Add to validation buffer if needed
-			if stats.TotalCount%cfg.SampleRate == 0 && partitionKeyValue != "" {
-				validationBuffer = append(validationBuffer, ValidationRecord{
+			if key != nil && sampler.Sampled(key, partitionKeyValue) {
+				record := ValidationRecord{
 					PartitionKeyValue: partitionKeyValue,
 					SortKeyValue:      sortKeyValue,
-				})
+					Key:               key,
+					EventID:           eventID,
+					EventName:         string(rec.EventName),
+					SequenceNumber:    aws.ToString(rec.Dynamodb.SequenceNumber),
+					NewImage:          streamImageToItem(rec.Dynamodb.NewImage),
+				}
+
+				// Coalesce with the buffered record of the same key. Parent and child shards are
+				// read concurrently, so the record that arrived last is not always the newest.
+				bufferKey := partitionKeyValue + "\x00" + sortKeyValue
+				if i, ok := bufferIndex[bufferKey]; ok {
+					buffered := validationBuffer[i]
+					if !sequenceNumberAfter(record.SequenceNumber, buffered.SequenceNumber) {
+						record = buffered
+					}
+					record.CoalescedEvents = buffered.CoalescedEvents + 1
+					validationBuffer[i] = record
+					stats.mu.Lock()
+					stats.Coalesced++
+					stats.mu.Unlock()
+				} else {
+					bufferIndex[bufferKey] = len(validationBuffer)
+					validationBuffer = append(validationBuffer, record)
+				}
 			}
 
 		case <-validationTicker.C:
 			processValidationBuffer()
 
 		case err := <-errCh:
-			log.Errorf("[STREAM] Error: %v", err)
+			logger.Errorf("[STREAM] Error: %v", err)
+			stats.mu.Lock()
+			stats.StreamErrors++
+			stats.mu.Unlock()
+			if errors.Is(err, ErrStreamStopped) {
+				logger.Error("[STREAM] No more records will be read, stopping the verification ❌")
+				stopErr = err
+				return shutdown()
+			}
+		case err := <-stopCh:
+			logger.Errorf("[VALIDATION] Error: %v, stopping the verification ❌", err)
+			stopErr = err
+			return shutdown()
 		case <-ticker.C:
+			if planner != nil {
+				replanSampleRate()
+			}
+			if cfg.ReadBudget > 0 {
+				limitSampleRate()
+			}
 			printStats()
-		case <-c:
-			log.Info("Interrupt received, shutting down stream listener...")
-			processValidationBuffer() // Process any remaining records
-			printStats()              // Show final statistics before exiting
-			return
 		case <-ctx.Done():
-			log.Info("Context canceled, shutting down stream listener...")
-			processValidationBuffer() // Process any remaining records
-			printStats()              // Show final statistics before exiting
-			return
+			logger.Info("Context canceled, shutting down stream listener...")
+			return shutdown() // Show final statistics before exiting
+		}
+	}
+}
+
+// sequenceNumberAfter reports whether stream sequence number a is after b. Sequence numbers
+// are decimal integers too large for int64. A malformed one is before a valid one, and a is
+// after b when both are malformed, so the record that arrived last wins.
+func sequenceNumberAfter(a, b string) bool {
+	x, okA := new(big.Int).SetString(a, 10)
+	y, okB := new(big.Int).SetString(b, 10)
+	if !okA || !okB {
+		return okA || !okB
+	}
+	return x.Cmp(y) > 0
+}
+
+// failedRecords returns the records whose lookup found no item, or an item that differs
+// from the stream image when comparing, and their indexes
+func failedRecords(rules *CompareRules, records []ValidationRecord, results []lookupResult) ([]ValidationRecord, []int) {
+	var failed []ValidationRecord
+	var indexes []int
+	for i, result := range results {
+		if !recordMatches(rules, records[i], result.Item) {
+			failed = append(failed, records[i])
+			indexes = append(indexes, i)
+		}
+	}
+	return failed, indexes
+}
+
+// transformRecords computes the expected key and item of every record from its stream
+// image, or from its key when the stream has no image. It returns the records transformed
+// and the records the transform failed on, with their errors.
+func transformRecords(transform *Transform, records []ValidationRecord, partitionKey, sortKey string) ([]ValidationRecord, []ValidationRecord, []error) {
+	transformed := make([]ValidationRecord, 0, len(records))
+	var failed []ValidationRecord
+	var errs []error
+	for _, record := range records {
+		image := record.NewImage
+		if image == nil {
+			image = record.Key
 		}
+
+		expected, err := transform.Apply(image)
+		if err == nil {
+			record.ExpectedKey = map[string]types.AttributeValue{partitionKey: expected[partitionKey]}
+			if sortKey != "" {
+				record.ExpectedKey[sortKey] = expected[sortKey]
+			}
+			for name, av := range record.ExpectedKey {
+				if av == nil {
+					err = fmt.Errorf("the expected item has no key attribute %s", name)
+				}
+			}
+		}
+		if err != nil {
+			failed = append(failed, record)
+			errs = append(errs, fmt.Errorf("%w: %v", errTransformFailed, err))
+			continue
+		}
+
+		if record.NewImage != nil {
+			record.ExpectedImage = expected
+		}
+		transformed = append(transformed, record)
+	}
+	return transformed, failed, errs
+}
+
+// lookupKey returns the key of the record in the verified table
+func (r ValidationRecord) lookupKey() map[string]types.AttributeValue {
+	if r.ExpectedKey != nil {
+		return r.ExpectedKey
+	}
+	return r.Key
+}
+
+// expectedImage returns the item the verified table should hold, nil if unknown
+func (r ValidationRecord) expectedImage() map[string]types.AttributeValue {
+	if r.ExpectedImage != nil {
+		return r.ExpectedImage
+	}
+	return r.NewImage
+}
+
+// compareRecord compares the item found with the stream image of the record. Nothing is
+// compared without rules, when the item is missing or when the stream has no new image.
+func compareRecord(rules *CompareRules, record ValidationRecord, item map[string]types.AttributeValue) ItemComparison {
+	if rules == nil || item == nil || record.expectedImage() == nil {
+		return ItemComparison{}
+	}
+	return rules.Compare(record.expectedImage(), item)
+}
+
+// recordMatches reports whether the item was found with no unexpected differences
+func recordMatches(rules *CompareRules, record ValidationRecord, item map[string]types.AttributeValue) bool {
+	return item != nil && len(compareRecord(rules, record, item).Mismatches) == 0
+}
+
+// diffStrings describes every difference
+func diffStrings(diffs []AttributeDiff) []string {
+	descriptions := make([]string, len(diffs))
+	for i, diff := range diffs {
+		descriptions[i] = diff.String()
+	}
+	return descriptions
+}
+
+// lookupItem fetches an item by its primary key, returning nil if it does not exist
+func lookupItem(ctx context.Context, client TableAPI, tableName string, key map[string]types.AttributeValue) (map[string]types.AttributeValue, error) {
+	result, err := client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(tableName),
+		Key:       key,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Item) == 0 {
+		return nil, nil
 	}
+	return result.Item, nil
 }
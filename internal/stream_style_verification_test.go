@@ -0,0 +1,831 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	streamtypes "github.com/aws/aws-sdk-go-v2/service/dynamodbstreams/types"
+	log "github.com/sirupsen/logrus"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// verificationRun is a stream verification running in the background against a fake migration
+type verificationRun struct {
+	mu      sync.Mutex
+	records int
+	results []ValidationResult
+	stats   StatsSummary
+	cancel  context.CancelFunc
+	done    chan StatsSummary
+}
+
+// testVerificationConfig returns a configuration that verifies m on the target table,
+// sampling every record and polling every few milliseconds
+func testVerificationConfig(m *fakeMigration) StreamVerificationConfig {
+	return StreamVerificationConfig{
+		SourceClient: m.source,
+		TargetClient: m.target,
+		StreamClient: m.stream,
+		StreamArn:    m.stream.arn,
+		TargetTable:  m.target.name,
+		SampleRate:   1,
+		PartitionKey: "pk",
+		SortKey:      "sk",
+		IteratorType: "TRIM_HORIZON",
+		VerifyOn:     "target",
+		ValidationConfig: ValidationConfig{
+			BufferSize:          100,
+			ChannelSize:         10,
+			ValidationInterval:  5 * time.Millisecond,
+			ReplicationWaitTime: 0,
+			RetryWaitTime:       20 * time.Millisecond,
+			BatchSize:           100,
+			StatsInterval:       10 * time.Millisecond,
+		},
+		StreamPolling: StreamPolling{
+			StartDelay:      time.Millisecond,
+			RefreshInterval: 20 * time.Millisecond,
+			RecordsInterval: time.Millisecond,
+			IdleInterval:    5 * time.Millisecond,
+		},
+	}
+}
+
+// startVerification runs the verification until stop is called or the test ends
+func startVerification(t *testing.T, cfg StreamVerificationConfig) *verificationRun {
+	t.Helper()
+	run := &verificationRun{done: make(chan StatsSummary, 1)}
+
+	cfg.OnRecord = func(*streamtypes.Record) {
+		run.mu.Lock()
+		run.records++
+		run.mu.Unlock()
+	}
+	cfg.OnValidation = func(result ValidationResult) {
+		run.mu.Lock()
+		run.results = append(run.results, result)
+		run.mu.Unlock()
+	}
+	cfg.OnStats = func(stats StatsSummary) {
+		run.mu.Lock()
+		run.stats = stats
+		run.mu.Unlock()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	run.cancel = cancel
+	t.Cleanup(cancel)
+
+	go func() {
+		run.done <- RunStreamStyleVerification(ctx, &cfg)
+	}()
+	return run
+}
+
+// waitForResults waits until n records have been validated and returns the results
+func (r *verificationRun) waitForResults(t *testing.T, n int) []ValidationResult {
+	t.Helper()
+	waitFor(t, func() bool {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		return len(r.results) >= n
+	})
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]ValidationResult(nil), r.results...)
+}
+
+// stop cancels the verification and returns its final statistics
+func (r *verificationRun) stop(t *testing.T) StatsSummary {
+	t.Helper()
+	r.cancel()
+	select {
+	case stats := <-r.done:
+		return stats
+	case <-time.After(5 * time.Second):
+		t.Fatal("verification did not stop after the context was canceled")
+		return StatsSummary{}
+	}
+}
+
+// waitFor polls cond until it is true, failing the test after 5 seconds
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for condition")
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+}
+
+// failedKeys returns the partition keys of the failed results, sorted
+func failedKeys(results []ValidationResult) []string {
+	var keys []string
+	for _, r := range results {
+		if !r.Success {
+			keys = append(keys, r.Record.PartitionKeyValue)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestStreamVerificationReplicatedItems(t *testing.T) {
+	m := newFakeMigration()
+	for i := 1; i <= 10; i++ {
+		m.write(testItem(i))
+	}
+
+	run := startVerification(t, testVerificationConfig(m))
+	results := run.waitForResults(t, 10)
+	stats := run.stop(t)
+
+	if keys := failedKeys(results); len(keys) != 0 {
+		t.Errorf("failed keys = %v, want none", keys)
+	}
+	if stats.TotalCount != 10 || stats.InsertCount != 10 || stats.UniqueEvents != 10 {
+		t.Errorf("events = %d (insert %d, unique %d), want 10", stats.TotalCount, stats.InsertCount, stats.UniqueEvents)
+	}
+	if stats.ValidationCount != 10 || stats.ValidationSuccess != 10 || stats.ValidationFailed != 0 {
+		t.Errorf("validation = %d/%d/%d, want 10 sampled, 10 success", stats.ValidationCount, stats.ValidationSuccess, stats.ValidationFailed)
+	}
+	if stats.SampleRate != 1 || stats.Confidence != 0.95 {
+		t.Errorf("sample rate = %d, confidence = %g, want 1 and 0.95", stats.SampleRate, stats.Confidence)
+	}
+	if stats.ReadCapacity != 5 {
+		t.Errorf("read capacity = %g RCU, want 5", stats.ReadCapacity)
+	}
+	for _, r := range results {
+		if r.Item == nil || r.Record.NewImage == nil || r.Record.SequenceNumber == "" {
+			t.Errorf("result for %s is missing the item, image or sequence number", r.Record.PartitionKeyValue)
+		}
+	}
+}
+
+func TestStreamVerificationMissingItems(t *testing.T) {
+	m := newFakeMigration()
+	for i := 1; i <= 8; i++ {
+		if i%3 == 0 {
+			m.writeUnreplicated(testItem(i))
+		} else {
+			m.write(testItem(i))
+		}
+	}
+
+	failureFile := filepath.Join(t.TempDir(), "failures.jsonl")
+	failureLog, err := OpenFailureLog(failureFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer failureLog.Close()
+
+	cfg := testVerificationConfig(m)
+	cfg.FailureLog = failureLog
+
+	run := startVerification(t, cfg)
+	results := run.waitForResults(t, 8)
+	stats := run.stop(t)
+
+	want := []string{"TEST_PK_3", "TEST_PK_6"}
+	if keys := failedKeys(results); !slices.Equal(keys, want) {
+		t.Errorf("failed keys = %v, want %v", keys, want)
+	}
+	if stats.ValidationSuccess != 6 || stats.ValidationFailed != 2 {
+		t.Errorf("validation = %d success, %d failed, want 6 and 2", stats.ValidationSuccess, stats.ValidationFailed)
+	}
+
+	records, err := ReadFailureRecords(failureFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("failure file has %d records, want 2", len(records))
+	}
+	for _, rec := range records {
+		if rec.Reason != FailureReasonNotFound || rec.VerifyOn != "target" || rec.Table != "orders" {
+			t.Errorf("failure record = %+v, want item_not_found on the target table", rec)
+		}
+		if rec.StreamImage == nil || rec.EventName != "INSERT" {
+			t.Errorf("failure record of %v is missing the stream image or event name", rec.Key)
+		}
+	}
+}
+
+func TestStreamVerificationReplicationDelay(t *testing.T) {
+	tests := []struct {
+		name        string
+		delay       time.Duration
+		wantSuccess bool
+	}{
+		{name: "replicated before retry", delay: 50 * time.Millisecond, wantSuccess: true},
+		{name: "replicated after retry", delay: time.Hour, wantSuccess: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newFakeMigration()
+			m.replicationDelay = tt.delay
+			m.write(testItem(1))
+
+			cfg := testVerificationConfig(m)
+			cfg.ValidationConfig.RetryWaitTime = 200 * time.Millisecond
+
+			run := startVerification(t, cfg)
+			results := run.waitForResults(t, 1)
+			run.stop(t)
+
+			if results[0].Success != tt.wantSuccess {
+				t.Errorf("success = %t, want %t", results[0].Success, tt.wantSuccess)
+			}
+		})
+	}
+}
+
+func TestStreamVerificationVerifyOnSource(t *testing.T) {
+	m := newFakeMigration()
+	for i := 1; i <= 3; i++ {
+		m.writeUnreplicated(testItem(i))
+	}
+
+	cfg := testVerificationConfig(m)
+	cfg.VerifyOn = "source"
+
+	run := startVerification(t, cfg)
+	results := run.waitForResults(t, 3)
+	run.stop(t)
+
+	if keys := failedKeys(results); len(keys) != 0 {
+		t.Errorf("failed keys = %v, want none", keys)
+	}
+	if m.target.readCalls != 0 {
+		t.Errorf("target table was read %d times, want 0", m.target.readCalls)
+	}
+}
+
+func TestStreamVerificationQueryErrors(t *testing.T) {
+	m := newFakeMigration()
+	m.write(testItem(1))
+	m.target.readErr = errors.New("throttled")
+
+	failureFile := filepath.Join(t.TempDir(), "failures.jsonl")
+	failureLog, err := OpenFailureLog(failureFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer failureLog.Close()
+
+	cfg := testVerificationConfig(m)
+	cfg.FailureLog = failureLog
+
+	run := startVerification(t, cfg)
+	results := run.waitForResults(t, 1)
+	run.stop(t)
+
+	if results[0].Success || results[0].Err == nil {
+		t.Errorf("result = success %t, error %v, want a failure with the query error", results[0].Success, results[0].Err)
+	}
+
+	records, err := ReadFailureRecords(failureFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Reason != FailureReasonQueryError || records[0].Error != "throttled" {
+		t.Errorf("failure records = %+v, want one query_error record", records)
+	}
+}
+
+func TestStreamVerificationSampleRate(t *testing.T) {
+	m := newFakeMigration()
+	for i := 1; i <= 40; i++ {
+		m.write(testItem(i))
+	}
+	for i := 1; i <= 40; i++ {
+		item := testItem(i)
+		item["data"] = &types.AttributeValueMemberS{Value: "updated"}
+		m.write(item)
+	}
+
+	cfg := testVerificationConfig(m)
+	cfg.SampleRate = 5
+	cfg.SampleSalt = "test"
+
+	// Every event of a sampled key is validated, or replaced by its later event
+	sampler := NewKeySampler(cfg.SampleRate, cfg.SampleSalt)
+	sampled := make(map[string]bool)
+	for i := 1; i <= 40; i++ {
+		if sampler.Sampled(m.keyOf(testItem(i))) {
+			sampled[fmt.Sprintf("TEST_PK_%d", i)] = true
+		}
+	}
+	if len(sampled) == 0 {
+		t.Fatal("no test key is sampled, change the salt")
+	}
+
+	run := startVerification(t, cfg)
+	waitFor(t, func() bool {
+		run.mu.Lock()
+		defer run.mu.Unlock()
+		modified := 0
+		for _, r := range run.results {
+			if r.Record.EventName == "MODIFY" {
+				modified++
+			}
+		}
+		return run.records == 80 && modified == len(sampled)
+	})
+	stats := run.stop(t)
+	results := run.waitForResults(t, len(sampled))
+
+	if stats.InsertCount != 40 || stats.ModifyCount != 40 {
+		t.Errorf("INSERT %d, MODIFY %d, want 40 and 40", stats.InsertCount, stats.ModifyCount)
+	}
+	if stats.ValidationCount+stats.Coalesced != 2*len(sampled) {
+		t.Errorf("validated %d records and coalesced %d, want %d in total", stats.ValidationCount, stats.Coalesced, 2*len(sampled))
+	}
+
+	events := make(map[string][]string)
+	for _, r := range results {
+		events[r.Record.PartitionKeyValue] = append(events[r.Record.PartitionKeyValue], r.Record.EventName)
+	}
+	for key, names := range events {
+		if !sampled[key] {
+			t.Errorf("validated %s, which is not sampled", key)
+		}
+		if names[len(names)-1] != "MODIFY" {
+			t.Errorf("events validated for %s = %v, want MODIFY last", key, names)
+		}
+	}
+}
+
+func TestStreamVerificationCoalescesHotKeys(t *testing.T) {
+	m := newFakeMigration()
+	for i := 1; i <= 50; i++ {
+		item := testItem(1)
+		item["data"] = &types.AttributeValueMemberS{Value: fmt.Sprintf("version %d", i)}
+		m.write(item)
+	}
+	m.write(testItem(2))
+
+	// Keep every record in one buffer
+	cfg := testVerificationConfig(m)
+	cfg.ValidationConfig.ValidationInterval = 200 * time.Millisecond
+
+	run := startVerification(t, cfg)
+	results := run.waitForResults(t, 2)
+	stats := run.stop(t)
+
+	if len(results) != 2 || stats.TotalCount != 51 || stats.Coalesced != 49 {
+		t.Fatalf("validated %d of %d events with %d coalesced, want 2 of 51 with 49", len(results), stats.TotalCount, stats.Coalesced)
+	}
+	for _, r := range results {
+		if r.Record.PartitionKeyValue != "TEST_PK_1" {
+			continue
+		}
+		if got := formatAttributeValue(r.Record.NewImage["data"]); got != "version 50" || r.Record.CoalescedEvents != 49 {
+			t.Errorf("validated image %q replacing %d events, want version 50 replacing 49", got, r.Record.CoalescedEvents)
+		}
+	}
+}
+
+func TestStreamVerificationCoalescesNewestRecord(t *testing.T) {
+	m := newFakeMigration()
+	older := testItem(1)
+	older["data"] = &types.AttributeValueMemberS{Value: "parent"}
+	m.write(older)
+	m.stream.split("shardId-00000001")
+	newer := testItem(1)
+	newer["data"] = &types.AttributeValueMemberS{Value: "child"}
+	m.write(newer)
+
+	// The parent shard is read after its children, so its older record arrives last
+	var slowParent sync.Once
+	m.stream.onGetRecords = func(shardID string) {
+		if shardID == "shardId-00000001" {
+			slowParent.Do(func() { time.Sleep(50 * time.Millisecond) })
+		}
+	}
+	cfg := testVerificationConfig(m)
+	cfg.ValidationConfig.ValidationInterval = 200 * time.Millisecond
+	cfg.CompareRules = &CompareRules{}
+
+	run := startVerification(t, cfg)
+	results := run.waitForResults(t, 1)
+	stats := run.stop(t)
+
+	record := results[0].Record
+	if got := formatAttributeValue(record.NewImage["data"]); got != "child" || record.CoalescedEvents != 1 || stats.Coalesced != 1 {
+		t.Errorf("validated image %q replacing %d events, %d coalesced, want child replacing 1", got, record.CoalescedEvents, stats.Coalesced)
+	}
+	if stats.ValidationSuccess != 1 || stats.Mismatched != 0 {
+		t.Errorf("success %d, mismatched %d, want 1 and 0", stats.ValidationSuccess, stats.Mismatched)
+	}
+}
+
+func TestSequenceNumberAfter(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"300000000000000000000000002", "300000000000000000000000001", true},
+		{"300000000000000000000000001", "300000000000000000000000002", false},
+		{"99", "100", false},
+		{"5", "5", false},
+		{"", "5", false},
+		{"5", "", true},
+		{"", "", true},
+	}
+	for _, tt := range tests {
+		if got := sequenceNumberAfter(tt.a, tt.b); got != tt.want {
+			t.Errorf("sequenceNumberAfter(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestStreamVerificationSkipsRemoveEvents(t *testing.T) {
+	m := newFakeMigration()
+	for i := 1; i <= 3; i++ {
+		m.write(testItem(i))
+	}
+	m.delete(testItem(4))
+
+	run := startVerification(t, testVerificationConfig(m))
+	results := run.waitForResults(t, 3)
+	waitFor(t, func() bool {
+		run.mu.Lock()
+		defer run.mu.Unlock()
+		return run.records == 4
+	})
+	stats := run.stop(t)
+
+	if stats.TotalCount != 3 || len(results) != 3 {
+		t.Errorf("counted %d events and validated %d, want 3 of each", stats.TotalCount, len(results))
+	}
+}
+
+func TestStreamVerificationShardSplit(t *testing.T) {
+	m := newFakeMigration()
+	m.stream.pageSize = 1 // Page through DescribeStream
+
+	// Child shards are read from TRIM_HORIZON, whatever the configured iterator type
+	cfg := testVerificationConfig(m)
+	cfg.IteratorType = "LATEST"
+
+	run := startVerification(t, cfg)
+
+	// LATEST only returns records written after the iterator is handed out
+	waitFor(t, func() bool { return m.stream.iteratorCount() > 0 })
+	for i := 1; i <= 5; i++ {
+		m.write(testItem(i))
+	}
+	m.stream.split("shardId-00000001")
+	for i := 6; i <= 20; i++ {
+		m.write(testItem(i))
+	}
+
+	results := run.waitForResults(t, 20)
+	stats := run.stop(t)
+
+	if keys := failedKeys(results); len(keys) != 0 {
+		t.Errorf("failed keys = %v, want none", keys)
+	}
+	if stats.TotalCount != 20 || stats.StreamErrors != 0 {
+		t.Errorf("events = %d, stream errors = %d, want 20 and 0", stats.TotalCount, stats.StreamErrors)
+	}
+}
+
+func TestStreamVerificationTrimmedRecords(t *testing.T) {
+	m := newFakeMigration()
+	for i := 1; i <= 10; i++ {
+		m.write(testItem(i))
+	}
+
+	// Trim the oldest records after the first iterator is handed out, as if the reader
+	// had fallen behind the stream retention
+	var once sync.Once
+	m.stream.onGetRecords = func(shardID string) {
+		once.Do(func() { m.stream.trim(shardID, 4) })
+	}
+
+	run := startVerification(t, testVerificationConfig(m))
+	results := run.waitForResults(t, 6)
+	stats := run.stop(t)
+
+	if results[0].Record.PartitionKeyValue != "TEST_PK_5" {
+		t.Errorf("first validated key = %s, want TEST_PK_5", results[0].Record.PartitionKeyValue)
+	}
+	if stats.TotalCount != 6 || stats.StreamErrors != 0 {
+		t.Errorf("events = %d, stream errors = %d, want 6 and 0", stats.TotalCount, stats.StreamErrors)
+	}
+}
+
+func TestStreamVerificationStreamErrors(t *testing.T) {
+	m := newFakeMigration()
+
+	cfg := testVerificationConfig(m)
+	cfg.StreamArn = "arn:aws:dynamodb:ap-northeast-1:123456789012:table/missing/stream/2025-01-01T00:00:00.000"
+
+	// Without a stream to read, the verification stops on its own
+	run := startVerification(t, cfg)
+	var stats StatsSummary
+	select {
+	case stats = <-run.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("verification did not stop when the stream could not be described")
+	}
+
+	if stats.StreamErrors != 1 || stats.TotalCount != 0 {
+		t.Errorf("stream errors = %d, events = %d, want 1 and 0", stats.StreamErrors, stats.TotalCount)
+	}
+	if !errors.Is(stats.StopErr, ErrStreamStopped) {
+		t.Errorf("stop error = %v, want the stream to be stopped", stats.StopErr)
+	}
+}
+
+func TestStreamVerificationEventFilter(t *testing.T) {
+	m := newFakeMigration()
+	for i := 1; i <= 4; i++ {
+		m.write(testItem(i))
+	}
+	m.write(testItem(1)) // MODIFY
+
+	filter, err := NewEventFilter(EventFilterConfig{
+		ExcludeKeyPrefixes: []string{"TEST_PK_4"},
+		EventTypes:         []string{"INSERT"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := testVerificationConfig(m)
+	cfg.EventFilter = filter
+
+	run := startVerification(t, cfg)
+	results := run.waitForResults(t, 3)
+	waitFor(t, func() bool {
+		run.mu.Lock()
+		defer run.mu.Unlock()
+		return run.records == 5
+	})
+	stats := run.stop(t)
+
+	if stats.TotalCount != 3 || len(results) != 3 {
+		t.Errorf("counted %d events and validated %d, want 3 of each", stats.TotalCount, len(results))
+	}
+	if stats.FilteredKey != 1 || stats.FilteredEventType != 1 || stats.FilteredTime != 0 {
+		t.Errorf("filtered key %d, event type %d, time %d, want 1, 1 and 0",
+			stats.FilteredKey, stats.FilteredEventType, stats.FilteredTime)
+	}
+}
+
+func TestStreamVerificationReadBudget(t *testing.T) {
+	m := newFakeMigration()
+	for i := 1; i <= 100; i++ {
+		m.write(testItem(i))
+	}
+
+	// 100 keys need 50 RCU, far more than the budget allows in one statistics interval
+	cfg := testVerificationConfig(m)
+	cfg.ReadBudget = 20
+
+	run := startVerification(t, cfg)
+	waitFor(t, func() bool {
+		run.mu.Lock()
+		defer run.mu.Unlock()
+		return run.stats.UnlimitedRate == 1 && run.stats.SampleRate > 1
+	})
+	stats := run.stop(t)
+
+	if stats.ReadBudget != 20 || stats.ReadCapacity > 50 {
+		t.Errorf("read budget = %g, consumed %g RCU, want 20 and at most 50", stats.ReadBudget, stats.ReadCapacity)
+	}
+	if !strings.Contains(stats.SampleRateReason, "read budget") {
+		t.Errorf("sample rate reason = %q, want the read budget", stats.SampleRateReason)
+	}
+}
+
+func TestStreamVerificationConsistentReads(t *testing.T) {
+	tests := []struct {
+		name              string
+		consistentRead    bool
+		consistentRecheck bool
+		wantFailed        int
+		wantResolved      int
+	}{
+		{"eventual", false, false, 5, 0},
+		{"consistent", true, false, 0, 0},
+		{"recheck", false, true, 0, 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newFakeMigration()
+			m.target.staleReads = time.Hour
+			for i := 1; i <= 5; i++ {
+				m.write(testItem(i))
+			}
+
+			cfg := testVerificationConfig(m)
+			cfg.ConsistentRead = tt.consistentRead
+			cfg.ConsistentRecheck = tt.consistentRecheck
+
+			run := startVerification(t, cfg)
+			run.waitForResults(t, 5)
+			stats := run.stop(t)
+
+			if stats.ValidationFailed != tt.wantFailed || stats.RecheckResolved != tt.wantResolved {
+				t.Errorf("failed %d, resolved by recheck %d, want %d and %d",
+					stats.ValidationFailed, stats.RecheckResolved, tt.wantFailed, tt.wantResolved)
+			}
+			if tt.consistentRecheck && stats.Rechecked != 5 {
+				t.Errorf("rechecked %d items, want 5", stats.Rechecked)
+			}
+			if tt.consistentRead && m.target.consistentCalls == 0 {
+				t.Error("no strongly consistent read was made")
+			}
+		})
+	}
+}
+
+func TestStreamVerificationCompareRules(t *testing.T) {
+	m := newFakeMigration()
+	// withAttrs returns item n of the test data with the attributes set, nil values removed
+	withAttrs := func(n int, attrs map[string]types.AttributeValue) map[string]types.AttributeValue {
+		item := testItem(n)
+		item["price"] = &types.AttributeValueMemberN{Value: "10.5"}
+		item["updatedAt"] = &types.AttributeValueMemberS{Value: "2024-05-01T10:00:00Z"}
+		for name, av := range attrs {
+			if av == nil {
+				delete(item, name)
+			} else {
+				item[name] = av
+			}
+		}
+		return item
+	}
+	legacy := &types.AttributeValueMemberS{Value: "v1"}
+	pairs := [][2]map[string]types.AttributeValue{
+		{withAttrs(1, nil), withAttrs(1, nil)},
+		{withAttrs(2, nil), withAttrs(2, map[string]types.AttributeValue{
+			"price":     &types.AttributeValueMemberN{Value: "10.49"},
+			"updatedAt": &types.AttributeValueMemberS{Value: "2024-05-01T10:00:01Z"},
+		})},
+		{withAttrs(3, map[string]types.AttributeValue{"legacy": legacy}), withAttrs(3, map[string]types.AttributeValue{"modern": legacy})},
+		{withAttrs(4, nil), withAttrs(4, map[string]types.AttributeValue{"price": &types.AttributeValueMemberN{Value: "12"}})},
+		{withAttrs(5, nil), withAttrs(5, map[string]types.AttributeValue{"etag": &types.AttributeValueMemberS{Value: "abc"}})},
+	}
+	for _, pair := range pairs {
+		m.writeUnreplicated(pair[0])
+		m.target.put(pair[1], 0)
+	}
+
+	failureFile := filepath.Join(t.TempDir(), "failures.jsonl")
+	failureLog, err := OpenFailureLog(failureFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer failureLog.Close()
+
+	cfg := testVerificationConfig(m)
+	cfg.FailureLog = failureLog
+	cfg.CompareRules = &CompareRules{
+		Ignore:           []string{"etag"},
+		Rename:           map[string]string{"legacy": "modern"},
+		NumericTolerance: map[string]float64{"price": 0.05},
+		TimeTolerance:    map[string]time.Duration{"updatedAt": 2 * time.Second},
+	}
+
+	run := startVerification(t, cfg)
+	results := run.waitForResults(t, 5)
+	stats := run.stop(t)
+
+	if keys := failedKeys(results); !slices.Equal(keys, []string{"TEST_PK_4"}) {
+		t.Errorf("failed keys = %v, want [TEST_PK_4]", keys)
+	}
+	if stats.ValidationSuccess != 4 || stats.Mismatched != 1 || stats.ExpectedDiffs != 3 {
+		t.Errorf("success %d, mismatched %d, expected differences %d, want 4, 1 and 3",
+			stats.ValidationSuccess, stats.Mismatched, stats.ExpectedDiffs)
+	}
+
+	records, err := ReadFailureRecords(failureFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Reason != FailureReasonMismatch ||
+		!slices.Equal(records[0].Differences, []string{"price: expected 10.5, got 12"}) {
+		t.Errorf("failure records = %+v, want one attribute_mismatch of price", records)
+	}
+}
+
+func TestStreamVerificationTransform(t *testing.T) {
+	m := newFakeMigration()
+	// reshaped returns item n as the migration writes it to the target table
+	reshaped := func(n int) map[string]types.AttributeValue {
+		return map[string]types.AttributeValue{
+			"pk":      &types.AttributeValueMemberS{Value: fmt.Sprintf("tenant42#TEST_PK_%d", n)},
+			"sk":      &types.AttributeValueMemberS{Value: fmt.Sprintf("test_sk_%d", n)},
+			"summary": &types.AttributeValueMemberS{Value: fmt.Sprintf("#%d", n)},
+		}
+	}
+	for i := 1; i <= 4; i++ {
+		m.writeUnreplicated(testItem(i))
+		if i != 4 {
+			m.target.put(reshaped(i), 0)
+		}
+	}
+	unreadable := testItem(5)
+	unreadable["data"] = &types.AttributeValueMemberS{Value: "short"}
+	m.writeUnreplicated(unreadable)
+	noData := testItem(6)
+	delete(noData, "data")
+	m.writeUnreplicated(noData)
+
+	failureFile := filepath.Join(t.TempDir(), "failures.jsonl")
+	failureLog, err := OpenFailureLog(failureFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer failureLog.Close()
+
+	transform, err := ParseTransform(`pk=tenant42#${pk}; sk=${lower(sk)}; summary=${split(data, " ", 4)}; note?=${upper(note)}; -data`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := testVerificationConfig(m)
+	cfg.FailureLog = failureLog
+	cfg.Transform = transform
+	cfg.CompareRules = &CompareRules{}
+
+	run := startVerification(t, cfg)
+	results := run.waitForResults(t, 6)
+	stats := run.stop(t)
+
+	if keys := failedKeys(results); !slices.Equal(keys, []string{"TEST_PK_4", "TEST_PK_5", "TEST_PK_6"}) {
+		t.Errorf("failed keys = %v, want [TEST_PK_4 TEST_PK_5 TEST_PK_6]", keys)
+	}
+	if stats.ValidationSuccess != 3 || stats.TransformErrors != 2 || stats.Mismatched != 0 {
+		t.Errorf("success %d, transform errors %d, mismatched %d, want 3, 2 and 0",
+			stats.ValidationSuccess, stats.TransformErrors, stats.Mismatched)
+	}
+
+	records, err := ReadFailureRecords(failureFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reasons := make(map[string]FailureRecord, len(records))
+	for _, rec := range records {
+		reasons[rec.Reason] = rec
+	}
+	notFound := reasons[FailureReasonNotFound]
+	if got := formatAttributeValue(notFound.ExpectedKey["pk"]); got != "tenant42#TEST_PK_4" {
+		t.Errorf("expected key of the missing item = %s, want tenant42#TEST_PK_4", got)
+	}
+	if _, ok := reasons[FailureReasonTransformError]; !ok || len(records) != 3 {
+		t.Errorf("failure records = %+v, want item_not_found and transform_error", records)
+	}
+}
+
+func TestStreamVerificationDrainsValidationsOnShutdown(t *testing.T) {
+	m := newFakeMigration()
+	for i := 1; i <= 5; i++ {
+		m.write(testItem(i))
+	}
+
+	// Records stay buffered until shutdown, and their lookup waits for replication
+	cfg := testVerificationConfig(m)
+	cfg.ValidationConfig.ValidationInterval = time.Hour
+	cfg.ValidationConfig.ReplicationWaitTime = 50 * time.Millisecond
+
+	run := startVerification(t, cfg)
+	waitFor(t, func() bool {
+		run.mu.Lock()
+		defer run.mu.Unlock()
+		return run.records >= 5
+	})
+	stats := run.stop(t)
+
+	if stats.ValidationSuccess != 5 || stats.ValidationFailed != 0 {
+		t.Errorf("validation = %d success, %d failed, want the 5 buffered records validated after cancel",
+			stats.ValidationSuccess, stats.ValidationFailed)
+	}
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	if len(run.results) != 5 {
+		t.Errorf("%d results before the verification returned, want 5", len(run.results))
+	}
+}
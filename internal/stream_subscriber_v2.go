@@ -3,6 +3,7 @@ package internal
 import (
 	"context"
 	"errors"
+	"fmt"
 	"sync"
 	"time"
 
@@ -10,36 +11,73 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodbstreams"
 	stypes "github.com/aws/aws-sdk-go-v2/service/dynamodbstreams/types"
-	"github.com/aws/smithy-go"
+	log "github.com/sirupsen/logrus"
 )
 
 // Usage:
-//  sub := NewStreamSubscriberV2(ddbClient, streamClient, "table_name")
-//  recCh, errCh := sub.GetStreamData()  // or GetStreamDataAsync()
+//  sub := NewStreamSubscriberV2WithArn(tableClient, streamClient, "table_name", streamArn)
+//  // or NewStreamSubscriberV2(tableClient, streamClient, "table_name") for the table's latest stream
+//  recCh, errCh := sub.GetStreamData(ctx)  // or GetStreamDataAsync(ctx)
 //  for r := range recCh { ... }
 //  // Receive from errCh to avoid goroutine leaks
 //
-//  Currently uses context.Background() internally. If more control is needed,
-//  consider modifying the implementation to accept a context parameter.
+//  All goroutines stop when ctx is canceled. The channels are not closed, so stop
+//  receiving once ctx is done. Errors wrapping ErrStreamStopped mean no more records
+//  will be sent.
 //
-//  Note: This implementation only covers the most common use cases and doesn't
-//  handle all AWS error types. If you need to support errors other than
-//  TrimmedDataAccess, please extend the implementation.
+//  Errors: a shard whose records were trimmed (TrimmedDataAccess) continues from
+//  TRIM_HORIZON without an error. Any other GetShardIterator or GetRecords error ends the
+//  read of the shard and is sent on errCh, GetStreamData then reads the shard again while
+//  GetStreamDataAsync does not.
+
+// ErrStreamStopped is wrapped by the errors after which the subscriber reads no more
+// records: shards can no longer be listed, or one of its goroutines panicked
+var ErrStreamStopped = errors.New("stream subscription stopped")
 
 type StreamSubscriberV2 struct {
-	dynamoSvc *dynamodb.Client
-	streamSvc *dynamodbstreams.Client
+	dynamoSvc TableAPI
+	streamSvc StreamAPI
 	table     string
 	streamArn string // Optional direct stream ARN
 
 	ShardIteratorType stypes.ShardIteratorType
 	Limit             *int32
+	Polling           StreamPolling
+}
+
+// StreamPolling controls how often the subscriber calls the stream. Zero fields use the defaults.
+type StreamPolling struct {
+	StartDelay      time.Duration // Wait before reading the first shards (defaults to 10s)
+	RefreshInterval time.Duration // How often to look for new shards (defaults to 1m)
+	RecordsInterval time.Duration // Wait between reads of a shard that returned records (defaults to 1s)
+	IdleInterval    time.Duration // Wait after a read that returned no records (defaults to 10s)
+	MaxShards       int           // Maximum number of shards read at the same time (defaults to 5)
+}
+
+// withDefaults returns p with the zero fields set to their defaults
+func (p StreamPolling) withDefaults() StreamPolling {
+	if p.StartDelay <= 0 {
+		p.StartDelay = 10 * time.Second
+	}
+	if p.RefreshInterval <= 0 {
+		p.RefreshInterval = time.Minute
+	}
+	if p.RecordsInterval <= 0 {
+		p.RecordsInterval = time.Second
+	}
+	if p.IdleInterval <= 0 {
+		p.IdleInterval = 10 * time.Second
+	}
+	if p.MaxShards <= 0 {
+		p.MaxShards = 5
+	}
+	return p
 }
 
 // NewStreamSubscriberV2 creates a new StreamSubscriberV2 instance
 func NewStreamSubscriberV2(
-	dynamoSvc *dynamodb.Client,
-	streamSvc *dynamodbstreams.Client,
+	dynamoSvc TableAPI,
+	streamSvc StreamAPI,
 	table string,
 ) *StreamSubscriberV2 {
 	s := &StreamSubscriberV2{
@@ -53,8 +91,8 @@ func NewStreamSubscriberV2(
 
 // NewStreamSubscriberV2WithArn creates a new StreamSubscriberV2 instance with a specific stream ARN
 func NewStreamSubscriberV2WithArn(
-	dynamoSvc *dynamodb.Client,
-	streamSvc *dynamodbstreams.Client,
+	dynamoSvc TableAPI,
+	streamSvc StreamAPI,
 	table, streamArn string,
 ) *StreamSubscriberV2 {
 	s := &StreamSubscriberV2{
@@ -82,6 +120,11 @@ func (s *StreamSubscriberV2) SetShardIteratorType(t stypes.ShardIteratorType) {
 	s.ShardIteratorType = t
 }
 
+// SetPolling sets the polling intervals, zero fields use the defaults
+func (s *StreamSubscriberV2) SetPolling(p StreamPolling) {
+	s.Polling = p
+}
+
 // SetStreamArn sets the explicit stream ARN to use
 func (s *StreamSubscriberV2) SetStreamArn(arn string) {
 	s.streamArn = arn
@@ -91,37 +134,37 @@ func (s *StreamSubscriberV2) SetStreamArn(arn string) {
 // 1. Find the "latest" or "next" Shard.
 // 2. Read data sequentially and send it to the Channel.
 // 3. If the Shard is closed (Iterator == nil), sleep for 10ms and retry.
-func (s *StreamSubscriberV2) GetStreamData() (<-chan *stypes.Record, <-chan error) {
+func (s *StreamSubscriberV2) GetStreamData(ctx context.Context) (<-chan *stypes.Record, <-chan error) {
+	polling := s.Polling.withDefaults()
 	recCh := make(chan *stypes.Record, 1)
 	errCh := make(chan error, 1)
 
 	go func() {
+		defer recoverStream(ctx, errCh)
 		var shardID *string
 		var prevShardID *string
 		var arn *string
 		var err error
 
-		ctx := context.Background()
-
-		for {
+		for ctx.Err() == nil {
 			prevShardID = shardID
 			shardID, arn, err = s.findProperShardID(ctx, prevShardID)
 			if err != nil {
-				errCh <- err
+				sendError(ctx, errCh, err)
 			}
 			if shardID != nil {
 				if err = s.processShard(ctx, &dynamodbstreams.GetShardIteratorInput{
 					StreamArn:         arn,
 					ShardId:           shardID,
 					ShardIteratorType: s.ShardIteratorType,
-				}, recCh); err != nil {
-					errCh <- err
+				}, polling, recCh); err != nil {
+					sendError(ctx, errCh, err)
 					// Process the same shard again
 					shardID = prevShardID
 				}
 			}
 			if shardID == nil {
-				time.Sleep(10 * time.Second)
+				sleepContext(ctx, polling.IdleInterval)
 			}
 		}
 	}()
@@ -130,8 +173,9 @@ func (s *StreamSubscriberV2) GetStreamData() (<-chan *stypes.Record, <-chan erro
 }
 
 // GetStreamDataAsync can process multiple Shards concurrently and checks for new Shards
-// periodically (every 1m). Default concurrency limit is 5.
-func (s *StreamSubscriberV2) GetStreamDataAsync() (<-chan *stypes.Record, <-chan error) {
+// periodically (every 1m by default). Default concurrency limit is 5.
+func (s *StreamSubscriberV2) GetStreamDataAsync(ctx context.Context) (<-chan *stypes.Record, <-chan error) {
+	polling := s.Polling.withDefaults()
 	recCh := make(chan *stypes.Record, 1)
 	errCh := make(chan error, 1)
 
@@ -139,43 +183,73 @@ func (s *StreamSubscriberV2) GetStreamDataAsync() (<-chan *stypes.Record, <-chan
 	needUpdate <- struct{}{}
 
 	allShards := make(map[string]struct{})
-	shardProcessingLimit := 5
+	shardProcessingLimit := polling.MaxShards
 	shardsCh := make(chan *dynamodbstreams.GetShardIteratorInput, shardProcessingLimit)
 	var lock sync.Mutex
 
-	// Push update request once per minute
+	// Push update request once per refresh interval
 	go func() {
-		ticker := time.NewTicker(time.Minute)
-		for range ticker.C {
-			needUpdate <- struct{}{}
+		defer recoverStream(ctx, errCh)
+		ticker := time.NewTicker(polling.RefreshInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				select {
+				case needUpdate <- struct{}{}:
+				default: // An update is already pending
+				}
+			case <-ctx.Done():
+				return
+			}
 		}
 	}()
 
 	// Listen for update signals and generate shards to process
 	go func() {
-		ctx := context.Background()
-		for range needUpdate {
+		defer recoverStream(ctx, errCh)
+		for {
+			select {
+			case <-needUpdate:
+			case <-ctx.Done():
+				return
+			}
+
 			arn, err := s.getLatestStreamArn(ctx)
 			if err != nil {
-				errCh <- err
+				sendError(ctx, errCh, fmt.Errorf("%w: %w", ErrStreamStopped, err))
 				return
 			}
 			ids, err := s.getShardIDs(ctx, arn)
 			if err != nil {
-				errCh <- err
+				sendError(ctx, errCh, fmt.Errorf("%w: %w", ErrStreamStopped, err))
 				return
 			}
 			for _, shard := range ids {
 				lock.Lock()
-				if _, ok := allShards[*shard.ShardId]; !ok {
-					allShards[*shard.ShardId] = struct{}{}
-					shardsCh <- &dynamodbstreams.GetShardIteratorInput{
-						StreamArn:         arn,
-						ShardId:           shard.ShardId,
-						ShardIteratorType: s.ShardIteratorType,
-					}
-				}
+				_, seen := allShards[*shard.ShardId]
+				_, parentSeen := allShards[aws.ToString(shard.ParentShardId)]
+				allShards[*shard.ShardId] = struct{}{}
 				lock.Unlock()
+				if seen {
+					continue
+				}
+
+				// A shard split from a shard being read continues it, so read it from the start
+				iteratorType := s.ShardIteratorType
+				if parentSeen {
+					iteratorType = stypes.ShardIteratorTypeTrimHorizon
+				}
+
+				select {
+				case shardsCh <- &dynamodbstreams.GetShardIteratorInput{
+					StreamArn:         arn,
+					ShardId:           shard.ShardId,
+					ShardIteratorType: iteratorType,
+				}:
+				case <-ctx.Done():
+					return
+				}
 			}
 		}
 	}()
@@ -183,15 +257,27 @@ func (s *StreamSubscriberV2) GetStreamDataAsync() (<-chan *stypes.Record, <-chan
 	limit := make(chan struct{}, shardProcessingLimit)
 
 	go func() {
-		time.Sleep(10 * time.Second)
-		for shardInput := range shardsCh {
-			limit <- struct{}{}
+		defer recoverStream(ctx, errCh)
+		sleepContext(ctx, polling.StartDelay)
+		for {
+			var shardInput *dynamodbstreams.GetShardIteratorInput
+			select {
+			case shardInput = <-shardsCh:
+			case <-ctx.Done():
+				return
+			}
+
+			select {
+			case limit <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
 			go func(input *dynamodbstreams.GetShardIteratorInput) {
-				ctx := context.Background()
-				if err := s.processShard(ctx, input, recCh); err != nil {
-					errCh <- err
+				defer func() { <-limit }()
+				defer recoverStream(ctx, errCh)
+				if err := s.processShard(ctx, input, polling, recCh); err != nil {
+					sendError(ctx, errCh, err)
 				}
-				<-limit
 			}(shardInput)
 		}
 	}()
@@ -202,16 +288,33 @@ func (s *StreamSubscriberV2) GetStreamDataAsync() (<-chan *stypes.Record, <-chan
 // ----------------- Private Helper Methods -----------------
 
 func (s *StreamSubscriberV2) getShardIDs(ctx context.Context, streamArn *string) ([]stypes.Shard, error) {
-	out, err := s.streamSvc.DescribeStream(ctx, &dynamodbstreams.DescribeStreamInput{
-		StreamArn: streamArn,
-	})
-	if err != nil {
-		return nil, err
+	var shards []stypes.Shard
+	var startShardID *string
+
+	// DescribeStream returns at most 100 shards per call
+	for {
+		out, err := s.streamSvc.DescribeStream(ctx, &dynamodbstreams.DescribeStreamInput{
+			StreamArn:             streamArn,
+			ExclusiveStartShardId: startShardID,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if out.StreamDescription == nil {
+			break
+		}
+		shards = append(shards, out.StreamDescription.Shards...)
+
+		startShardID = out.StreamDescription.LastEvaluatedShardId
+		if startShardID == nil {
+			break
+		}
 	}
-	if out.StreamDescription == nil || len(out.StreamDescription.Shards) == 0 {
+
+	if len(shards) == 0 {
 		return nil, nil
 	}
-	return out.StreamDescription.Shards, nil
+	return shards, nil
 }
 
 func (s *StreamSubscriberV2) findProperShardID(ctx context.Context, prevShardID *string) (*string, *string, error) {
@@ -257,7 +360,7 @@ func (s *StreamSubscriberV2) getLatestStreamArn(ctx context.Context) (*string, e
 	return out.Table.LatestStreamArn, nil
 }
 
-func (s *StreamSubscriberV2) processShard(ctx context.Context, input *dynamodbstreams.GetShardIteratorInput, recCh chan<- *stypes.Record) error {
+func (s *StreamSubscriberV2) processShard(ctx context.Context, input *dynamodbstreams.GetShardIteratorInput, polling StreamPolling, recCh chan<- *stypes.Record) error {
 	iterOut, err := s.streamSvc.GetShardIterator(ctx, input)
 	if err != nil {
 		return err
@@ -274,29 +377,74 @@ func (s *StreamSubscriberV2) processShard(ctx context.Context, input *dynamodbst
 			Limit:         s.Limit,
 		})
 		if err != nil {
-			var apiErr smithy.APIError
-			if errors.As(err, &apiErr) && apiErr.ErrorCode() == "TrimmedDataAccessException" {
-				// Trying to read data older than 24h, can be safely ignored
-				return nil
+			if !isTrimmedDataAccess(err) {
+				return err
 			}
-			return err
+
+			// The records behind the iterator are older than the stream retention (24h),
+			// continue from the oldest record still available
+			log.WithField("shard_id", aws.ToString(input.ShardId)).Warn("[STREAM] Records were trimmed before they were read, continuing from TRIM_HORIZON ⚠️")
+			iterOut, err = s.streamSvc.GetShardIterator(ctx, &dynamodbstreams.GetShardIteratorInput{
+				StreamArn:         input.StreamArn,
+				ShardId:           input.ShardId,
+				ShardIteratorType: stypes.ShardIteratorTypeTrimHorizon,
+			})
+			if err != nil {
+				return err
+			}
+			next = iterOut.ShardIterator
+			continue
 		}
 
 		for i := range recOut.Records {
 			// Address the record to avoid concurrency issues
 			rec := recOut.Records[i]
-			recCh <- &rec
+			select {
+			case recCh <- &rec:
+			case <-ctx.Done():
+				return nil
+			}
 		}
 
 		next = recOut.NextShardIterator
 
-		sleep := time.Second
+		sleep := polling.RecordsInterval
 		if next == nil {
 			sleep = 10 * time.Millisecond
 		} else if len(recOut.Records) == 0 {
-			sleep = 10 * time.Second
+			sleep = polling.IdleInterval
+		}
+		if !sleepContext(ctx, sleep) {
+			return nil
 		}
-		time.Sleep(sleep)
 	}
 	return nil
 }
+
+// recoverStream reports a panic of a subscriber goroutine as a stopped subscription, so
+// it cannot take down the process. Deferred by every goroutine of the subscriber.
+func recoverStream(ctx context.Context, errCh chan<- error) {
+	if r := recover(); r != nil {
+		sendError(ctx, errCh, fmt.Errorf("%w: panic: %v", ErrStreamStopped, r))
+	}
+}
+
+// sendError reports an error unless ctx is canceled first
+func sendError(ctx context.Context, errCh chan<- error, err error) {
+	select {
+	case errCh <- err:
+	case <-ctx.Done():
+	}
+}
+
+// sleepContext waits for d, returning false if ctx is canceled first
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
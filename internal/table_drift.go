@@ -0,0 +1,297 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	log "github.com/sirupsen/logrus"
+)
+
+// Drift categories, used to group and ignore differences
+const (
+	DriftCategoryKeySchema  = "key_schema"
+	DriftCategoryGSI        = "gsi"
+	DriftCategoryLSI        = "lsi"
+	DriftCategoryBilling    = "billing"
+	DriftCategoryCapacity   = "capacity"
+	DriftCategoryTableClass = "table_class"
+	DriftCategoryProtection = "protection"
+	DriftCategorySSE        = "sse"
+	DriftCategoryStream     = "stream"
+	DriftCategoryTTL        = "ttl"
+	DriftCategoryPITR       = "pitr"
+	DriftCategoryTags       = "tags"
+)
+
+// driftMissing is shown for a setting that only exists on one side
+const driftMissing = "(missing)"
+
+// TableDrift is one configuration setting that differs between the source and target table
+type TableDrift struct {
+	Category string
+	Setting  string
+	Source   string
+	Target   string
+}
+
+// DriftResult holds the differences found for one source/target table pair
+type DriftResult struct {
+	Table  string
+	Drifts []TableDrift
+	Error  string // Set if the tables could not be described
+}
+
+// DriftConfig contains the configuration of a table drift comparison
+type DriftConfig struct {
+	SourceClient TableAdminAPI
+	TargetClient TableAdminAPI
+	Tables       []TableConfig
+	Ignore       []string // Categories left out of the comparison (optional)
+}
+
+// tableSetting is a single normalized configuration value of a table
+type tableSetting struct {
+	category string
+	value    string
+}
+
+// RunDriftCheck compares the configuration of every source/target table pair and prints
+// the differences
+func RunDriftCheck(ctx context.Context, cfg DriftConfig) []DriftResult {
+	ignored := make(map[string]bool, len(cfg.Ignore))
+	for _, category := range cfg.Ignore {
+		ignored[strings.TrimSpace(category)] = true
+	}
+
+	results := make([]DriftResult, 0, len(cfg.Tables))
+	for _, table := range cfg.Tables {
+		log.Infof("[DRIFT] Comparing %s with %s", table.SourceTable, table.TargetTable)
+		result := DriftResult{Table: table.Name}
+
+		source, err := describeTableSettings(ctx, cfg.SourceClient, table.SourceTable)
+		if err != nil {
+			result.Error = fmt.Sprintf("source table %s: %v", table.SourceTable, err)
+			results = append(results, result)
+			continue
+		}
+		target, err := describeTableSettings(ctx, cfg.TargetClient, table.TargetTable)
+		if err != nil {
+			result.Error = fmt.Sprintf("target table %s: %v", table.TargetTable, err)
+			results = append(results, result)
+			continue
+		}
+
+		for _, drift := range diffTableSettings(source, target) {
+			if !ignored[drift.Category] {
+				result.Drifts = append(result.Drifts, drift)
+			}
+		}
+		results = append(results, result)
+	}
+
+	logDriftResults(results)
+	return results
+}
+
+// diffTableSettings returns every setting whose value differs, sorted by setting name
+func diffTableSettings(source, target map[string]tableSetting) []TableDrift {
+	names := make(map[string]struct{}, len(source)+len(target))
+	for name := range source {
+		names[name] = struct{}{}
+	}
+	for name := range target {
+		names[name] = struct{}{}
+	}
+
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	var drifts []TableDrift
+	for _, name := range sorted {
+		s, inSource := source[name]
+		t, inTarget := target[name]
+		if inSource && inTarget && s.value == t.value {
+			continue
+		}
+
+		drift := TableDrift{Setting: name, Source: driftMissing, Target: driftMissing}
+		if inSource {
+			drift.Category = s.category
+			drift.Source = s.value
+		}
+		if inTarget {
+			drift.Category = t.category
+			drift.Target = t.value
+		}
+		drifts = append(drifts, drift)
+	}
+	return drifts
+}
+
+// describeTableSettings reads the configuration of a table into normalized settings. Only
+// DescribeTable is required, the other calls are recorded as unavailable if they fail.
+func describeTableSettings(ctx context.Context, client TableAdminAPI, tableName string) (map[string]tableSetting, error) {
+	out, err := client.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(tableName)})
+	if err != nil {
+		return nil, errors.New(describeAWSError(err))
+	}
+	desc := out.Table
+	settings := make(map[string]tableSetting)
+	set := func(category, name, value string) {
+		settings[name] = tableSetting{category: category, value: value}
+	}
+
+	// Keys and capacity
+	set(DriftCategoryKeySchema, "key schema", formatKeySchema(desc))
+
+	billingMode := types.BillingModeProvisioned
+	if desc.BillingModeSummary != nil && desc.BillingModeSummary.BillingMode != "" {
+		billingMode = desc.BillingModeSummary.BillingMode
+	}
+	set(DriftCategoryBilling, "billing mode", string(billingMode))
+	if billingMode == types.BillingModeProvisioned {
+		set(DriftCategoryCapacity, "capacity", formatThroughput(desc.ProvisionedThroughput))
+	}
+
+	// Indexes
+	for _, gsi := range desc.GlobalSecondaryIndexes {
+		name := "gsi " + aws.ToString(gsi.IndexName)
+		set(DriftCategoryGSI, name+" key schema", formatKeySchemaElements(desc, gsi.KeySchema))
+		set(DriftCategoryGSI, name+" projection", formatProjection(gsi.Projection))
+		if billingMode == types.BillingModeProvisioned {
+			set(DriftCategoryCapacity, name+" capacity", formatThroughput(gsi.ProvisionedThroughput))
+		}
+	}
+	for _, lsi := range desc.LocalSecondaryIndexes {
+		name := "lsi " + aws.ToString(lsi.IndexName)
+		set(DriftCategoryLSI, name+" key schema", formatKeySchemaElements(desc, lsi.KeySchema))
+		set(DriftCategoryLSI, name+" projection", formatProjection(lsi.Projection))
+	}
+
+	// Table class and deletion protection
+	tableClass := types.TableClassStandard
+	if desc.TableClassSummary != nil && desc.TableClassSummary.TableClass != "" {
+		tableClass = desc.TableClassSummary.TableClass
+	}
+	set(DriftCategoryTableClass, "table class", string(tableClass))
+	set(DriftCategoryProtection, "deletion protection", fmt.Sprint(aws.ToBool(desc.DeletionProtectionEnabled)))
+
+	// Encryption at rest, the key ARN differs between accounts so only the type is compared
+	sse := "DEFAULT (AWS owned key)"
+	if desc.SSEDescription != nil && desc.SSEDescription.Status == types.SSEStatusEnabled {
+		sse = string(desc.SSEDescription.SSEType)
+	}
+	set(DriftCategorySSE, "encryption", sse)
+
+	// Stream specification
+	stream := "disabled"
+	if desc.StreamSpecification != nil && aws.ToBool(desc.StreamSpecification.StreamEnabled) {
+		stream = string(desc.StreamSpecification.StreamViewType)
+	}
+	set(DriftCategoryStream, "stream", stream)
+
+	// Time to live
+	ttlOut, err := client.DescribeTimeToLive(ctx, &dynamodb.DescribeTimeToLiveInput{TableName: aws.String(tableName)})
+	switch {
+	case err != nil:
+		set(DriftCategoryTTL, "ttl", "unavailable: "+describeAWSError(err))
+	case ttlOut.TimeToLiveDescription == nil:
+		set(DriftCategoryTTL, "ttl", string(types.TimeToLiveStatusDisabled))
+	default:
+		ttl := string(ttlOut.TimeToLiveDescription.TimeToLiveStatus)
+		if attr := aws.ToString(ttlOut.TimeToLiveDescription.AttributeName); attr != "" {
+			ttl += " on " + attr
+		}
+		set(DriftCategoryTTL, "ttl", ttl)
+	}
+
+	// Point-in-time recovery
+	backupOut, err := client.DescribeContinuousBackups(ctx, &dynamodb.DescribeContinuousBackupsInput{TableName: aws.String(tableName)})
+	switch {
+	case err != nil:
+		set(DriftCategoryPITR, "point-in-time recovery", "unavailable: "+describeAWSError(err))
+	case backupOut.ContinuousBackupsDescription == nil || backupOut.ContinuousBackupsDescription.PointInTimeRecoveryDescription == nil:
+		set(DriftCategoryPITR, "point-in-time recovery", string(types.PointInTimeRecoveryStatusDisabled))
+	default:
+		set(DriftCategoryPITR, "point-in-time recovery",
+			string(backupOut.ContinuousBackupsDescription.PointInTimeRecoveryDescription.PointInTimeRecoveryStatus))
+	}
+
+	// Tags
+	var nextToken *string
+	for {
+		tagsOut, err := client.ListTagsOfResource(ctx, &dynamodb.ListTagsOfResourceInput{
+			ResourceArn: desc.TableArn,
+			NextToken:   nextToken,
+		})
+		if err != nil {
+			set(DriftCategoryTags, "tags", "unavailable: "+describeAWSError(err))
+			break
+		}
+		for _, tag := range tagsOut.Tags {
+			set(DriftCategoryTags, "tag "+aws.ToString(tag.Key), aws.ToString(tag.Value))
+		}
+		nextToken = tagsOut.NextToken
+		if nextToken == nil {
+			break
+		}
+	}
+
+	return settings, nil
+}
+
+// formatProjection describes an index projection, e.g. "INCLUDE [email, name]"
+func formatProjection(p *types.Projection) string {
+	if p == nil {
+		return ""
+	}
+	if len(p.NonKeyAttributes) == 0 {
+		return string(p.ProjectionType)
+	}
+	attrs := append([]string(nil), p.NonKeyAttributes...)
+	sort.Strings(attrs)
+	return fmt.Sprintf("%s [%s]", p.ProjectionType, strings.Join(attrs, ", "))
+}
+
+// formatThroughput describes provisioned capacity, e.g. "RCU=5 WCU=5"
+func formatThroughput(t *types.ProvisionedThroughputDescription) string {
+	if t == nil {
+		return ""
+	}
+	return fmt.Sprintf("RCU=%d WCU=%d", aws.ToInt64(t.ReadCapacityUnits), aws.ToInt64(t.WriteCapacityUnits))
+}
+
+// logDriftResults prints the differences of every table pair followed by a summary
+func logDriftResults(results []DriftResult) {
+	log.Infof("========= Table Drift Report =========")
+	drifted := 0
+	for _, r := range results {
+		switch {
+		case r.Error != "":
+			drifted++
+			log.Errorf("❌ [%s] could not compare: %s", r.Table, r.Error)
+		case len(r.Drifts) == 0:
+			log.Infof("✅ [%s] no configuration drift", r.Table)
+		default:
+			drifted++
+			for _, d := range r.Drifts {
+				log.WithFields(log.Fields{
+					"category": d.Category,
+					"source":   d.Source,
+					"target":   d.Target,
+				}).Errorf("❌ [%s] %s differs", r.Table, d.Setting)
+			}
+		}
+	}
+	log.Infof("Summary: %d of %d tables drifted", drifted, len(results))
+	log.Infof("========================================")
+}
@@ -0,0 +1,149 @@
+package internal
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go"
+)
+
+// newSettingsTable returns a table with an index, a stream, TTL, point-in-time recovery
+// and a tag, changed by modify (optional)
+func newSettingsTable(name string, modify func(t *fakeTable)) *fakeTable {
+	t := newFakeTable(name, "pk", "sk")
+	t.definition = &types.TableDescription{
+		AttributeDefinitions: []types.AttributeDefinition{
+			{AttributeName: aws.String("pk"), AttributeType: types.ScalarAttributeTypeS},
+			{AttributeName: aws.String("sk"), AttributeType: types.ScalarAttributeTypeS},
+			{AttributeName: aws.String("email"), AttributeType: types.ScalarAttributeTypeS},
+		},
+		KeySchema: []types.KeySchemaElement{
+			{AttributeName: aws.String("pk"), KeyType: types.KeyTypeHash},
+			{AttributeName: aws.String("sk"), KeyType: types.KeyTypeRange},
+		},
+		BillingModeSummary: &types.BillingModeSummary{BillingMode: types.BillingModePayPerRequest},
+		GlobalSecondaryIndexes: []types.GlobalSecondaryIndexDescription{{
+			IndexName:  aws.String("by_email"),
+			KeySchema:  []types.KeySchemaElement{{AttributeName: aws.String("email"), KeyType: types.KeyTypeHash}},
+			Projection: &types.Projection{ProjectionType: types.ProjectionTypeInclude, NonKeyAttributes: []string{"status", "name"}},
+		}},
+		StreamSpecification: &types.StreamSpecification{StreamEnabled: aws.Bool(true), StreamViewType: types.StreamViewTypeNewAndOldImages},
+	}
+	t.ttl = &types.TimeToLiveDescription{TimeToLiveStatus: types.TimeToLiveStatusEnabled, AttributeName: aws.String("expiresAt")}
+	t.pitr = types.PointInTimeRecoveryStatusEnabled
+	t.tags = []types.Tag{{Key: aws.String("env"), Value: aws.String("prod")}}
+	if modify != nil {
+		modify(t)
+	}
+	return t
+}
+
+func TestRunDriftCheck(t *testing.T) {
+	tests := []struct {
+		name      string
+		target    func(t *fakeTable)
+		ignore    []string
+		want      []TableDrift
+		wantError bool
+	}{
+		{
+			name: "identical tables",
+		},
+		{
+			name:   "missing GSI",
+			target: func(t *fakeTable) { t.definition.GlobalSecondaryIndexes = nil },
+			want: []TableDrift{
+				{Category: DriftCategoryGSI, Setting: "gsi by_email key schema", Source: "email (S, HASH)", Target: driftMissing},
+				{Category: DriftCategoryGSI, Setting: "gsi by_email projection", Source: "INCLUDE [name, status]", Target: driftMissing},
+			},
+		},
+		{
+			name: "GSI projection",
+			target: func(t *fakeTable) {
+				t.definition.GlobalSecondaryIndexes[0].Projection = &types.Projection{ProjectionType: types.ProjectionTypeAll}
+			},
+			want: []TableDrift{
+				{Category: DriftCategoryGSI, Setting: "gsi by_email projection", Source: "INCLUDE [name, status]", Target: "ALL"},
+			},
+		},
+		{
+			name:   "TTL disabled",
+			target: func(t *fakeTable) { t.ttl = nil },
+			want: []TableDrift{
+				{Category: DriftCategoryTTL, Setting: "ttl", Source: "ENABLED on expiresAt", Target: "DISABLED"},
+			},
+		},
+		{
+			name:   "point-in-time recovery disabled",
+			target: func(t *fakeTable) { t.pitr = types.PointInTimeRecoveryStatusDisabled },
+			want: []TableDrift{
+				{Category: DriftCategoryPITR, Setting: "point-in-time recovery", Source: "ENABLED", Target: "DISABLED"},
+			},
+		},
+		{
+			name: "customer managed key",
+			target: func(t *fakeTable) {
+				t.definition.SSEDescription = &types.SSEDescription{Status: types.SSEStatusEnabled, SSEType: types.SSETypeKms}
+			},
+			want: []TableDrift{
+				{Category: DriftCategorySSE, Setting: "encryption", Source: "DEFAULT (AWS owned key)", Target: "KMS"},
+			},
+		},
+		{
+			name: "tags",
+			target: func(t *fakeTable) {
+				t.tags = []types.Tag{
+					{Key: aws.String("env"), Value: aws.String("staging")},
+					{Key: aws.String("owner"), Value: aws.String("migration")},
+				}
+			},
+			want: []TableDrift{
+				{Category: DriftCategoryTags, Setting: "tag env", Source: "prod", Target: "staging"},
+				{Category: DriftCategoryTags, Setting: "tag owner", Source: driftMissing, Target: "migration"},
+			},
+		},
+		{
+			name:   "ignored category",
+			target: func(t *fakeTable) { t.tags = nil },
+			ignore: []string{DriftCategoryTags},
+		},
+		{
+			name: "tags unavailable",
+			target: func(t *fakeTable) {
+				t.tagsErr = &smithy.GenericAPIError{Code: "AccessDeniedException", Message: "no ListTagsOfResource"}
+			},
+			want: []TableDrift{
+				{Category: DriftCategoryTags, Setting: "tag env", Source: "prod", Target: driftMissing},
+				{Category: DriftCategoryTags, Setting: "tags", Source: driftMissing, Target: "unavailable: not permitted: no ListTagsOfResource"},
+			},
+		},
+		{
+			name:      "missing target table",
+			target:    func(t *fakeTable) { t.name = "orders_old" },
+			wantError: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results := RunDriftCheck(context.Background(), DriftConfig{
+				SourceClient: newSettingsTable("orders", nil),
+				TargetClient: newSettingsTable("orders_v2", tt.target),
+				Tables:       []TableConfig{{Name: "orders", SourceTable: "orders", TargetTable: "orders_v2"}},
+				Ignore:       tt.ignore,
+			})
+
+			if len(results) != 1 {
+				t.Fatalf("got %d results, want 1", len(results))
+			}
+			if (results[0].Error != "") != tt.wantError {
+				t.Fatalf("error = %q, want an error: %t", results[0].Error, tt.wantError)
+			}
+			if !reflect.DeepEqual(results[0].Drifts, tt.want) {
+				t.Errorf("drifts = %+v, want %+v", results[0].Drifts, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,474 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// errTransformFailed wraps the errors of transforming a sampled record
+var errTransformFailed = errors.New("transform failed")
+
+// errMissingAttribute is returned when an expression refers to an attribute the stream
+// image does not have, which fails the transform unless the step is optional
+var errMissingAttribute = errors.New("missing attribute")
+
+// Transform computes the item expected in the verified table from the stream image, for
+// migrations that reshape items. It is a list of steps separated by semicolons:
+//
+//	pk=tenant42#${pk}; sk=${upper(sk)}; firstName=${split(name, " ", 0)}; -name
+//
+// An assignment sets an attribute to a template, where ${...} holds an expression: an
+// attribute of the stream image, a "string", an integer, or a function call. A template
+// made of a single expression keeps the type of its value, any other template is a string.
+// An assignment whose expression refers to an attribute the stream image does not have
+// fails, unless it is written name?=template, which then leaves the attribute out.
+// -name removes an attribute. Every expression reads the stream image, not the
+// result of earlier steps, and attributes that are not assigned are kept.
+type Transform struct {
+	spec  string
+	steps []transformStep
+}
+
+// transformStep sets or removes one attribute
+type transformStep struct {
+	attribute string
+	remove    bool
+	optional  bool // Leave the attribute out when the template refers to a missing attribute
+	template  []templatePart
+}
+
+// templatePart is a literal text or an expression of a template
+type templatePart struct {
+	literal string
+	expr    *transformExpr
+}
+
+// transformExpr is an attribute reference, a literal value or a function call
+type transformExpr struct {
+	attribute string
+	value     types.AttributeValue
+	function  string
+	args      []*transformExpr
+}
+
+// transformFunction evaluates a function on its arguments
+type transformFunction struct {
+	minArgs, maxArgs int
+	call             func(args []types.AttributeValue) (types.AttributeValue, error)
+}
+
+// transformFunctions are the functions expressions can call
+var transformFunctions = map[string]transformFunction{
+	"upper": {1, 1, stringFunction(func(args []string) (string, error) { return strings.ToUpper(args[0]), nil })},
+	"lower": {1, 1, stringFunction(func(args []string) (string, error) { return strings.ToLower(args[0]), nil })},
+	"trimprefix": {2, 2, stringFunction(func(args []string) (string, error) {
+		return strings.TrimPrefix(args[0], args[1]), nil
+	})},
+	"trimsuffix": {2, 2, stringFunction(func(args []string) (string, error) {
+		return strings.TrimSuffix(args[0], args[1]), nil
+	})},
+	"replace": {3, 3, stringFunction(func(args []string) (string, error) {
+		return strings.ReplaceAll(args[0], args[1], args[2]), nil
+	})},
+	// split(s, separator, index) returns one part, negative indexes count from the end
+	"split": {3, 3, stringFunction(func(args []string) (string, error) {
+		parts := strings.Split(args[0], args[1])
+		i, err := strconv.Atoi(args[2])
+		if err != nil {
+			return "", fmt.Errorf("split index %q is not an integer", args[2])
+		}
+		if i < 0 {
+			i += len(parts)
+		}
+		if i < 0 || i >= len(parts) {
+			return "", fmt.Errorf("split of %q by %q has no part %s", args[0], args[1], args[2])
+		}
+		return parts[i], nil
+	})},
+	// substr(s, start[, end]) returns the characters from start up to end
+	"substr": {2, 3, stringFunction(func(args []string) (string, error) {
+		runes := []rune(args[0])
+		start, err := strconv.Atoi(args[1])
+		end := len(runes)
+		if err == nil && len(args) == 3 {
+			end, err = strconv.Atoi(args[2])
+		}
+		if err != nil || start < 0 || start > end || end > len(runes) {
+			return "", fmt.Errorf("substr of %q has no characters %s", args[0], strings.Join(args[1:], " to "))
+		}
+		return string(runes[start:end]), nil
+	})},
+	// pad(s, width, char) pads s on the left to width characters, e.g. pad(id, 8, "0")
+	"pad": {3, 3, stringFunction(func(args []string) (string, error) {
+		width, err := strconv.Atoi(args[1])
+		if err != nil || len([]rune(args[2])) != 1 {
+			return "", fmt.Errorf("pad needs an integer width and a single character, got %q and %q", args[1], args[2])
+		}
+		if n := width - len([]rune(args[0])); n > 0 {
+			return strings.Repeat(args[2], n) + args[0], nil
+		}
+		return args[0], nil
+	})},
+	// number(s) converts a string to a number
+	"number": {1, 1, func(args []types.AttributeValue) (types.AttributeValue, error) {
+		s := scalarString(args[0])
+		if _, err := strconv.ParseFloat(s, 64); err != nil {
+			return nil, fmt.Errorf("%q is not a number", s)
+		}
+		return &types.AttributeValueMemberN{Value: s}, nil
+	}},
+	// string(v) converts a number or boolean to a string
+	"string": {1, 1, stringFunction(func(args []string) (string, error) { return args[0], nil })},
+	// attr(name) reads an attribute whose name is not an identifier
+	"attr": {1, 1, nil},
+}
+
+// stringFunction adapts a function of strings. Every argument must be a string, number or
+// boolean, and the result is a string.
+func stringFunction(fn func(args []string) (string, error)) func([]types.AttributeValue) (types.AttributeValue, error) {
+	return func(args []types.AttributeValue) (types.AttributeValue, error) {
+		strs := make([]string, len(args))
+		for i, arg := range args {
+			s, err := templateString(arg)
+			if err != nil {
+				return nil, err
+			}
+			strs[i] = s
+		}
+		s, err := fn(strs)
+		if err != nil {
+			return nil, err
+		}
+		return &types.AttributeValueMemberS{Value: s}, nil
+	}
+}
+
+// ParseTransform parses a transformation
+func ParseTransform(spec string) (*Transform, error) {
+	p := &transformParser{input: spec}
+	t := &Transform{spec: strings.TrimSpace(spec)}
+	for {
+		p.skipSpaces()
+		if p.done() {
+			break
+		}
+		if p.peek() == ';' {
+			p.pos++
+			continue
+		}
+
+		step, err := p.parseStep()
+		if err != nil {
+			return nil, fmt.Errorf("invalid transform at character %d: %w", p.pos+1, err)
+		}
+		t.steps = append(t.steps, step)
+	}
+	if len(t.steps) == 0 {
+		return nil, errors.New("transform has no step")
+	}
+	return t, nil
+}
+
+// String returns the transformation as it was written
+func (t *Transform) String() string {
+	return t.spec
+}
+
+// Apply returns the item expected in the verified table for the stream image. The image
+// is not modified.
+func (t *Transform) Apply(image map[string]types.AttributeValue) (map[string]types.AttributeValue, error) {
+	item := make(map[string]types.AttributeValue, len(image))
+	for name, av := range image {
+		item[name] = av
+	}
+
+	for _, step := range t.steps {
+		if step.remove {
+			delete(item, step.attribute)
+			continue
+		}
+		av, err := evalTemplate(step.template, image)
+		if step.optional && errors.Is(err, errMissingAttribute) {
+			delete(item, step.attribute)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("attribute %s: %w", step.attribute, err)
+		}
+		item[step.attribute] = av
+	}
+	return item, nil
+}
+
+// evalTemplate evaluates a template on the stream image
+func evalTemplate(template []templatePart, image map[string]types.AttributeValue) (types.AttributeValue, error) {
+	// A single expression keeps the type of its value
+	if len(template) == 1 && template[0].expr != nil {
+		return template[0].expr.eval(image)
+	}
+
+	var sb strings.Builder
+	for _, part := range template {
+		if part.expr == nil {
+			sb.WriteString(part.literal)
+			continue
+		}
+		av, err := part.expr.eval(image)
+		if err != nil {
+			return nil, err
+		}
+		s, err := templateString(av)
+		if err != nil {
+			return nil, err
+		}
+		sb.WriteString(s)
+	}
+	return &types.AttributeValueMemberS{Value: sb.String()}, nil
+}
+
+// eval evaluates the expression on the stream image
+func (e *transformExpr) eval(image map[string]types.AttributeValue) (types.AttributeValue, error) {
+	switch {
+	case e.value != nil:
+		return e.value, nil
+	case e.function == "":
+		return lookupAttribute(image, e.attribute)
+	}
+
+	args := make([]types.AttributeValue, len(e.args))
+	for i, arg := range e.args {
+		av, err := arg.eval(image)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = av
+	}
+	if e.function == "attr" {
+		name, err := templateString(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return lookupAttribute(image, name)
+	}
+	return transformFunctions[e.function].call(args)
+}
+
+// lookupAttribute returns an attribute of the stream image
+func lookupAttribute(image map[string]types.AttributeValue, name string) (types.AttributeValue, error) {
+	av, ok := image[name]
+	if !ok {
+		return nil, fmt.Errorf("%w %s", errMissingAttribute, name)
+	}
+	return av, nil
+}
+
+// templateString returns a string, number or boolean value as a string
+func templateString(av types.AttributeValue) (string, error) {
+	switch av.(type) {
+	case *types.AttributeValueMemberS, *types.AttributeValueMemberN, *types.AttributeValueMemberBOOL:
+		return scalarString(av), nil
+	}
+	return "", fmt.Errorf("%s cannot be used as text", formatAttributeValue(av))
+}
+
+// transformParser reads a transformation one character at a time
+type transformParser struct {
+	input string
+	pos   int
+}
+
+// done reports whether the whole input was read
+func (p *transformParser) done() bool {
+	return p.pos >= len(p.input)
+}
+
+// peek returns the next byte without reading it
+func (p *transformParser) peek() byte {
+	if p.done() {
+		return 0
+	}
+	return p.input[p.pos]
+}
+
+// skipSpaces skips white space
+func (p *transformParser) skipSpaces() {
+	for !p.done() && unicode.IsSpace(rune(p.peek())) {
+		p.pos++
+	}
+}
+
+// parseStep reads an assignment or a removal, up to the next semicolon
+func (p *transformParser) parseStep() (transformStep, error) {
+	if p.peek() == '-' {
+		p.pos++
+		end := strings.IndexByte(p.input[p.pos:], ';')
+		if end < 0 {
+			end = len(p.input) - p.pos
+		}
+		name := strings.TrimSpace(p.input[p.pos : p.pos+end])
+		p.pos += end
+		if name == "" {
+			return transformStep{}, errors.New("missing attribute name after -")
+		}
+		return transformStep{attribute: name, remove: true}, nil
+	}
+
+	eq := strings.IndexByte(p.input[p.pos:], '=')
+	if eq < 0 {
+		return transformStep{}, errors.New("expected name=template or -name")
+	}
+	name := strings.TrimSpace(p.input[p.pos : p.pos+eq])
+	optional := strings.HasSuffix(name, "?")
+	if optional {
+		name = strings.TrimSpace(strings.TrimSuffix(name, "?"))
+	}
+	if name == "" || strings.ContainsAny(name, ";${}?") {
+		return transformStep{}, fmt.Errorf("invalid attribute name %q", name)
+	}
+	p.pos += eq + 1
+
+	template, err := p.parseTemplate()
+	if err != nil {
+		return transformStep{}, err
+	}
+	return transformStep{attribute: name, optional: optional, template: template}, nil
+}
+
+// parseTemplate reads literal text and ${...} expressions up to the next semicolon.
+// Spaces around the template are dropped.
+func (p *transformParser) parseTemplate() ([]templatePart, error) {
+	var parts []templatePart
+	var literal strings.Builder
+	flush := func() {
+		if literal.Len() > 0 {
+			parts = append(parts, templatePart{literal: literal.String()})
+			literal.Reset()
+		}
+	}
+
+	p.skipSpaces()
+	for !p.done() && p.peek() != ';' {
+		if strings.HasPrefix(p.input[p.pos:], "${") {
+			flush()
+			p.pos += 2
+			p.skipSpaces()
+			expr, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			p.skipSpaces()
+			if p.peek() != '}' {
+				return nil, errors.New("expected } after the expression")
+			}
+			p.pos++
+			parts = append(parts, templatePart{expr: expr})
+			continue
+		}
+		literal.WriteByte(p.peek())
+		p.pos++
+	}
+
+	// Spaces before the semicolon are not part of the template
+	text := strings.TrimRightFunc(literal.String(), unicode.IsSpace)
+	literal.Reset()
+	literal.WriteString(text)
+	flush()
+	if len(parts) == 0 {
+		return nil, errors.New("empty template")
+	}
+	return parts, nil
+}
+
+// parseExpr reads an attribute name, a quoted string, an integer or a function call
+func (p *transformParser) parseExpr() (*transformExpr, error) {
+	c := p.peek()
+	switch {
+	case c == '"':
+		s, err := p.parseString()
+		if err != nil {
+			return nil, err
+		}
+		return &transformExpr{value: &types.AttributeValueMemberS{Value: s}}, nil
+	case c == '-' || (c >= '0' && c <= '9'):
+		start := p.pos
+		p.pos++
+		for !p.done() && p.peek() >= '0' && p.peek() <= '9' {
+			p.pos++
+		}
+		if _, err := strconv.Atoi(p.input[start:p.pos]); err != nil {
+			return nil, fmt.Errorf("invalid integer %q", p.input[start:p.pos])
+		}
+		return &transformExpr{value: &types.AttributeValueMemberN{Value: p.input[start:p.pos]}}, nil
+	case c == '_' || unicode.IsLetter(rune(c)):
+	default:
+		return nil, errors.New("expected an attribute, a string, an integer or a function call")
+	}
+
+	start := p.pos
+	for !p.done() && (p.peek() == '_' || p.peek() == '.' || unicode.IsLetter(rune(p.peek())) || unicode.IsDigit(rune(p.peek()))) {
+		p.pos++
+	}
+	name := p.input[start:p.pos]
+	p.skipSpaces()
+	if p.peek() != '(' {
+		return &transformExpr{attribute: name}, nil
+	}
+
+	fn, ok := transformFunctions[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %s", name)
+	}
+	p.pos++
+	expr := &transformExpr{function: name}
+	for {
+		p.skipSpaces()
+		if p.peek() == ')' && len(expr.args) == 0 {
+			p.pos++
+			break
+		}
+		arg, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		expr.args = append(expr.args, arg)
+		p.skipSpaces()
+		if p.peek() == ',' {
+			p.pos++
+			continue
+		}
+		if p.peek() != ')' {
+			return nil, fmt.Errorf("expected , or ) in the arguments of %s", name)
+		}
+		p.pos++
+		break
+	}
+	if n := len(expr.args); n < fn.minArgs || n > fn.maxArgs {
+		if fn.minArgs == fn.maxArgs {
+			return nil, fmt.Errorf("%s takes %d arguments, got %d", name, fn.minArgs, n)
+		}
+		return nil, fmt.Errorf("%s takes %d to %d arguments, got %d", name, fn.minArgs, fn.maxArgs, n)
+	}
+	return expr, nil
+}
+
+// parseString reads a double-quoted string with Go escapes
+func (p *transformParser) parseString() (string, error) {
+	start := p.pos
+	p.pos++
+	for !p.done() && p.peek() != '"' {
+		if p.peek() == '\\' {
+			p.pos++
+		}
+		p.pos++
+	}
+	if p.done() {
+		return "", errors.New("unterminated string")
+	}
+	p.pos++
+	return strconv.Unquote(p.input[start:p.pos])
+}
@@ -0,0 +1,109 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func TestTransformApply(t *testing.T) {
+	image := map[string]types.AttributeValue{
+		"pk":     &types.AttributeValueMemberS{Value: "USER#42"},
+		"sk":     &types.AttributeValueMemberS{Value: "2024-05-01"},
+		"name":   &types.AttributeValueMemberS{Value: "Ann Lee"},
+		"amount": &types.AttributeValueMemberN{Value: "7"},
+		"id":     &types.AttributeValueMemberN{Value: "42"},
+		"flags":  &types.AttributeValueMemberSS{Value: []string{"a"}},
+		"odd":    &types.AttributeValueMemberS{Value: "x;y"},
+	}
+
+	tests := []struct {
+		spec string
+		want map[string]string // Attribute to its value as formatted, "" if it must be missing
+	}{
+		{`pk=tenant42#${pk}`, map[string]string{"pk": "tenant42#USER#42", "sk": "2024-05-01"}},
+		{`sk=ORDER#${replace(sk, "-", "")}`, map[string]string{"sk": "ORDER#20240501"}},
+		{`first=${split(name, " ", 0)}; last=${split(name, " ", -1)}; -name`, map[string]string{"first": "Ann", "last": "Lee", "name": ""}},
+		{`total=${amount}; amount=${string(amount)}`, map[string]string{"total": "7", "amount": "7"}},
+		{`id=${pad(id, 6, "0")}; year=${number(substr(sk, 0, 4))}`, map[string]string{"id": "000042", "year": "2024"}},
+		{`copy=${attr("odd")}; upper=${upper(trimprefix(pk, "USER#"))}`, map[string]string{"copy": "x;y", "upper": "42"}},
+		{`note?=${missing}; pk ?= ${pk}#${missing}`, map[string]string{"note": "", "pk": ""}},
+		{`note?=${upper(name)}`, map[string]string{"note": "ANN LEE"}},
+		{` pk = ${ pk } ; `, map[string]string{"pk": "USER#42"}},
+	}
+	for _, tt := range tests {
+		transform, err := ParseTransform(tt.spec)
+		if err != nil {
+			t.Errorf("ParseTransform(%q): %v", tt.spec, err)
+			continue
+		}
+		item, err := transform.Apply(image)
+		if err != nil {
+			t.Errorf("%q: %v", tt.spec, err)
+			continue
+		}
+		for name, want := range tt.want {
+			av, ok := item[name]
+			switch {
+			case want == "" && ok:
+				t.Errorf("%q: %s = %s, want it removed", tt.spec, name, formatAttributeValue(av))
+			case want != "" && (!ok || formatAttributeValue(av) != want):
+				t.Errorf("%q: %s = %v, want %s", tt.spec, name, av, want)
+			}
+		}
+	}
+
+	// A single expression keeps the type of its value, a template is a string
+	transform, _ := ParseTransform(`total=${amount}; label=#${amount}`)
+	item, _ := transform.Apply(image)
+	if _, ok := item["total"].(*types.AttributeValueMemberN); !ok {
+		t.Errorf("total = %#v, want a number", item["total"])
+	}
+	if _, ok := item["label"].(*types.AttributeValueMemberS); !ok {
+		t.Errorf("label = %#v, want a string", item["label"])
+	}
+	if _, ok := image["label"]; ok {
+		t.Error("Apply modified the stream image")
+	}
+}
+
+func TestTransformErrors(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"pk",
+		"pk=",
+		"-",
+		"pk=${pk",
+		"pk=${unknown(pk)}",
+		"pk=${upper(pk, sk)}",
+		`pk=${split(pk, "#)}`,
+		"pk=${(pk)}",
+		"?=${pk}",
+		"p?k=${pk}",
+	} {
+		if _, err := ParseTransform(spec); err == nil {
+			t.Errorf("ParseTransform(%q): expected an error", spec)
+		}
+	}
+
+	image := map[string]types.AttributeValue{
+		"pk":    &types.AttributeValueMemberS{Value: "a#b"},
+		"flags": &types.AttributeValueMemberSS{Value: []string{"a"}},
+	}
+	for _, spec := range []string{
+		`pk=${split(pk, "#", 5)}`,
+		`pk=${number(pk)}`,
+		`pk=${substr(pk, 2, 9)}`,
+		`pk=x${flags}`,
+		`note=${missing}`,
+		`pk=${pk}#${upper(missing)}`,
+	} {
+		transform, err := ParseTransform(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := transform.Apply(image); err == nil {
+			t.Errorf("%q: expected an error", spec)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package monitor
+
+import (
+	"context"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodbstreams"
+	streamtypes "github.com/aws/aws-sdk-go-v2/service/dynamodbstreams/types"
+)
+
+const testStreamArn = "arn:aws:dynamodb:ap-northeast-1:123456789012:table/orders/stream/2025-01-01T00:00:00.000"
+
+// fakeTable is a TableAdminAPI that is never called, the methods of the embedded nil
+// interface panic
+type fakeTable struct {
+	TableAdminAPI
+}
+
+// fakeStream is a StreamAPI of a stream without shards, whose DescribeStream fails with
+// err when it is set
+type fakeStream struct {
+	StreamAPI
+
+	mu       sync.Mutex
+	err      error
+	describe int // Number of DescribeStream calls
+}
+
+// DescribeStream implements StreamAPI
+func (s *fakeStream) DescribeStream(_ context.Context, params *dynamodbstreams.DescribeStreamInput, _ ...func(*dynamodbstreams.Options)) (*dynamodbstreams.DescribeStreamOutput, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.describe++
+	if s.err != nil {
+		return nil, s.err
+	}
+	return &dynamodbstreams.DescribeStreamOutput{StreamDescription: &streamtypes.StreamDescription{
+		StreamArn:    params.StreamArn,
+		StreamStatus: streamtypes.StreamStatusEnabled,
+		TableName:    aws.String("orders"),
+	}}, nil
+}
+
+// describeCalls returns the number of DescribeStream calls
+func (s *fakeStream) describeCalls() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.describe
+}
+
+// testClients returns clients backed by the fakes
+func testClients(stream *fakeStream) *Clients {
+	return &Clients{
+		SourceClient: &fakeTable{},
+		TargetClient: &fakeTable{},
+		StreamClient: stream,
+	}
+}
@@ -0,0 +1,138 @@
+// Package monitor exposes the stream subscriber and the stream verifier of the DynamoDB
+// Migration Monitor, so other Go programs can embed them instead of running the binary.
+//
+//	clients, err := monitor.NewClients(ctx, monitor.ClientConfig{
+//		SourceProfile: "source",
+//		TargetProfile: "target",
+//	})
+//	if err != nil { ... }
+//	verifier, err := monitor.NewVerifier(clients,
+//		monitor.WithStreamArn(streamArn),
+//		monitor.WithTables("orders", "orders"),
+//		monitor.WithKeys("pk", "sk"),
+//		monitor.WithVerifyOn(monitor.VerifyOnTarget),
+//		monitor.WithObserver(observer),
+//	)
+//	if err != nil { ... }
+//	stats := verifier.Run(ctx) // Blocks until ctx is canceled or the stream stops (stats.StopErr)
+package monitor
+
+import (
+	"context"
+
+	streamtypes "github.com/aws/aws-sdk-go-v2/service/dynamodbstreams/types"
+	"github.com/yotsuba1022/dynamodb-migration-monitor/internal"
+)
+
+// Iterator types, where to start reading shards
+const (
+	IteratorTypeLatest      = "LATEST"
+	IteratorTypeTrimHorizon = "TRIM_HORIZON"
+)
+
+// Tables that sampled records can be verified against
+const (
+	VerifyOnSource = "source"
+	VerifyOnTarget = "target"
+)
+
+type (
+	// ClientConfig describes how the source, target and stream clients are created
+	ClientConfig = internal.ClientConfig
+
+	// Clients holds the source, target and stream clients
+	Clients = internal.DynamoDBClients
+
+	// TableAPI is the part of the DynamoDB client used to read and write tables
+	TableAPI = internal.TableAPI
+
+	// TableAdminAPI is TableAPI with the calls that read the settings of a table and create one
+	TableAdminAPI = internal.TableAdminAPI
+
+	// StreamAPI is the part of the DynamoDB Streams client used to read a stream
+	StreamAPI = internal.StreamAPI
+
+	// Record is a DynamoDB stream record
+	Record = streamtypes.Record
+
+	// ValidationRecord is a sampled stream record waiting to be validated
+	ValidationRecord = internal.ValidationRecord
+
+	// ValidationResult is the outcome of validating one sampled record
+	ValidationResult = internal.ValidationResult
+
+	// RepairOutcome describes what the repairer did with a failed record
+	RepairOutcome = internal.RepairOutcome
+
+	// Stats is a snapshot of the verification statistics
+	Stats = internal.StatsSummary
+
+	// ValidationConfig tunes buffering, waiting and reporting of the verifier
+	ValidationConfig = internal.ValidationConfig
+
+	// SamplePlanConfig describes the precision the sample rate is planned for
+	SamplePlanConfig = internal.SamplePlanConfig
+
+	// AdaptiveSamplingConfig describes how sampling escalates when validations fail
+	AdaptiveSamplingConfig = internal.AdaptiveSamplingConfig
+
+	// EventFilterConfig selects the stream records that are counted and validated
+	EventFilterConfig = internal.EventFilterConfig
+
+	// CompareRules describe the differences expected between the stream image and the verified item
+	CompareRules = internal.CompareRules
+
+	// AttributeDiff is one attribute that differs between the stream image and the verified item
+	AttributeDiff = internal.AttributeDiff
+)
+
+// ErrStreamStopped is wrapped by the error after which no more stream records are read,
+// sent by a Subscriber or set as Stats.StopErr
+var ErrStreamStopped = internal.ErrStreamStopped
+
+// NewClients creates the source, target and stream clients, with the same profile, role,
+// endpoint and region handling as the binary
+func NewClients(ctx context.Context, cfg ClientConfig) (*Clients, error) {
+	return internal.NewDynamoDBClients(ctx, cfg)
+}
+
+// DefaultValidationConfig returns the default validation configuration
+func DefaultValidationConfig() ValidationConfig {
+	return internal.DefaultValidationConfig()
+}
+
+// Observer receives the events of a verification run. OnValidation may be called from
+// several goroutines at once.
+type Observer interface {
+	OnRecord(rec *Record)                 // Every stream record received
+	OnValidation(result ValidationResult) // Every sampled record once it has been validated
+	OnStats(stats Stats)                  // A snapshot every time the statistics are printed
+}
+
+// ObserverFuncs is an Observer built from functions, nil functions are skipped
+type ObserverFuncs struct {
+	Record     func(*Record)
+	Validation func(ValidationResult)
+	Stats      func(Stats)
+}
+
+// OnRecord calls f.Record if it is set
+func (f ObserverFuncs) OnRecord(rec *Record) {
+	if f.Record != nil {
+		f.Record(rec)
+	}
+}
+
+// OnValidation calls f.Validation if it is set
+func (f ObserverFuncs) OnValidation(result ValidationResult) {
+	if f.Validation != nil {
+		f.Validation(result)
+	}
+}
+
+// OnStats calls f.Stats if it is set
+func (f ObserverFuncs) OnStats(stats Stats) {
+	if f.Stats != nil {
+		f.Stats(stats)
+	}
+}
@@ -0,0 +1,192 @@
+package monitor
+
+import "time"
+
+// Option configures a Verifier or a Subscriber. Options that do not apply to a Subscriber
+// are ignored by NewSubscriber.
+type Option func(*options)
+
+// options holds the settings collected from the options
+type options struct {
+	name         string
+	streamArn    string
+	sourceTable  string
+	targetTable  string
+	partitionKey string
+	sortKey      string
+	sampleRate   int
+	sampleSalt   string
+	confidence   float64
+	samplePlan   *SamplePlanConfig
+	adaptive     *AdaptiveSamplingConfig
+	eventFilter  *EventFilterConfig
+	lookupAttrs  []string
+	compare      *CompareRules
+	transform    string
+	readBudget   float64
+	consistent   bool
+	recheck      bool
+	iteratorType string
+	verifyOn     string
+	verbose      bool
+	validation   ValidationConfig
+	observer     Observer
+}
+
+// defaultOptions returns the settings used when no option is given, the same as the binary
+func defaultOptions() options {
+	return options{
+		sampleRate:   100,
+		confidence:   0.95,
+		iteratorType: IteratorTypeLatest,
+		verifyOn:     VerifyOnSource,
+		validation:   DefaultValidationConfig(),
+	}
+}
+
+// WithName sets a label added to every log line and to the statistics
+func WithName(name string) Option {
+	return func(o *options) { o.name = name }
+}
+
+// WithStreamArn sets the source table's stream (required by NewVerifier)
+func WithStreamArn(arn string) Option {
+	return func(o *options) { o.streamArn = arn }
+}
+
+// WithTables sets the source and target table names (the target table is required by
+// NewVerifier). An empty source table defaults to the target table.
+func WithTables(source, target string) Option {
+	return func(o *options) {
+		o.sourceTable = source
+		o.targetTable = target
+	}
+}
+
+// WithKeys sets the partition key (required by NewVerifier) and the sort key (optional)
+func WithKeys(partitionKey, sortKey string) Option {
+	return func(o *options) {
+		o.partitionKey = partitionKey
+		o.sortKey = sortKey
+	}
+}
+
+// WithSampleRate validates every event of 1 out of every n keys (defaults to 100)
+func WithSampleRate(n int) Option {
+	return func(o *options) { o.sampleRate = n }
+}
+
+// WithSampleSalt changes which keys are sampled. Instances with the same rate and salt
+// sample the same keys.
+func WithSampleSalt(salt string) Option {
+	return func(o *options) { o.sampleSalt = salt }
+}
+
+// WithConfidence sets the confidence level of the reported success interval (defaults to 0.95)
+func WithConfidence(confidence float64) Option {
+	return func(o *options) { o.confidence = confidence }
+}
+
+// WithSamplePlan chooses the sample rate automatically so the success rate interval is
+// within ±margin, e.g. 0.005, once per window (1h if 0). The sample rate follows the
+// observed traffic, and expectedEventRate (events per second, optional) sets the first one.
+func WithSamplePlan(margin, expectedEventRate float64, window time.Duration) Option {
+	return func(o *options) {
+		o.samplePlan = &SamplePlanConfig{
+			TargetMargin:      margin,
+			ExpectedEventRate: expectedEventRate,
+			Window:            window,
+		}
+	}
+}
+
+// WithAdaptiveSampling raises the sample rate while the rolling failure rate is above
+// cfg.FailureThreshold, and validates every event of the keys or key prefixes that failed
+func WithAdaptiveSampling(cfg AdaptiveSamplingConfig) Option {
+	return func(o *options) { o.adaptive = &cfg }
+}
+
+// WithEventFilter only counts and validates the records matching cfg, by partition key,
+// event type and creation time
+func WithEventFilter(cfg EventFilterConfig) Option {
+	return func(o *options) { o.eventFilter = &cfg }
+}
+
+// WithLookupAttributes reads these attributes of the verified item besides the primary
+// key, for the Item of every ValidationResult. By default only the key is read.
+func WithLookupAttributes(names ...string) Option {
+	return func(o *options) { o.lookupAttrs = names }
+}
+
+// WithTransform computes the item expected in the target table, and so the key looked up,
+// from the stream image of every sampled record, for migrations that reshape items. spec
+// is a list of steps separated by semicolons, e.g. "pk=tenant42#${pk}; -legacyId". It
+// requires VerifyOnTarget.
+func WithTransform(spec string) Option {
+	return func(o *options) { o.transform = spec }
+}
+
+// WithCompareRules compares the attributes of every item found with the stream image,
+// not only its existence. Differences covered by rules are reported in the Expected field
+// of the ValidationResult instead of failing it. A zero CompareRules expects no difference.
+func WithCompareRules(rules CompareRules) Option {
+	return func(o *options) { o.compare = &rules }
+}
+
+// WithReadBudget limits validation lookups to rcuPerSecond read capacity units per
+// second. When the sampled keys need more, lookups wait and fewer keys are sampled.
+func WithReadBudget(rcuPerSecond float64) Option {
+	return func(o *options) { o.readBudget = rcuPerSecond }
+}
+
+// WithConsistentRead makes every validation lookup strongly consistent
+func WithConsistentRead() Option {
+	return func(o *options) { o.consistent = true }
+}
+
+// WithConsistentRecheck reads the items still missing after retry again with a strongly
+// consistent read before counting them as failed
+func WithConsistentRecheck() Option {
+	return func(o *options) { o.recheck = true }
+}
+
+// WithIteratorType sets where to start reading shards: IteratorTypeLatest (default) or
+// IteratorTypeTrimHorizon
+func WithIteratorType(iteratorType string) Option {
+	return func(o *options) { o.iteratorType = iteratorType }
+}
+
+// WithVerifyOn sets the table sampled records are verified against: VerifyOnSource
+// (default) or VerifyOnTarget
+func WithVerifyOn(verifyOn string) Option {
+	return func(o *options) { o.verifyOn = verifyOn }
+}
+
+// WithVerbose logs successful validations too
+func WithVerbose(verbose bool) Option {
+	return func(o *options) { o.verbose = verbose }
+}
+
+// WithValidationConfig replaces the buffering, waiting and reporting settings
+func WithValidationConfig(cfg ValidationConfig) Option {
+	return func(o *options) { o.validation = cfg }
+}
+
+// WithBatchSize sets the number of stream records read per GetRecords call (defaults to 100)
+func WithBatchSize(n int32) Option {
+	return func(o *options) { o.validation.BatchSize = n }
+}
+
+// WithObserver receives the record, validation result and statistics events
+func WithObserver(observer Observer) Option {
+	return func(o *options) { o.observer = observer }
+}
+
+// applyOptions returns the default settings with opts applied
+func applyOptions(opts []Option) options {
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
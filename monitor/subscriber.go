@@ -0,0 +1,90 @@
+package monitor
+
+import (
+	"context"
+	"errors"
+
+	streamtypes "github.com/aws/aws-sdk-go-v2/service/dynamodbstreams/types"
+	"github.com/yotsuba1022/dynamodb-migration-monitor/internal"
+)
+
+// Subscriber reads the records of a DynamoDB stream
+type Subscriber interface {
+	// Subscribe reads every shard of the stream, including shards created later, until ctx
+	// is canceled or the stream can no longer be read. Both channels are closed once ctx is
+	// canceled, or after an error wrapping ErrStreamStopped was sent. Receive from the error
+	// channel to keep the subscriber running.
+	Subscribe(ctx context.Context) (<-chan *Record, <-chan error)
+}
+
+// subscriber adapts the internal stream subscriber to Subscriber
+type subscriber struct {
+	inner *internal.StreamSubscriberV2
+}
+
+// NewSubscriber creates a Subscriber for the given stream. WithIteratorType and
+// WithBatchSize apply, other options are ignored.
+func NewSubscriber(streamClient StreamAPI, streamArn string, opts ...Option) (Subscriber, error) {
+	if streamClient == nil {
+		return nil, errors.New("stream client is required")
+	}
+	if streamArn == "" {
+		return nil, errors.New("stream ARN is required")
+	}
+
+	o := applyOptions(opts)
+	if err := validateIteratorType(o.iteratorType); err != nil {
+		return nil, err
+	}
+
+	// The table client is only used to look up the stream ARN, which is given here
+	inner := internal.NewStreamSubscriberV2WithArn(nil, streamClient, "", streamArn)
+	inner.SetShardIteratorType(streamtypes.ShardIteratorType(o.iteratorType))
+	inner.SetLimit(o.validation.BatchSize)
+	return &subscriber{inner: inner}, nil
+}
+
+// Subscribe implements Subscriber
+func (s *subscriber) Subscribe(ctx context.Context) (<-chan *Record, <-chan error) {
+	// Canceled once the stream stopped, to close the records channel too
+	ctx, cancel := context.WithCancel(ctx)
+	recCh, errCh := s.inner.GetStreamDataAsync(ctx)
+
+	records := make(chan *Record)
+	errs := make(chan error)
+	go forward(ctx, recCh, records, nil)
+	go func() {
+		defer cancel()
+		forward(ctx, errCh, errs, func(err error) bool { return errors.Is(err, ErrStreamStopped) })
+	}()
+	return records, errs
+}
+
+// forward copies values from in to out until ctx is canceled or a value for which last
+// returns true was sent, then closes out
+func forward[T any](ctx context.Context, in <-chan T, out chan<- T, last func(T) bool) {
+	defer close(out)
+	for {
+		select {
+		case v := <-in:
+			select {
+			case out <- v:
+			case <-ctx.Done():
+				return
+			}
+			if last != nil && last(v) {
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// validateIteratorType checks that t is a supported iterator type
+func validateIteratorType(t string) error {
+	if t != IteratorTypeLatest && t != IteratorTypeTrimHorizon {
+		return errors.New("iterator type must be either LATEST or TRIM_HORIZON")
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package monitor
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestNewSubscriberValidatesOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		client  StreamAPI
+		arn     string
+		opts    []Option
+		wantErr bool
+	}{
+		{name: "valid", client: &fakeStream{}, arn: testStreamArn, opts: []Option{WithIteratorType(IteratorTypeTrimHorizon), WithBatchSize(10)}},
+		{name: "no client", arn: testStreamArn, wantErr: true},
+		{name: "no stream ARN", client: &fakeStream{}, wantErr: true},
+		{name: "unknown iterator type", client: &fakeStream{}, arn: testStreamArn, opts: []Option{WithIteratorType("AT_SEQUENCE_NUMBER")}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewSubscriber(tt.client, tt.arn, tt.opts...)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("error = %v, want an error: %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// waitClosed fails the test if ch is not closed within a second, ignoring any value
+func waitClosed[T any](t *testing.T, name string, ch <-chan T) {
+	t.Helper()
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case _, ok := <-ch:
+			if !ok {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("%s channel was not closed", name)
+		}
+	}
+}
+
+func TestSubscriberClosesChannels(t *testing.T) {
+	t.Run("stream stops", func(t *testing.T) {
+		sub, err := NewSubscriber(&fakeStream{err: errors.New("stream deleted")}, testStreamArn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		records, errs := sub.Subscribe(context.Background())
+
+		select {
+		case err := <-errs:
+			if !errors.Is(err, ErrStreamStopped) {
+				t.Errorf("error = %v, want the stream to be stopped", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("no error received")
+		}
+		waitClosed(t, "error", errs)
+		waitClosed(t, "records", records)
+	})
+
+	t.Run("context canceled", func(t *testing.T) {
+		sub, err := NewSubscriber(&fakeStream{}, testStreamArn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		records, errs := sub.Subscribe(ctx)
+		cancel()
+
+		waitClosed(t, "error", errs)
+		waitClosed(t, "records", records)
+	})
+}
@@ -0,0 +1,122 @@
+package monitor
+
+import (
+	"context"
+	"errors"
+
+	"github.com/yotsuba1022/dynamodb-migration-monitor/internal"
+)
+
+// Verifier samples the records of a source stream and checks that each sampled item
+// exists in the source or target table
+type Verifier interface {
+	// Run verifies stream records until ctx is canceled and returns the final statistics.
+	// It returns early with Stats.StopErr set if the stream can no longer be read.
+	Run(ctx context.Context) Stats
+}
+
+// verifier adapts the internal stream verification to Verifier
+type verifier struct {
+	cfg internal.StreamVerificationConfig
+}
+
+// NewVerifier creates a Verifier. WithStreamArn, WithTables and WithKeys are required.
+func NewVerifier(clients *Clients, opts ...Option) (Verifier, error) {
+	if clients == nil || clients.SourceClient == nil || clients.TargetClient == nil || clients.StreamClient == nil {
+		return nil, errors.New("source, target and stream clients are required")
+	}
+
+	o := applyOptions(opts)
+	switch {
+	case o.streamArn == "":
+		return nil, errors.New("stream ARN is required")
+	case o.targetTable == "":
+		return nil, errors.New("target table is required")
+	case o.partitionKey == "":
+		return nil, errors.New("partition key is required")
+	case o.sampleRate <= 0:
+		return nil, errors.New("sample rate must be greater than 0")
+	case o.transform != "" && o.verifyOn != VerifyOnTarget:
+		return nil, errors.New("transform requires verify-on target")
+	case o.consistent && o.recheck:
+		return nil, errors.New("consistent read and consistent recheck cannot be combined")
+	case o.readBudget < 0:
+		return nil, errors.New("read budget must not be negative")
+	case o.confidence <= 0 || o.confidence >= 1:
+		return nil, errors.New("confidence must be between 0 and 1")
+	case o.samplePlan != nil && (o.samplePlan.TargetMargin <= 0 || o.samplePlan.TargetMargin >= 0.5):
+		return nil, errors.New("sample plan margin must be between 0 and 0.5")
+	case o.adaptive != nil && (o.adaptive.FailureThreshold <= 0 || o.adaptive.FailureThreshold >= 1):
+		return nil, errors.New("adaptive sampling failure threshold must be between 0 and 1")
+	case o.verifyOn != VerifyOnSource && o.verifyOn != VerifyOnTarget:
+		return nil, errors.New("verify-on must be either source or target")
+	}
+	if err := validateIteratorType(o.iteratorType); err != nil {
+		return nil, err
+	}
+
+	if o.compare != nil {
+		if err := o.compare.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	var transform *internal.Transform
+	if o.transform != "" {
+		var err error
+		if transform, err = internal.ParseTransform(o.transform); err != nil {
+			return nil, err
+		}
+	}
+
+	var eventFilter *internal.EventFilter
+	if o.eventFilter != nil {
+		var err error
+		if eventFilter, err = internal.NewEventFilter(*o.eventFilter); err != nil {
+			return nil, err
+		}
+	}
+
+	cfg := internal.StreamVerificationConfig{
+		Name:         o.name,
+		SourceClient: clients.SourceClient,
+		TargetClient: clients.TargetClient,
+		StreamClient: clients.StreamClient,
+		StreamArn:    o.streamArn,
+		SourceTable:  o.sourceTable,
+		TargetTable:  o.targetTable,
+		SampleRate:   o.sampleRate,
+		SampleSalt:   o.sampleSalt,
+		Confidence:   o.confidence,
+		PartitionKey: o.partitionKey,
+		SortKey:      o.sortKey,
+		IteratorType: o.iteratorType,
+		VerifyOn:     o.verifyOn,
+		Verbose:      o.verbose,
+
+		LookupAttributes:  o.lookupAttrs,
+		ReadBudget:        o.readBudget,
+		ConsistentRead:    o.consistent,
+		ConsistentRecheck: o.recheck,
+		SamplePlan:        o.samplePlan,
+		AdaptiveSampling:  o.adaptive,
+		EventFilter:       eventFilter,
+		CompareRules:      o.compare,
+		Transform:         transform,
+		ValidationConfig:  o.validation,
+	}
+	if o.observer != nil {
+		cfg.OnRecord = o.observer.OnRecord
+		cfg.OnValidation = o.observer.OnValidation
+		cfg.OnStats = o.observer.OnStats
+	}
+
+	return &verifier{cfg: cfg}, nil
+}
+
+// Run implements Verifier
+func (v *verifier) Run(ctx context.Context) Stats {
+	// The engine fills in defaults, keep the verifier reusable
+	cfg := v.cfg
+	return internal.RunStreamStyleVerification(ctx, &cfg)
+}
@@ -0,0 +1,147 @@
+package monitor
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	streamtypes "github.com/aws/aws-sdk-go-v2/service/dynamodbstreams/types"
+)
+
+// requiredOptions are the options every verifier needs
+func requiredOptions(opts ...Option) []Option {
+	return append([]Option{
+		WithStreamArn(testStreamArn),
+		WithTables("orders", "orders"),
+		WithKeys("pk", "sk"),
+	}, opts...)
+}
+
+func TestNewVerifierValidatesOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		clients *Clients
+		opts    []Option
+		wantErr bool
+	}{
+		{name: "required options", opts: requiredOptions()},
+		{name: "all options", opts: requiredOptions(
+			WithName("orders"),
+			WithSampleRate(10),
+			WithSampleSalt("run-2"),
+			WithConfidence(0.99),
+			WithSamplePlan(0.005, 100, time.Hour),
+			WithAdaptiveSampling(AdaptiveSamplingConfig{FailureThreshold: 0.01}),
+			WithEventFilter(EventFilterConfig{EventTypes: []string{"INSERT"}}),
+			WithLookupAttributes("status"),
+			WithTransform("-legacyId"),
+			WithCompareRules(CompareRules{}),
+			WithReadBudget(50),
+			WithConsistentRead(),
+			WithIteratorType(IteratorTypeTrimHorizon),
+			WithVerifyOn(VerifyOnTarget),
+			WithBatchSize(50),
+		)},
+		{name: "no clients", clients: &Clients{}, opts: requiredOptions(), wantErr: true},
+		{name: "no stream ARN", opts: []Option{WithTables("orders", "orders"), WithKeys("pk", "sk")}, wantErr: true},
+		{name: "no target table", opts: []Option{WithStreamArn(testStreamArn), WithKeys("pk", "sk")}, wantErr: true},
+		{name: "no partition key", opts: []Option{WithStreamArn(testStreamArn), WithTables("orders", "orders")}, wantErr: true},
+		{name: "sample rate 0", opts: requiredOptions(WithSampleRate(0)), wantErr: true},
+		{name: "transform on source", opts: requiredOptions(WithTransform("-legacyId")), wantErr: true},
+		{name: "invalid transform", opts: requiredOptions(WithVerifyOn(VerifyOnTarget), WithTransform("=")), wantErr: true},
+		{name: "invalid event filter", opts: requiredOptions(WithEventFilter(EventFilterConfig{IncludeKeyPattern: "("})), wantErr: true},
+		{name: "consistent read and recheck", opts: requiredOptions(WithConsistentRead(), WithConsistentRecheck()), wantErr: true},
+		{name: "negative read budget", opts: requiredOptions(WithReadBudget(-1)), wantErr: true},
+		{name: "confidence 1", opts: requiredOptions(WithConfidence(1)), wantErr: true},
+		{name: "sample plan margin 0.5", opts: requiredOptions(WithSamplePlan(0.5, 0, 0)), wantErr: true},
+		{name: "adaptive threshold 0", opts: requiredOptions(WithAdaptiveSampling(AdaptiveSamplingConfig{})), wantErr: true},
+		{name: "unknown verify-on", opts: requiredOptions(WithVerifyOn("both")), wantErr: true},
+		{name: "unknown iterator type", opts: requiredOptions(WithIteratorType("AT_SEQUENCE_NUMBER")), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clients := tt.clients
+			if clients == nil {
+				clients = testClients(&fakeStream{})
+			}
+			_, err := NewVerifier(clients, tt.opts...)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("error = %v, want an error: %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestObserverFuncs(t *testing.T) {
+	var records, validations, stats int
+	v, err := NewVerifier(testClients(&fakeStream{}), requiredOptions(WithObserver(ObserverFuncs{
+		Record:     func(*Record) { records++ },
+		Validation: func(ValidationResult) { validations++ },
+		Stats:      func(Stats) { stats++ },
+	}))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The observer is called by the verification through these hooks
+	cfg := v.(*verifier).cfg
+	cfg.OnRecord(&streamtypes.Record{})
+	cfg.OnValidation(ValidationResult{})
+	cfg.OnValidation(ValidationResult{})
+	cfg.OnStats(Stats{})
+	if records != 1 || validations != 2 || stats != 1 {
+		t.Errorf("got %d records, %d validations and %d stats, want 1, 2 and 1", records, validations, stats)
+	}
+
+	// Nil functions are skipped
+	var observer Observer = ObserverFuncs{}
+	observer.OnRecord(&streamtypes.Record{})
+	observer.OnValidation(ValidationResult{})
+	observer.OnStats(Stats{})
+}
+
+func TestVerifierRun(t *testing.T) {
+	t.Run("stream stops", func(t *testing.T) {
+		stream := &fakeStream{err: errors.New("stream deleted")}
+		v, err := NewVerifier(testClients(stream), requiredOptions()...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		stats := v.Run(ctx)
+		if ctx.Err() != nil {
+			t.Fatal("Run did not return when the stream stopped")
+		}
+		if !errors.Is(stats.StopErr, ErrStreamStopped) {
+			t.Errorf("stop error = %v, want the stream to be stopped", stats.StopErr)
+		}
+	})
+
+	t.Run("context canceled", func(t *testing.T) {
+		stream := &fakeStream{}
+		v, err := NewVerifier(testClients(stream), requiredOptions()...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		stats := v.Run(ctx)
+		if stats.StopErr != nil {
+			t.Errorf("stop error = %v, want none", stats.StopErr)
+		}
+		if stream.describeCalls() == 0 {
+			t.Error("the stream was never described")
+		}
+
+		// The verifier can run again
+		ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		if stats := v.Run(ctx); stats.StopErr != nil {
+			t.Errorf("second run: stop error = %v, want none", stats.StopErr)
+		}
+	})
+}